@@ -0,0 +1,60 @@
+package carving
+
+import (
+	"math"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+)
+
+// An angledCarvingRun is a carving run along a straight line at an arbitrary angle, from a
+// given start point to a given end point.
+type angledCarvingRun struct {
+	carvingRun
+}
+
+func (r *angledCarvingRun) configure(
+	sampler hmap.ScalarGridSampler, // The sampler to get the height-map value at each point.
+	generator codeGenerator, // The output code generator.
+	p0 geom.Pt2, // The start point of the run.
+	p1 geom.Pt2, // The end point of the run.
+	whiteCarvingDepth float64, // The carving depth for white image samples.
+	blackCarvingDepth float64, // The carving depth for black image samples.
+	depthStepDown float64, // How much to step down in depth at each pass.
+) {
+	runLength := p1.Sub(p0).Len()
+
+	// Get the number of available samples along the run from the number of samples along
+	// each axis. We need at least two samples, one at each end of the run.
+	numSamplesX := float64(sampler.GetNumSamplesFromX0ToX1(p0.X, p1.X))
+	numSamplesY := float64(sampler.GetNumSamplesFromY0ToY1(p0.Y, p1.Y))
+	numSamples := int(math.Ceil(math.Hypot(numSamplesX, numSamplesY)))
+	if numSamples <= 1 {
+		numSamples = 2
+	}
+
+	if runLength/float64(numSamples-1) < minStepSize {
+		numSamples = int(math.Ceil(runLength/minStepSize)) + 1
+		if numSamples <= 1 {
+			numSamples = 2
+		}
+	}
+
+	r.sampler = sampler
+	r.generator = generator
+
+	// The passes visit numSteps points along the run, the last one being the end point.
+	r.numSteps = numSamples
+	r.step = p1.Sub(p0).Scale(1.0 / float64(numSamples-1))
+	r.startingPoint = p0
+	r.endPoint = p1
+
+	r.blackCarvingDepth = blackCarvingDepth
+	r.whiteCarvingDepth = whiteCarvingDepth
+	r.depthStepDown = depthStepDown
+	r.currentCarvingDepth = 0.0
+
+	r.needMorePasses = true
+
+	r.sanitize()
+}
@@ -0,0 +1,183 @@
+package carving
+
+import (
+	"math"
+
+	"alvin.com/GoCarver/geom"
+)
+
+const (
+	arcTolerance = flatnessTolerance // Max distance from the points to a fitted arc.
+	minArcPoints = 5                 // Min number of points to replace with an arc.
+	minArcRadius = 0.5               // Smaller arcs are left as line segments.
+	maxArcRadius = 1000.0            // Larger arcs are left as line segments.
+
+	// Arcs are emitted with a radius. Keeping them well under a half circle avoids any ambiguity
+	// and keeps the center accurate after rounding.
+	maxArcSweep = 0.75 * math.Pi
+)
+
+// A circular arc in the XY plane, fitted to points of a line-segment component. Z varies
+// linearly with the angle along the arc, making the arc helical.
+type fittedArc struct {
+	radius    float64
+	clockwise bool
+}
+
+// Split the line-segment component into line-segment and arc components, replacing the runs
+// of at least minArcPoints points that lie on a helical arc, within tolerance, with a single
+// arc component. Return the component unchanged when no arc is found.
+func (s *pathComponent) fitArcs() []pathComponent {
+	n := len(s.points)
+	if !s.isLineSegmentComponent() || n < minArcPoints {
+		return []pathComponent{*s}
+	}
+
+	var comps []pathComponent
+	lineStart := 0
+	i := 0
+	for i+minArcPoints-1 < n {
+		j, arc, found := s.findArcFrom(i)
+		if !found {
+			i++
+			continue
+		}
+
+		// An arc starting at the next point may go further, e.g. when point i is the end of a
+		// line tangent to the arc. Prefer the longer arc.
+		for i+1 < j {
+			j1, arc1, found1 := s.findArcFrom(i + 1)
+			if !found1 || j1 <= j {
+				break
+			}
+			i, j, arc = i+1, j1, arc1
+		}
+
+		if i > lineStart {
+			comps = append(comps, newLineSegmentsComponent(s.points[lineStart:i+1]))
+		}
+		comps = append(comps, newArcComponent(arc, s.points[j]))
+		lineStart = j
+		i = j
+	}
+
+	if len(comps) == 0 {
+		return []pathComponent{*s}
+	}
+	if lineStart < n-1 {
+		comps = append(comps, newLineSegmentsComponent(s.points[lineStart:]))
+	}
+
+	return comps
+}
+
+// Find the longest arc that starts at point i. Return the index of the last point on the arc,
+// the arc and whether an arc was found. Points that are almost colinear are not considered to
+// be on an arc, since they are better simplified into line segments.
+func (s *pathComponent) findArcFrom(i int) (int, fittedArc, bool) {
+	last := -1
+	var arc fittedArc
+	for j := i + minArcPoints - 1; j < len(s.points); j++ {
+		a, ok := s.fitArc(i, j)
+		if !ok {
+			break
+		}
+		last, arc = j, a
+	}
+
+	if last < 0 {
+		return 0, arc, false
+	}
+
+	maxDistSqrd := 0.0
+	for k := i + 1; k < last; k++ {
+		d := distQtoP0P1Sqrd(s.points[k], s.points[i], s.points[last])
+		maxDistSqrd = math.Max(maxDistSqrd, d)
+	}
+	if maxDistSqrd <= flatnessToleranceSqrd {
+		return 0, arc, false
+	}
+
+	return last, arc, true
+}
+
+// Fit an arc to points i to j. The arc goes through points i, j and the point half-way between
+// them. Return false if any of the points is out of tolerance, if the points don't go around the
+// center in a consistent direction or if the arc would be too small, too large or too long.
+func (s *pathComponent) fitArc(i, j int) (fittedArc, bool) {
+	p0, pm, p1 := s.points[i], s.points[(i+j)/2], s.points[j]
+
+	// Find the center of the circle through the three points, at the intersection of the
+	// perpendicular bisectors.
+	d := 2 * (p0.X*(pm.Y-p1.Y) + pm.X*(p1.Y-p0.Y) + p1.X*(p0.Y-pm.Y))
+	if math.Abs(d) < epsilon {
+		return fittedArc{}, false
+	}
+
+	sq0 := p0.X*p0.X + p0.Y*p0.Y
+	sqm := pm.X*pm.X + pm.Y*pm.Y
+	sq1 := p1.X*p1.X + p1.Y*p1.Y
+	center := geom.NewPt2(
+		(sq0*(pm.Y-p1.Y)+sqm*(p1.Y-p0.Y)+sq1*(p0.Y-pm.Y))/d,
+		(sq0*(p1.X-pm.X)+sqm*(p0.X-p1.X)+sq1*(pm.X-p0.X))/d)
+	radius := math.Hypot(p0.X-center.X, p0.Y-center.Y)
+	if radius < minArcRadius || radius > maxArcRadius {
+		return fittedArc{}, false
+	}
+
+	// The points must go around the center in the same direction as the three points above.
+	// Accumulate the angle swept from point i to each point.
+	clockwise := d < 0
+	angles := make([]float64, j-i+1)
+	for k := i + 1; k <= j; k++ {
+		v0 := geom.NewVec2(s.points[k-1].X-center.X, s.points[k-1].Y-center.Y)
+		v1 := geom.NewVec2(s.points[k].X-center.X, s.points[k].Y-center.Y)
+		step := math.Atan2(v0.X*v1.Y-v0.Y*v1.X, v0.Dot(v1))
+		if step == 0 || (step < 0) != clockwise {
+			return fittedArc{}, false
+		}
+
+		angles[k-i] = angles[k-i-1] + math.Abs(step)
+		if angles[k-i] > maxArcSweep {
+			return fittedArc{}, false
+		}
+
+		r := math.Hypot(s.points[k].X-center.X, s.points[k].Y-center.Y)
+		if math.Abs(r-radius) > arcTolerance {
+			return fittedArc{}, false
+		}
+	}
+
+	// Z must vary linearly with the angle along the arc.
+	sweep := angles[j-i]
+	for k := i + 1; k < j; k++ {
+		z := p0.Z + (p1.Z-p0.Z)*angles[k-i]/sweep
+		if math.Abs(s.points[k].Z-z) > arcTolerance {
+			return fittedArc{}, false
+		}
+	}
+
+	return fittedArc{radius: radius, clockwise: clockwise}, true
+}
+
+// Return a new line-segment component with a copy of the given points.
+func newLineSegmentsComponent(points []pt3) pathComponent {
+	return pathComponent{
+		flavor: lineSegmentsComponent,
+		points: append(make([]pt3, 0, len(points)), points...),
+	}
+}
+
+// Return a new arc component for the given arc, ending at point p1. See pathComponent for the
+// layout of arc components.
+func newArcComponent(arc fittedArc, p1 pt3) pathComponent {
+	direction := counterclockwiseArc
+	if arc.clockwise {
+		direction = clockwiseArc
+	}
+
+	return pathComponent{
+		flavor: arcComponent,
+		points: []pt3{geom.NewPt3(direction, arc.radius, p1.Z), p1},
+	}
+}
@@ -6,20 +6,31 @@ import (
 
 	g "alvin.com/GoCarver/geom"
 	"alvin.com/GoCarver/hmap"
+	"alvin.com/GoCarver/stock"
 )
 
 const (
-	ToolTypeBallPoint = 1
-	ToolTypeFlat      = 2
-
-	CarveModeXOnly  = 100
-	CarveModeYOnly  = 101
-	CarveModeXThenY = 102
+	ToolTypeBallPoint   = 1
+	ToolTypeFlat        = 2
+	ToolTypeVBit        = 3
+	ToolTypeTaperedBall = 4
+	ToolTypeBullNose    = 5
+
+	CarveModeXOnly      = 100
+	CarveModeYOnly      = 101
+	CarveModeXThenY     = 102
+	CarveModeAtAngle    = 103
+	CarveModeSpiral     = 104
+	CarveModeConcentric = 105
 
 	FinishPassModeAlongFirstDirOnly = 200
 	FinishPassModeAlongLastDirOnly  = 201
 	FinishPassModeAlongAllDirs      = 202
 
+	RasterBackAndForth     = 700
+	RasterClimbOnly        = 701
+	RasterConventionalOnly = 702
+
 	minStepSize = 0.1 // Minimum step size in mm, a.k.a. resolution.
 )
 
@@ -34,17 +45,33 @@ type Carver struct {
 	carvingBottomLeft g.Pt2
 	carvingDimMm      g.Size2
 
-	carveMode int
+	carveMode       int
+	rasterAngleRad  float64 // Direction of the runs when carving at an angle.
+	rasterDirection int     // One of the RasterXXX values.
+	spindleDir      int     // One of the SpindleXXX values, clockwise when 0.
+
+	loopCornerRadiusMm float64 // Corner radius of the outer loop for spirals and concentric loops.
 
 	zWhite      float64 // Z coordinate for white samples.
 	zBlack      float64 // Z coordinate for black samples.
 	maxStepDown float64
 
+	toolType         int
 	toolDiameterMm   float64
+	toolAngleDeg     float64 // Included angle of V-bits and tapered ball-nose tools.
+	tipRadiusMm      float64 // Radius of the ball at the tip of tapered ball-nose tools.
+	cornerRadiusMm   float64 // Corner radius of bull-nose tools.
 	stepOverFraction float64
 	horizFeedRate    float64
 	vertFeedRate     float64
 
+	scallopHeightMm float64 // Target scallop height, or 0 to use a fixed step-over.
+	enableStayDown  bool    // Whether the tool may stay down between adjacent runs.
+
+	enableAirCutElimination bool // Whether runs skip the stretches carved by previous passes.
+
+	remainingStock *stock.Stock // The stock left by the previous operations, or nil.
+
 	enableFinishingPass        bool
 	finishingPassStepFraction  float64
 	finishingPassMode          int
@@ -80,11 +107,21 @@ func (c *Carver) ConfigureTool(
 	horizontalFeedRateMmPerMin float64,
 	verticalFeedRateMmPerMin float64) {
 
+	c.toolType = toolType
 	c.toolDiameterMm = toolDiameterMm
 	c.horizFeedRate = horizontalFeedRateMmPerMin
 	c.vertFeedRate = verticalFeedRateMmPerMin
 }
 
+// ConfigureToolShape is used to configure the shape of the carving tool, for the tool types
+// that need it: the included angle, in degrees, of V-bits and tapered ball-nose tools, the tip
+// radius of tapered ball-nose tools and the corner radius of bull-nose tools.
+func (c *Carver) ConfigureToolShape(toolAngleDeg, tipRadiusMm, cornerRadiusMm float64) {
+	c.toolAngleDeg = toolAngleDeg
+	c.tipRadiusMm = tipRadiusMm
+	c.cornerRadiusMm = cornerRadiusMm
+}
+
 // ConfigureCarvingProfile is used to configure the carving-profile parameters,
 // such as the height of the material, carving mode, etc.
 func (c *Carver) ConfigureCarvingProfile(
@@ -103,6 +140,68 @@ func (c *Carver) ConfigureCarvingProfile(
 	c.maxStepDown = maxStepDownSizeMm
 }
 
+// ConfigureScallopHeight is used to configure a target scallop height for the runs along X,
+// Y or at an angle. When the height is positive, the step-over is computed from the tool
+// shape to leave scallops no higher than the target on flat areas, without exceeding the
+// step-over fraction. Extra runs are inserted where the surface is too steep for that
+// step-over. This requires a sampler that implements hmap.SlopeSampler, and Run fails with
+// ErrUnsupportedMode otherwise or when carving along loops. A height of 0 disables the
+// feature.
+func (c *Carver) ConfigureScallopHeight(scallopHeightMm float64) {
+	c.scallopHeightMm = math.Max(0, scallopHeightMm)
+}
+
+// ConfigureRasterAngle is used to configure the direction of the carving runs, in degrees
+// counterclockwise from the x-axis, when carving at an angle.
+func (c *Carver) ConfigureRasterAngle(angleDeg float64) {
+	c.rasterAngleRad = math.Mod(angleDeg, 180.0) * math.Pi / 180.0
+}
+
+// ConfigureRasterDirection is used to configure the direction of the runs along X, Y or at an
+// angle. The runs go back and forth with RasterBackAndForth. Otherwise, they all go in the same
+// direction, climb or conventional milling for the direction of the spindle, and the tool
+// returns to the start of each run with rapid moves.
+func (c *Carver) ConfigureRasterDirection(rasterDirection int) {
+	c.rasterDirection = rasterDirection
+}
+
+// ConfigureSpindleDirection is used to configure the direction of rotation of the spindle, one
+// of the SpindleXXX values, which tells climb from conventional milling.
+func (c *Carver) ConfigureSpindleDirection(spindleDir int) {
+	c.spindleDir = spindleDir
+}
+
+// ConfigureLoopCornerRadius is used to configure the corner radius of the outermost loop, as
+// measured on the carving area, when carving along a spiral or along concentric loops.
+func (c *Carver) ConfigureLoopCornerRadius(cornerRadiusMm float64) {
+	c.loopCornerRadiusMm = cornerRadiusMm
+}
+
+// ConfigureStayDownLinking is used to enable linking adjacent runs at cutting depth. When
+// enabled, the tool goes straight from the end of a run to the start of the next run, without
+// retracting, if the runs are at most one tool diameter apart and the link doesn't cut into
+// the carving profile.
+func (c *Carver) ConfigureStayDownLinking(enable bool) {
+	c.enableStayDown = enable
+}
+
+// ConfigureAirCutElimination is used to enable skipping the stretches of the runs along X, Y
+// or at an angle that were already carved to their final depth by the previous passes. Each
+// pass is split into the stretches that remove material and the tool hops from one stretch to
+// the next with rapid moves.
+func (c *Carver) ConfigureAirCutElimination(enable bool) {
+	c.enableAirCutElimination = enable
+}
+
+// ConfigureRemainingStock is used to carve the stock left by the previous operations, e.g.
+// roughing, rather than the whole material. The passes only cut where they go below the
+// remaining stock: the runs along X, Y or at an angle skip the stretches in the air, as with
+// air-cut elimination, and the passes that don't remove any material are dropped. Pass nil
+// to carve the whole material.
+func (c *Carver) ConfigureRemainingStock(remaining *stock.Stock) {
+	c.remainingStock = remaining
+}
+
 // Configure the finishing pass. When enabled, the finishing pass is the very last carving pass
 // in either direction. It runs once at full depth with the step-over reduced to the given
 // fraction.
@@ -121,24 +220,55 @@ func (c *Carver) ConfigureFinishingPass(
 
 // Run is called to generate the carving code. It is ok to (re)configure the carver and
 // call Run multiple times. However, all output go to the same writer.
-func (c *Carver) Run(gen codeGenerator) {
-	c.carveAlongX(gen)
-	c.carveAlongY(gen)
+func (c *Carver) Run(gen codeGenerator) error {
+	if err := checkScallopHeightSupport(c.scallopHeightMm, c.carveMode, c.sampler); err != nil {
+		return err
+	}
+	if c.enableStayDown && c.sampler != nil {
+		gen.setStayDownLinker(newStayDownLinker(c.sampler, c.zWhite, c.zBlack, c.toolDiameterMm))
+		defer gen.setStayDownLinker(nil)
+	}
+	if c.sampler != nil {
+		gen.setRetractPlanner(newRetractPlanner(c.sampler, c.zWhite, c.zBlack, c.maxStepDown))
+		defer gen.setRetractPlanner(nil)
+		gen.setEntryPlanner(newEntryPlanner(c.sampler, c.zWhite, c.zBlack, c.carvingBottomLeft,
+			c.carvingBottomLeft.Add(g.NewVec2(c.carvingDimMm.W, c.carvingDimMm.H))))
+		defer gen.setEntryPlanner(nil)
+	}
+	if c.isUnidirectional() || c.enableAirCutElimination || c.remainingStock != nil {
+		gen.setRapidRepositioning(true)
+		defer gen.setRapidRepositioning(false)
+	}
+
+	if err := c.carveAlongX(gen); err != nil {
+		return err
+	}
+	if err := c.carveAlongY(gen); err != nil {
+		return err
+	}
+	if err := c.carveAtAngle(gen); err != nil {
+		return err
+	}
+	return c.carveAlongLoops(gen)
 }
 
 // Generate carving runs along the x-direction. This will generate the main carving passes as
 // well as the optional finishing pass if carving only takes place along X.
-func (c *Carver) carveAlongX(gen codeGenerator) {
+func (c *Carver) carveAlongX(gen codeGenerator) error {
 	if c.carveMode != CarveModeXOnly && c.carveMode != CarveModeXThenY {
-		return
+		return nil
 	}
 
-	c.genCarvingRunsAlongX(c.stepOverFraction, false /* not full depth */, gen)
+	err := c.genCarvingRunsAlongX(c.stepOverFraction, false /* not full depth */, gen)
+	if err != nil {
+		return err
+	}
 	if c.needFinishingPassAlongX() {
 		oldFeedRate := gen.changeHorizontalFeedRate(c.finishingPassHorizFeedRate)
-		c.genCarvingRunsAlongX(c.finishingPassStepFraction, true /* full depth */, gen)
-		gen.changeHorizontalFeedRate(oldFeedRate)
+		defer gen.changeHorizontalFeedRate(oldFeedRate)
+		return c.genCarvingRunsAlongX(c.finishingPassStepFraction, true /* full depth */, gen)
 	}
+	return nil
 }
 
 // Generating a series of carving path in the x-direction to fully cover the entire
@@ -147,43 +277,30 @@ func (c *Carver) carveAlongX(gen codeGenerator) {
 // a single pass at full depth  along each run. Carving at full depth should only be used
 // for the very last pass.
 func (c *Carver) genCarvingRunsAlongX(
-	stepOverFraction float64, carveAtFullDepth bool, gen codeGenerator) {
+	stepOverFraction float64, carveAtFullDepth bool, gen codeGenerator) error {
 
+	// The runs step over along +Y, so runs along +X have the material on their left, where a
+	// clockwise spindle cuts conventionally.
 	runs := c.setupXRuns(stepOverFraction, gen, carveAtFullDepth)
-	stepDir := 1.0
-	iRun := -1
-	for {
-		nextRun := c.findNextUnfinishedRun(iRun, runs)
-		if nextRun == -1 {
-			break
-		}
-
-		c.genMoveToNextRun(iRun, nextRun)
-
-		iRun = nextRun
-		run := runs[iRun]
-		run.doOnePass(stepDir)
-
-		// Flip the step direction after each run.
-		if stepDir == 1.0 {
-			stepDir = -1.0
-		} else {
-			stepDir = 1.0
-		}
-	}
+	stepDir := c.getRasterStepDir(false /* forward is climb */)
+	return c.genCarvingRuns(runs, stepDir, !c.isUnidirectional())
 }
 
 // Generate carving runs along the y-direction. This will generate the main carving passes as
 // well as the optional finishing pass if carving only takes place along X.
-func (c *Carver) carveAlongY(gen codeGenerator) {
-	if c.carveMode != CarveModeXOnly {
-		c.genCarvingRunsAlongY(c.stepOverFraction, c.carveMode == CarveModeXThenY, gen)
+func (c *Carver) carveAlongY(gen codeGenerator) error {
+	if c.carveMode == CarveModeYOnly || c.carveMode == CarveModeXThenY {
+		err := c.genCarvingRunsAlongY(c.stepOverFraction, c.carveMode == CarveModeXThenY, gen)
+		if err != nil {
+			return err
+		}
 	}
 	if c.needFinishingPassAlongY() {
 		oldFeedRate := gen.changeHorizontalFeedRate(c.finishingPassHorizFeedRate)
-		c.genCarvingRunsAlongY(c.finishingPassStepFraction, true /* full depth */, gen)
-		gen.changeHorizontalFeedRate(oldFeedRate)
+		defer gen.changeHorizontalFeedRate(oldFeedRate)
+		return c.genCarvingRunsAlongY(c.finishingPassStepFraction, true /* full depth */, gen)
 	}
+	return nil
 }
 
 // Generating a series of carving path in the y-direction to fully cover the entire
@@ -192,10 +309,103 @@ func (c *Carver) carveAlongY(gen codeGenerator) {
 // a single pass at full depth  along each run. Carving at full depth should only be used
 // for the very last pass.
 func (c *Carver) genCarvingRunsAlongY(
-	stepOverFraction float64, carveAtFullDepth bool, gen codeGenerator) {
+	stepOverFraction float64, carveAtFullDepth bool, gen codeGenerator) error {
 
+	// The runs step over along +X, so runs along +Y have the material on their right, where a
+	// clockwise spindle climbs into it.
 	runs := c.setupYRuns(stepOverFraction, gen, carveAtFullDepth)
-	stepDir := 1.0
+	stepDir := c.getRasterStepDir(true /* forward is climb */)
+	return c.genCarvingRuns(runs, stepDir, !c.isUnidirectional())
+}
+
+// Generate carving runs at the configured raster angle. This will generate the main carving
+// passes as well as the optional finishing pass.
+func (c *Carver) carveAtAngle(gen codeGenerator) error {
+	if c.carveMode != CarveModeAtAngle {
+		return nil
+	}
+
+	err := c.genCarvingRunsAtAngle(c.stepOverFraction, false /* not full depth */, gen)
+	if err != nil {
+		return err
+	}
+	if c.needFinishingPassInMode(CarveModeAtAngle) {
+		oldFeedRate := gen.changeHorizontalFeedRate(c.finishingPassHorizFeedRate)
+		defer gen.changeHorizontalFeedRate(oldFeedRate)
+		return c.genCarvingRunsAtAngle(c.finishingPassStepFraction, true /* full depth */, gen)
+	}
+	return nil
+}
+
+// Generating a series of parallel carving paths at the raster angle to fully cover the
+// entire carving area for the given step-over fraction. See genCarvingRunsAlongX for
+// carving at full depth.
+func (c *Carver) genCarvingRunsAtAngle(
+	stepOverFraction float64, carveAtFullDepth bool, gen codeGenerator) error {
+
+	// The runs step over to the left of the raster direction, as with runs along X.
+	runs := c.setupAngledRuns(stepOverFraction, gen, carveAtFullDepth)
+	stepDir := c.getRasterStepDir(false /* forward is climb */)
+	return c.genCarvingRuns(runs, stepDir, !c.isUnidirectional())
+}
+
+// Generate carving runs along an inward spiral or along concentric loops. This will generate
+// the main carving passes as well as the optional finishing pass.
+func (c *Carver) carveAlongLoops(gen codeGenerator) error {
+	if c.carveMode != CarveModeSpiral && c.carveMode != CarveModeConcentric {
+		return nil
+	}
+
+	err := c.genCarvingRunsAlongLoops(c.stepOverFraction, false /* not full depth */, gen)
+	if err != nil {
+		return err
+	}
+	if c.needFinishingPassInMode(c.carveMode) {
+		oldFeedRate := gen.changeHorizontalFeedRate(c.finishingPassHorizFeedRate)
+		defer gen.changeHorizontalFeedRate(oldFeedRate)
+		return c.genCarvingRunsAlongLoops(c.finishingPassStepFraction, true /* full depth */, gen)
+	}
+	return nil
+}
+
+// Generating the carving loops to fully cover the entire carving area for the given step-over
+// fraction. Successive passes along a spiral alternate going inward and outward. Concentric
+// loops always go clockwise. See genCarvingRunsAlongX for carving at full depth.
+func (c *Carver) genCarvingRunsAlongLoops(
+	stepOverFraction float64, carveAtFullDepth bool, gen codeGenerator) error {
+
+	if c.carveMode == CarveModeSpiral {
+		runs := c.setupSpiralRun(stepOverFraction, gen, carveAtFullDepth)
+		return c.genCarvingRuns(runs, 1.0, true /* alternate direction */)
+	}
+	runs := c.setupConcentricRuns(stepOverFraction, gen, carveAtFullDepth)
+	return c.genCarvingRuns(runs, 1.0, false /* same direction */)
+}
+
+// Generate the carving passes for the given runs, going along the runs until they are all
+// done. The first run goes forward if stepDir is 1 and backward if stepDir is -1. If
+// alternateDirection is true, the direction is flipped after each run, so that the tool goes
+// back and forth. Moving from run to run is left to the code generator, which
+// stays down between adjacent runs when stay-down linking is enabled.
+func (c *Carver) genCarvingRuns(runs []oneRun, stepDir float64, alternateDirection bool) error {
+	if len(runs) == 0 {
+		return nil
+	}
+
+	var stockTop func(q g.Pt2) float64
+	if c.remainingStock != nil {
+		cutter := newStockCutter(ToolConfig{ToolType: c.toolType, ToolDiameter: c.toolDiameterMm,
+			ToolAngle: c.toolAngleDeg, TipRadius: c.tipRadiusMm, CornerRadius: c.cornerRadiusMm})
+		stockTop = func(q g.Pt2) float64 {
+			return c.remainingStock.ContactHeight(q, cutter)
+		}
+	}
+
+	for _, run := range runs {
+		run.setEnableAirCutElimination(c.enableAirCutElimination || c.remainingStock != nil)
+		run.setRemainingStock(stockTop)
+	}
+
 	iRun := -1
 	for {
 		nextRun := c.findNextUnfinishedRun(iRun, runs)
@@ -203,19 +413,36 @@ func (c *Carver) genCarvingRunsAlongY(
 			break
 		}
 
-		c.genMoveToNextRun(iRun, nextRun)
-
 		iRun = nextRun
 		run := runs[iRun]
-		run.doOnePass(stepDir)
+		if err := run.doOnePass(stepDir); err != nil {
+			return err
+		}
 
-		// Flip the step direction after each run.
-		if stepDir == 1.0 {
-			stepDir = -1.0
-		} else {
-			stepDir = 1.0
+		// Flip the step direction after each run, when going back and forth.
+		if alternateDirection {
+			stepDir = -stepDir
 		}
 	}
+	return nil
+}
+
+// Return whether all the runs along X, Y or at an angle go in the same direction.
+func (c *Carver) isUnidirectional() bool {
+	return c.rasterDirection == RasterClimbOnly || c.rasterDirection == RasterConventionalOnly
+}
+
+// Return the direction of the first run along X, Y or at an angle: 1 to go forward and -1 to go
+// backward. Parameter forwardIsClimb tells whether going forward is climb milling with a
+// clockwise spindle. A counterclockwise spindle swaps climb and conventional milling.
+func (c *Carver) getRasterStepDir(forwardIsClimb bool) float64 {
+	if c.spindleDir == SpindleCounterclockwise {
+		forwardIsClimb = !forwardIsClimb
+	}
+	if c.isUnidirectional() && (c.rasterDirection == RasterClimbOnly) != forwardIsClimb {
+		return -1.0
+	}
+	return 1.0
 }
 
 // Find and return the index to the next unfinished run after fromRun. If fromRun is
@@ -239,29 +466,25 @@ func (c *Carver) findNextUnfinishedRun(fromRun int, runs []oneRun) int {
 	}
 }
 
-// Generate a tool move fromRun to toRun. If fromRun == -1, this is the initial move at
-// the start of carving a series of runs.
-func (c *Carver) genMoveToNextRun(fromRun, toRun int) {
-	// TODO: for now grbl generator handles moving from path to path adequately.
-}
-
 // Setup the carving runs in the x-direction. Returns an array of x-carving-runs.
 func (c *Carver) setupXRuns(
 	stepOverFraction float64, gen codeGenerator, carveAtFulldepth bool) []oneRun {
 
+	stepOverFraction = c.getScallopStepOverFraction(stepOverFraction)
 	numRuns := c.getNumRunsNeeded(stepOverFraction, c.carvingDimMm.H)
 	if numRuns == 0 {
 		return nil
 	}
 
-	runs := make([]oneRun, numRuns)
+	runs := make([]oneRun, 0, numRuns)
+	var prevP0, prevP1 g.Pt2
 
 	yStep := 0.0
 	if numRuns > 1 {
 		yStep = (c.carvingDimMm.H - c.toolDiameterMm) / float64(numRuns-1)
 	}
 
-	for i := range runs {
+	for i := 0; i < numRuns; i++ {
 		y := c.carvingBottomLeft.Y + c.toolDiameterMm*0.5 + float64(i)*yStep
 		if i == numRuns-1 {
 			// Last run, y = Ymax.
@@ -279,7 +502,12 @@ func (c *Carver) setupXRuns(
 			run.setEnableCarvingAtFulldepth(true)
 		}
 
-		runs[i] = run
+		if i > 0 {
+			runs = append(runs, c.setupScallopRuns(
+				prevP0, prevP1, run.startingPoint, run.endPoint, gen, carveAtFulldepth)...)
+		}
+		runs = append(runs, run)
+		prevP0, prevP1 = run.startingPoint, run.endPoint
 	}
 
 	return runs
@@ -289,19 +517,21 @@ func (c *Carver) setupXRuns(
 func (c *Carver) setupYRuns(
 	stepOverFraction float64, gen codeGenerator, carveAtFulldepth bool) []oneRun {
 
+	stepOverFraction = c.getScallopStepOverFraction(stepOverFraction)
 	numRuns := c.getNumRunsNeeded(stepOverFraction, c.carvingDimMm.W)
 	if numRuns == 0 {
 		return nil
 	}
 
-	runs := make([]oneRun, numRuns)
+	runs := make([]oneRun, 0, numRuns)
+	var prevP0, prevP1 g.Pt2
 
 	xStep := 0.0
 	if numRuns > 1 {
 		xStep = (c.carvingDimMm.W - c.toolDiameterMm) / float64(numRuns-1)
 	}
 
-	for i := range runs {
+	for i := 0; i < numRuns; i++ {
 		x := c.carvingBottomLeft.X + c.toolDiameterMm*0.5 + float64(i)*xStep
 		if i == numRuns-1 {
 			// Last run, x = Xmax.
@@ -319,12 +549,221 @@ func (c *Carver) setupYRuns(
 			run.setEnableCarvingAtFulldepth(true)
 		}
 
-		runs[i] = run
+		if i > 0 {
+			runs = append(runs, c.setupScallopRuns(
+				prevP0, prevP1, run.startingPoint, run.endPoint, gen, carveAtFulldepth)...)
+		}
+		runs = append(runs, run)
+		prevP0, prevP1 = run.startingPoint, run.endPoint
+	}
+
+	return runs
+}
+
+// Setup the carving runs at the raster angle. The runs are parallel lines, spaced evenly
+// along the normal to the raster direction, that are clipped to the carving area inset by
+// the tool radius. Returns an array of angled-carving-runs.
+func (c *Carver) setupAngledRuns(
+	stepOverFraction float64, gen codeGenerator, carveAtFulldepth bool) []oneRun {
+
+	pMin, pMax, ok := c.getInsetCarvingArea()
+	if !ok {
+		return nil
+	}
+
+	// Each run is the line {p : p.nrm = s} for some offset s. Find the range of offsets that
+	// covers the inset carving area.
+	dir := g.NewVec2(math.Cos(c.rasterAngleRad), math.Sin(c.rasterAngleRad))
+	nrm := g.NewVec2(-dir.Y, dir.X)
+	sMin, sMax := math.Inf(1), math.Inf(-1)
+	for _, q := range []g.Pt2{pMin, pMax, g.NewPt2(pMin.X, pMax.Y), g.NewPt2(pMax.X, pMin.Y)} {
+		s := g.NewVec2(q.X, q.Y).Dot(nrm)
+		sMin = math.Min(sMin, s)
+		sMax = math.Max(sMax, s)
+	}
+
+	stepOverFraction = c.getScallopStepOverFraction(stepOverFraction)
+	numRuns := c.getNumRunsNeeded(stepOverFraction, sMax-sMin+c.toolDiameterMm)
+	if numRuns == 0 {
+		return nil
+	}
+
+	sStep := 0.0
+	if numRuns > 1 {
+		sStep = (sMax - sMin) / float64(numRuns-1)
+	}
+
+	runs := make([]oneRun, 0, numRuns)
+	var prevRun *angledCarvingRun
+	var prevS float64
+	for i := 0; i < numRuns; i++ {
+		s := sMin + float64(i)*sStep
+		if i == numRuns-1 {
+			s = sMax
+		}
+
+		p0, p1, ok := clipLineToRect(nrm.Scale(s), dir, pMin, pMax)
+		if !ok {
+			continue
+		}
+
+		run := &angledCarvingRun{}
+		run.configure(c.sampler, gen, p0, p1, c.zWhite, c.zBlack, c.maxStepDown)
+
+		if carveAtFulldepth {
+			run.setEnableCarvingAtFulldepth(true)
+		}
+
+		if prevRun != nil {
+			// The adjacent runs are clipped to different lengths. The extra runs between them
+			// span both runs, and are clipped to the carving area in turn.
+			tAlong := func(p g.Pt2) float64 { return g.NewVec2(p.X, p.Y).Dot(dir) }
+			t0 := math.Min(tAlong(prevRun.startingPoint), tAlong(p0))
+			t1 := math.Max(tAlong(prevRun.endPoint), tAlong(p1))
+			pointAt := func(s, t float64) g.Pt2 {
+				return g.NewPt2(s*nrm.X+t*dir.X, s*nrm.Y+t*dir.Y)
+			}
+			runs = append(runs, c.setupScallopRuns(pointAt(prevS, t0), pointAt(prevS, t1),
+				pointAt(s, t0), pointAt(s, t1), gen, carveAtFulldepth)...)
+		}
+		runs = append(runs, run)
+		prevRun, prevS = run, s
+	}
+
+	return runs
+}
+
+// Return the corners of the carving area inset by the tool radius, i.e. the area covered by
+// the center of the tool. Returns false if the tool doesn't fit in the carving area.
+func (c *Carver) getInsetCarvingArea() (pMin, pMax g.Pt2, ok bool) {
+	toolRadius := 0.5 * c.toolDiameterMm
+	pMin = c.carvingBottomLeft.Add(g.NewVec2(toolRadius, toolRadius))
+	pMax = c.carvingBottomLeft.Add(
+		g.NewVec2(c.carvingDimMm.W-toolRadius, c.carvingDimMm.H-toolRadius))
+	return pMin, pMax, pMax.X >= pMin.X && pMax.Y >= pMin.Y
+}
+
+// Return the outline of the loops that cover the carving area, inset by the tool radius.
+// Returns false if the tool doesn't fit in the carving area.
+func (c *Carver) getLoopOutline() (loopOutline, bool) {
+	pMin, pMax, ok := c.getInsetCarvingArea()
+	if !ok {
+		return loopOutline{}, false
+	}
+
+	return newLoopOutline(pMin, pMax, c.loopCornerRadiusMm-0.5*c.toolDiameterMm), true
+}
+
+// Setup a single carving run along an inward spiral. The spiral starts with a whole loop
+// around the carving area and ends with a whole loop at the center. In between, each side
+// of the spiral steps inward by a quarter of the step-over. Returns an array with the single
+// spiral run.
+func (c *Carver) setupSpiralRun(
+	stepOverFraction float64, gen codeGenerator, carveAtFulldepth bool) []oneRun {
+
+	outline, ok := c.getLoopOutline()
+	if !ok {
+		return nil
+	}
+
+	maxInset := outline.maxInset()
+	sideStep := 0.25 * c.toolDiameterMm * stepOverFraction
+	numSides := int(math.Ceil(maxInset/sideStep - 0.001))
+	if numSides < 0 {
+		numSides = 0
+	}
+
+	segments := outline.appendLoop(nil, 0, 0)
+	for k := 0; k < numSides; k++ {
+		a := maxInset * float64(k) / float64(numSides)
+		b := maxInset * float64(k+1) / float64(numSides)
+		segments = outline.appendSideAndCorner(segments, k, a, b)
+	}
+	segments = outline.appendLoop(segments, numSides, maxInset)
+
+	return []oneRun{c.newLoopRun(segments, outline, maxInset, gen, carveAtFulldepth)}
+}
+
+// Setup the carving runs along concentric loops, from the outside in. Returns an array of
+// loop-carving-runs.
+func (c *Carver) setupConcentricRuns(
+	stepOverFraction float64, gen codeGenerator, carveAtFulldepth bool) []oneRun {
+
+	outline, ok := c.getLoopOutline()
+	if !ok {
+		return nil
+	}
+
+	maxInset := outline.maxInset()
+	// Each loop carves both sides of the carving area, so the loops only need to cover
+	// the distance from the edges to the center.
+	numRuns := c.getNumRunsNeeded(stepOverFraction, maxInset+c.toolDiameterMm)
+	if numRuns == 0 {
+		return nil
+	}
+
+	runs := make([]oneRun, numRuns)
+	for i := range runs {
+		inset := maxInset
+		if numRuns > 1 {
+			inset = maxInset * float64(i) / float64(numRuns-1)
+		}
+
+		segments := outline.appendLoop(nil, 0, inset)
+		runs[i] = c.newLoopRun(segments, outline, inset, gen, carveAtFulldepth)
 	}
 
 	return runs
 }
 
+// Create a loop-carving-run along the given segments. If there are no segments, the loop at
+// the given inset has degenerated to a point and the run carves that point only.
+func (c *Carver) newLoopRun(segments []loopSegment, outline loopOutline, inset float64,
+	gen codeGenerator, carveAtFulldepth bool) *loopCarvingRun {
+
+	if len(segments) == 0 {
+		p := outline.corner(0, inset).p0
+		segments = []loopSegment{{p0: p, p1: p}}
+	}
+
+	run := &loopCarvingRun{}
+	run.configure(c.sampler, gen, segments, c.zWhite, c.zBlack, c.maxStepDown)
+
+	if carveAtFulldepth {
+		run.setEnableCarvingAtFulldepth(true)
+	}
+
+	return run
+}
+
+// Clip the line {q + t * dir} to the rectangle with corners pMin and pMax. Returns the end
+// points of the clipped segment, ordered along dir, and whether the line crosses the
+// rectangle at all.
+func clipLineToRect(q, dir g.Vec2, pMin, pMax g.Pt2) (p0, p1 g.Pt2, ok bool) {
+	const eps = 1e-9
+	t0, t1 := math.Inf(-1), math.Inf(1)
+	clip := func(qi, di, lo, hi float64) bool {
+		if math.Abs(di) < eps {
+			return qi >= lo-eps && qi <= hi+eps
+		}
+		ta, tb := (lo-qi)/di, (hi-qi)/di
+		if ta > tb {
+			ta, tb = tb, ta
+		}
+		t0, t1 = math.Max(t0, ta), math.Min(t1, tb)
+		return true
+	}
+
+	if !clip(q.X, dir.X, pMin.X, pMax.X) || !clip(q.Y, dir.Y, pMin.Y, pMax.Y) || t0 > t1+eps {
+		return p0, p1, false
+	}
+
+	t1 = math.Max(t0, t1)
+	p0 = g.NewPt2(q.X+t0*dir.X, q.Y+t0*dir.Y)
+	p1 = g.NewPt2(q.X+t1*dir.X, q.Y+t1*dir.Y)
+	return p0, p1, true
+}
+
 // Return the number of runs (carving paths) needed to cover distToCover. There is always runs
 // just inside both sides of the carving area. Runs are added in the middle to cover the
 // entire carving area.
@@ -395,5 +834,22 @@ func (c *Carver) needFinishingPassAlongY() bool {
 	}
 
 	// Carving along Y only, then finishing is always allowable.
-	return true
+	return c.carveMode == CarveModeYOnly
+}
+
+// Returns whether a finishing pass is needed for the given carving mode, which carves in a
+// single direction.
+func (c *Carver) needFinishingPassInMode(carveMode int) bool {
+	// Finishing must be enabled
+	if !c.enableFinishingPass {
+		return false
+	}
+
+	// There's no point in finishing with the same step-over fraction
+	if math.Abs(c.finishingPassStepFraction-c.stepOverFraction) < 0.02 {
+		return false
+	}
+
+	// Carving in a single direction, then any finish mode is fine.
+	return c.carveMode == carveMode
 }
@@ -1,7 +1,7 @@
 package carving
 
 import (
-	"log"
+	"fmt"
 	"math"
 
 	"alvin.com/GoCarver/geom"
@@ -12,11 +12,17 @@ import (
 type oneRun interface {
 	isDone() bool
 	setEnableCarvingAtFulldepth(enable bool)
-	doOnePass(delta float64)
+	setEnableAirCutElimination(enable bool)
+	setRemainingStock(stockTop func(q g.Pt2) float64)
+	doOnePass(delta float64) error
 }
 
 var maxDepth = 0.0
 
+// Stretches of a run that cut air and are shorter than this, in mm, are cut through rather
+// than hopped over, since the hop would take longer.
+const minAirCutLength = 3.0
+
 // carvingRun represent a single rectilinear run of the carving tool. It is used to manage
 // the multiple carving passes that are required to carve the material to the maximum depth
 // given the maximum step-down size.
@@ -31,10 +37,18 @@ type carvingRun struct {
 	currentCarvingDepth float64 // The current carving depth, always starting at 0.
 	depthStepDown       float64 // How much to step down for each new pass.
 
-	enableCarveAtFullDepth bool
+	enableCarveAtFullDepth  bool
+	enableAirCutElimination bool
 
 	needMorePasses bool // Whether more passes are need to finish this run.
 
+	previousPassDepth float64 // The carving depth of the previous pass.
+	passCutsMaterial  bool    // Whether the current pass goes below the previous pass.
+
+	// The height the tool can go down to at q without touching the stock left by the previous
+	// operations, or nil if the stock isn't known.
+	remainingStockTop func(q g.Pt2) float64
+
 	sampler   hmap.ScalarGridSampler
 	generator codeGenerator
 }
@@ -53,81 +67,136 @@ func (r *carvingRun) setEnableCarvingAtFulldepth(enable bool) {
 	r.enableCarveAtFullDepth = enable
 }
 
+// setEnableAirCutElimination is used to enable splitting each pass into the stretches that
+// remove material, skipping the stretches that were already carved by the previous passes.
+func (r *carvingRun) setEnableAirCutElimination(enable bool) {
+	r.enableAirCutElimination = enable
+}
+
+// setRemainingStock is used to give the run the stock left by the previous operations, as the
+// height the tool can go down to at each point without touching it. Passes only cut where they
+// go below the stock, as well as below the previous pass. Pass nil when the stock isn't known.
+func (r *carvingRun) setRemainingStock(stockTop func(q g.Pt2) float64) {
+	r.remainingStockTop = stockTop
+}
+
 // doOnePass is called to generate one carving pass along the run. Parameter delta must be
 // either +1 or -1. It determines wether the run goes forward or backward along the run.
-func (r *carvingRun) doOnePass(delta float64) {
+// Return ErrInternal for other values.
+func (r *carvingRun) doOnePass(delta float64) error {
 	if !r.needMorePasses {
-		return
+		return nil
 	}
 
 	if math.Abs(delta) != 1.0 {
-		log.Fatalln("Invalid delta value, should be 1.0 or -1.0")
+		return fmt.Errorf("%w: invalid pass direction %v, should be 1 or -1", ErrInternal, delta)
+	}
+
+	r.startPass()
+
+	// The starting point and end point depend on the run direction.
+	origin, end := r.startingPoint, r.endPoint
+	if delta < 0 {
+		origin, end = end, origin
+	}
+
+	points := make([]geom.Pt2, r.numSteps)
+	depths := make([]float64, r.numSteps)
+	materialTops := make([]float64, r.numSteps)
+	for s := range points {
+		pt := origin.Add(r.step.Scale(float64(s) * delta))
+		if s == r.numSteps-1 && s > 0 {
+			pt = end
+		}
+		points[s] = pt
+		depths[s], materialTops[s] = r.getPassDepthAt(pt)
+	}
+
+	if r.enableAirCutElimination {
+		r.genCuttingStretches(points, depths, materialTops)
+		return nil
+	}
+
+	r.generator.startPath(points[0].X, points[0].Y, depths[0])
+	for s := 1; s < len(points); s++ {
+		r.generator.moveTo(points[s].X, points[s].Y, depths[s])
+	}
+	r.generator.endPath(!r.passCutsMaterial)
+	return nil
+}
+
+// Generate a separate path for each stretch of the pass that goes below the top of the material
+// left by the previous passes, i.e. that removes material. Each stretch includes the points
+// just before and after it, where the tool leaves and reaches the surface carved by the
+// previous passes. Stretches separated by less than minAirCutLength are merged.
+func (r *carvingRun) genCuttingStretches(points []geom.Pt2, depths, materialTops []float64) {
+	stepLen := r.step.Len()
+	first, last := -1, -1
+	genStretch := func() {
+		r.generator.startPath(points[first].X, points[first].Y, depths[first])
+		for s := first + 1; s <= last; s++ {
+			r.generator.moveTo(points[s].X, points[s].Y, depths[s])
+		}
+		r.generator.endPath(false)
 	}
 
-	// Check wether the carving depth reaches below the old carving depth. If it doesn't we
-	// can discard the path. This is mostly useful on the very fisrt pass.
-	oldCarvingDepth := r.currentCarvingDepth
-	discardPath := true
+	for s := range points {
+		if depths[s] >= materialTops[s] {
+			continue
+		}
+
+		s0 := s - 1
+		if s0 < 0 {
+			s0 = 0
+		}
+		if first >= 0 && float64(s0-last)*stepLen >= minAirCutLength {
+			genStretch()
+			first = -1
+		}
+		if first < 0 {
+			first = s0
+		}
+
+		last = s + 1
+		if last >= len(points) {
+			last = len(points) - 1
+		}
+	}
+
+	if first >= 0 {
+		genStretch()
+	}
+}
+
+// startPass is called at the start of each carving pass to step down the carving depth.
+func (r *carvingRun) startPass() {
+	// We keep track of wether the carving depth reaches below the old carving depth. If it
+	// doesn't we can discard the path. This is mostly useful on the very fisrt pass.
+	r.previousPassDepth = r.currentCarvingDepth
+	r.passCutsMaterial = false
 
 	// If the carving depth doesn't go as deep as the deepest sampled carving depth,
 	// we'll need more passes.
 	r.needMorePasses = false
 	r.currentCarvingDepth = r.currentCarvingDepth - r.depthStepDown
+}
 
-	// fmt.Printf("*** Run y=%f, carving depth = %f, delta = %2.0f\n", r.startingPoint.Y, r.currentCarvingDepth, delta)
-	// fmt.Printf("        black=%5.2f, white=%5.2f\n", r.blackCarvingDepth, r.whiteCarvingDepth)
-
-	var origin geom.Pt2
-	for s := 0; s < r.numSteps; s++ {
-		var depth = 0.0
-		var clipped = false
-
-		if s == 0 {
-			// First step: starting point depends on run direction.
-			pt := r.startingPoint
-			if delta < 0 {
-				pt = r.endPoint
-			}
-
-			origin = pt
-
-			depth, clipped = r.getCarvingDepthAt(pt)
-			r.needMorePasses = r.needMorePasses || clipped
-			if depth < oldCarvingDepth {
-				discardPath = false
-			}
-
-			r.generator.startPath(pt.X, pt.Y, depth)
-			// fmt.Printf("  Start: %4.1f, %4.1f, %4.1f\n", pt.X, pt.Y, depth)
-		} else if s == r.numSteps-1 {
-			// Last step: end point depends on direction.
-			pt := r.startingPoint
-			if delta > 0 {
-				pt = r.endPoint
-			}
-
-			depth, clipped = r.getCarvingDepthAt(pt)
-			r.needMorePasses = r.needMorePasses || clipped
-			if depth < oldCarvingDepth {
-				discardPath = false
-			}
-
-			r.generator.moveTo(pt.X, pt.Y, depth)
-			r.generator.endPath(discardPath)
-
-			// fmt.Printf("  End: %4.1f, %4.1f, depth = %4.1f, discard = %v, more = %v\n", pt.X, pt.Y, depth, discardPath, r.needMorePasses)
-		} else {
-			stepVec := r.step.Scale(float64(s) * delta)
-			pt := origin.Add(stepVec)
-			depth, clipped = r.getCarvingDepthAt(pt)
-			r.needMorePasses = r.needMorePasses || clipped
-			if depth < oldCarvingDepth {
-				discardPath = false
-			}
-
-			r.generator.moveTo(pt.X, pt.Y, depth)
-		}
+// getPassDepthAt returns the carving depth at the given location for the current pass, and the
+// top of the material there before the pass. It keeps track of whether more passes are needed
+// and whether the pass cuts any material.
+func (r *carvingRun) getPassDepthAt(q geom.Pt2) (depth, materialTop float64) {
+	depth, clipped := r.getCarvingDepthAt(q)
+	r.needMorePasses = r.needMorePasses || clipped
+
+	materialTop = r.previousPassDepth
+	if r.remainingStockTop != nil {
+		materialTop = math.Min(materialTop, r.remainingStockTop(q))
 	}
+	if depth < materialTop {
+		r.passCutsMaterial = true
+	}
+
+	return depth, materialTop
 }
 
 // getCarvingDepthAt samples and returns the carving depth at the given location. This
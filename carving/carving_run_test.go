@@ -1,8 +1,11 @@
 package carving
 
 import (
+	"errors"
+	"math"
 	"testing"
 
+	"alvin.com/GoCarver/geom"
 	"alvin.com/GoCarver/hmap"
 )
 
@@ -163,3 +166,476 @@ func TestRunYForward(t *testing.T) {
 		t.Errorf("yCarvingRun: should be Done after one pass.\n")
 	}
 }
+
+func TestRunAngledForward(t *testing.T) {
+	r := angledCarvingRun{}
+	sampler := hmap.NewConstantDepthSampler(0)
+	gen := unitTestGenerator{}
+
+	r.configure(&sampler, &gen, geom.NewPt2(10, 20), geom.NewPt2(40, 60), 0, -0.1, 0.2)
+	if r.isDone() {
+		t.Errorf("angledCarvingRun: should not be Done right after configure.\n")
+	}
+
+	r.doOnePass(1)
+
+	if !gen.pathCompleted {
+		t.Errorf("angledCarvingRun: path left open after one pass\n")
+	}
+
+	q := gen.firstPoint
+	if q.X != 10 || q.Y != 20 {
+		t.Errorf("angledCarvingRun: 1st path point should be (10, 20) got %v\n", q)
+	}
+
+	q = gen.lastPoint
+	if q.X != 40 || q.Y != 60 {
+		t.Errorf("angledCarvingRun: last path point should be (40, 60) got %v\n", q)
+	}
+
+	if gen.lastDepth != -0.1 {
+		t.Errorf("angledCarvingRun: expected depth should be -0.1 got %f\n", gen.lastDepth)
+	}
+
+	if !r.isDone() {
+		t.Errorf("angledCarvingRun: should be Done after one pass.\n")
+	}
+}
+
+func TestCarveAtAngle(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	gen := recordingTestGenerator{}
+
+	c := NewCarver(nil)
+	c.ConfigureMaterial(geom.NewSize2(20, 20), geom.NewPt2(0, 0), geom.NewSize2(20, 20), 10)
+	c.ConfigureTool(ToolTypeFlat, 2, 500, 300)
+	c.ConfigureCarvingProfile(&sampler, 10, 9.9, 0.5, 1, CarveModeAtAngle)
+	c.ConfigureRasterAngle(45)
+	c.Run(&gen)
+
+	// The inset diagonal is 18 * sqrt(2) long, with 1 mm step-over.
+	if len(gen.paths) != 27 {
+		t.Fatalf("Carve at angle: expected 27 runs, got %d\n", len(gen.paths))
+	}
+
+	const eps = 1e-9
+	prevOffset := math.Inf(-1)
+	for i, path := range gen.paths {
+		p0 := path.points[0]
+		p1 := path.points[len(path.points)-1]
+		for _, p := range []geom.Pt3{p0, p1} {
+			if p.X < 1-eps || p.X > 19+eps || p.Y < 1-eps || p.Y > 19+eps {
+				t.Errorf("Carve at angle: run %d ends outside of the carving area: %v\n", i, p)
+			}
+		}
+
+		// Runs are at 45 degrees and go back and forth.
+		d := p1.Sub(p0)
+		if math.Abs(d.X-d.Y) > eps {
+			t.Errorf("Carve at angle: run %d is not at 45 degrees: %v\n", i, d)
+		}
+		if d.X != 0 && (d.X > 0) != (i%2 == 0) {
+			t.Errorf("Carve at angle: run %d goes in the wrong direction\n", i)
+		}
+
+		offset := (p0.Y - p0.X) / math.Sqrt2
+		if offset <= prevOffset || offset-prevOffset > 1+eps && i > 0 {
+			t.Errorf("Carve at angle: bad spacing between runs %d and %d\n", i-1, i)
+		}
+		prevOffset = offset
+	}
+}
+
+func TestRunLoop(t *testing.T) {
+	outline := newLoopOutline(geom.NewPt2(0, 0), geom.NewPt2(10, 10), 2)
+	segments := outline.appendLoop(nil, 0, 0)
+	if len(segments) != 8 {
+		t.Fatalf("loopCarvingRun: expected 4 sides and 4 corners, got %d segments\n", len(segments))
+	}
+
+	for _, delta := range []float64{1, -1} {
+		r := loopCarvingRun{}
+		sampler := hmap.NewConstantDepthSampler(0)
+		gen := recordingTestGenerator{}
+
+		r.configure(&sampler, &gen, segments, 0, -0.1, 0.2)
+		r.doOnePass(delta)
+
+		if len(gen.paths) != 1 {
+			t.Fatalf("loopCarvingRun: expected a single path, got %d\n", len(gen.paths))
+		}
+
+		path := gen.paths[0]
+		p0 := path.points[0]
+		p1 := path.points[len(path.points)-1]
+		if !p0.EqXyz(0, 2, -0.1) || !p1.EqXyz(0, 2, -0.1) {
+			t.Errorf("loopCarvingRun: path should start and end at (0, 2, -0.1), got %v, %v\n", p0, p1)
+		}
+
+		// The depth is constant, so all the corners are arcs.
+		if path.numArcs != 4 {
+			t.Errorf("loopCarvingRun: expected 4 arcs, got %d\n", path.numArcs)
+		}
+
+		if !r.isDone() {
+			t.Errorf("loopCarvingRun: should be Done after one pass.\n")
+		}
+	}
+}
+
+func TestCarveAlongLoops(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+
+	newCarver := func(carveMode int) *Carver {
+		c := NewCarver(nil)
+		c.ConfigureMaterial(geom.NewSize2(20, 20), geom.NewPt2(0, 0), geom.NewSize2(20, 20), 10)
+		c.ConfigureTool(ToolTypeFlat, 2, 500, 300)
+		c.ConfigureCarvingProfile(&sampler, 10, 9.5, 0.5, 1, carveMode)
+		c.ConfigureLoopCornerRadius(5)
+		return c
+	}
+
+	checkInside := func(path recordedPath) {
+		const eps = 1e-9
+		for _, p := range path.points {
+			if p.X < 1-eps || p.X > 19+eps || p.Y < 1-eps || p.Y > 19+eps {
+				t.Errorf("Carve along loops: point outside of the carving area: %v\n", p)
+			}
+		}
+	}
+
+	// The spiral is a single path from the outer loop to the center.
+	gen := recordingTestGenerator{}
+	newCarver(CarveModeSpiral).Run(&gen)
+	if len(gen.paths) != 1 {
+		t.Fatalf("Carve spiral: expected a single path, got %d\n", len(gen.paths))
+	}
+
+	path := gen.paths[0]
+	checkInside(path)
+	if p := path.points[0]; !p.EqXyz(1, 5, -0.5) {
+		t.Errorf("Carve spiral: expected to start at (1, 5, -0.5), got %v\n", p)
+	}
+	if p := path.points[len(path.points)-1]; !p.EqXyz(10, 10, -0.5) {
+		t.Errorf("Carve spiral: expected to end at (10, 10, -0.5), got %v\n", p)
+	}
+
+	// Concentric loops are closed paths, 1 mm apart.
+	gen = recordingTestGenerator{}
+	newCarver(CarveModeConcentric).Run(&gen)
+	if len(gen.paths) != 10 {
+		t.Fatalf("Carve concentric: expected 10 loops, got %d\n", len(gen.paths))
+	}
+
+	for i, path := range gen.paths {
+		checkInside(path)
+		p0 := path.points[0]
+		p1 := path.points[len(path.points)-1]
+		if p0.Sub(p1).Len() > 1e-9 {
+			t.Errorf("Carve concentric: loop %d is not closed: %v, %v\n", i, p0, p1)
+		}
+		if math.Abs(p0.X-(1+float64(i))) > 1e-9 {
+			t.Errorf("Carve concentric: loop %d starts at the wrong inset: %v\n", i, p0)
+		}
+	}
+}
+
+// A constant-depth sampler with a 45-degree slope across X runs for x in [xMin, xMax].
+type slopedBandTestSampler struct {
+	hmap.ConstantDepthTestSampler
+	xMin, xMax float64
+}
+
+var _ hmap.SlopeSampler = (*slopedBandTestSampler)(nil)
+
+func (s *slopedBandTestSampler) MaxSlopeAt(p geom.Pt2, dir geom.Vec2) float64 {
+	if p.X < s.xMin || p.X > s.xMax {
+		return 0
+	}
+	return math.Abs(dir.Norm().Y)
+}
+
+func TestCarveWithScallopHeight(t *testing.T) {
+	sampler := slopedBandTestSampler{
+		ConstantDepthTestSampler: hmap.NewConstantDepthSampler(0), xMin: 12, xMax: 18}
+
+	newCarver := func(scallopHeight float64) *Carver {
+		c := NewCarver(nil)
+		c.ConfigureMaterial(geom.NewSize2(20, 20), geom.NewPt2(0, 0), geom.NewSize2(20, 20), 10)
+		c.ConfigureTool(ToolTypeBallPoint, 2, 500, 300)
+		c.ConfigureCarvingProfile(&sampler, 10, 9.5, 0.9, 1, CarveModeXOnly)
+		c.ConfigureScallopHeight(scallopHeight)
+		return c
+	}
+
+	// Without a scallop height, the runs are 1.8 mm apart.
+	gen := recordingTestGenerator{}
+	newCarver(0).Run(&gen)
+	if len(gen.paths) != 11 {
+		t.Fatalf("Carve with scallop height: expected 11 runs, got %d\n", len(gen.paths))
+	}
+
+	// A 0.1 mm scallop height on flat areas needs a 0.87 mm step-over, hence 22 runs. The
+	// 45-degree band needs one extra run between each pair of runs, extended by the tool radius.
+	gen = recordingTestGenerator{}
+	newCarver(0.1).Run(&gen)
+	if len(gen.paths) != 43 {
+		t.Fatalf("Carve with scallop height: expected 43 runs, got %d\n", len(gen.paths))
+	}
+
+	const eps = 1e-9
+	prevY := math.Inf(-1)
+	for i, path := range gen.paths {
+		p0 := path.points[0]
+		p1 := path.points[len(path.points)-1]
+		if p0.Y <= prevY || p0.Y-prevY > 0.5 && i > 0 {
+			t.Errorf("Carve with scallop height: bad spacing between runs %d and %d\n", i-1, i)
+		}
+		prevY = p0.Y
+
+		xMin, xMax := math.Min(p0.X, p1.X), math.Max(p0.X, p1.X)
+		if i%2 == 0 && (math.Abs(xMin-1) > eps || math.Abs(xMax-19) > eps) {
+			t.Errorf("Carve with scallop height: run %d should span the carving area\n", i)
+		}
+		if i%2 == 1 && (math.Abs(xMin-11) > eps || math.Abs(xMax-19) > eps) {
+			t.Errorf("Carve with scallop height: run %d should span the steep band\n", i)
+		}
+	}
+}
+
+func TestCarveAtAngleWithScallopHeight(t *testing.T) {
+	sampler := slopedBandTestSampler{
+		ConstantDepthTestSampler: hmap.NewConstantDepthSampler(0), xMin: 12, xMax: 18}
+
+	newCarver := func(scallopHeight, angle float64) *Carver {
+		c := NewCarver(nil)
+		c.ConfigureMaterial(geom.NewSize2(20, 20), geom.NewPt2(0, 0), geom.NewSize2(20, 20), 10)
+		c.ConfigureTool(ToolTypeBallPoint, 2, 500, 300)
+		c.ConfigureCarvingProfile(&sampler, 10, 9.5, 0.9, 1, CarveModeAtAngle)
+		c.ConfigureRasterAngle(angle)
+		c.ConfigureScallopHeight(scallopHeight)
+		return c
+	}
+
+	// At 0 degrees, the runs are the same as along X, see TestCarveWithScallopHeight.
+	gen := recordingTestGenerator{}
+	if err := newCarver(0.1, 0).Run(&gen); err != nil {
+		t.Fatalf("Carve at angle with scallop height: unexpected error: %v\n", err)
+	}
+	if len(gen.paths) != 43 {
+		t.Fatalf("Carve at angle with scallop height: expected 43 runs, got %d\n", len(gen.paths))
+	}
+
+	// At 30 degrees, there are more runs than without a scallop height, and the extra runs
+	// stay in the carving area.
+	gen = recordingTestGenerator{}
+	newCarver(0, 30).Run(&gen)
+	numRunsWithoutScallopHeight := len(gen.paths)
+	gen = recordingTestGenerator{}
+	newCarver(0.1, 30).Run(&gen)
+	if len(gen.paths) <= 2*numRunsWithoutScallopHeight {
+		t.Errorf("Carve at angle with scallop height: expected more than %d runs, got %d\n",
+			2*numRunsWithoutScallopHeight, len(gen.paths))
+	}
+
+	const eps = 1e-9
+	for i, path := range gen.paths {
+		for _, p := range path.points {
+			if p.X < 1-eps || p.X > 19+eps || p.Y < 1-eps || p.Y > 19+eps {
+				t.Errorf("Carve at angle with scallop height: run %d leaves the carving area at "+
+					"(%.2f, %.2f)\n", i, p.X, p.Y)
+				break
+			}
+		}
+	}
+}
+
+func TestCarveWithUnsupportedScallopHeight(t *testing.T) {
+	slopedSampler := slopedBandTestSampler{
+		ConstantDepthTestSampler: hmap.NewConstantDepthSampler(0), xMin: 12, xMax: 18}
+	flatSampler := hmap.NewConstantDepthSampler(0)
+
+	tests := []struct {
+		name      string
+		sampler   hmap.ScalarGridSampler
+		carveMode int
+	}{
+		{"spiral", &slopedSampler, CarveModeSpiral},
+		{"concentric", &slopedSampler, CarveModeConcentric},
+		{"no slopes", &flatSampler, CarveModeXOnly},
+	}
+
+	for _, test := range tests {
+		c := NewCarver(nil)
+		c.ConfigureMaterial(geom.NewSize2(20, 20), geom.NewPt2(0, 0), geom.NewSize2(20, 20), 10)
+		c.ConfigureTool(ToolTypeBallPoint, 2, 500, 300)
+		c.ConfigureCarvingProfile(test.sampler, 10, 9.5, 0.9, 1, test.carveMode)
+		c.ConfigureScallopHeight(0.1)
+		gen := recordingTestGenerator{}
+		if err := c.Run(&gen); !errors.Is(err, ErrUnsupportedMode) {
+			t.Errorf("Carve with unsupported scallop height, %s: expected an error, got %v\n",
+				test.name, err)
+		}
+		if len(gen.paths) != 0 {
+			t.Errorf("Carve with unsupported scallop height, %s: expected no runs\n", test.name)
+		}
+	}
+}
+
+func TestCarveInOneDirection(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	newCarver := func(carveMode, rasterDirection, spindleDir int) *Carver {
+		c := NewCarver(nil)
+		c.ConfigureMaterial(geom.NewSize2(20, 20), geom.NewPt2(0, 0), geom.NewSize2(20, 20), 10)
+		c.ConfigureTool(ToolTypeFlat, 2, 500, 300)
+		c.ConfigureCarvingProfile(&sampler, 10, 9, 0.5, 0.5, carveMode)
+		c.ConfigureRasterDirection(rasterDirection)
+		c.ConfigureSpindleDirection(spindleDir)
+		return c
+	}
+
+	// Return the direction of each run, along X or Y.
+	getDirs := func(c *Carver, alongX bool) []float64 {
+		gen := recordingTestGenerator{}
+		c.Run(&gen)
+		dirs := make([]float64, len(gen.paths))
+		for i, path := range gen.paths {
+			d := path.points[len(path.points)-1].Sub(path.points[0])
+			dirs[i] = d.Y
+			if alongX {
+				dirs[i] = d.X
+			}
+		}
+		return dirs
+	}
+
+	// Two passes of 19 runs each. The runs along X step over along +Y, leaving the material on
+	// the left of runs along +X, and the runs along Y on the right of runs along +Y. A clockwise
+	// spindle climbs along -X and along +Y, a counterclockwise one along +X and along -Y.
+	tests := []struct {
+		carveMode, rasterDirection, spindleDir int
+		alongX                                 bool
+		positive                               bool
+	}{
+		{CarveModeXOnly, RasterClimbOnly, SpindleClockwise, true, false},
+		{CarveModeXOnly, RasterConventionalOnly, SpindleClockwise, true, true},
+		{CarveModeYOnly, RasterClimbOnly, SpindleClockwise, false, true},
+		{CarveModeYOnly, RasterConventionalOnly, SpindleClockwise, false, false},
+		{CarveModeXOnly, RasterClimbOnly, 0, true, false},
+		{CarveModeXOnly, RasterClimbOnly, SpindleCounterclockwise, true, true},
+		{CarveModeXOnly, RasterConventionalOnly, SpindleCounterclockwise, true, false},
+		{CarveModeYOnly, RasterClimbOnly, SpindleCounterclockwise, false, false},
+		{CarveModeYOnly, RasterConventionalOnly, SpindleCounterclockwise, false, true},
+	}
+	for _, test := range tests {
+		c := newCarver(test.carveMode, test.rasterDirection, test.spindleDir)
+		dirs := getDirs(c, test.alongX)
+		if len(dirs) != 38 {
+			t.Fatalf("Carve in one direction: expected 38 runs, got %d\n", len(dirs))
+		}
+		for i, d := range dirs {
+			if (d > 0) != test.positive {
+				t.Errorf("Carve in one direction: mode %d, direction %d, spindle %d: "+
+					"run %d goes the wrong way\n",
+					test.carveMode, test.rasterDirection, test.spindleDir, i)
+				break
+			}
+		}
+	}
+
+	// Back and forth, the runs alternate, starting forward.
+	dirs := getDirs(newCarver(CarveModeYOnly, RasterBackAndForth, SpindleClockwise), false)
+	for i, d := range dirs {
+		if (d > 0) != (i%2 == 0) {
+			t.Errorf("Carve back and forth: run %d goes the wrong way\n", i)
+			break
+		}
+	}
+}
+
+func TestCarveWithAirCutElimination(t *testing.T) {
+	// A 2 mm deep pocket in the middle of the carving area, carved in two passes.
+	sampler := pocketTestSampler{pMin: geom.NewPt2(8, 8), pMax: geom.NewPt2(12, 12)}
+	carve := func(enable bool) recordingTestGenerator {
+		c := NewCarver(nil)
+		c.ConfigureMaterial(geom.NewSize2(20, 20), geom.NewPt2(0, 0), geom.NewSize2(20, 20), 10)
+		c.ConfigureTool(ToolTypeFlat, 2, 500, 300)
+		c.ConfigureCarvingProfile(&sampler, 10, 8, 0.5, 1, CarveModeXOnly)
+		c.ConfigureAirCutElimination(enable)
+		gen := recordingTestGenerator{}
+		c.Run(&gen)
+		return gen
+	}
+
+	// Only the runs across the pocket cut material, five runs in two passes.
+	for _, enable := range []bool{false, true} {
+		gen := carve(enable)
+		if len(gen.paths) != 10 {
+			t.Fatalf("Air-cut elimination %v: expected 10 paths, got %d\n", enable, len(gen.paths))
+		}
+
+		for i, path := range gen.paths {
+			xMin, xMax := math.Inf(1), math.Inf(-1)
+			for _, p := range path.points {
+				xMin, xMax = math.Min(xMin, p.X), math.Max(xMax, p.X)
+			}
+
+			// Without elimination, the paths go across the whole carving area. Otherwise, they
+			// stop one sample away from the pocket.
+			if !enable && (xMin > 1+1e-9 || xMax < 19-1e-9) {
+				t.Errorf("Air-cut elimination off: path %d doesn't span the area\n", i)
+			}
+			if enable && (xMin < 8-1.1 || xMin > 8 || xMax > 12+1.1 || xMax < 12) {
+				t.Errorf("Air-cut elimination on: path %d spans [%f, %f]\n", i, xMin, xMax)
+			}
+		}
+	}
+}
+
+// A sampler with a second pocket. See pocketTestSampler.
+type twoPocketsTestSampler struct {
+	pocketTestSampler
+	other pocketTestSampler
+}
+
+func (s *twoPocketsTestSampler) At(q geom.Pt2) float64 {
+	return math.Min(s.pocketTestSampler.At(q), s.other.At(q))
+}
+
+func TestRunWithAirCutElimination(t *testing.T) {
+	newRun := func(gap float64) (*xCarvingRun, *recordingTestGenerator) {
+		sampler := twoPocketsTestSampler{
+			pocketTestSampler: pocketTestSampler{pMin: geom.NewPt2(10, -1), pMax: geom.NewPt2(20, 1)},
+			other:             pocketTestSampler{pMin: geom.NewPt2(20+gap, -1), pMax: geom.NewPt2(40, 1)},
+		}
+		gen := &recordingTestGenerator{}
+		r := &xCarvingRun{}
+		r.configure(&sampler, gen, 50, 0, 0, 0, -1, 1)
+		r.setEnableAirCutElimination(true)
+		return r, gen
+	}
+
+	// Pockets 5 mm apart are cut separately.
+	r, gen := newRun(5)
+	r.doOnePass(1)
+	if len(gen.paths) != 2 {
+		t.Fatalf("Air-cut elimination: expected 2 paths, got %d\n", len(gen.paths))
+	}
+
+	// Each path starts and ends at the surface, one sample away from its pocket.
+	for i, pocket := range [][]float64{{10, 20}, {25, 40}} {
+		path := gen.paths[i]
+		p0, p1 := path.points[0], path.points[len(path.points)-1]
+		if p0.Z != 0 || p0.X >= pocket[0] || p0.X < pocket[0]-1.1 ||
+			p1.Z != 0 || p1.X <= pocket[1] || p1.X > pocket[1]+1.1 {
+			t.Errorf("Air-cut elimination: unexpected path %d from %v to %v\n", i, p0, p1)
+		}
+	}
+
+	// Pockets 2 mm apart are cut in a single path.
+	r, gen = newRun(2)
+	r.doOnePass(1)
+	if len(gen.paths) != 1 {
+		t.Fatalf("Air-cut elimination: expected 1 path, got %d\n", len(gen.paths))
+	}
+}
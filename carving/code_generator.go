@@ -1,9 +1,16 @@
 package carving
 
-import "io"
+import (
+	"io"
 
-// codeGenerator defines an interface through which the output code is emitted to a writer.
-// An example of code generator is the GRBL generator (grblGenerator).
+	"alvin.com/GoCarver/stock"
+)
+
+// codeGenerator defines an interface through which the output code is emitted to a writer. The
+// Run methods of the operations, e.g. Carver.Run, emit their paths through it. It is internal
+// to the package, with a single implementation, the G-code generator (gcodeGenerator), which
+// writes the code of every dialect. Other packages generate the code with DoMachining, and
+// support a new controller by registering a PostProcessor rather than with a code generator.
 type codeGenerator interface {
 	// Configure the generator with the outpout writer and material dimensions.
 	configure(codeWriter io.Writer, matWidth, matHeight, matThickness float64)
@@ -18,6 +25,36 @@ type codeGenerator interface {
 	// Change the vertical fee rate to <newFeedRateMmPerMin> and return the old feed rate.
 	changeVerticalFeedRate(newFeedRateMmPerMin float64) float64
 
+	// Change the tool to tool number <toolNumber>, described by <description>. The generator
+	// does nothing if the tool is already in use.
+	changeTool(toolNumber int, description string)
+
+	// Set the spindle and the coolant for the tool in use. The generator only emits the commands
+	// that change the state of the spindle or of the coolant.
+	setSpindle(spindle SpindleConfig)
+
+	// Set the linker that decides whether the tool can stay down between the end of a path and
+	// the start of the next path, or nil to always retract between paths.
+	setStayDownLinker(linker *stayDownLinker)
+
+	// Set the planner that works out how high the material may be between paths, used with
+	// RetractMinimum, or nil to retract to the retract height.
+	setRetractPlanner(planner *retractPlanner)
+
+	// Set how the tool goes down at the start of each path.
+	setEntry(entry EntryConfig)
+
+	// Set the planner that decides where helix entries fit beside the paths, or nil to ramp
+	// down instead of going down along a helix.
+	setEntryPlanner(planner *entryPlanner)
+
+	// Set whether the tool moves from path to path with rapid moves, however close the paths.
+	setRapidRepositioning(enable bool)
+
+	// Set the cutter of the tool in use and its maximum step-down, used to track the remaining
+	// stock and to slow down the moves that cut deeper than the step-down.
+	setStockCutter(cutter stock.Cutter, maxStepDown float64)
+
 	// Each carving path constitutes of a series of 3D linear segments. The starting point is
 	// set with startPath. The subsequent points along the path are set with moveTo. Finally,
 	// the path is terminmated with a call to endPath. If discardPath is true, the generator
@@ -0,0 +1,184 @@
+package carving
+
+import (
+	"math"
+
+	g "alvin.com/GoCarver/geom"
+)
+
+const (
+	ContourAroundCarvingArea = 300
+	ContourAroundMaterial    = 301
+)
+
+// ContourCutter provides support for generating the code to cut the carving area, or the
+// whole material, free along a rounded-rectangle outline. The outline is cut in several
+// passes, determined by the max step-down size, down to the bottom of the material. Holding
+// tabs can be left along each side of the outline to keep the part attached to the stock.
+// The contour tool is a flat or a bull-nose end mill. A bull-nose tool goes one corner radius
+// below the bottom of the material, so that the wall of the part is vertical all the way down.
+// Usage:
+// 1. Create a contour cutter with NewContourCutter.
+// 2. Configure the various contour parameters with the ConfigureXXX functions.
+// 3. Generate the contour code by calling the Run function.
+type ContourCutter struct {
+	outlineBottomLeft g.Pt2
+	outlineDimMm      g.Size2
+	cornerRadiusMm    float64
+	cutDepth          float64 // Final depth of the cut, always negative.
+
+	toolDiameterMm     float64
+	toolCornerRadiusMm float64 // Corner radius of a bull-nose tool, zero for a flat tool.
+	maxStepDown        float64
+
+	numTabsPerSide int
+	tabWidthMm     float64
+	tabHeightMm    float64
+}
+
+// NewContourCutter creates and returns a new contour cutter.
+func NewContourCutter() *ContourCutter {
+	return &ContourCutter{}
+}
+
+// ConfigureOutline is used to configure the outline to cut out. The outline is a rectangle
+// with rounded corners. The cut goes all the way through a material with the given thickness.
+func (c *ContourCutter) ConfigureOutline(
+	outlineBottomLeft g.Pt2,
+	outlineDimMm g.Size2,
+	cornerRadiusMm float64,
+	materialThicknessMm float64) {
+
+	c.outlineBottomLeft = outlineBottomLeft
+	c.outlineDimMm = outlineDimMm
+	c.cornerRadiusMm = math.Max(0, cornerRadiusMm)
+	c.cornerRadiusMm = math.Min(c.cornerRadiusMm, 0.5*math.Min(outlineDimMm.W, outlineDimMm.H))
+	c.cutDepth = -math.Abs(materialThicknessMm)
+}
+
+// ConfigureTool is used to configure the contour-tool parameters.
+func (c *ContourCutter) ConfigureTool(toolDiameterMm float64, maxStepDownSizeMm float64) {
+	c.toolDiameterMm = toolDiameterMm
+	c.maxStepDown = math.Abs(maxStepDownSizeMm)
+}
+
+// ConfigureToolCornerRadius is used to configure the corner radius of a bull-nose contour
+// tool. The default is zero, for a flat tool.
+func (c *ContourCutter) ConfigureToolCornerRadius(cornerRadiusMm float64) {
+	c.toolCornerRadiusMm = math.Max(0, cornerRadiusMm)
+}
+
+// ConfigureTabs is used to configure the holding tabs left along each side of the outline.
+// The tab width is measured on the part, at the top of the tab.
+func (c *ContourCutter) ConfigureTabs(numTabsPerSide int, tabWidthMm, tabHeightMm float64) {
+	c.numTabsPerSide = numTabsPerSide
+	c.tabWidthMm = tabWidthMm
+	c.tabHeightMm = tabHeightMm
+}
+
+// Run is called to generate the contour code. Each pass is a single closed path that goes
+// clockwise around the outline. With a clockwise spindle rotation, this climb-mills the part.
+// Helix entries go on the left of the paths, outside the outline, so as not to cut the part.
+func (c *ContourCutter) Run(gen codeGenerator) {
+	if c.outlineDimMm.W <= 0 || c.outlineDimMm.H <= 0 || c.cutDepth >= 0 {
+		return
+	}
+
+	gen.setEntryPlanner(newClearSideEntryPlanner(true /* clear on left */))
+	defer gen.setEntryPlanner(nil)
+
+	bottom := c.cutDepth - c.toolCornerRadiusMm
+	numPasses := 1
+	if c.maxStepDown > 0 {
+		numPasses = int(math.Ceil(-bottom/c.maxStepDown - 0.001))
+		if numPasses < 1 {
+			numPasses = 1
+		}
+	}
+
+	stepDown := -bottom / float64(numPasses)
+	for i := 1; i <= numPasses; i++ {
+		depth := -float64(i) * stepDown
+		if i == numPasses {
+			depth = bottom
+		}
+
+		c.genOnePass(depth, gen)
+	}
+}
+
+// Generate a single closed pass around the tool-radius-offset outline at the given depth. The
+// path starts on the left side, just above the bottom-left corner and goes clockwise.
+func (c *ContourCutter) genOnePass(depth float64, gen codeGenerator) {
+	toolRadius := 0.5 * c.toolDiameterMm
+	r := c.cornerRadiusMm + toolRadius
+	x0 := c.outlineBottomLeft.X - toolRadius
+	y0 := c.outlineBottomLeft.Y - toolRadius
+	x1 := c.outlineBottomLeft.X + c.outlineDimMm.W + toolRadius
+	y1 := c.outlineBottomLeft.Y + c.outlineDimMm.H + toolRadius
+
+	gen.startPath(x0, y0+r, depth)
+
+	// Left side going up, then top-left corner.
+	c.genSideWithTabs(g.NewPt2(x0, y0+r), g.NewPt2(x0, y1-r), depth, gen)
+	gen.clockwiseArcTo(x0+r, y1, depth, r)
+
+	// Top side going right, then top-right corner.
+	c.genSideWithTabs(g.NewPt2(x0+r, y1), g.NewPt2(x1-r, y1), depth, gen)
+	gen.clockwiseArcTo(x1, y1-r, depth, r)
+
+	// Right side going down, then bottom-right corner.
+	c.genSideWithTabs(g.NewPt2(x1, y1-r), g.NewPt2(x1, y0+r), depth, gen)
+	gen.clockwiseArcTo(x1-r, y0, depth, r)
+
+	// Bottom side going left, then bottom-left corner back to the starting point.
+	c.genSideWithTabs(g.NewPt2(x1-r, y0), g.NewPt2(x0+r, y0), depth, gen)
+	gen.clockwiseArcTo(x0, y0+r, depth, r)
+
+	gen.endPath(false)
+}
+
+// Generate the straight side of the outline from p0 to p1, at the given depth. The tool is
+// assumed to already be at p0. When the pass depth reaches below the top of the tabs, the
+// tool ramps up at 45 degrees over each tab and back down after it.
+func (c *ContourCutter) genSideWithTabs(p0, p1 g.Pt2, depth float64, gen codeGenerator) {
+	side := p1.Sub(p0)
+	sideLen := side.Len()
+
+	tabTop := c.cutDepth + c.tabHeightMm
+	lift := tabTop - depth
+	if c.numTabsPerSide <= 0 || c.tabHeightMm <= 0 || lift <= 0 || sideLen < epsilon {
+		gen.moveTo(p1.X, p1.Y, depth)
+		return
+	}
+
+	// Along the tool path, the flat top of each tab spans the tab width plus the tool
+	// diameter. Ramps are added on each side of the flat top.
+	dir := side.Scale(1.0 / sideLen)
+	halfTop := 0.5 * (c.tabWidthMm + c.toolDiameterMm)
+	halfBase := halfTop + lift
+
+	pointAt := func(s float64) g.Pt2 {
+		return p0.Add(dir.Scale(s))
+	}
+
+	prevTabEnd := 0.0
+	for i := 0; i < c.numTabsPerSide; i++ {
+		center := sideLen * float64(i+1) / float64(c.numTabsPerSide+1)
+		if center-halfBase < prevTabEnd || center+halfBase > sideLen {
+			continue // The tab doesn't fit along this side.
+		}
+		prevTabEnd = center + halfBase
+
+		q := pointAt(center - halfBase)
+		gen.moveTo(q.X, q.Y, depth)
+		q = pointAt(center - halfTop)
+		gen.moveTo(q.X, q.Y, tabTop)
+		q = pointAt(center + halfTop)
+		gen.moveTo(q.X, q.Y, tabTop)
+		q = pointAt(center + halfBase)
+		gen.moveTo(q.X, q.Y, depth)
+	}
+
+	gen.moveTo(p1.X, p1.Y, depth)
+}
@@ -0,0 +1,92 @@
+package carving
+
+import (
+	"testing"
+
+	"alvin.com/GoCarver/geom"
+	a "gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestContourPasses(t *testing.T) {
+	c := NewContourCutter()
+	c.ConfigureOutline(geom.NewPt2(10, 10), geom.NewSize2(100, 50), 5, 6)
+	c.ConfigureTool(4, 2.5)
+	c.ConfigureTabs(0, 4, 1)
+
+	gen := recordingTestGenerator{}
+	c.Run(&gen)
+
+	// 6mm at 2.5mm max step-down requires 3 passes of 2mm each.
+	a.Assert(t, is.Len(gen.paths, 3))
+	for i, p := range gen.paths {
+		depth := -2.0 * float64(i+1)
+		a.Equal(t, p.numArcs, 4)
+		for _, q := range p.points {
+			a.Equal(t, q.Z, depth)
+		}
+
+		// Path starts and ends on the left side of the outline, offset by the tool radius,
+		// just above the bottom-left rounded corner.
+		first := p.points[0]
+		last := p.points[len(p.points)-1]
+		a.DeepEqual(t, first, geom.NewPt3(8, 15, depth))
+		a.DeepEqual(t, last, first)
+	}
+}
+
+func TestContourBullNose(t *testing.T) {
+	c := NewContourCutter()
+	c.ConfigureOutline(geom.NewPt2(10, 10), geom.NewSize2(100, 50), 5, 6)
+	c.ConfigureTool(4, 2.5)
+	c.ConfigureToolCornerRadius(1.5)
+	c.ConfigureTabs(0, 4, 1)
+
+	gen := recordingTestGenerator{}
+	c.Run(&gen)
+
+	// The last pass goes one corner radius below the material, for a vertical wall down to
+	// the bottom of the part: 7.5mm at 2.5mm max step-down requires 3 passes.
+	a.Assert(t, is.Len(gen.paths, 3))
+	for i, p := range gen.paths {
+		depth := -2.5 * float64(i+1)
+		for _, q := range p.points {
+			a.Equal(t, q.Z, depth)
+		}
+	}
+}
+
+func TestContourTabs(t *testing.T) {
+	c := NewContourCutter()
+	c.ConfigureOutline(geom.NewPt2(0, 0), geom.NewSize2(100, 100), 0, 3)
+	c.ConfigureTool(2, 1)
+	c.ConfigureTabs(2, 4, 1.5)
+
+	gen := recordingTestGenerator{}
+	c.Run(&gen)
+	a.Assert(t, is.Len(gen.paths, 3))
+
+	// The first pass is above the tabs and has no tabs.
+	a.Equal(t, len(gen.paths[0].points), 9)
+
+	// The other passes go over two tabs per side, each adding four points.
+	tabTop := -3.0 + 1.5
+	for _, p := range gen.paths[1:] {
+		a.Equal(t, len(p.points), 9+4*4*2)
+
+		numAtTabTop := 0
+		for _, q := range p.points {
+			a.Assert(t, q.Z <= tabTop)
+			if q.Z == tabTop {
+				numAtTabTop++
+			}
+		}
+		a.Equal(t, numAtTabTop, 2*4*2)
+	}
+
+	// Check the flat top of the first tab on the left side: it is centered a third of the
+	// way along the side and spans the tab width plus the tool diameter.
+	p := gen.paths[2].points
+	a.Assert(t, p[2].Sub(geom.NewPt3(-1, 100.0/3-3, tabTop)).Len() < 1e-9)
+	a.Assert(t, p[3].Sub(geom.NewPt3(-1, 100.0/3+3, tabTop)).Len() < 1e-9)
+}
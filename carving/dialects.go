@@ -0,0 +1,305 @@
+package carving
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/stock"
+)
+
+// The post-processors shipped with the carver. They only differ by their preamble, program
+// end, tool changes, comments and arc format, so they are all described by a dialect.
+func init() {
+	// GRBL ignores tool numbers and M6 unless built for a tool changer. Homing the Z axis with
+	// G28 goes to the position stored with G28.1.
+	RegisterPostProcessor(&dialect{
+		name:          "grbl",
+		preamble:      []string{"G90", "G17", "G21", "G28 G91 Z0", "G90"},
+		programEnd:    []string{"G28 G91 Z0", "M30"},
+		selectTool:    []string{"T%d M6"},
+		pause:         "M0",
+		machineCoords: true,
+		commentFormat: "(%s)",
+	})
+
+	// LinuxCNC starts from a known modal state and applies the tool length offset after each
+	// tool change. Arc centers are relative to the start of the arc, the default G91.1 mode.
+	RegisterPostProcessor(&dialect{
+		name:          "linuxcnc",
+		preamble:      []string{"G17 G21 G40 G49 G80 G90 G94"},
+		programEnd:    []string{"M2"},
+		selectTool:    []string{"T%d M6", "G43 H%d"},
+		pause:         "M0",
+		machineCoords: true,
+		commentFormat: "(%s)",
+		arcCenters:    true,
+	})
+
+	// Mach3 and Mach4 can be set up for absolute or relative arc centers, so the preamble
+	// selects relative ones with G91.1.
+	RegisterPostProcessor(&dialect{
+		name:          "mach",
+		preamble:      []string{"G17 G21 G40 G49 G80 G90 G94 G91.1"},
+		programEnd:    []string{"M30"},
+		selectTool:    []string{"T%d M6", "G43 H%d"},
+		pause:         "M0",
+		machineCoords: true,
+		commentFormat: "(%s)",
+		arcCenters:    true,
+	})
+
+	// Marlin has no tool changer, T selects an extruder. Machine coordinates need the optional
+	// CNC coordinate systems, so the tool is changed where it stands. M30 would delete the file
+	// from the SD card, so the program ends by waiting for the moves to complete. Dwells are in
+	// milliseconds.
+	RegisterPostProcessor(&dialect{
+		name:          "marlin",
+		preamble:      []string{"G21", "G90"},
+		programEnd:    []string{"M400"},
+		pause:         "M0",
+		commentFormat: ";%s",
+		arcCenters:    true,
+		dwellMillis:   true,
+	})
+
+	// Smoothieware has no tool changer and pauses with M600, until resumed with M601 or from
+	// the panel. It only takes arcs given by their center. As with Marlin, M30 deletes a file
+	// and dwells are in milliseconds.
+	RegisterPostProcessor(&dialect{
+		name:          "smoothieware",
+		preamble:      []string{"G90", "G17", "G21"},
+		programEnd:    []string{"M400"},
+		pause:         "M600",
+		machineCoords: true,
+		commentFormat: "(%s)",
+		arcCenters:    true,
+		dwellMillis:   true,
+	})
+}
+
+// A dialect implements the PostProcessor interface for the controllers that take the usual
+// G0/G1/G2/G3 moves.
+type dialect struct {
+	name       string
+	preamble   []string
+	programEnd []string
+
+	// Formats of the lines that select a tool, each one given the tool number, or nil without
+	// a tool changer.
+	selectTool []string
+	// The command that pauses the program until the operator resumes it.
+	pause string
+	// Whether the tool can be parked in machine coordinates, with G53.
+	machineCoords bool
+
+	commentFormat string
+	arcCenters    bool // Whether arcs are given by their center, with I and J, or their radius.
+	dwellMillis   bool // Whether dwells are given in milliseconds, or seconds.
+
+	inches bool // Whether the code is written in inches, see WithUnits.
+}
+
+var _ PostProcessor = (*dialect)(nil)
+
+// Lengths are formatted with the number of decimals of their units, as given by the dialect.
+const (
+	rapidMoveToXyzFormat  = "G0 X%s Y%s Z%s"
+	rapidMoveToZFormat    = "G0 Z%s"
+	linearMoveToZFormat   = "G1 Z%s F%s"
+	linearMoveToXyzFormat = "G1 X%s Y%s Z%s F%s"
+	arcByRadiusFormat     = "G%d X%s Y%s Z%s R%s F%s"
+	arcByCenterFormat     = "G%d X%s Y%s Z%s I%s J%s F%s"
+
+	spindleClockwiseFormat        = "M3 S%.0f"
+	spindleCounterclockwiseFormat = "M4 S%.0f"
+	dwellSecondsFormat            = "G4 P%.1f"
+	dwellMillisFormat             = "G4 P%.0f"
+
+	machineRapidMoveToZFormat  = "G53 G0 Z%s"
+	machineRapidMoveToXyFormat = "G53 G0 X%s Y%s"
+
+	millimeterDecimals = 2
+	inchDecimals       = 4
+)
+
+func (d *dialect) Name() string {
+	return d.name
+}
+
+// WithUnits returns the dialect writing the code in the given units. Inches are selected with
+// G20 instead of G21, and written with four decimals.
+func (d *dialect) WithUnits(units int) PostProcessor {
+	withUnits := *d
+	withUnits.inches = units == UnitsInches
+	return &withUnits
+}
+
+func (d *dialect) Preamble() []string {
+	if !d.inches {
+		return d.preamble
+	}
+
+	lines := make([]string, len(d.preamble))
+	for i, line := range d.preamble {
+		words := strings.Fields(line)
+		for j, w := range words {
+			if w == "G21" {
+				words[j] = "G20"
+			}
+		}
+		lines[i] = strings.Join(words, " ")
+	}
+	return lines
+}
+
+func (d *dialect) ProgramEnd() []string {
+	return d.programEnd
+}
+
+// Comments cannot contain parentheses, nor line breaks.
+func (d *dialect) Comment(text string) string {
+	text = strings.NewReplacer("(", "", ")", "", "\n", " ").Replace(text)
+	return fmt.Sprintf(d.commentFormat, text)
+}
+
+func (d *dialect) SelectWorkOffset(n int) string {
+	return fmt.Sprintf("G%d", 53+n)
+}
+
+func (d *dialect) SelectTool(toolNumber int) []string {
+	if d.selectTool == nil {
+		return nil
+	}
+
+	lines := make([]string, len(d.selectTool))
+	for i, format := range d.selectTool {
+		lines[i] = fmt.Sprintf(format, toolNumber)
+	}
+	return lines
+}
+
+func (d *dialect) ManualToolChange(toolNumber int, park geom.Pt3) []string {
+	var lines []string
+	if d.machineCoords {
+		lines = append(lines,
+			fmt.Sprintf(machineRapidMoveToZFormat, d.length(park.Z)),
+			fmt.Sprintf(machineRapidMoveToXyFormat, d.length(park.X), d.length(park.Y)))
+	}
+	return append(lines,
+		d.Comment(fmt.Sprintf("Change to tool T%d, set Z zero, then resume", toolNumber)),
+		d.pause)
+}
+
+func (d *dialect) SpindleOn(speed float64, clockwise bool) string {
+	if clockwise {
+		return fmt.Sprintf(spindleClockwiseFormat, speed)
+	}
+	return fmt.Sprintf(spindleCounterclockwiseFormat, speed)
+}
+
+func (d *dialect) SpindleOff() string {
+	return "M5"
+}
+
+func (d *dialect) Dwell(seconds float64) string {
+	if d.dwellMillis {
+		return fmt.Sprintf(dwellMillisFormat, 1000*seconds)
+	}
+	return fmt.Sprintf(dwellSecondsFormat, seconds)
+}
+
+func (d *dialect) Coolant(mode int) string {
+	switch mode {
+	case CoolantMist:
+		return "M7"
+	case CoolantFlood:
+		return "M8"
+	default:
+		return "M9"
+	}
+}
+
+func (d *dialect) RapidMoveToZ(z float64) string {
+	return fmt.Sprintf(rapidMoveToZFormat, d.length(z))
+}
+
+func (d *dialect) RapidMoveToXyz(p geom.Pt3) string {
+	return fmt.Sprintf(rapidMoveToXyzFormat, d.length(p.X), d.length(p.Y), d.length(p.Z))
+}
+
+func (d *dialect) LinearMoveToZ(z, feedRate float64) string {
+	return fmt.Sprintf(linearMoveToZFormat, d.length(z), d.rate(feedRate))
+}
+
+func (d *dialect) LinearMoveToXyz(p geom.Pt3, feedRate float64) string {
+	return fmt.Sprintf(linearMoveToXyzFormat, d.length(p.X), d.length(p.Y), d.length(p.Z),
+		d.rate(feedRate))
+}
+
+func (d *dialect) ArcTo(arc Arc, feedRate float64) string {
+	g := 3
+	if arc.Clockwise {
+		g = 2
+	}
+
+	to, feed := arc.To, d.rate(feedRate)
+	if d.arcCenters {
+		// Controllers check that both ends of the arc are at the same distance from the
+		// center, so the center is worked out from the ends as written, in the output units.
+		from, to := d.roundPt3(arc.From), d.roundPt3(arc.To)
+		center := stock.ArcCenter(from, to, d.toUnits(arc.Radius), arc.Clockwise)
+		return fmt.Sprintf(arcByCenterFormat, g,
+			d.format(to.X), d.format(to.Y), d.format(to.Z),
+			d.formatOffset(center.X-from.X), d.formatOffset(center.Y-from.Y), feed)
+	}
+	return fmt.Sprintf(arcByRadiusFormat, g, d.length(to.X), d.length(to.Y), d.length(to.Z),
+		d.length(arc.Radius), feed)
+}
+
+// Return the number of decimals of the lengths in the output units.
+func (d *dialect) decimals() int {
+	if d.inches {
+		return inchDecimals
+	}
+	return millimeterDecimals
+}
+
+// Return the length v, in millimeters, in the output units.
+func (d *dialect) toUnits(v float64) float64 {
+	if d.inches {
+		return v / 25.4
+	}
+	return v
+}
+
+// Return the length v, in millimeters, formatted in the output units.
+func (d *dialect) length(v float64) string {
+	return d.format(d.toUnits(v))
+}
+
+// Return the feed rate v, in millimeters per minute, formatted in the output units per minute.
+func (d *dialect) rate(v float64) string {
+	return strconv.FormatFloat(d.toUnits(v), 'f', 2, 64)
+}
+
+// Return the value v, in the output units, formatted with the decimals of the output units.
+func (d *dialect) format(v float64) string {
+	return strconv.FormatFloat(v, 'f', d.decimals(), 64)
+}
+
+// Return the arc center offset v, in the output units, with two more decimals than the ends of
+// the arc and without a negative zero.
+func (d *dialect) formatOffset(v float64) string {
+	scale := math.Pow10(d.decimals() + 2)
+	return strconv.FormatFloat(math.Round(v*scale)/scale+0, 'f', d.decimals()+2, 64)
+}
+
+// Return the point p, in millimeters, in the output units and rounded as written in the code.
+func (d *dialect) roundPt3(p geom.Pt3) geom.Pt3 {
+	scale := math.Pow10(d.decimals())
+	round := func(v float64) float64 { return math.Round(d.toUnits(v)*scale) / scale }
+	return geom.NewPt3(round(p.X), round(p.Y), round(p.Z))
+}
@@ -0,0 +1,14 @@
+package carving
+
+import "errors"
+
+// Errors returned when generating the code for a job. They are wrapped with the details of the
+// failure, so use errors.Is to check for them.
+var (
+	ErrInvalidTool      = errors.New("carving: invalid tool")
+	ErrInvalidMaterial  = errors.New("carving: invalid material")
+	ErrEmptyCarvingArea = errors.New("carving: empty carving area")
+	ErrUnsupportedMode  = errors.New("carving: unsupported mode")
+	ErrInvalidParameter = errors.New("carving: invalid parameter")
+	ErrInternal         = errors.New("carving: internal error")
+)
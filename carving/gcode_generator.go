@@ -0,0 +1,919 @@
+package carving
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/stock"
+)
+
+type pt3 = geom.Pt3      // Shorthand for Pt3
+type componentFlavor int // Enum type for path component flavors.
+
+// Path components are of two flavors:
+// - Line segments, defined as an array of points. The first point of each line-segment component
+//   is always equal to the last point of the preceding component.
+// - A single arc. In this case, the component consists of exactly two points p0, p1
+//   as follows:
+//    x0 = +1 for clockwise arc, -1 for counterclockwise arc.
+//    y0 = radius.
+//    z0 = z at start of arc.
+//    (x1, y1) = end point of arc.
+//    z1 = z at end of arc.
+type pathComponent struct {
+	flavor componentFlavor
+	points []pt3
+}
+
+const (
+	initialPathBufferSize = 40
+
+	epsilon     = 1e-5
+	epsilonSqrd = epsilon * epsilon
+
+	flatnessTolerance     = 0.04
+	flatnessToleranceSqrd = flatnessTolerance * flatnessTolerance
+
+	proximityTolerance     = 0.15
+	proximityToleranceSqrd = proximityTolerance * proximityTolerance
+
+	lineSegmentsComponent componentFlavor = iota
+	arcComponent
+
+	clockwiseArc        = 1.0
+	counterclockwiseArc = -1.0
+)
+
+// gcodeGenerator implements the codeGenerator interface to generate G-code. The code is written
+// in the dialect of its post-processor, GRBL unless configured otherwise.
+type gcodeGenerator struct {
+	horizFeedRate float64
+	vertFeedRate  float64
+
+	toolChangeMode int      // One of the ToolChangeXXX modes.
+	parkPosition   geom.Pt3 // Machine position for manual tool changes.
+	currentTool    int      // Number of the tool in use, or 0 before the first tool.
+
+	// The state of the spindle and of the coolant, both off when zero.
+	spindle SpindleConfig
+
+	enableArcFitting bool        // Whether to replace points along arcs with G2/G3 moves.
+	entry            EntryConfig // How the tool goes down at the start of each path.
+	rapidReposition  bool        // Whether to always move from path to path with rapid moves.
+	retract          RetractConfig
+
+	// The work zero, relative to the bottom-left corner of the top of the material, the work
+	// offset to select, if not 0, and the description of the work zero for the header.
+	workOrigin     geom.Pt3
+	workOffset     int
+	workOriginDesc string
+
+	// Works out how high the material may be between paths, if not nil. See RetractMinimum.
+	retractPlanner *retractPlanner
+
+	// Decides where helix entries fit, if not nil. Without it, helixes are replaced by ramps.
+	entryPlanner *entryPlanner
+
+	// Decides whether the tool can stay down between paths, if not nil. The tool can only stay
+	// down when it is still at the end of the previous path.
+	linker      *stayDownLinker
+	isAtPathEnd bool
+
+	// The model of the remaining stock and the cutter in use, if not nil, with the maximum
+	// step-down of the tool. See configureStock.
+	stock            *stock.Stock
+	stockCutter      stock.Cutter
+	stockMaxStepDown float64
+
+	// A path consists of a series of successive components.
+	path          []pathComponent
+	startingPoint pt3
+
+	currentLoc pt3
+	codeOut    io.Writer
+	post       PostProcessor
+
+	// The first error met while generating the code, if any. See getError.
+	err error
+}
+
+var _ codeGenerator = (*gcodeGenerator)(nil)
+
+func newGcodeGenerator(horizFeedRate, vertFeedRate float64) *gcodeGenerator {
+	post, _ := GetPostProcessor(DefaultPostProcessor)
+	return &gcodeGenerator{
+		horizFeedRate: horizFeedRate,
+		vertFeedRate:  vertFeedRate,
+		retract:       RetractConfig{}.withDefaults(),
+		post:          post,
+	}
+}
+
+func (g *gcodeGenerator) configure(
+	output io.Writer,
+	matWidth, matHeight, matThickness float64) {
+
+	g.codeOut = &gcodeWriter{gen: g, w: output}
+}
+
+// Return the first error met while generating the code, such as an error writing the output.
+// The generator keeps going after an error, but the code is incomplete.
+func (g *gcodeGenerator) getError() error {
+	return g.err
+}
+
+// Record the error, unless an earlier error was already recorded.
+func (g *gcodeGenerator) setError(err error) {
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// gcodeWriter records the errors writing the output of the generator and stops writing after
+// the first one.
+type gcodeWriter struct {
+	gen *gcodeGenerator
+	w   io.Writer
+}
+
+func (w *gcodeWriter) Write(p []byte) (int, error) {
+	if w.gen.err != nil {
+		return 0, w.gen.err
+	}
+
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.gen.setError(err)
+	}
+	return n, err
+}
+
+// Configure the post-processor that writes the code in the dialect of the machine controller.
+func (g *gcodeGenerator) configurePostProcessor(post PostProcessor) {
+	g.post = post
+}
+
+// Configure how tools are changed between operations. With ToolChangeWithPause, the tool is
+// parked at the given position, in machine coordinates, and the program pauses.
+func (g *gcodeGenerator) configureToolChange(toolChangeMode int, parkPosition geom.Pt3) {
+	g.toolChangeMode = toolChangeMode
+	g.parkPosition = parkPosition
+}
+
+// Configure whether runs of points that lie on circular arcs in the XY plane are replaced
+// with helical G2/G3 moves.
+func (g *gcodeGenerator) configureArcFitting(enable bool) {
+	g.enableArcFitting = enable
+}
+
+// Configure the work zero. All the coordinates are translated by -origin when written. The
+// description is written as a comment at the start of the job, if not empty.
+func (g *gcodeGenerator) configureWorkOrigin(origin geom.Pt3, workOffset int, description string) {
+	g.workOrigin = origin
+	g.workOffset = workOffset
+	g.workOriginDesc = description
+}
+
+// Configure the heights the tool goes up to, using the default values for the zero fields.
+func (g *gcodeGenerator) configureRetract(retract RetractConfig) {
+	g.retract = retract.withDefaults()
+}
+
+func (g *gcodeGenerator) setEntry(entry EntryConfig) {
+	g.entry = entry
+}
+
+func (g *gcodeGenerator) setRapidRepositioning(enable bool) {
+	g.rapidReposition = enable
+}
+
+func (g *gcodeGenerator) changeHorizontalFeedRate(newFeedRateMmPerMin float64) float64 {
+	retVal := g.horizFeedRate
+	g.horizFeedRate = newFeedRateMmPerMin
+	return retVal
+}
+
+func (g *gcodeGenerator) changeVerticalFeedRate(newFeedRateMmPerMin float64) float64 {
+	retVal := g.vertFeedRate
+	g.vertFeedRate = newFeedRateMmPerMin
+	return retVal
+}
+
+func (g *gcodeGenerator) changeTool(toolNumber int, description string) {
+	if toolNumber == g.currentTool {
+		return
+	}
+
+	// When pausing for tool changes, the first tool is expected to be in the spindle already.
+	isFirstTool := g.currentTool == 0
+	g.currentTool = toolNumber
+	g.isAtPathEnd = false
+	g.writeComment(fmt.Sprintf("T%d: %s", toolNumber, description))
+	if isFirstTool && g.toolChangeMode == ToolChangeWithPause {
+		return
+	}
+
+	g.genRapidMoveToZ(g.retract.SafeHeight)
+	g.genSpindleStop()
+	if g.toolChangeMode != ToolChangeWithPause {
+		// Without a tool changer, fall back to changing the tool by hand.
+		if lines := g.post.SelectTool(toolNumber); lines != nil {
+			g.writeLines(lines)
+			return
+		}
+	}
+
+	g.writeLines(g.post.ManualToolChange(toolNumber, g.parkPosition))
+
+	// The tool position is unknown after the pause. Go back up to the safe height, so that
+	// the next path starts with a rapid move from there.
+	g.currentLoc = geom.NewPt3(math.Inf(1), math.Inf(1), math.NaN())
+	g.genRapidMoveToZ(g.retract.SafeHeight)
+}
+
+func (g *gcodeGenerator) setSpindle(spindle SpindleConfig) {
+	if spindle.Direction == 0 {
+		spindle.Direction = SpindleClockwise
+	}
+	if spindle.Coolant == CoolantOff {
+		spindle.Coolant = 0
+	}
+
+	if spindle.Speed > 0 &&
+		(spindle.Speed != g.spindle.Speed || spindle.Direction != g.spindle.Direction) {
+		g.writeStrLn(g.post.SpindleOn(spindle.Speed, spindle.Direction == SpindleClockwise))
+		if spindle.SpinUpDwell > 0 {
+			g.writeStrLn(g.post.Dwell(spindle.SpinUpDwell))
+		}
+		g.spindle.Speed = spindle.Speed
+		g.spindle.Direction = spindle.Direction
+	}
+
+	if spindle.Coolant != g.spindle.Coolant {
+		// Turn off the coolant in use first, as controllers can run mist and flood together.
+		if g.spindle.Coolant != 0 {
+			g.writeStrLn(g.post.Coolant(CoolantOff))
+		}
+		if spindle.Coolant != 0 {
+			g.writeStrLn(g.post.Coolant(spindle.Coolant))
+		}
+		g.spindle.Coolant = spindle.Coolant
+	}
+}
+
+func (g *gcodeGenerator) setStayDownLinker(linker *stayDownLinker) {
+	g.linker = linker
+}
+
+func (g *gcodeGenerator) setEntryPlanner(planner *entryPlanner) {
+	g.entryPlanner = planner
+}
+
+func (g *gcodeGenerator) setRetractPlanner(planner *retractPlanner) {
+	g.retractPlanner = planner
+}
+
+func (g *gcodeGenerator) startJob() {
+	g.reset()
+	g.currentTool = 0
+	g.spindle = SpindleConfig{}
+	g.isAtPathEnd = false
+	g.path = g.path[:0] // Empty
+	g.genPreamble()
+}
+
+func (g *gcodeGenerator) endJob() {
+	g.genEpilogue()
+}
+
+func (g *gcodeGenerator) startPath(x, y, depth float64) {
+	if g.path == nil {
+		g.path = make([]pathComponent, 0, 8)
+	} else {
+		g.path = g.path[:0]
+	}
+
+	// For now just record the path starting point.
+	g.startingPoint = geom.NewPt3(x, y, depth)
+}
+
+func (g *gcodeGenerator) moveTo(x, y, depth float64) {
+	g.appendPointToPath(geom.NewPt3(x, y, depth))
+}
+
+func (g *gcodeGenerator) clockwiseArcTo(x, y, depth, radius float64) {
+	comp := pathComponent{
+		flavor: arcComponent,
+		points: make([]geom.Pt3, 2),
+	}
+	comp.points[0] = geom.NewPt3(clockwiseArc, radius, depth)
+	comp.points[1] = geom.NewPt3(x, y, depth)
+	g.path = append(g.path, comp)
+}
+
+func (g *gcodeGenerator) counterclockwiseArcTo(x, y, depth, radius float64) {
+	comp := pathComponent{
+		flavor: arcComponent,
+		points: make([]geom.Pt3, 2),
+	}
+	comp.points[0] = geom.NewPt3(counterclockwiseArc, radius, depth)
+	comp.points[1] = geom.NewPt3(x, y, depth)
+	g.path = append(g.path, comp)
+}
+
+func (g *gcodeGenerator) endPath(discard bool) {
+	if discard {
+		if g.path != nil {
+			g.path = g.path[:0]
+		}
+		return
+	}
+
+	g.simplifyCompoundPath()
+	g.trimMovesThatRemoveNothing()
+	if len(g.path) > 0 {
+		g.emitCompoundPath()
+		g.isAtPathEnd = true
+	}
+
+	g.path = g.path[:0]
+}
+
+// Reset the generator.
+func (g *gcodeGenerator) reset() {
+	g.path = g.path[:0] // Empty
+}
+
+// Append point q at the end of the path. Individual points are always appended to line-segments
+// components, creating one if necessary.
+func (g *gcodeGenerator) appendPointToPath(q pt3) {
+	section := g.getPathComponentToAppendPointTo()
+	if section.shouldUsePoint(q) {
+		section.points = append(section.points, q)
+	}
+}
+
+// Return the line-segment component to which a new point can be appended. That is either the
+// last component in the path, if it is a line-segment component or a new line-segment
+// component otherwise.
+func (g *gcodeGenerator) getPathComponentToAppendPointTo() *pathComponent {
+	numComponents := len(g.path)
+
+	// If the compound path is empty, create a line-segment component and return it.
+	if numComponents == 0 {
+		// Create a new line-segment component.
+		comp := pathComponent{
+			flavor: lineSegmentsComponent,
+			points: make([]pt3, 0, initialPathBufferSize),
+		}
+
+		// Since it's the very first component in the path, it should have the path starting point.
+		comp.points = append(comp.points, g.startingPoint)
+		// Add it to the compound path.
+		g.path = append(g.path, comp)
+
+		return &g.path[0]
+	}
+
+	// If the component at the end of the compound path is a line-segment component, return it.
+	if g.path[numComponents-1].flavor == lineSegmentsComponent {
+		return &g.path[numComponents-1]
+	}
+
+	// Add a new line-segment component at the end of the path and return it.
+	comp := pathComponent{
+		flavor: lineSegmentsComponent,
+		points: make([]geom.Pt3, 0, 16),
+	}
+
+	// Add the last point of the previous component as the starting point for this new component.
+	endPoint, err := g.path[numComponents-1].getComponentEndPoint()
+	if err != nil {
+		g.setError(err)
+	}
+	comp.points = append(comp.points, endPoint)
+
+	g.path = append(g.path, comp)
+	return &g.path[numComponents]
+}
+
+// Simplify the path in-place. Arcs are fitted first, when enabled, so that the remaining
+// line-segment components are simplified on their own.
+func (g *gcodeGenerator) simplifyCompoundPath() {
+	if g.enableArcFitting {
+		path := make([]pathComponent, 0, len(g.path))
+		for i := range g.path {
+			path = append(path, g.path[i].fitArcs()...)
+		}
+		g.path = path
+	}
+
+	for i := range g.path {
+		g.path[i].simplifyComponent()
+	}
+}
+
+// Emit the code to cut a path. That includes safely repositioning the tool to the first
+// point in the path.
+func (g *gcodeGenerator) emitCompoundPath() {
+	for i, section := range g.path {
+		if section.isLineSegmentComponent() {
+			// For the very first section, we must reposition to the first point. For subsequent
+			// sections, we must skip the first point as it is a duplicate of the last point
+			// from the previous section.
+			if i == 0 {
+				g.genRepositionToPoint(section.points[0])
+			}
+
+			numPoints := len(section.points)
+			for j := 1; j < numPoints; j++ {
+				g.genLinearMoveToXyz(section.points[j])
+			}
+		} else {
+			// For the very first section, we must reposition to the starting point.
+			if i == 0 {
+				g.genRepositionToPoint(g.startingPoint)
+			}
+
+			p1 := section.points[0]
+			p2 := section.points[1]
+			if p1.X > 0 {
+				g.genClockwiseArcTo(p1.Y, p2)
+			} else {
+				g.genCounterclockwiseArcTo(p1.Y, p2)
+			}
+		}
+	}
+}
+
+func (g *gcodeGenerator) genRepositionToPoint(p geom.Pt3) {
+	// Go straight to the next path without lifting the tool, when possible.
+	if g.isAtPathEnd && g.linker != nil && g.linker.canLink(g.currentLoc, p) {
+		g.genLinearMoveToXyz(p)
+		return
+	}
+
+	// Check whether we need to move at all.
+	if g.currentLoc.X == p.X && g.currentLoc.Y == p.Y {
+		g.genEntryToPoint(p)
+		return
+	}
+
+	// For distances above the rapid threshold, go up to the clearance height and use a rapid
+	// move. Otherwise go up to the retract height and use a linear move, unless rapid
+	// repositioning is enabled.
+	threshold := g.retract.RapidThreshold
+	if distP0ToP1Sqrd(g.currentLoc, p) > threshold*threshold {
+		z := g.retract.ClearanceHeight
+		g.genRetractToZ(z)
+		g.genRapidMoveToXyz(geom.NewPt3(p.X, p.Y, z))
+	} else if z := g.getRetractHeight(g.currentLoc, p); g.rapidReposition {
+		g.genRapidMoveToZ(z)
+		g.genRapidMoveToXyz(geom.NewPt3(p.X, p.Y, z))
+	} else {
+		g.genRetractToZ(z)
+		g.genLinearMoveToXyz(geom.NewPt3(p.X, p.Y, z))
+	}
+	g.genEntryToPoint(p)
+}
+
+// Return the height to go up to, for moving from p0 to p1 between paths.
+func (g *gcodeGenerator) getRetractHeight(p0, p1 geom.Pt3) float64 {
+	z := g.retract.RetractHeight
+	if g.retract.Mode == RetractMinimum && g.retractPlanner != nil {
+		z = math.Min(z, g.retractPlanner.getHighestMaterial(p0, p1)+g.retract.RetractHeight)
+	}
+	return z
+}
+
+// Go straight up to height z, at the vertical feed rate since the tool may be cutting, or down
+// to z with a rapid move.
+func (g *gcodeGenerator) genRetractToZ(z float64) {
+	if g.currentLoc.Z > z {
+		g.genRapidMoveToZ(z)
+	} else {
+		g.genLinearMoveToZ(z)
+	}
+}
+
+// Go down to point p, directly below the tool, at the start of the current path, using the
+// configured entry. Ramps and helixes start at the top of the material, since there is nothing
+// to cut above it. Helixes that don't fit beside the path are replaced by ramps, and ramps
+// along paths that don't start with a line segment by plunges.
+func (g *gcodeGenerator) genEntryToPoint(p geom.Pt3) {
+	z0 := math.Min(0, g.currentLoc.Z)
+	tanAngle := math.Tan(g.entry.MaxRampAngle * math.Pi / 180)
+	if p.Z >= z0-epsilon || tanAngle <= 0 || tanAngle > 1e3 {
+		g.genLinearMoveToZ(p.Z)
+		return
+	}
+
+	switch g.entry.Mode {
+	case EntryHelix:
+		if quarters, clockwise, ok := g.planHelixEntry(p, z0, tanAngle); ok {
+			g.genLinearMoveToZ(z0)
+			for _, q := range quarters {
+				g.genArcTo(g.entry.HelixRadius, q, clockwise)
+			}
+			return
+		}
+		fallthrough
+	case EntryRamp:
+		if q, ok := g.getFirstPathSegmentEnd(); ok {
+			g.genLinearMoveToZ(z0)
+			g.genRampEntry(p, q, z0, tanAngle)
+			return
+		}
+	}
+
+	g.genLinearMoveToZ(p.Z)
+}
+
+// Return the end of the first segment of the current path, if the path starts with a line
+// segment.
+func (g *gcodeGenerator) getFirstPathSegmentEnd() (geom.Pt3, bool) {
+	if len(g.path) == 0 || !g.path[0].isLineSegmentComponent() || len(g.path[0].points) < 2 {
+		return geom.Pt3{}, false
+	}
+
+	p := g.path[0].points[0]
+	q := g.path[0].points[1]
+	if math.Hypot(q.X-p.X, q.Y-p.Y) < epsilon {
+		return geom.Pt3{}, false
+	}
+	return q, true
+}
+
+// Ramp down from z0 to p, going back and forth along the first segment of the path, p-q, so
+// that the tool never goes down steeper than the max ramp angle. The ramp stays above the
+// segment, so that it doesn't cut deeper than the path itself.
+func (g *gcodeGenerator) genRampEntry(p, q geom.Pt3, z0, tanAngle float64) {
+	segLen := math.Hypot(q.X-p.X, q.Y-p.Y)
+	dz := z0 - p.Z
+	rampLen := dz / tanAngle
+
+	// Go at least out and back, to end at p.
+	legLen := math.Min(segLen, 0.5*rampLen)
+	numLegs := 2 * int(math.Ceil(rampLen/(2*legLen)-0.001))
+	t := legLen / segLen
+	far := geom.NewPt3(p.X+t*(q.X-p.X), p.Y+t*(q.Y-p.Y), p.Z+t*(q.Z-p.Z))
+
+	for k := 1; k <= numLegs; k++ {
+		z := z0 - dz*float64(k)/float64(numLegs)
+		if k%2 == 1 {
+			g.genLinearMoveToXyz(geom.NewPt3(far.X, far.Y, math.Max(z, far.Z)))
+		} else {
+			g.genLinearMoveToXyz(geom.NewPt3(p.X, p.Y, z))
+		}
+	}
+}
+
+// Plan a helix that goes down from z0 to p, so that the tool never goes down steeper than the
+// max ramp angle. The helix starts and ends at p, tangent to the first segment of the path, if
+// any, so that the tool leaves it along the path. It turns counterclockwise on the left of the
+// path or, if the entry planner doesn't let it fit there, clockwise on the right. Return the
+// ends of the quarter circles of the helix and whether it turns clockwise, or false if it fits
+// on neither side.
+func (g *gcodeGenerator) planHelixEntry(
+	p geom.Pt3, z0, tanAngle float64) (quarters []geom.Pt3, clockwise bool, ok bool) {
+
+	r := g.entry.HelixRadius
+	if r <= epsilon || g.entryPlanner == nil {
+		return nil, false, false
+	}
+
+	dir := geom.NewVec2(1, 0)
+	if q, ok := g.getFirstPathSegmentEnd(); ok {
+		dir = geom.NewVec2(q.X-p.X, q.Y-p.Y).Norm()
+	}
+
+	dz := z0 - p.Z
+	numTurns := int(math.Ceil(dz/(2*math.Pi*r*tanAngle) - 0.001))
+	numQuarters := 4 * numTurns
+
+	// Sample the helix finely enough to check it against the surface.
+	numStepsPerQuarter := int(math.Ceil(0.5 * math.Pi * r / minStepSize))
+	numSteps := numQuarters * numStepsPerQuarter
+
+	for _, clockwise := range []bool{false, true} {
+		// The center is on the left of the path for counterclockwise helixes.
+		sign := 1.0
+		if clockwise {
+			sign = -1
+		}
+		center := geom.NewPt2(p.X-sign*r*dir.Y, p.Y+sign*r*dir.X)
+		angle0 := math.Atan2(p.Y-center.Y, p.X-center.X)
+		pointAt := func(i int) geom.Pt3 {
+			t := float64(i) / float64(numSteps)
+			angle := angle0 + sign*2*math.Pi*float64(numTurns)*t
+			return geom.NewPt3(
+				center.X+r*math.Cos(angle), center.Y+r*math.Sin(angle), z0-dz*t)
+		}
+
+		points := make([]geom.Pt3, 0, numSteps+1)
+		for i := 0; i <= numSteps; i++ {
+			points = append(points, pointAt(i))
+		}
+		if !g.entryPlanner.canEnterAlong(points, !clockwise) {
+			continue
+		}
+
+		quarters = make([]geom.Pt3, 0, numQuarters)
+		for k := 1; k < numQuarters; k++ {
+			quarters = append(quarters, pointAt(k*numStepsPerQuarter))
+		}
+		return append(quarters, p), clockwise, true
+	}
+
+	return nil, false, false
+}
+
+func (g *gcodeGenerator) genPreamble() {
+	g.writeLines(g.post.Preamble())
+	if g.workOriginDesc != "" {
+		g.writeComment(g.workOriginDesc)
+	}
+	if g.workOffset > 0 {
+		g.writeStrLn(g.post.SelectWorkOffset(g.workOffset))
+	}
+}
+
+func (g *gcodeGenerator) genEpilogue() {
+	g.isAtPathEnd = false
+	g.genRapidMoveToZ(g.retract.SafeHeight)
+	g.genSpindleStop()
+	g.writeLines(g.post.ProgramEnd())
+}
+
+// Stop the spindle and the coolant, if running.
+func (g *gcodeGenerator) genSpindleStop() {
+	g.setSpindle(SpindleConfig{})
+	if g.spindle.Speed > 0 {
+		g.writeStrLn(g.post.SpindleOff())
+		g.spindle.Speed = 0
+	}
+}
+
+func (g *gcodeGenerator) genLinearMoveToZ(z float64) {
+	if g.currentLoc.Z != z {
+		g.writeStrLn(g.post.LinearMoveToZ(z-g.workOrigin.Z, g.vertFeedRate))
+		g.cutStock(g.currentLoc, geom.NewPt3(g.currentLoc.X, g.currentLoc.Y, z))
+		g.currentLoc.Z = z
+	}
+}
+
+func (g *gcodeGenerator) genLinearMoveToXyz(q geom.Pt3) {
+	if !g.currentLoc.Eq(q) {
+		feedRate := g.feedRateForMove(g.currentLoc, q, g.horizFeedRate)
+		g.writeStrLn(g.post.LinearMoveToXyz(g.toWork(q), feedRate))
+		g.cutStock(g.currentLoc, q)
+		g.currentLoc = q
+	}
+}
+
+func (g *gcodeGenerator) genRapidMoveToXyz(q geom.Pt3) {
+	if !g.currentLoc.Eq(q) {
+		g.writeStrLn(g.post.RapidMoveToXyz(g.toWork(q)))
+		g.cutStock(g.currentLoc, q)
+		g.currentLoc = q
+	}
+}
+
+func (g *gcodeGenerator) genRapidMoveToZ(z float64) {
+	if g.currentLoc.Z != z {
+		g.writeStrLn(g.post.RapidMoveToZ(z - g.workOrigin.Z))
+		g.cutStock(g.currentLoc, geom.NewPt3(g.currentLoc.X, g.currentLoc.Y, z))
+		g.currentLoc.Z = z
+	}
+}
+
+func (g *gcodeGenerator) genClockwiseArcTo(radius float64, q pt3) {
+	g.genArcTo(radius, q, true)
+}
+
+func (g *gcodeGenerator) genCounterclockwiseArcTo(radius float64, q pt3) {
+	g.genArcTo(radius, q, false)
+}
+
+func (g *gcodeGenerator) genArcTo(radius float64, q pt3, clockwise bool) {
+	if radius > 0 {
+		p := g.currentLoc
+		center := stock.ArcCenter(p, q, radius, clockwise)
+		feedRate := g.feedRateForArc(p, q, radius, clockwise, g.horizFeedRate)
+		g.writeStrLn(g.post.ArcTo(Arc{
+			From:      g.toWork(p),
+			To:        g.toWork(q),
+			Center:    geom.NewPt2(center.X-g.workOrigin.X, center.Y-g.workOrigin.Y),
+			Radius:    radius,
+			Clockwise: clockwise,
+		}, feedRate))
+		g.cutStockAlongArc(p, q, radius, clockwise)
+		g.currentLoc = q
+	}
+}
+
+// Return point q in work coordinates, relative to the work zero.
+func (g *gcodeGenerator) toWork(q pt3) pt3 {
+	return geom.NewPt3(q.X-g.workOrigin.X, q.Y-g.workOrigin.Y, q.Z-g.workOrigin.Z)
+}
+
+func (g *gcodeGenerator) writeStrLn(s string) {
+	fmt.Fprintf(g.codeOut, "%s\n", s)
+}
+
+func (g *gcodeGenerator) writeLines(lines []string) {
+	for _, s := range lines {
+		g.writeStrLn(s)
+	}
+}
+
+// Write a comment on its own line.
+func (g *gcodeGenerator) writeComment(s string) {
+	g.writeStrLn(g.post.Comment(s))
+}
+
+// Returns whether the component has line-segment flavor.
+func (s *pathComponent) isLineSegmentComponent() bool {
+	return s.flavor == lineSegmentsComponent
+}
+
+// Returns whether the component has arc flavor.
+func (s *pathComponent) isArcComponent() bool {
+	return s.flavor == arcComponent
+}
+
+// Returns whether point q should be added to the line-segment component. This is used to
+// eliminate points that can be trivially discarded, such as successive, co-located points.
+func (s *pathComponent) shouldUsePoint(q pt3) bool {
+	numPoints := len(s.points)
+	if numPoints == 0 {
+		return true
+	}
+
+	lastPathPoint := s.points[numPoints-1]
+	return !lastPathPoint.Eq(q)
+}
+
+// Simplify the component in-place. Only line-segment component are simplified.
+func (s *pathComponent) simplifyComponent() {
+	if s.isLineSegmentComponent() {
+		s.simplifyPathByFlatness()
+		s.simplifyPathByProximity()
+	}
+}
+
+// Return the endpoint for this component. Return ErrInternal if the component is malformed.
+func (s *pathComponent) getComponentEndPoint() (pt3, error) {
+	if s.flavor == lineSegmentsComponent {
+		n := len(s.points)
+		if n == 0 {
+			return pt3{}, fmt.Errorf("%w: empty line-segment component", ErrInternal)
+		}
+		return s.points[n-1], nil
+	}
+
+	if len(s.points) != 2 {
+		return pt3{}, fmt.Errorf("%w: an arc component should have exactly two points", ErrInternal)
+	}
+	return s.points[1], nil
+}
+
+// Simplify the path using a flatness criterion. Points that are almost colinear are coalesced
+// into line segments.
+func (s *pathComponent) simplifyPathByFlatness() {
+	if len(s.points) < 3 {
+		return
+	}
+
+	// Function keepPoint and related keepIndex are used to keep track of points we do not discard,
+	// in-place within g.currentPath.
+	keepIndex := 0
+	keepPoint := func(i int) {
+		if i != keepIndex {
+			s.points[keepIndex] = s.points[i]
+		}
+		keepIndex++
+	}
+
+	// Start at the begining of the path and accumulate vertices that are colinear.
+	p0 := 0
+	p1 := 2
+	keepPoint(p0)
+	for p1 < len(s.points) {
+		// For all the points q between p0 and p1, measure the max distance from q to line p0-p1.
+		q := p0 + 1
+		distSqrd := 0.0
+		for q < p1 {
+			ptQ := s.points[q]
+			ptP0 := s.points[p0]
+			ptP1 := s.points[p1]
+
+			d := distQtoP0P1Sqrd(ptQ, ptP0, ptP1)
+			distSqrd = math.Max(distSqrd, d)
+			q++
+		}
+
+		if distSqrd > flatnessToleranceSqrd {
+			// Some point q between p0 and p1 is out of tolerance. We need to keep p0, p1-1 and discard
+			// all the points between p0 and p1-1. Then we start a new colinear-point accumulation at
+			// p1-1.
+			// We keep p0 at the begining of each new colinear accumulation. No need to do it again.
+			p0 = p1 - 1
+			keepPoint(p0)
+			p1 = p0 + 1
+		}
+
+		p1++
+	}
+
+	// All remaining points since last p0 are colinear. Only keep the last one.
+	p0++
+	if p0 < len(s.points) {
+		keepPoint(len(s.points) - 1)
+	}
+
+	s.points = s.points[:keepIndex]
+}
+
+// Simplify the path using proximity criterion. Points that are too close to eachother,
+// along X or Y are coalesced.
+func (s *pathComponent) simplifyPathByProximity() {
+	if len(s.points) < 3 {
+		return
+	}
+
+	// Function keepPoint and related keepIndex are used to keep track of points we do not discard,
+	// in-place within g.currentPath.
+	keepIndex := 0
+	keepPoint := func(q geom.Pt3) {
+		if keepIndex > 0 {
+			if s.points[keepIndex-1].Eq(q) {
+				return
+			}
+		}
+		s.points[keepIndex] = q
+		keepIndex++
+	}
+
+	updateLastPoint := func(q geom.Pt3) {
+		if keepIndex > 0 {
+			s.points[keepIndex-1] = q
+		}
+	}
+
+	p0 := 0
+	p1 := 1
+	n := len(s.points)
+	q0 := s.points[p0]
+
+	keepPoint(q0)
+	for p1 < n {
+		q1 := s.points[p1]
+
+		xy1 := geom.NewPt2(q1.X, q1.Y)
+		xy0 := geom.NewPt2(q0.X, q0.Y)
+		d := xy1.Sub(xy0).LenSq()
+		if d < proximityToleranceSqrd {
+			maxZ := math.Max(q0.Z, q1.Z)
+			if p1 == n-1 {
+				// p1 is the last point along the path; keep it.
+				q1.Z = maxZ
+				updateLastPoint(q1)
+			} else {
+				q0.Z = maxZ
+				updateLastPoint(q0)
+			}
+		} else {
+			keepPoint(q1)
+			p0 = p1
+			q0 = q1
+		}
+
+		p1++
+	}
+
+	s.points = s.points[:keepIndex]
+}
+
+// Return the distance from p0 to p1 squared.
+func distP0ToP1Sqrd(p0, p1 geom.Pt3) float64 {
+	return p0.Sub(p1).LenSq()
+}
+
+// Return the distance from q to line p0-p1, squared. If p0 and p1 are coincident, returns
+// the distance from q to p0 squared.
+func distQtoP0P1Sqrd(q, p0, p1 pt3) float64 {
+	v0 := q.Sub(p0)
+	w := p1.Sub(p0)
+	lSqrd := w.Dot(w)
+
+	if lSqrd < epsilonSqrd {
+		return v0.Dot(v0)
+	}
+
+	s := v0.Dot(w) / lSqrd
+	d := v0.Sub(w.Scale(s))
+	return d.Dot(d)
+}
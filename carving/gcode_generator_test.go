@@ -0,0 +1,541 @@
+package carving
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/stock"
+	a "gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func buildPath(g *gcodeGenerator, verts []geom.Pt3) {
+	for _, v := range verts {
+		g.appendPointToPath(v)
+	}
+}
+
+func TestAddPoint(t *testing.T) {
+	g := newGcodeGenerator(100, 100)
+
+	g.appendPointToPath(geom.NewPt3(0, 0, 0))
+	if g.getNumPathPointsForTest() != 1 {
+		t.Errorf("addPoint: expected len == 1\n")
+	}
+
+	g.appendPointToPath(geom.NewPt3(1, 1, 1))
+	if g.getNumPathPointsForTest() != 2 {
+		t.Errorf("addPoint: expected len == 2\n")
+	}
+}
+
+func TestDistQtoP0P1Sqrd(t *testing.T) {
+	p0 := geom.NewPt3(0, 0, 0)
+	p1 := geom.NewPt3(10, 10, 10)
+	q := geom.NewPt3(5, 5, 5)
+	d := distQtoP0P1Sqrd(q, p0, p1)
+	a.Assert(t, d < 1e-5)
+}
+
+func TestSimplifyPath(t *testing.T) {
+	g := newGcodeGenerator(100, 100)
+
+	// Three non-colinear vertices.
+	verts := []geom.Pt3{{0, 0, 0}, {1, 1, 1}, {0, 1, 1}}
+	buildPath(g, verts)
+	g.simplifyCompoundPath()
+	a.Assert(t, is.Equal(g.getNumPathPointsForTest(), 3))
+	for i, q := range g.getAllPathPointsForTest() {
+		a.DeepEqual(t, q, verts[i])
+	}
+
+	// 2 vertices.
+	g.reset()
+	verts = []geom.Pt3{{0, 0, 0}, {1, 1, 1}}
+	buildPath(g, verts)
+	g.simplifyCompoundPath()
+	a.Assert(t, is.Equal(g.getNumPathPointsForTest(), 2))
+
+	pts := g.getAllPathPointsForTest()
+	a.DeepEqual(t, pts[0], verts[0])
+	a.DeepEqual(t, pts[1], verts[1])
+
+	// 3 colinear vertices.
+	g.reset()
+	verts = []geom.Pt3{{0, 0, 0}, {1, 1, 1}, {2, 2, 2}}
+	buildPath(g, verts)
+	g.simplifyCompoundPath()
+	a.Assert(t, is.Equal(g.getNumPathPointsForTest(), 2))
+
+	pts = g.getAllPathPointsForTest()
+	a.DeepEqual(t, pts[0], verts[0])
+	a.DeepEqual(t, pts[1], verts[2])
+
+	// 4 colinear vertices.
+	g.reset()
+	verts = []geom.Pt3{{0, 0, 0}, {1, 1, 1}, {2, 2, 2}, {3, 3, 3}}
+	buildPath(g, verts)
+	g.simplifyCompoundPath()
+	a.Assert(t, is.Equal(g.getNumPathPointsForTest(), 2))
+
+	pts = g.getAllPathPointsForTest()
+	a.DeepEqual(t, pts[0], verts[0])
+	a.DeepEqual(t, pts[1], verts[3])
+
+	// 4 colinear vertices and one outlier
+	g.reset()
+	verts = []geom.Pt3{{0, 0, 0}, {1, 1, 1}, {2, 2, 2}, {3, 3, 3}, {4, 4, 0}}
+	buildPath(g, verts)
+	g.simplifyCompoundPath()
+	a.Assert(t, is.Equal(g.getNumPathPointsForTest(), 3))
+
+	pts = g.getAllPathPointsForTest()
+	a.DeepEqual(t, pts[0], verts[0])
+	a.DeepEqual(t, pts[1], verts[3])
+	a.DeepEqual(t, pts[2], verts[4])
+
+	// 4 colinear + 3 colinear vertices.
+	g.reset()
+	verts = []geom.Pt3{{0, 0, 0}, {1, 1, 1}, {2, 2, 2}, {3, 3, 3}, {4, 4, 0}, {5, 5, 0}, {6, 6, 0}}
+	buildPath(g, verts)
+	g.simplifyCompoundPath()
+	a.Assert(t, is.Equal(g.getNumPathPointsForTest(), 4))
+
+	pts = g.getAllPathPointsForTest()
+	a.DeepEqual(t, pts[0], verts[0])
+	a.DeepEqual(t, pts[1], verts[3])
+	a.DeepEqual(t, pts[2], verts[4])
+	a.DeepEqual(t, pts[3], verts[6])
+
+	// 4  + 3 + 2colinear vertices.
+	g.reset()
+	verts = []geom.Pt3{{0, 0, 0}, {1, 1, 1}, {2, 2, 2}, {3, 3, 3}, {4, 4, 0}, {5, 5, 0}, {6, 6, 0},
+		{7, 0, 0}, {8, 0, 0}}
+	buildPath(g, verts)
+	g.simplifyCompoundPath()
+	a.Assert(t, is.Equal(g.getNumPathPointsForTest(), 6))
+
+	pts = g.getAllPathPointsForTest()
+	a.DeepEqual(t, pts[0], verts[0])
+	a.DeepEqual(t, pts[1], verts[3])
+	a.DeepEqual(t, pts[2], verts[4])
+	a.DeepEqual(t, pts[3], verts[6])
+	a.DeepEqual(t, pts[4], verts[7])
+	a.DeepEqual(t, pts[5], verts[8])
+}
+
+func TestChangeTool(t *testing.T) {
+	var out bytes.Buffer
+	g := newGcodeGenerator(100, 100)
+	g.configure(&out, 100, 100, 10)
+	g.startJob()
+
+	// With M6 tool changes, every tool is selected, including the first one.
+	preambleLen := out.Len()
+	g.changeTool(1, "6.00 mm flat end-mill")
+	g.changeTool(1, "6.00 mm flat end-mill")
+	g.changeTool(2, "1.50 mm ball-nose")
+	a.Equal(t, out.String()[preambleLen:],
+		"(T1: 6.00 mm flat end-mill)\nG0 Z25.00\nT1 M6\n(T2: 1.50 mm ball-nose)\nT2 M6\n")
+
+	// With pauses, the first tool is expected to be in the spindle already.
+	out.Reset()
+	g = newGcodeGenerator(100, 100)
+	g.configure(&out, 100, 100, 10)
+	g.configureToolChange(ToolChangeWithPause, geom.NewPt3(-10, -20, -1))
+	g.startJob()
+	preambleLen = out.Len()
+	g.changeTool(1, "6.00 mm flat end-mill")
+	g.changeTool(2, "1.50 mm ball-nose (fine)")
+	a.Equal(t, out.String()[preambleLen:],
+		"(T1: 6.00 mm flat end-mill)\n(T2: 1.50 mm ball-nose fine)\nG0 Z25.00\n"+
+			"G53 G0 Z-1.00\nG53 G0 X-10.00 Y-20.00\n(Change to tool T2, set Z zero, then resume)\n"+
+			"M0\nG0 Z25.00\n")
+}
+
+func TestSetSpindle(t *testing.T) {
+	var out bytes.Buffer
+	g := newGcodeGenerator(100, 100)
+	g.configure(&out, 100, 100, 10)
+	g.configureToolChange(ToolChangeWithM6, geom.NewPt3(0, 0, -1))
+	g.startJob()
+	g.changeTool(1, "6.00 mm flat end-mill")
+
+	// The spindle spins up, then only the changes are emitted.
+	out.Reset()
+	g.setSpindle(SpindleConfig{Speed: 18000, SpinUpDwell: 2, Coolant: CoolantMist})
+	g.setSpindle(SpindleConfig{Speed: 18000, Direction: SpindleClockwise, Coolant: CoolantMist})
+	g.setSpindle(SpindleConfig{Speed: 12000, Direction: SpindleCounterclockwise,
+		Coolant: CoolantFlood})
+	a.Equal(t, out.String(), "M3 S18000\nG4 P2.0\nM7\nM4 S12000\nM9\nM8\n")
+
+	// The spindle and the coolant stop for tool changes and at the end of the job. A speed of
+	// 0 leaves the spindle alone.
+	out.Reset()
+	g.changeTool(2, "1.50 mm ball-nose")
+	g.setSpindle(SpindleConfig{})
+	g.endJob()
+	a.Equal(t, out.String(), "(T2: 1.50 mm ball-nose)\nM9\nM5\nT2 M6\nG28 G91 Z0\nM30\n")
+}
+
+func TestStayDownLinking(t *testing.T) {
+	var out bytes.Buffer
+	g := newGcodeGenerator(100, 100)
+	g.configure(&out, 100, 100, 10)
+	g.startJob()
+
+	// The carving profile is 1 mm deep, except for a wall at y in [1.5, 2].
+	sampler := pocketTestSampler{pMin: geom.NewPt2(-100, 1.5), pMax: geom.NewPt2(100, 2)}
+	g.setStayDownLinker(newStayDownLinker(&sampler, -1, 0, 2))
+
+	// Runs go back and forth along X.
+	x0, x1 := 10.0, 0.0
+	cutAlongX := func(y float64) string {
+		x0, x1 = x1, x0
+		out.Reset()
+		g.startPath(x0, y, -1)
+		g.moveTo(x1, y, -1)
+		g.endPath(false)
+		return out.String()
+	}
+
+	cutAlongX(0)
+
+	// The next run is adjacent: the tool stays down.
+	a.Equal(t, cutAlongX(1), "G1 X10.00 Y1.00 Z-1.00 F100.00\nG1 X0.00 Y1.00 Z-1.00 F100.00\n")
+
+	// The link would cut into the wall: the tool retracts.
+	a.Equal(t, cutAlongX(2.5), "G1 Z1.00 F100.00\nG1 X0.00 Y2.50 Z1.00 F100.00\n"+
+		"G1 Z-1.00 F100.00\nG1 X10.00 Y2.50 Z-1.00 F100.00\n")
+
+	// The next run is too far: the tool retracts.
+	a.Equal(t, cutAlongX(6), "G1 Z1.00 F100.00\nG1 X10.00 Y6.00 Z1.00 F100.00\n"+
+		"G1 Z-1.00 F100.00\nG1 X0.00 Y6.00 Z-1.00 F100.00\n")
+
+	// Without a linker, the tool always retracts.
+	g.setStayDownLinker(nil)
+	a.Equal(t, cutAlongX(7), "G1 Z1.00 F100.00\nG1 X0.00 Y7.00 Z1.00 F100.00\n"+
+		"G1 Z-1.00 F100.00\nG1 X10.00 Y7.00 Z-1.00 F100.00\n")
+}
+
+func TestFitArcs(t *testing.T) {
+	// A counterclockwise quarter circle of radius 10, going down by 1 mm, followed by a
+	// straight line.
+	var verts []geom.Pt3
+	for i := 0; i <= 30; i++ {
+		angle := 0.5 * math.Pi * float64(i) / 30
+		verts = append(verts, geom.NewPt3(10*math.Cos(angle), 10*math.Sin(angle), -1-float64(i)/30))
+	}
+	for i := 1; i <= 10; i++ {
+		verts = append(verts, geom.NewPt3(-float64(i), 10, -2))
+	}
+
+	cut := func(verts []geom.Pt3, enableArcFitting bool) string {
+		var out bytes.Buffer
+		g := newGcodeGenerator(100, 100)
+		g.configure(&out, 100, 100, 10)
+		g.configureArcFitting(enableArcFitting)
+		g.currentLoc = verts[0]
+
+		g.startPath(verts[0].X, verts[0].Y, verts[0].Z)
+		for _, v := range verts[1:] {
+			g.moveTo(v.X, v.Y, v.Z)
+		}
+		g.endPath(false)
+		return out.String()
+	}
+
+	a.Equal(t, cut(verts, true),
+		"G3 X0.00 Y10.00 Z-2.00 R10.00 F100.00\nG1 X-10.00 Y10.00 Z-2.00 F100.00\n")
+
+	// The other way around, the arc is clockwise.
+	reversed := make([]geom.Pt3, len(verts))
+	for i, v := range verts {
+		reversed[len(verts)-1-i] = v
+	}
+	a.Equal(t, cut(reversed, true),
+		"G1 X0.00 Y10.00 Z-2.00 F100.00\nG2 X10.00 Y0.00 Z-1.00 R10.00 F100.00\n")
+
+	// Without arc fitting, the arc is made of line segments.
+	out := cut(verts, false)
+	a.Assert(t, !strings.Contains(out, "G2") && !strings.Contains(out, "G3"))
+	a.Assert(t, strings.Count(out, "G1 ") > 10)
+
+	// Half circles are split in two arcs, each shorter than a half circle.
+	verts = verts[:0]
+	for i := 0; i <= 60; i++ {
+		angle := math.Pi * float64(i) / 60
+		verts = append(verts, geom.NewPt3(10*math.Cos(angle), 10*math.Sin(angle), -1))
+	}
+	out = cut(verts, true)
+	a.Equal(t, strings.Count(out, "G3 "), 2)
+	a.Assert(t, strings.HasSuffix(out, "G3 X-10.00 Y0.00 Z-1.00 R10.00 F100.00\n"))
+}
+
+func TestEntry(t *testing.T) {
+	var planner *entryPlanner
+	cut := func(entry EntryConfig, verts []geom.Pt3) string {
+		var out bytes.Buffer
+		g := newGcodeGenerator(100, 100)
+		g.configure(&out, 100, 100, 10)
+		g.setEntry(entry)
+		g.setEntryPlanner(planner)
+		g.currentLoc = geom.NewPt3(0, 0, 1)
+
+		g.startPath(verts[0].X, verts[0].Y, verts[0].Z)
+		for _, v := range verts[1:] {
+			g.moveTo(v.X, v.Y, v.Z)
+		}
+		g.endPath(false)
+		return out.String()
+	}
+
+	verts := []geom.Pt3{{0, 0, -2}, {0.5, 0, -2}, {0.5, 5, -2}}
+
+	// By default, the tool plunges straight down.
+	a.Equal(t, cut(EntryConfig{}, verts),
+		"G1 Z-2.00 F100.00\nG1 X0.50 Y0.00 Z-2.00 F100.00\nG1 X0.50 Y5.00 Z-2.00 F100.00\n")
+
+	// The ramp goes back and forth along the short first segment, from the top of the material.
+	a.Equal(t, cut(EntryConfig{Mode: EntryRamp, MaxRampAngle: 45}, verts),
+		"G1 Z0.00 F100.00\n"+
+			"G1 X0.50 Y0.00 Z-0.50 F100.00\nG1 X0.00 Y0.00 Z-1.00 F100.00\n"+
+			"G1 X0.50 Y0.00 Z-1.50 F100.00\nG1 X0.00 Y0.00 Z-2.00 F100.00\n"+
+			"G1 X0.50 Y0.00 Z-2.00 F100.00\nG1 X0.50 Y5.00 Z-2.00 F100.00\n")
+
+	// Without an entry planner, nothing tells where a helix would fit, so the tool ramps down.
+	helix := EntryConfig{Mode: EntryHelix, MaxRampAngle: 45, HelixRadius: 1}
+	ramp := cut(EntryConfig{Mode: EntryRamp, MaxRampAngle: 45}, verts)
+	a.Equal(t, cut(helix, verts), ramp)
+
+	// A single turn of the helix is enough. The helix is tangent to the path and goes
+	// counterclockwise on its left when it stays above the surface there, here in a pocket 2 mm
+	// deep.
+	pocketAt := func(y0, y1 float64) *entryPlanner {
+		sampler := pocketTestSampler{pMin: geom.NewPt2(-10, y0), pMax: geom.NewPt2(10, y1)}
+		return newEntryPlanner(&sampler, 0, -2, geom.NewPt2(-10, -10), geom.NewPt2(10, 10))
+	}
+	planner = pocketAt(-0.2, 2.5)
+	a.Equal(t, cut(helix, verts),
+		"G1 Z0.00 F100.00\n"+
+			"G3 X1.00 Y1.00 Z-0.50 R1.00 F100.00\nG3 X0.00 Y2.00 Z-1.00 R1.00 F100.00\n"+
+			"G3 X-1.00 Y1.00 Z-1.50 R1.00 F100.00\nG3 X0.00 Y0.00 Z-2.00 R1.00 F100.00\n"+
+			"G1 X0.50 Y0.00 Z-2.00 F100.00\nG1 X0.50 Y5.00 Z-2.00 F100.00\n")
+
+	// Otherwise it goes clockwise on the right of the path.
+	clockwise := "G1 Z0.00 F100.00\n" +
+		"G2 X1.00 Y-1.00 Z-0.50 R1.00 F100.00\nG2 X0.00 Y-2.00 Z-1.00 R1.00 F100.00\n" +
+		"G2 X-1.00 Y-1.00 Z-1.50 R1.00 F100.00\nG2 X0.00 Y0.00 Z-2.00 R1.00 F100.00\n" +
+		"G1 X0.50 Y0.00 Z-2.00 F100.00\nG1 X0.50 Y5.00 Z-2.00 F100.00\n"
+	planner = pocketAt(-2.5, 0.2)
+	a.Equal(t, cut(helix, verts), clockwise)
+
+	// In a groove too narrow for the helix, or when the helix would leave the carving area, the
+	// tool ramps down.
+	planner = pocketAt(-0.2, 0.2)
+	a.Equal(t, cut(helix, verts), ramp)
+	planner = pocketAt(-2.5, 2.5)
+	planner.areaMin = geom.NewPt2(-0.5, -0.5)
+	a.Equal(t, cut(helix, verts), ramp)
+
+	// Without a surface, the helix goes on the clear side of the path.
+	planner = newClearSideEntryPlanner(false /* clear on right */)
+	a.Equal(t, cut(helix, verts), clockwise)
+}
+
+func TestRapidRepositioning(t *testing.T) {
+	var out bytes.Buffer
+	g := newGcodeGenerator(100, 100)
+	g.configure(&out, 100, 100, 10)
+	g.setRapidRepositioning(true)
+	g.currentLoc = geom.NewPt3(10, 0, -1)
+
+	g.startPath(0, 1, -1)
+	g.moveTo(10, 1, -1)
+	g.endPath(false)
+	a.Equal(t, out.String(), "G0 Z1.00\nG0 X0.00 Y1.00 Z1.00\nG1 Z-1.00 F100.00\n"+
+		"G1 X10.00 Y1.00 Z-1.00 F100.00\n")
+}
+
+func TestRetractHeights(t *testing.T) {
+	var out bytes.Buffer
+	g := newGcodeGenerator(100, 100)
+	g.configure(&out, 100, 100, 10)
+	g.configureRetract(RetractConfig{
+		SafeHeight: 40, ClearanceHeight: 12, RetractHeight: 2, RapidThreshold: 20})
+	g.startJob()
+	g.currentLoc = geom.NewPt3(0, 0, -1)
+
+	cut := func(x0, y0, x1, y1 float64) string {
+		out.Reset()
+		g.startPath(x0, y0, -1)
+		g.moveTo(x1, y1, -1)
+		g.endPath(false)
+		return out.String()
+	}
+
+	// Short moves go up to the retract height, long moves up to the clearance height.
+	a.Equal(t, cut(5, 0, 6, 0), "G1 Z2.00 F100.00\nG1 X5.00 Y0.00 Z2.00 F100.00\n"+
+		"G1 Z-1.00 F100.00\nG1 X6.00 Y0.00 Z-1.00 F100.00\n")
+	a.Equal(t, cut(6, 30, 7, 30), "G1 Z12.00 F100.00\nG0 X6.00 Y30.00 Z12.00\n"+
+		"G1 Z-1.00 F100.00\nG1 X7.00 Y30.00 Z-1.00 F100.00\n")
+
+	// The job ends at the safe height.
+	out.Reset()
+	g.endJob()
+	a.Equal(t, out.String(), "G0 Z40.00\nG28 G91 Z0\nM30\n")
+}
+
+func TestMinimumRetract(t *testing.T) {
+	var out bytes.Buffer
+	g := newGcodeGenerator(100, 100)
+	g.configure(&out, 100, 100, 10)
+	g.configureRetract(RetractConfig{Mode: RetractMinimum})
+
+	// The surface is 4 mm deep, except for a wall 2 mm deep at y in [1.5, 2], carved 1 mm at a
+	// time.
+	sampler := pocketTestSampler{pMin: geom.NewPt2(-100, 1.5), pMax: geom.NewPt2(100, 2)}
+	g.setRetractPlanner(newRetractPlanner(&sampler, -4, -2, 1))
+	cut := func(x0, y0, x1, y1 float64) string {
+		out.Reset()
+		g.startPath(x0, y0, -4)
+		g.moveTo(x1, y1, -4)
+		g.endPath(false)
+		return out.String()
+	}
+	cut(0, 0, 10, 0)
+
+	// The previous pass left material up to 1 mm above the paths.
+	a.Equal(t, cut(0, 1, 10, 1), "G1 Z-2.00 F100.00\nG1 X0.00 Y1.00 Z-2.00 F100.00\n"+
+		"G1 Z-4.00 F100.00\nG1 X10.00 Y1.00 Z-4.00 F100.00\n")
+
+	// The tool goes over the wall.
+	a.Equal(t, cut(0, 3, 10, 3), "G1 Z-1.00 F100.00\nG1 X0.00 Y3.00 Z-1.00 F100.00\n"+
+		"G1 Z-4.00 F100.00\nG1 X10.00 Y3.00 Z-4.00 F100.00\n")
+
+	// Retracting to the retract height, the tool goes up to 1 mm.
+	g.configureRetract(RetractConfig{})
+	a.Equal(t, cut(0, 4, 10, 4), "G1 Z1.00 F100.00\nG1 X0.00 Y4.00 Z1.00 F100.00\n"+
+		"G1 Z-4.00 F100.00\nG1 X10.00 Y4.00 Z-4.00 F100.00\n")
+}
+
+// Return the total number of points in the current path. Each arc counts for a single point.
+// Useful for unit testing.
+func (g *gcodeGenerator) getNumPathPointsForTest() int {
+	count := 0
+	for i, s := range g.path {
+		if s.isArcComponent() {
+			count++
+		} else {
+			if i == 0 {
+				count = len(s.points)
+			} else {
+				count = count + len(s.points) - 1
+			}
+		}
+	}
+
+	return count
+}
+
+// Return all the points in the current path as a single array. For arc, only the endpoint
+// is produced in that array. Useful for unit testing.
+func (g *gcodeGenerator) getAllPathPointsForTest() []pt3 {
+	if len(g.path) == 1 && g.path[0].isLineSegmentComponent() {
+		return g.path[0].points
+	}
+
+	allPoints := make([]pt3, 0, 200)
+	for i, s := range g.path {
+		if s.isLineSegmentComponent() {
+			if i == 0 {
+				allPoints = append(allPoints, s.points...)
+			} else {
+				allPoints = append(allPoints, s.points[1:]...)
+			}
+		} else {
+			allPoints = append(allPoints, s.points[1])
+		}
+	}
+
+	return allPoints
+}
+
+func TestStockTracking(t *testing.T) {
+	var out bytes.Buffer
+	g := newGcodeGenerator(100, 100)
+	g.configure(&out, 20, 20, 10)
+	g.configureStock(stock.NewStock(geom.NewPt2(0, 0), geom.NewSize2(20, 20), 0.1))
+	g.setStockCutter(stock.NewBallCutter(2), 0)
+	g.currentLoc = geom.NewPt3(0, 10, 5)
+
+	cut := func(z float64) string {
+		out.Reset()
+		g.startPath(0, 10, z)
+		g.moveTo(10, 10, z)
+		g.endPath(false)
+		return out.String()
+	}
+
+	// The first path removes material, going along it again doesn't and going deeper does.
+	if cut(-1) == "" {
+		t.Errorf("Expected the first path to be emitted\n")
+	}
+	a.Equal(t, cut(-1), "")
+	a.Equal(t, cut(-0.5), "")
+	if cut(-2) == "" {
+		t.Errorf("Expected the deeper path to be emitted\n")
+	}
+
+	// Arcs are subtracted from the stock too.
+	out.Reset()
+	g.startPath(10, 10, -2)
+	g.counterclockwiseArcTo(15, 15, -2, 5)
+	g.endPath(false)
+	if out.Len() == 0 {
+		t.Errorf("Expected the arc to be emitted\n")
+	}
+	out.Reset()
+	g.startPath(10, 10, -2)
+	g.counterclockwiseArcTo(15, 15, -2, 5)
+	g.endPath(false)
+	a.Equal(t, out.String(), "")
+}
+
+func TestStockTrackingMoves(t *testing.T) {
+	var out bytes.Buffer
+	g := newGcodeGenerator(100, 100)
+	g.configure(&out, 20, 20, 10)
+	g.configureStock(stock.NewStock(geom.NewPt2(0, 0), geom.NewSize2(20, 20), 0.1))
+	g.setStockCutter(stock.NewFlatCutter(2), 1)
+	g.currentLoc = geom.NewPt3(0, 10, 0)
+
+	// Moves cutting twice as deep as the maximum step-down go at half the feed rate.
+	g.startPath(0, 10, -2)
+	g.moveTo(10, 10, -2)
+	g.endPath(false)
+	a.Equal(t, out.String(), "G1 Z-2.00 F100.00\nG1 X10.00 Y10.00 Z-2.00 F50.00\n")
+
+	// The moves at either end of the path that remove nothing are skipped.
+	out.Reset()
+	g.startPath(10, 10, -1)
+	g.moveTo(2, 10, -1)
+	g.moveTo(2, 5, -1)
+	g.moveTo(5, 5, -1)
+	g.moveTo(5, 10, -1)
+	g.moveTo(8, 10, -1)
+	g.endPath(false)
+	a.Equal(t, out.String(), "G1 Z1.00 F100.00\nG1 X2.00 Y10.00 Z1.00 F100.00\n"+
+		"G1 Z-1.00 F100.00\nG1 X2.00 Y5.00 Z-1.00 F100.00\nG1 X5.00 Y5.00 Z-1.00 F100.00\n"+
+		"G1 X5.00 Y10.00 Z-1.00 F100.00\n")
+
+	// Moves much deeper than the maximum step-down don't slow down past a minimum feed rate.
+	out.Reset()
+	g.startPath(5, 10, -8)
+	g.moveTo(5, 15, -8)
+	g.endPath(false)
+	a.Equal(t, out.String(), "G1 Z-8.00 F100.00\nG1 X5.00 Y15.00 Z-8.00 F25.00\n")
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/stock"
 )
 
 // A code generator that prints output, for debugging.
@@ -73,6 +74,30 @@ func (g *unitTestGenerator) changeVerticalFeedRate(newFeedRateMmPerMin float64)
 	return 300.0
 }
 
+func (g *unitTestGenerator) changeTool(toolNumber int, description string) {
+}
+
+func (g *unitTestGenerator) setSpindle(spindle SpindleConfig) {
+}
+
+func (g *unitTestGenerator) setStayDownLinker(linker *stayDownLinker) {
+}
+
+func (g *unitTestGenerator) setRetractPlanner(planner *retractPlanner) {
+}
+
+func (g *unitTestGenerator) setEntryPlanner(planner *entryPlanner) {
+}
+
+func (g *unitTestGenerator) setEntry(entry EntryConfig) {
+}
+
+func (g *unitTestGenerator) setRapidRepositioning(enable bool) {
+}
+
+func (g *unitTestGenerator) setStockCutter(cutter stock.Cutter, maxStepDown float64) {
+}
+
 func (g *unitTestGenerator) startJob() {
 
 }
@@ -119,3 +144,89 @@ func (g *unitTestGenerator) endPath(discard bool) {
 		g.gotStart = false
 	}
 }
+
+// A path recorded by the recordingTestGenerator. Arcs are recorded by their end point.
+type recordedPath struct {
+	points  []geom.Pt3
+	numArcs int
+}
+
+// A code generator that records all the non-discarded paths, for unit testing.
+type recordingTestGenerator struct {
+	paths       []recordedPath
+	currentPath recordedPath
+	toolChanges []int
+}
+
+var _ codeGenerator = (*recordingTestGenerator)(nil)
+
+func (g *recordingTestGenerator) configure(
+	output io.Writer,
+	matWidth, matHeight, matThickness float64) {
+}
+
+func (g *recordingTestGenerator) changeHorizontalFeedRate(newFeedRateMmPerMin float64) float64 {
+	return 400.0
+}
+
+func (g *recordingTestGenerator) changeVerticalFeedRate(newFeedRateMmPerMin float64) float64 {
+	return 300.0
+}
+
+func (g *recordingTestGenerator) changeTool(toolNumber int, description string) {
+	g.toolChanges = append(g.toolChanges, toolNumber)
+}
+
+func (g *recordingTestGenerator) setSpindle(spindle SpindleConfig) {
+}
+
+func (g *recordingTestGenerator) setStayDownLinker(linker *stayDownLinker) {
+}
+
+func (g *recordingTestGenerator) setRetractPlanner(planner *retractPlanner) {
+}
+
+func (g *recordingTestGenerator) setEntryPlanner(planner *entryPlanner) {
+}
+
+func (g *recordingTestGenerator) setEntry(entry EntryConfig) {
+}
+
+func (g *recordingTestGenerator) setRapidRepositioning(enable bool) {
+}
+
+func (g *recordingTestGenerator) setStockCutter(cutter stock.Cutter, maxStepDown float64) {
+}
+
+func (g *recordingTestGenerator) startJob() {
+
+}
+
+func (g *recordingTestGenerator) endJob() {
+
+}
+
+func (g *recordingTestGenerator) startPath(x, y, depth float64) {
+	g.currentPath = recordedPath{points: []geom.Pt3{geom.NewPt3(x, y, depth)}}
+}
+
+func (g *recordingTestGenerator) moveTo(x, y, depth float64) {
+	g.currentPath.points = append(g.currentPath.points, geom.NewPt3(x, y, depth))
+}
+
+func (g *recordingTestGenerator) clockwiseArcTo(x, y, depth, radius float64) {
+	g.currentPath.points = append(g.currentPath.points, geom.NewPt3(x, y, depth))
+	g.currentPath.numArcs++
+}
+
+func (g *recordingTestGenerator) counterclockwiseArcTo(x, y, depth, radius float64) {
+	g.currentPath.points = append(g.currentPath.points, geom.NewPt3(x, y, depth))
+	g.currentPath.numArcs++
+}
+
+func (g *recordingTestGenerator) endPath(discard bool) {
+	if !discard {
+		g.paths = append(g.paths, g.currentPath)
+	}
+	g.currentPath = recordedPath{}
+}
@@ -0,0 +1,164 @@
+package carving
+
+import (
+	"math"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+)
+
+// A stayDownLinker decides whether the tool can go straight from the end of a path to the
+// start of the next path at cutting depth, rather than retracting and plunging again. The tool
+// may stay down when the paths are close to each other and the straight link between them
+// doesn't go below the target surface, which is checked by sampling the surface along the link.
+type stayDownLinker struct {
+	sampler       hmap.ScalarGridSampler
+	zWhite        float64 // Z coordinate for white samples.
+	zBlack        float64 // Z coordinate for black samples.
+	maxLinkLength float64 // Longest link, in the XY plane, along which the tool stays down.
+}
+
+func newStayDownLinker(
+	sampler hmap.ScalarGridSampler, zWhite, zBlack, maxLinkLength float64) *stayDownLinker {
+
+	return &stayDownLinker{
+		sampler:       sampler,
+		zWhite:        zWhite,
+		zBlack:        zBlack,
+		maxLinkLength: maxLinkLength,
+	}
+}
+
+// Return whether the tool can go straight from p0 to p1 without lifting.
+func (l *stayDownLinker) canLink(p0, p1 geom.Pt3) bool {
+	// The position of the tool may be unknown, e.g. after a tool change.
+	if math.IsNaN(p0.Z) || math.IsInf(p0.X, 0) || math.IsInf(p0.Y, 0) {
+		return false
+	}
+
+	q0 := geom.NewPt2(p0.X, p0.Y)
+	q1 := geom.NewPt2(p1.X, p1.Y)
+	linkLength := q1.Sub(q0).Len()
+	if linkLength > l.maxLinkLength+epsilon {
+		return false
+	}
+
+	numSteps := int(math.Ceil(linkLength / minStepSize))
+	for i := 0; i <= numSteps; i++ {
+		t := 0.0
+		if numSteps > 0 {
+			t = float64(i) / float64(numSteps)
+		}
+
+		q := q0.Add(q1.Sub(q0).Scale(t))
+		z := p0.Z + t*(p1.Z-p0.Z)
+		s := l.sampler.At(q)
+		if z < (1-s)*l.zBlack+s*l.zWhite-epsilon {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A retractPlanner works out how high the material may be between the end of a path and the
+// start of the next path, for lifting the tool no higher than needed. The material is no higher
+// than the target surface, sampled along the straight link between the paths, nor than one
+// step-down above the paths, since the previous pass went down that far.
+type retractPlanner struct {
+	sampler     hmap.ScalarGridSampler
+	zWhite      float64 // Z coordinate for white samples.
+	zBlack      float64 // Z coordinate for black samples.
+	maxStepDown float64 // Max depth of each pass, or 0 to carve in a single pass.
+}
+
+func newRetractPlanner(
+	sampler hmap.ScalarGridSampler, zWhite, zBlack, maxStepDown float64) *retractPlanner {
+
+	return &retractPlanner{
+		sampler:     sampler,
+		zWhite:      zWhite,
+		zBlack:      zBlack,
+		maxStepDown: maxStepDown,
+	}
+}
+
+// Return the height of the highest point of the material between p0 and p1, no higher than the
+// top of the material, at 0.
+func (r *retractPlanner) getHighestMaterial(p0, p1 geom.Pt3) float64 {
+	// The material is untouched before the first pass, and the position of the tool may be
+	// unknown, e.g. after a tool change.
+	top := math.Max(p0.Z, p1.Z) + r.maxStepDown
+	if r.maxStepDown <= 0 || math.IsNaN(top) || math.IsInf(p0.X, 0) || math.IsInf(p0.Y, 0) {
+		return 0
+	}
+
+	q0 := geom.NewPt2(p0.X, p0.Y)
+	q1 := geom.NewPt2(p1.X, p1.Y)
+	numSteps := int(math.Ceil(q1.Sub(q0).Len() / minStepSize))
+	for i := 0; i <= numSteps && top < 0; i++ {
+		t := 0.0
+		if numSteps > 0 {
+			t = float64(i) / float64(numSteps)
+		}
+
+		s := r.sampler.At(q0.Add(q1.Sub(q0).Scale(t)))
+		top = math.Max(top, (1-s)*r.zBlack+s*r.zWhite)
+	}
+
+	return math.Min(0, top)
+}
+
+// An entryPlanner decides on which side of a path the tool can go down along a helix, at the
+// start of the path, without cutting below the target surface. With a sampler, the helix is
+// checked against the surface sampled along it and must stay over the carving area, since the
+// material around it is not carved. Without a sampler, the helix must go on the clear side of
+// the path, where there is only waste to cut, as outside a contour.
+type entryPlanner struct {
+	sampler     hmap.ScalarGridSampler
+	zWhite      float64  // Z coordinate for white samples.
+	zBlack      float64  // Z coordinate for black samples.
+	areaMin     geom.Pt2 // Corners of the carving area.
+	areaMax     geom.Pt2
+	clearOnLeft bool // Whether the left of the paths is clear, without a sampler.
+}
+
+func newEntryPlanner(sampler hmap.ScalarGridSampler, zWhite, zBlack float64,
+	areaMin, areaMax geom.Pt2) *entryPlanner {
+
+	return &entryPlanner{
+		sampler: sampler,
+		zWhite:  zWhite,
+		zBlack:  zBlack,
+		areaMin: areaMin,
+		areaMax: areaMax,
+	}
+}
+
+func newClearSideEntryPlanner(clearOnLeft bool) *entryPlanner {
+	return &entryPlanner{clearOnLeft: clearOnLeft}
+}
+
+// Return whether the tool can go through the given points, left of the path if onLeft or right
+// of it otherwise, without cutting below the target surface. The points must be no further
+// apart than the resolution of the surface.
+func (e *entryPlanner) canEnterAlong(points []geom.Pt3, onLeft bool) bool {
+	if e.sampler == nil {
+		return onLeft == e.clearOnLeft
+	}
+
+	for _, p := range points {
+		q := geom.NewPt2(p.X, p.Y)
+		if q.X < e.areaMin.X-epsilon || q.X > e.areaMax.X+epsilon ||
+			q.Y < e.areaMin.Y-epsilon || q.Y > e.areaMax.Y+epsilon {
+			return false
+		}
+
+		s := e.sampler.At(q)
+		if p.Z < (1-s)*e.zBlack+s*e.zWhite-epsilon {
+			return false
+		}
+	}
+
+	return true
+}
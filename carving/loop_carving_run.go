@@ -0,0 +1,249 @@
+package carving
+
+import (
+	"fmt"
+	"math"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+)
+
+// A loopSegment is either a straight segment or a circular arc along a loop carving run.
+type loopSegment struct {
+	p0, p1    geom.Pt2 // Start and end points of the segment.
+	center    geom.Pt2 // Center of the arc, for arcs only.
+	radius    float64  // Radius of the arc, or 0 for straight segments.
+	clockwise bool     // Direction of the arc, for arcs only.
+}
+
+// Return the segment going in the reverse direction.
+func (s loopSegment) reversed() loopSegment {
+	return loopSegment{
+		p0:        s.p1,
+		p1:        s.p0,
+		center:    s.center,
+		radius:    s.radius,
+		clockwise: !s.clockwise,
+	}
+}
+
+// Return the length of the segment.
+func (s loopSegment) length() float64 {
+	if s.radius == 0 {
+		return s.p1.Sub(s.p0).Len()
+	}
+	return math.Abs(s.sweepAngle()) * s.radius
+}
+
+// Return the angle swept by the arc, negative for clockwise arcs.
+func (s loopSegment) sweepAngle() float64 {
+	a0 := math.Atan2(s.p0.Y-s.center.Y, s.p0.X-s.center.X)
+	a1 := math.Atan2(s.p1.Y-s.center.Y, s.p1.X-s.center.X)
+	sweep := a1 - a0
+	if s.clockwise {
+		for sweep > 0 {
+			sweep -= 2 * math.Pi
+		}
+	} else {
+		for sweep < 0 {
+			sweep += 2 * math.Pi
+		}
+	}
+	return sweep
+}
+
+// Return the point at fraction t of the segment, 0 <= t <= 1.
+func (s loopSegment) pointAt(t float64) geom.Pt2 {
+	if s.radius == 0 {
+		return s.p0.Add(s.p1.Sub(s.p0).Scale(t))
+	}
+	a := math.Atan2(s.p0.Y-s.center.Y, s.p0.X-s.center.X) + t*s.sweepAngle()
+	return s.center.Add(geom.NewVec2(math.Cos(a), math.Sin(a)).Scale(s.radius))
+}
+
+// A loopCarvingRun is a carving run along a path made of straight segments and arcs, such as
+// a rectangle with rounded corners or a spiral.
+type loopCarvingRun struct {
+	carvingRun
+
+	segments []loopSegment // The segments of the path, in the forward direction.
+	stepSize float64       // The sampling distance along the path.
+}
+
+var _ oneRun = (*loopCarvingRun)(nil)
+
+func (r *loopCarvingRun) configure(
+	sampler hmap.ScalarGridSampler, // The sampler to get the height-map value at each point.
+	generator codeGenerator, // The output code generator.
+	segments []loopSegment, // The segments of the path, in the forward direction.
+	whiteCarvingDepth float64, // The carving depth for white image samples.
+	blackCarvingDepth float64, // The carving depth for black image samples.
+	depthStepDown float64, // How much to step down in depth at each pass.
+) {
+	r.sampler = sampler
+	r.generator = generator
+	r.segments = segments
+
+	// Sample the path at about the resolution of the sampler over the bounds of the path.
+	pMin := geom.NewPt2(math.Inf(1), math.Inf(1))
+	pMax := geom.NewPt2(math.Inf(-1), math.Inf(-1))
+	for _, s := range segments {
+		for _, p := range []geom.Pt2{s.p0, s.p1} {
+			pMin = geom.NewPt2(math.Min(pMin.X, p.X), math.Min(pMin.Y, p.Y))
+			pMax = geom.NewPt2(math.Max(pMax.X, p.X), math.Max(pMax.Y, p.Y))
+		}
+	}
+
+	r.stepSize = math.Inf(1)
+	if n := sampler.GetNumSamplesFromX0ToX1(pMin.X, pMax.X); n > 1 {
+		r.stepSize = (pMax.X - pMin.X) / float64(n-1)
+	}
+	if n := sampler.GetNumSamplesFromY0ToY1(pMin.Y, pMax.Y); n > 1 {
+		r.stepSize = math.Min(r.stepSize, (pMax.Y-pMin.Y)/float64(n-1))
+	}
+	r.stepSize = math.Max(r.stepSize, minStepSize)
+
+	r.blackCarvingDepth = blackCarvingDepth
+	r.whiteCarvingDepth = whiteCarvingDepth
+	r.depthStepDown = depthStepDown
+	r.currentCarvingDepth = 0.0
+
+	r.needMorePasses = len(segments) > 0
+
+	r.sanitize()
+}
+
+// doOnePass is called to generate one carving pass along the loop. Parameter delta must be
+// either +1 or -1. It determines wether the pass goes forward or backward along the loop.
+// Return ErrInternal for other values.
+func (r *loopCarvingRun) doOnePass(delta float64) error {
+	if !r.needMorePasses {
+		return nil
+	}
+
+	if math.Abs(delta) != 1.0 {
+		return fmt.Errorf("%w: invalid pass direction %v, should be 1 or -1", ErrInternal, delta)
+	}
+
+	r.startPass()
+
+	segments := r.segments
+	if delta < 0 {
+		segments = make([]loopSegment, len(r.segments))
+		for i, s := range r.segments {
+			segments[len(segments)-1-i] = s.reversed()
+		}
+	}
+
+	p := segments[0].p0
+	prevDepth, _ := r.getPassDepthAt(p)
+	r.generator.startPath(p.X, p.Y, prevDepth)
+
+	for _, s := range segments {
+		numSteps := int(math.Ceil(s.length()/r.stepSize - 0.001))
+		if numSteps < 1 {
+			numSteps = 1
+		}
+
+		points := make([]geom.Pt2, numSteps)
+		depths := make([]float64, numSteps)
+		flat := true
+		for i := range points {
+			points[i] = s.pointAt(float64(i+1) / float64(numSteps))
+			if i == numSteps-1 {
+				points[i] = s.p1
+			}
+
+			depths[i], _ = r.getPassDepthAt(points[i])
+			flat = flat && math.Abs(depths[i]-prevDepth) < epsilon
+		}
+		prevDepth = depths[numSteps-1]
+
+		// Arcs are only emitted as such where the carving depth is constant along the arc.
+		// Otherwise, we follow the arc with straight segments, like along straight sides.
+		if s.radius > 0 && flat {
+			if s.clockwise {
+				r.generator.clockwiseArcTo(s.p1.X, s.p1.Y, depths[0], s.radius)
+			} else {
+				r.generator.counterclockwiseArcTo(s.p1.X, s.p1.Y, depths[0], s.radius)
+			}
+			continue
+		}
+
+		for i, q := range points {
+			r.generator.moveTo(q.X, q.Y, depths[i])
+		}
+	}
+
+	r.generator.endPath(!r.passCutsMaterial)
+	return nil
+}
+
+// A loopOutline describes the family of loops obtained by insetting the rectangle pMin-pMax
+// with rounded corners. The corner radius of the outer loop is reduced by the inset, so that
+// successive loops stay parallel around the corners too. Loops go clockwise.
+type loopOutline struct {
+	pMin, pMax   geom.Pt2
+	cornerRadius float64 // Corner radius of the outer loop, at inset 0.
+}
+
+// Create the outline for the given rectangle and corner radius. The corner radius is clamped
+// to half the smallest dimension of the rectangle.
+func newLoopOutline(pMin, pMax geom.Pt2, cornerRadius float64) loopOutline {
+	o := loopOutline{pMin: pMin, pMax: pMax}
+	o.cornerRadius = math.Max(0, math.Min(cornerRadius, o.maxInset()))
+	return o
+}
+
+// Return the inset at which the loops degenerate to a line or a point.
+func (o loopOutline) maxInset() float64 {
+	return 0.5 * math.Min(o.pMax.X-o.pMin.X, o.pMax.Y-o.pMin.Y)
+}
+
+// Return the arc for corner j of the loop at the given inset. Corners are numbered clockwise,
+// starting with the top-left corner.
+func (o loopOutline) corner(j int, inset float64) loopSegment {
+	r := math.Max(0, o.cornerRadius-inset)
+	x0, y0 := o.pMin.X+inset, o.pMin.Y+inset
+	x1, y1 := o.pMax.X-inset, o.pMax.Y-inset
+
+	var s loopSegment
+	switch j % 4 {
+	case 0:
+		s = loopSegment{p0: geom.NewPt2(x0, y1-r), p1: geom.NewPt2(x0+r, y1), center: geom.NewPt2(x0+r, y1-r)}
+	case 1:
+		s = loopSegment{p0: geom.NewPt2(x1-r, y1), p1: geom.NewPt2(x1, y1-r), center: geom.NewPt2(x1-r, y1-r)}
+	case 2:
+		s = loopSegment{p0: geom.NewPt2(x1, y0+r), p1: geom.NewPt2(x1-r, y0), center: geom.NewPt2(x1-r, y0+r)}
+	case 3:
+		s = loopSegment{p0: geom.NewPt2(x0+r, y0), p1: geom.NewPt2(x0, y0+r), center: geom.NewPt2(x0+r, y0+r)}
+	}
+
+	s.radius = r
+	s.clockwise = true
+	return s
+}
+
+// Append side j, going from the end of corner j-1 at inset a to the start of corner j at
+// inset b, followed by corner j at inset b. Sides are numbered clockwise, starting with the
+// left side. Zero-length segments are skipped.
+func (o loopOutline) appendSideAndCorner(segments []loopSegment, j int, a, b float64) []loopSegment {
+	c0 := o.corner(j+3, a)
+	c1 := o.corner(j, b)
+
+	if side := (loopSegment{p0: c0.p1, p1: c1.p0}); side.length() > epsilon {
+		segments = append(segments, side)
+	}
+	if c1.radius > epsilon {
+		segments = append(segments, c1)
+	}
+	return segments
+}
+
+// Append a whole loop at the given inset, starting with side j.
+func (o loopOutline) appendLoop(segments []loopSegment, j int, inset float64) []loopSegment {
+	for k := 0; k < 4; k++ {
+		segments = o.appendSideAndCorner(segments, j+k, inset, inset)
+	}
+	return segments
+}
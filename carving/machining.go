@@ -1,12 +1,141 @@
 package carving
 
 import (
+	"fmt"
 	"io"
 
 	"alvin.com/GoCarver/geom"
 	"alvin.com/GoCarver/hmap"
+	"alvin.com/GoCarver/mesh"
+	"alvin.com/GoCarver/stock"
 )
 
+const (
+	OperationRoughing = 400
+	OperationCarving  = 401
+	OperationContour  = 402
+	OperationRest     = 403
+
+	ToolChangeWithM6    = 500
+	ToolChangeWithPause = 501
+
+	EntryPlunge = 600
+	EntryRamp   = 601
+	EntryHelix  = 602
+
+	SpindleClockwise        = 800 // M3
+	SpindleCounterclockwise = 801 // M4
+
+	CoolantOff   = 900 // M9
+	CoolantMist  = 901 // M7, often wired to an air assist.
+	CoolantFlood = 902 // M8
+
+	RetractToHeight = 1000
+	RetractMinimum  = 1001
+
+	OriginBottomLeft  = 1100
+	OriginBottomRight = 1101
+	OriginTopLeft     = 1102
+	OriginTopRight    = 1103
+	OriginCenter      = 1104
+
+	OriginOfMaterial    = 1200
+	OriginOfCarvingArea = 1201
+
+	ZOriginStockTop = 1300
+	ZOriginBed      = 1301
+
+	UnitsMillimeters = 1400
+	UnitsInches      = 1401
+)
+
+type MachineConfig struct {
+	ToolChangeMode int
+	ParkPosition   geom.Pt3 // Where to park the tool for manual tool changes, in machine coordinates.
+
+	EnableArcFitting bool // Replace points along arcs with G2/G3 moves.
+
+	// The name of the post-processor writing the code for the machine controller, see
+	// GetPostProcessorNames. The default post-processor is used when empty.
+	PostProcessor string
+
+	Retract RetractConfig
+	Origin  OriginConfig
+
+	// The units of the generated code, one of the UnitsXXX values. Millimeters when 0. Only the
+	// code is converted: the configuration is always in millimeters.
+	Units int
+}
+
+// OriginConfig configures the work zero of the generated code. The code is generated with the
+// origin at the bottom-left corner of the material, with Z=0 at its top, then translated to the
+// work zero. The default values are used for zero fields.
+type OriginConfig struct {
+	XY         int // One of the OriginXXX values. OriginBottomLeft when 0.
+	XYOf       int // OriginOfMaterial or OriginOfCarvingArea. The material when 0.
+	Z          int // One of the ZOriginXXX values. ZOriginStockTop when 0.
+	WorkOffset int // 1 to 6 to select G54 to G59, or 0 to keep the active work offset.
+}
+
+// RetractConfig configures the heights the tool goes up to, in millimeters above the top of the
+// material. Moves between paths longer than the rapid threshold go up to the clearance height,
+// clear of the clamps, and move with rapids. Shorter moves go up to the retract height. With
+// RetractMinimum, the carving operation only lifts the tool the retract height above the
+// highest point of the material left between the paths, as worked out from the height map and
+// the max step-down. The default values are used for zero fields.
+type RetractConfig struct {
+	Mode            int     // One of the RetractXXX values. RetractToHeight when 0.
+	SafeHeight      float64 // For tool changes and the end of the job. 25 mm by default.
+	ClearanceHeight float64 // 5 mm by default.
+	RetractHeight   float64 // 1 mm by default.
+	RapidThreshold  float64 // Length of the XY move, 50 mm by default.
+}
+
+// Return the configuration with the default values for the zero fields.
+func (r RetractConfig) withDefaults() RetractConfig {
+	if r.Mode == 0 {
+		r.Mode = RetractToHeight
+	}
+	if r.SafeHeight == 0 {
+		r.SafeHeight = 25
+	}
+	if r.ClearanceHeight == 0 {
+		r.ClearanceHeight = 5
+	}
+	if r.RetractHeight == 0 {
+		r.RetractHeight = 1
+	}
+	if r.RapidThreshold == 0 {
+		r.RapidThreshold = 50
+	}
+	return r
+}
+
+// EntryConfig configures how the tool goes down at the start of each path, for the carving and
+// contour operations. Ramps and helixes go down no steeper than the max ramp angle. Helixes go
+// beside the paths, where they don't cut below the target surface nor into the part, and ramps
+// replace them where they don't fit.
+type EntryConfig struct {
+	Mode         int     // One of the EntryXXX values. Plunges straight down when 0.
+	MaxRampAngle float64 // Degrees from the horizontal.
+	HelixRadius  float64
+}
+
+// StockConfig configures the model of the material remaining on the workpiece. When enabled,
+// every move of the job is subtracted from the stock. The moves at either end of a path that
+// remove no material, e.g. because an earlier pass or operation already went deeper, are
+// skipped, as are whole paths that remove nothing. Moves that cut deeper than the maximum
+// step-down of the tool are slowed down. The carving only cuts the stock left by the previous
+// operations. The stock is always tracked when roughing is enabled, so that the operations
+// after the roughing start from the material it left.
+type StockConfig struct {
+	Enable     bool
+	Resolution float64 // Width of the cells of the stock model, defaultStockResolution when 0.
+}
+
+// Width of the cells of the stock model when not configured, in mm.
+const defaultStockResolution = 0.25
+
 type MaterialConfig struct {
 	MaterialDim       geom.Size2
 	CarvingAreaOrigin geom.Pt2
@@ -15,11 +144,26 @@ type MaterialConfig struct {
 }
 
 type ToolConfig struct {
+	ToolNumber    int // Assigned automatically when 0.
 	ToolType      int
 	ToolDiameter  float64
 	HorizFeedRate float64
 	VertFeedRate  float64
 	MaxStepDown   float64
+	ToolAngle     float64 // Included angle in degrees, for V-bits and tapered ball-nose tools.
+	TipRadius     float64 // Radius of the ball at the tip of tapered ball-nose tools.
+	CornerRadius  float64 // Corner radius of bull-nose tools.
+	Spindle       SpindleConfig
+}
+
+// SpindleConfig configures the spindle and the coolant while a tool cuts. The spindle is left
+// alone when the speed is 0, e.g. for trim routers switched on by hand. The spindle and the
+// coolant are turned off for tool changes and at the end of the job.
+type SpindleConfig struct {
+	Speed       float64 // Revolutions per minute.
+	Direction   int     // One of the SpindleXXX values. Clockwise when 0.
+	SpinUpDwell float64 // Seconds to wait for the spindle to reach its speed.
+	Coolant     int     // One of the CoolantXXX values. Off when 0.
 }
 
 type CarvingConfig struct {
@@ -28,16 +172,65 @@ type CarvingConfig struct {
 	CarvingTopZ         float64
 	CarvingBottomZ      float64
 	StepOverFraction    float64
+	ScallopHeight       float64 // Target scallop height, or 0 to use a fixed step-over.
+	EnableStayDown      bool    // Link adjacent runs at cutting depth when possible.
+	SkipAirCuts         bool    // Skip the stretches of the runs carved by previous passes.
 	CarvingMode         int
+	RasterAngle         float64 // Direction of the runs in degrees, when carving at an angle.
+	RasterDirection     int     // One of the RasterXXX values.
+	LoopCornerRadius    float64 // Corner radius of the outer loop, for spirals and concentric loops.
 	EnableFinishing     bool
 	FinishStepFraction  float64
 	FinishHorizFeedRate float64
 	FinishMode          int
 }
 
+type ContourConfig struct {
+	Enable         bool
+	Tool           ToolConfig
+	Outline        int
+	CornerRadius   float64
+	NumTabsPerSide int
+	TabWidth       float64
+	TabHeight      float64
+}
+
+type RoughingConfig struct {
+	Enable           bool
+	Tool             ToolConfig
+	StepOverFraction float64
+	StockToLeave     float64
+	Target           *mesh.TriangleMesh // The carving, sliced at each roughing level.
+}
+
+// RestConfig configures the rest machining with a tool smaller than the carving tool, where
+// the carving tool could not reach.
+type RestConfig struct {
+	Enable           bool
+	Tool             ToolConfig
+	StepOverFraction float64
+	Sampler          hmap.ScalarGridSampler // Must account for the shape of the rest-machining tool.
+}
+
 type MachiningConfig struct {
+	Machine  MachineConfig
 	Material MaterialConfig
+	Roughing RoughingConfig
 	Carving  CarvingConfig
+	Rest     RestConfig
+	Contour  ContourConfig
+	Entry    EntryConfig
+	Stock    StockConfig
+
+	// The operations of the job, in order, each one of the OperationXXX values. When empty,
+	// the job consists of the enabled operations: roughing, carving, rest machining then contour.
+	Operations []int
+}
+
+// An operation of the job, with its tool.
+type operation struct {
+	kind int
+	tool ToolConfig
 }
 
 func configureCarver(c *Carver, mc *MachiningConfig) {
@@ -46,23 +239,528 @@ func configureCarver(c *Carver, mc *MachiningConfig) {
 
 	c.ConfigureTool(mc.Carving.Tool.ToolType, mc.Carving.Tool.ToolDiameter,
 		mc.Carving.Tool.HorizFeedRate, mc.Carving.Tool.VertFeedRate)
+	c.ConfigureToolShape(mc.Carving.Tool.ToolAngle, mc.Carving.Tool.TipRadius,
+		mc.Carving.Tool.CornerRadius)
 
 	c.ConfigureCarvingProfile(mc.Carving.Sampler, mc.Carving.CarvingTopZ, mc.Carving.CarvingBottomZ,
 		mc.Carving.StepOverFraction, mc.Carving.Tool.MaxStepDown, mc.Carving.CarvingMode)
+	c.ConfigureRasterAngle(mc.Carving.RasterAngle)
+	c.ConfigureRasterDirection(mc.Carving.RasterDirection)
+	c.ConfigureSpindleDirection(mc.Carving.Tool.Spindle.Direction)
+	c.ConfigureLoopCornerRadius(mc.Carving.LoopCornerRadius)
+	c.ConfigureScallopHeight(mc.Carving.ScallopHeight)
+	c.ConfigureStayDownLinking(mc.Carving.EnableStayDown)
+	c.ConfigureAirCutElimination(mc.Carving.SkipAirCuts)
 
 	c.ConfigureFinishingPass(mc.Carving.EnableFinishing, mc.Carving.FinishStepFraction,
 		mc.Carving.FinishMode, mc.Carving.FinishHorizFeedRate)
 }
 
-func DoMachining(config *MachiningConfig, output io.Writer) {
-	gen := newGrblGenerator(config.Carving.Tool.HorizFeedRate, config.Carving.Tool.VertFeedRate)
-	gen.configure(output, config.Material.MaterialDim.W, config.Material.MaterialDim.H,
-		config.Material.MaterialThickness)
+func configureRougher(r *WaterlineRougher, mc *MachiningConfig) {
+	r.ConfigureArea(mc.Material.CarvingAreaOrigin, mc.Material.CarvingAreaDim)
+	r.ConfigureTool(mc.Roughing.Tool.ToolDiameter, mc.Roughing.StepOverFraction,
+		mc.Roughing.Tool.MaxStepDown)
+	r.ConfigureProfile(mc.Roughing.Target, mc.Material.MaterialThickness, mc.Roughing.StockToLeave)
+}
+
+func configureRestMachiner(r *RestMachiner, mc *MachiningConfig) {
+	r.ConfigureArea(mc.Material.CarvingAreaOrigin, mc.Material.CarvingAreaDim)
+	r.ConfigureTool(mc.Rest.Tool.ToolDiameter, mc.Rest.StepOverFraction, mc.Rest.Tool.MaxStepDown)
+	r.ConfigureProfile(mc.Rest.Sampler, mc.Carving.CarvingTopZ, mc.Carving.CarvingBottomZ,
+		mc.Material.MaterialThickness)
+	r.ConfigurePreviousTool(mc.Carving.Sampler, mc.Carving.Tool.ToolDiameter)
+}
+
+func configureContourCutter(c *ContourCutter, mc *MachiningConfig) {
+	outlineOrigin := mc.Material.CarvingAreaOrigin
+	outlineDim := mc.Material.CarvingAreaDim
+	if mc.Contour.Outline == ContourAroundMaterial {
+		outlineOrigin = geom.NewPt2(0, 0)
+		outlineDim = mc.Material.MaterialDim
+	}
 
-	carver := NewCarver(output)
-	configureCarver(carver, config)
+	c.ConfigureOutline(outlineOrigin, outlineDim, mc.Contour.CornerRadius,
+		mc.Material.MaterialThickness)
+	c.ConfigureTool(mc.Contour.Tool.ToolDiameter, mc.Contour.Tool.MaxStepDown)
+	if mc.Contour.Tool.ToolType == ToolTypeBullNose {
+		c.ConfigureToolCornerRadius(mc.Contour.Tool.CornerRadius)
+	}
+	c.ConfigureTabs(mc.Contour.NumTabsPerSide, mc.Contour.TabWidth, mc.Contour.TabHeight)
+}
+
+// DoMachining generates the code for the whole job to the given output, with tool changes
+// between operations that use different tools. Return an error wrapping one of the ErrXXX
+// values if the job is not valid, or the error writing the output. No code is generated for
+// an invalid job.
+func DoMachining(config *MachiningConfig, output io.Writer) error {
+	if err := validateMachiningConfig(config); err != nil {
+		return err
+	}
 
+	remainingStock := newMachiningStock(config)
+	gen := newMachiningGenerator(config, output, remainingStock)
 	gen.startJob()
-	carver.Run(gen)
+	for _, op := range getOperations(config) {
+		if err := doOperation(op, config, gen, remainingStock); err != nil {
+			return err
+		}
+	}
+	gen.endJob()
+	return gen.getError()
+}
+
+// DoMachiningWithOneFilePerTool generates the code for the job to a separate output for each
+// tool change. Function newOutput is called to get the output for the n-th tool change, n
+// starting at 0, and the tool to use. Return errors as DoMachining.
+func DoMachiningWithOneFilePerTool(
+	config *MachiningConfig, newOutput func(n int, tool ToolConfig) io.Writer) error {
+
+	if err := validateMachiningConfig(config); err != nil {
+		return err
+	}
+
+	var gen *gcodeGenerator
+	numOutputs := 0
+	remainingStock := newMachiningStock(config)
+	for _, op := range getOperations(config) {
+		if gen == nil || op.tool.ToolNumber != gen.currentTool {
+			if gen != nil {
+				gen.endJob()
+				if err := gen.getError(); err != nil {
+					return err
+				}
+			}
+			gen = newMachiningGenerator(config, newOutput(numOutputs, op.tool), remainingStock)
+			gen.startJob()
+			numOutputs++
+		}
+		if err := doOperation(op, config, gen, remainingStock); err != nil {
+			return err
+		}
+	}
+
+	if gen == nil {
+		return nil
+	}
 	gen.endJob()
+	return gen.getError()
+}
+
+// Check that the job can be carved: the material, the carving area, the tools and the modes of
+// the enabled operations.
+func validateMachiningConfig(config *MachiningConfig) error {
+	if _, err := GetPostProcessor(config.Machine.PostProcessor); err != nil {
+		return err
+	}
+
+	mat := &config.Material
+	if mat.MaterialDim.W <= 0 || mat.MaterialDim.H <= 0 || mat.MaterialThickness <= 0 {
+		return fmt.Errorf("%w: %.2f x %.2f mm, %.2f mm thick", ErrInvalidMaterial,
+			mat.MaterialDim.W, mat.MaterialDim.H, mat.MaterialThickness)
+	}
+	if mat.CarvingAreaDim.W <= 0 || mat.CarvingAreaDim.H <= 0 {
+		return fmt.Errorf("%w: %.2f x %.2f mm", ErrEmptyCarvingArea,
+			mat.CarvingAreaDim.W, mat.CarvingAreaDim.H)
+	}
+
+	switch config.Machine.ToolChangeMode {
+	case 0, ToolChangeWithM6, ToolChangeWithPause:
+	default:
+		return fmt.Errorf("%w: tool change mode %d", ErrUnsupportedMode,
+			config.Machine.ToolChangeMode)
+	}
+	switch config.Entry.Mode {
+	case 0, EntryPlunge, EntryRamp, EntryHelix:
+	default:
+		return fmt.Errorf("%w: entry mode %d", ErrUnsupportedMode, config.Entry.Mode)
+	}
+	if err := validateRetractConfig(config.Machine.Retract); err != nil {
+		return err
+	}
+	if err := validateOriginConfig(config.Machine.Origin); err != nil {
+		return err
+	}
+	switch config.Machine.Units {
+	case 0, UnitsMillimeters, UnitsInches:
+	default:
+		return fmt.Errorf("%w: units %d", ErrUnsupportedMode, config.Machine.Units)
+	}
+
+	ops := getOperations(config)
+	if len(ops) == 0 {
+		return fmt.Errorf("%w: no operation", ErrInvalidParameter)
+	}
+	for _, op := range ops {
+		if err := validateTool(op.tool); err != nil {
+			return err
+		}
+
+		switch op.kind {
+		case OperationRoughing:
+			if config.Roughing.Target == nil || config.Roughing.StepOverFraction <= 0 {
+				return fmt.Errorf("%w: roughing needs a target mesh and a step-over",
+					ErrInvalidParameter)
+			}
+		case OperationCarving:
+			if err := validateCarvingConfig(&config.Carving); err != nil {
+				return err
+			}
+		case OperationRest:
+			if config.Rest.Sampler == nil || config.Rest.StepOverFraction <= 0 {
+				return fmt.Errorf("%w: rest machining needs a height map and a step-over",
+					ErrInvalidParameter)
+			}
+		case OperationContour:
+			if op.tool.ToolType != ToolTypeFlat && op.tool.ToolType != ToolTypeBullNose {
+				return fmt.Errorf("%w: T%d must be a flat or bull-nose tool to cut the contour",
+					ErrInvalidTool, op.tool.ToolNumber)
+			}
+			if config.Contour.Outline != 0 && config.Contour.Outline != ContourAroundCarvingArea &&
+				config.Contour.Outline != ContourAroundMaterial {
+				return fmt.Errorf("%w: contour outline %d", ErrUnsupportedMode,
+					config.Contour.Outline)
+			}
+		}
+	}
+	return nil
+}
+
+// Check the shape, size, step-down and feed rates of the tool.
+func validateTool(tool ToolConfig) error {
+	if tool.ToolType < ToolTypeBallPoint || tool.ToolType > ToolTypeBullNose {
+		return fmt.Errorf("%w: T%d has unknown type %d", ErrInvalidTool,
+			tool.ToolNumber, tool.ToolType)
+	}
+	if tool.ToolDiameter <= 0 {
+		return fmt.Errorf("%w: T%d has diameter %.2f mm", ErrInvalidTool,
+			tool.ToolNumber, tool.ToolDiameter)
+	}
+	if tool.MaxStepDown <= 0 {
+		return fmt.Errorf("%w: T%d has max step-down %.2f mm", ErrInvalidTool,
+			tool.ToolNumber, tool.MaxStepDown)
+	}
+	if tool.HorizFeedRate <= 0 || tool.VertFeedRate <= 0 {
+		return fmt.Errorf("%w: T%d has feed rates %.2f and %.2f mm/min", ErrInvalidTool,
+			tool.ToolNumber, tool.HorizFeedRate, tool.VertFeedRate)
+	}
+
+	radius := 0.5 * tool.ToolDiameter
+	switch tool.ToolType {
+	case ToolTypeVBit, ToolTypeTaperedBall:
+		if tool.ToolAngle <= 0 || tool.ToolAngle >= 180 {
+			return fmt.Errorf("%w: T%d has included angle %.0f degrees", ErrInvalidTool,
+				tool.ToolNumber, tool.ToolAngle)
+		}
+		if tool.ToolType == ToolTypeTaperedBall &&
+			(tool.TipRadius <= 0 || tool.TipRadius > radius) {
+			return fmt.Errorf("%w: T%d has tip radius %.2f mm", ErrInvalidTool,
+				tool.ToolNumber, tool.TipRadius)
+		}
+	case ToolTypeBullNose:
+		if tool.CornerRadius < 0 || tool.CornerRadius > radius {
+			return fmt.Errorf("%w: T%d has corner radius %.2f mm", ErrInvalidTool,
+				tool.ToolNumber, tool.CornerRadius)
+		}
+	}
+
+	spindle := &tool.Spindle
+	if spindle.Speed < 0 || spindle.SpinUpDwell < 0 {
+		return fmt.Errorf("%w: T%d has spindle speed %.0f rpm and spin-up dwell %.1f s",
+			ErrInvalidTool, tool.ToolNumber, spindle.Speed, spindle.SpinUpDwell)
+	}
+	switch spindle.Direction {
+	case 0, SpindleClockwise, SpindleCounterclockwise:
+	default:
+		return fmt.Errorf("%w: spindle direction %d", ErrUnsupportedMode, spindle.Direction)
+	}
+	switch spindle.Coolant {
+	case 0, CoolantOff, CoolantMist, CoolantFlood:
+	default:
+		return fmt.Errorf("%w: coolant mode %d", ErrUnsupportedMode, spindle.Coolant)
+	}
+	return nil
+}
+
+// Check the origin choices and the work offset.
+func validateOriginConfig(o OriginConfig) error {
+	if o.XY != 0 && (o.XY < OriginBottomLeft || o.XY > OriginCenter) {
+		return fmt.Errorf("%w: XY origin %d", ErrUnsupportedMode, o.XY)
+	}
+	if o.XYOf != 0 && o.XYOf != OriginOfMaterial && o.XYOf != OriginOfCarvingArea {
+		return fmt.Errorf("%w: XY origin of %d", ErrUnsupportedMode, o.XYOf)
+	}
+	if o.Z != 0 && o.Z != ZOriginStockTop && o.Z != ZOriginBed {
+		return fmt.Errorf("%w: Z origin %d", ErrUnsupportedMode, o.Z)
+	}
+	if o.WorkOffset < 0 || o.WorkOffset > 6 {
+		return fmt.Errorf("%w: work offset %d", ErrUnsupportedMode, o.WorkOffset)
+	}
+	return nil
+}
+
+// GetWorkOrigin returns the position of the work zero, relative to the bottom-left corner of
+// the top of the material.
+func GetWorkOrigin(config *MachiningConfig) geom.Pt3 {
+	origin := config.Machine.Origin
+	corner := geom.NewPt2(0, 0)
+	size := config.Material.MaterialDim
+	if origin.XYOf == OriginOfCarvingArea {
+		corner = config.Material.CarvingAreaOrigin
+		size = config.Material.CarvingAreaDim
+	}
+
+	p := geom.NewPt3(corner.X, corner.Y, 0)
+	switch origin.XY {
+	case OriginBottomRight:
+		p.X += size.W
+	case OriginTopLeft:
+		p.Y += size.H
+	case OriginTopRight:
+		p.X += size.W
+		p.Y += size.H
+	case OriginCenter:
+		p.X += 0.5 * size.W
+		p.Y += 0.5 * size.H
+	}
+	if origin.Z == ZOriginBed {
+		p.Z = -config.Material.MaterialThickness
+	}
+	return p
+}
+
+// Return a human-readable description of the work zero, for the header of the code.
+func describeWorkOrigin(origin OriginConfig) string {
+	corner := map[int]string{
+		OriginBottomRight: "the bottom-right corner",
+		OriginTopLeft:     "the top-left corner",
+		OriginTopRight:    "the top-right corner",
+		OriginCenter:      "the center",
+	}[origin.XY]
+	if corner == "" {
+		corner = "the bottom-left corner"
+	}
+
+	of := "the material"
+	if origin.XYOf == OriginOfCarvingArea {
+		of = "the carving area"
+	}
+
+	z := "the top of the material"
+	if origin.Z == ZOriginBed {
+		z = "the machine bed"
+	}
+
+	desc := fmt.Sprintf("Work zero: X0 Y0 at %s of %s, Z0 at %s", corner, of, z)
+	if origin.WorkOffset > 0 {
+		desc += fmt.Sprintf(", in G%d", 53+origin.WorkOffset)
+	}
+	return desc
+}
+
+// Check that the heights go up from the retract height to the safe height.
+func validateRetractConfig(r RetractConfig) error {
+	if r.Mode != 0 && r.Mode != RetractToHeight && r.Mode != RetractMinimum {
+		return fmt.Errorf("%w: retract mode %d", ErrUnsupportedMode, r.Mode)
+	}
+
+	r = r.withDefaults()
+	if r.RetractHeight <= 0 || r.ClearanceHeight < r.RetractHeight ||
+		r.SafeHeight < r.ClearanceHeight {
+		return fmt.Errorf("%w: retract, clearance and safe heights %.2f, %.2f and %.2f mm",
+			ErrInvalidParameter, r.RetractHeight, r.ClearanceHeight, r.SafeHeight)
+	}
+	if r.RapidThreshold < 0 {
+		return fmt.Errorf("%w: rapid threshold %.2f mm", ErrInvalidParameter, r.RapidThreshold)
+	}
+	return nil
+}
+
+// Check the height map, step-over and modes of the carving operation.
+func validateCarvingConfig(cc *CarvingConfig) error {
+	if cc.Sampler == nil {
+		return fmt.Errorf("%w: carving needs a height map", ErrInvalidParameter)
+	}
+	if cc.StepOverFraction <= 0 && cc.ScallopHeight <= 0 {
+		return fmt.Errorf("%w: carving needs a step-over or a scallop height",
+			ErrInvalidParameter)
+	}
+	if cc.CarvingMode < CarveModeXOnly || cc.CarvingMode > CarveModeConcentric {
+		return fmt.Errorf("%w: carving mode %d", ErrUnsupportedMode, cc.CarvingMode)
+	}
+	if err := checkScallopHeightSupport(cc.ScallopHeight, cc.CarvingMode, cc.Sampler); err != nil {
+		return err
+	}
+	if cc.RasterDirection != 0 &&
+		(cc.RasterDirection < RasterBackAndForth || cc.RasterDirection > RasterConventionalOnly) {
+		return fmt.Errorf("%w: raster direction %d", ErrUnsupportedMode, cc.RasterDirection)
+	}
+	if cc.EnableFinishing && (cc.FinishMode < FinishPassModeAlongFirstDirOnly ||
+		cc.FinishMode > FinishPassModeAlongAllDirs) {
+		return fmt.Errorf("%w: finishing pass mode %d", ErrUnsupportedMode, cc.FinishMode)
+	}
+	return nil
+}
+
+func newMachiningGenerator(
+	config *MachiningConfig, output io.Writer, remainingStock *stock.Stock) *gcodeGenerator {
+
+	gen := newGcodeGenerator(config.Carving.Tool.HorizFeedRate, config.Carving.Tool.VertFeedRate)
+	gen.configure(output, config.Material.MaterialDim.W, config.Material.MaterialDim.H,
+		config.Material.MaterialThickness)
+	gen.configureToolChange(config.Machine.ToolChangeMode, config.Machine.ParkPosition)
+	gen.configureArcFitting(config.Machine.EnableArcFitting)
+	gen.configureRetract(config.Machine.Retract)
+	gen.configureWorkOrigin(GetWorkOrigin(config), config.Machine.Origin.WorkOffset,
+		describeWorkOrigin(config.Machine.Origin))
+	gen.configureStock(remainingStock)
+	if post, err := GetPostProcessor(config.Machine.PostProcessor); err == nil {
+		gen.configurePostProcessor(post.WithUnits(config.Machine.Units))
+	}
+	return gen
+}
+
+// Return the model of the stock over the whole material, or nil when the stock isn't tracked.
+// The stock is shared by all the operations of the job.
+func newMachiningStock(config *MachiningConfig) *stock.Stock {
+	if !config.Stock.Enable && !config.Roughing.Enable {
+		return nil
+	}
+
+	resolution := config.Stock.Resolution
+	if resolution <= 0 {
+		resolution = defaultStockResolution
+	}
+	return stock.NewStock(geom.NewPt2(0, 0), config.Material.MaterialDim, resolution)
+}
+
+// GetToolCutters returns the cutters of the tools used by the job, by tool number, as numbered
+// in the generated code.
+func GetToolCutters(config *MachiningConfig) map[int]stock.Cutter {
+	cutters := map[int]stock.Cutter{}
+	for _, op := range getOperations(config) {
+		cutters[op.tool.ToolNumber] = newStockCutter(op.tool)
+	}
+	return cutters
+}
+
+// Return the operations of the job, in order, with their tools. Tools without a tool number
+// get the number of an identical tool used earlier in the job, or the next unused number.
+func getOperations(config *MachiningConfig) []operation {
+	kinds := config.Operations
+	if len(kinds) == 0 {
+		if config.Roughing.Enable {
+			kinds = append(kinds, OperationRoughing)
+		}
+		kinds = append(kinds, OperationCarving)
+		if config.Rest.Enable {
+			kinds = append(kinds, OperationRest)
+		}
+		if config.Contour.Enable {
+			kinds = append(kinds, OperationContour)
+		}
+	}
+
+	ops := make([]operation, 0, len(kinds))
+	maxToolNumber := 0
+	for _, kind := range kinds {
+		op := operation{kind: kind}
+		switch kind {
+		case OperationRoughing:
+			op.tool = config.Roughing.Tool
+			op.tool.ToolType = ToolTypeFlat
+		case OperationCarving:
+			op.tool = config.Carving.Tool
+		case OperationRest:
+			op.tool = config.Rest.Tool
+		case OperationContour:
+			op.tool = config.Contour.Tool
+		default:
+			continue
+		}
+
+		if op.tool.ToolNumber > maxToolNumber {
+			maxToolNumber = op.tool.ToolNumber
+		}
+		ops = append(ops, op)
+	}
+
+	for i := range ops {
+		if ops[i].tool.ToolNumber != 0 {
+			continue
+		}
+		for j := 0; j < i; j++ {
+			if isSameCutter(ops[i].tool, ops[j].tool) {
+				ops[i].tool.ToolNumber = ops[j].tool.ToolNumber
+				break
+			}
+		}
+		if ops[i].tool.ToolNumber == 0 {
+			maxToolNumber++
+			ops[i].tool.ToolNumber = maxToolNumber
+		}
+	}
+
+	return ops
+}
+
+// Return whether both tools have the same cutter, regardless of feed rates and step-down.
+func isSameCutter(t1, t2 ToolConfig) bool {
+	return t1.ToolType == t2.ToolType &&
+		t1.ToolDiameter == t2.ToolDiameter &&
+		t1.ToolAngle == t2.ToolAngle &&
+		t1.TipRadius == t2.TipRadius &&
+		t1.CornerRadius == t2.CornerRadius
+}
+
+// Return a short, human-readable description of the tool.
+func describeTool(tool ToolConfig) string {
+	switch tool.ToolType {
+	case ToolTypeFlat:
+		return fmt.Sprintf("%.2f mm flat end-mill", tool.ToolDiameter)
+	case ToolTypeVBit:
+		return fmt.Sprintf("%.2f mm %.0f-degree V-bit", tool.ToolDiameter, tool.ToolAngle)
+	case ToolTypeTaperedBall:
+		return fmt.Sprintf("%.2f mm %.0f-degree tapered ball-nose, %.2f mm tip radius",
+			tool.ToolDiameter, tool.ToolAngle, tool.TipRadius)
+	case ToolTypeBullNose:
+		return fmt.Sprintf("%.2f mm bull-nose, %.2f mm corner radius",
+			tool.ToolDiameter, tool.CornerRadius)
+	default:
+		return fmt.Sprintf("%.2f mm ball-nose", tool.ToolDiameter)
+	}
+}
+
+// Generate the code for one operation, changing the tool first if needed.
+func doOperation(
+	op operation, config *MachiningConfig, gen codeGenerator, remainingStock *stock.Stock) error {
+
+	gen.changeTool(op.tool.ToolNumber, describeTool(op.tool))
+	gen.setSpindle(op.tool.Spindle)
+	gen.changeHorizontalFeedRate(op.tool.HorizFeedRate)
+	gen.changeVerticalFeedRate(op.tool.VertFeedRate)
+	gen.setEntry(EntryConfig{Mode: EntryPlunge})
+	gen.setStockCutter(newStockCutter(op.tool), op.tool.MaxStepDown)
+
+	switch op.kind {
+	case OperationRoughing:
+		rougher := NewWaterlineRougher()
+		configureRougher(rougher, config)
+		rougher.Run(gen)
+	case OperationCarving:
+		carver := NewCarver(nil)
+		configureCarver(carver, config)
+		carver.ConfigureRemainingStock(remainingStock)
+		gen.setEntry(config.Entry)
+		return carver.Run(gen)
+	case OperationRest:
+		rest := NewRestMachiner()
+		configureRestMachiner(rest, config)
+		return rest.Run(gen)
+	case OperationContour:
+		contour := NewContourCutter()
+		configureContourCutter(contour, config)
+		gen.setEntry(config.Entry)
+		contour.Run(gen)
+	}
+	return nil
 }
@@ -0,0 +1,344 @@
+package carving
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+	"alvin.com/GoCarver/mesh"
+)
+
+func newMachiningConfigForTest(t *testing.T, sampler hmap.ScalarGridSampler) *MachiningConfig {
+	mc := &MachiningConfig{}
+	mc.Material = MaterialConfig{
+		MaterialDim:       geom.NewSize2(20, 20),
+		CarvingAreaOrigin: geom.NewPt2(0, 0),
+		CarvingAreaDim:    geom.NewSize2(20, 20),
+		MaterialThickness: 10,
+	}
+
+	mc.Roughing.Enable = true
+	mc.Roughing.Tool = ToolConfig{ToolDiameter: 6, HorizFeedRate: 800, VertFeedRate: 300, MaxStepDown: 2}
+	mc.Roughing.StepOverFraction = 0.5
+	target, err := mesh.NewTriangleMesh(geom.NewPt2(0, 0), geom.NewPt2(20, 20), 8, 10, sampler)
+	if err != nil {
+		t.Fatalf("Machining config: unexpected error building the target mesh: %v\n", err)
+	}
+	mc.Roughing.Target = target
+
+	mc.Carving.Tool = ToolConfig{ToolType: ToolTypeBallPoint, ToolDiameter: 1.5,
+		HorizFeedRate: 500, VertFeedRate: 300, MaxStepDown: 1}
+	mc.Carving.Sampler = sampler
+	mc.Carving.CarvingTopZ = 10
+	mc.Carving.CarvingBottomZ = 8
+	mc.Carving.StepOverFraction = 0.5
+	mc.Carving.CarvingMode = CarveModeXOnly
+
+	mc.Contour.Enable = true
+	mc.Contour.Tool = mc.Roughing.Tool
+	mc.Contour.Tool.ToolType = ToolTypeFlat
+	mc.Contour.Tool.MaxStepDown = 5
+	return mc
+}
+
+func TestGetOperations(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	mc := newMachiningConfigForTest(t, &sampler)
+
+	// The contour tool is the same cutter as the roughing tool, so it gets the same number.
+	ops := getOperations(mc)
+	expected := []operation{
+		{kind: OperationRoughing, tool: ToolConfig{ToolNumber: 1}},
+		{kind: OperationCarving, tool: ToolConfig{ToolNumber: 2}},
+		{kind: OperationContour, tool: ToolConfig{ToolNumber: 1}},
+	}
+	if len(ops) != len(expected) {
+		t.Fatalf("Get operations: expected %d operations, got %d\n", len(expected), len(ops))
+	}
+	for i, op := range ops {
+		if op.kind != expected[i].kind || op.tool.ToolNumber != expected[i].tool.ToolNumber {
+			t.Errorf("Get operations: operation %d, expected %d with T%d, got %d with T%d\n", i,
+				expected[i].kind, expected[i].tool.ToolNumber, op.kind, op.tool.ToolNumber)
+		}
+	}
+
+	cutters := GetToolCutters(mc)
+	if len(cutters) != 2 || cutters[1].Radius() != 3 || cutters[2].Radius() != 0.75 {
+		t.Errorf("Get operations: unexpected cutters %v\n", cutters)
+	}
+
+	// Explicit tool numbers are kept and automatic ones are assigned after them.
+	mc.Carving.Tool.ToolNumber = 5
+	mc.Operations = []int{OperationCarving, OperationRoughing}
+	ops = getOperations(mc)
+	if len(ops) != 2 || ops[0].tool.ToolNumber != 5 || ops[1].tool.ToolNumber != 6 {
+		t.Errorf("Get operations: unexpected operations %v\n", ops)
+	}
+}
+
+func TestDoMachiningWithToolChanges(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	mc := newMachiningConfigForTest(t, &sampler)
+
+	// A single output, with two tool changes.
+	var out bytes.Buffer
+	DoMachining(mc, &out)
+	code := out.String()
+	if n := strings.Count(code, " M6\n"); n != 3 {
+		t.Errorf("Do machining: expected 3 tool selections, got %d\n", n)
+	}
+	i1 := strings.Index(code, "T1 M6")
+	i2 := strings.Index(code, "T2 M6")
+	if i1 < 0 || i2 < i1 || strings.LastIndex(code, "T1 M6") < i2 {
+		t.Errorf("Do machining: expected tools T1, T2 then T1\n")
+	}
+
+	// One output per tool change.
+	var outs []*bytes.Buffer
+	var tools []int
+	DoMachiningWithOneFilePerTool(mc, func(n int, tool ToolConfig) io.Writer {
+		if n != len(outs) {
+			t.Errorf("Do machining per tool: expected output %d, got %d\n", len(outs), n)
+		}
+		outs = append(outs, &bytes.Buffer{})
+		tools = append(tools, tool.ToolNumber)
+		return outs[n]
+	})
+
+	if len(outs) != 3 || tools[0] != 1 || tools[1] != 2 || tools[2] != 1 {
+		t.Fatalf("Do machining per tool: expected outputs for T1, T2 then T1, got %v\n", tools)
+	}
+	for n, out := range outs {
+		code := out.String()
+		if strings.Count(code, " M6\n") != 1 || !strings.HasSuffix(code, "M30\n") {
+			t.Errorf("Do machining per tool: output %d is not a complete job for one tool\n", n)
+		}
+	}
+}
+
+func TestWorkOrigin(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	mc := newMachiningConfigForTest(t, &sampler)
+	mc.Material.MaterialDim = geom.NewSize2(40, 30)
+	mc.Material.CarvingAreaOrigin = geom.NewPt2(5, 10)
+
+	tests := []struct {
+		origin   OriginConfig
+		expected geom.Pt3
+	}{
+		{OriginConfig{}, geom.NewPt3(0, 0, 0)},
+		{OriginConfig{XY: OriginTopRight}, geom.NewPt3(40, 30, 0)},
+		{OriginConfig{XY: OriginCenter, Z: ZOriginBed}, geom.NewPt3(20, 15, -10)},
+		{OriginConfig{XY: OriginBottomLeft, XYOf: OriginOfCarvingArea}, geom.NewPt3(5, 10, 0)},
+		{OriginConfig{XY: OriginTopLeft, XYOf: OriginOfCarvingArea}, geom.NewPt3(5, 30, 0)},
+	}
+	for _, test := range tests {
+		mc.Machine.Origin = test.origin
+		if o := GetWorkOrigin(mc); !o.Eq(test.expected) {
+			t.Errorf("Work origin %v: expected %v, got %v\n", test.origin, test.expected, o)
+		}
+	}
+
+	// The header tells where the work zero is and selects the work offset. Coordinates are
+	// relative to the work zero.
+	mc.Machine.Origin = OriginConfig{XY: OriginCenter, Z: ZOriginBed, WorkOffset: 3}
+	var out bytes.Buffer
+	if err := DoMachining(mc, &out); err != nil {
+		t.Fatalf("Work origin: unexpected error: %v\n", err)
+	}
+	code := out.String()
+	header := "G90\n(Work zero: X0 Y0 at the center of the material, Z0 at the machine bed, " +
+		"in G56)\nG56\n"
+	if !strings.Contains(code, header) {
+		t.Errorf("Work origin: expected header %q\n", header)
+	}
+	if !strings.Contains(code, "G0 Z35.00\n") || strings.Contains(code, "Z25.00") {
+		t.Errorf("Work origin: expected the safe height 25 mm above the material\n")
+	}
+
+	mc.Machine.Origin.WorkOffset = 7
+	if err := DoMachining(mc, &out); !errors.Is(err, ErrUnsupportedMode) {
+		t.Errorf("Work origin: expected an error for work offset 7, got %v\n", err)
+	}
+}
+
+// Return the total horizontal length of the feed moves in code.
+func getHorizontalCutLength(code string) float64 {
+	length := 0.0
+	var x, y float64
+	for _, line := range strings.Split(code, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || (fields[0] != "G0" && fields[0] != "G1") {
+			continue
+		}
+
+		x0, y0 := x, y
+		for _, f := range fields[1:] {
+			switch f[0] {
+			case 'X':
+				x, _ = strconv.ParseFloat(f[1:], 64)
+			case 'Y':
+				y, _ = strconv.ParseFloat(f[1:], 64)
+			}
+		}
+		if fields[0] == "G1" {
+			length += math.Hypot(x-x0, y-y0)
+		}
+	}
+	return length
+}
+
+func TestDoMachiningWithStockTracking(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	mc := newMachiningConfigForTest(t, &sampler)
+	mc.Contour.Enable = false
+
+	// Return the code of the carving operation, after the roughing if enabled.
+	carve := func() string {
+		var out bytes.Buffer
+		if err := DoMachining(mc, &out); err != nil {
+			t.Fatalf("Do machining with stock: unexpected error: %v\n", err)
+		}
+		code := out.String()
+		if i := strings.Index(code, "T2 M6"); i >= 0 {
+			return code[i:]
+		}
+		return code
+	}
+
+	mc.Roughing.Enable = false
+	mc.Stock = StockConfig{Enable: true, Resolution: 0.25}
+	alone := carve()
+
+	// The roughing clears most of the carving area down to the bottom. The stock is tracked
+	// even if not enabled, so that the carving only cuts what the roughing left.
+	mc.Roughing.Enable = true
+	mc.Stock = StockConfig{}
+	afterRoughing := carve()
+	cutLengthAlone := getHorizontalCutLength(alone)
+	cutLengthAfterRoughing := getHorizontalCutLength(afterRoughing)
+	if cutLengthAfterRoughing >= cutLengthAlone/2 {
+		t.Errorf("Do machining with stock: expected a much shorter cut after roughing, "+
+			"got %.1f mm, vs %.1f mm\n", cutLengthAfterRoughing, cutLengthAlone)
+	}
+
+	// The carving still goes down to the bottom along the sides, which the roughing tool
+	// doesn't reach.
+	if !strings.Contains(afterRoughing, "Z-2.00 F") {
+		t.Errorf("Do machining with stock: expected the carving to reach the bottom\n")
+	}
+}
+
+// A writer that fails after writing a given number of bytes.
+type failingTestWriter struct {
+	remaining int
+}
+
+func (w *failingTestWriter) Write(p []byte) (int, error) {
+	if len(p) > w.remaining {
+		n := w.remaining
+		w.remaining = 0
+		return n, io.ErrShortWrite
+	}
+	w.remaining -= len(p)
+	return len(p), nil
+}
+
+func TestDoMachiningErrors(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	tests := []struct {
+		name     string
+		change   func(mc *MachiningConfig)
+		expected error
+	}{
+		{"valid job", func(mc *MachiningConfig) {}, nil},
+		{"no material", func(mc *MachiningConfig) {
+			mc.Material.MaterialThickness = 0
+		}, ErrInvalidMaterial},
+		{"empty carving area", func(mc *MachiningConfig) {
+			mc.Material.CarvingAreaDim = geom.NewSize2(0, 20)
+		}, ErrEmptyCarvingArea},
+		{"unknown tool type", func(mc *MachiningConfig) {
+			mc.Carving.Tool.ToolType = 0
+		}, ErrInvalidTool},
+		{"no tool diameter", func(mc *MachiningConfig) {
+			mc.Contour.Tool.ToolDiameter = 0
+		}, ErrInvalidTool},
+		{"no carving step-down", func(mc *MachiningConfig) {
+			mc.Carving.Tool.MaxStepDown = 0
+		}, ErrInvalidTool},
+		{"negative roughing step-down", func(mc *MachiningConfig) {
+			mc.Roughing.Tool.MaxStepDown = -1
+		}, ErrInvalidTool},
+		{"no rest step-down", func(mc *MachiningConfig) {
+			mc.Rest = RestConfig{Enable: true, Tool: mc.Carving.Tool, StepOverFraction: 0.5,
+				Sampler: mc.Carving.Sampler}
+			mc.Rest.Tool.ToolDiameter = 0.5
+			mc.Rest.Tool.MaxStepDown = 0
+		}, ErrInvalidTool},
+		{"no contour step-down", func(mc *MachiningConfig) {
+			mc.Contour.Tool.MaxStepDown = 0
+		}, ErrInvalidTool},
+		{"ball-nose contour tool", func(mc *MachiningConfig) {
+			mc.Contour.Tool.ToolType = ToolTypeBallPoint
+		}, ErrInvalidTool},
+		{"V-bit without angle", func(mc *MachiningConfig) {
+			mc.Carving.Tool.ToolType = ToolTypeVBit
+		}, ErrInvalidTool},
+		{"negative spindle speed", func(mc *MachiningConfig) {
+			mc.Carving.Tool.Spindle.Speed = -1000
+		}, ErrInvalidTool},
+		{"unknown coolant mode", func(mc *MachiningConfig) {
+			mc.Contour.Tool.Spindle.Coolant = SpindleClockwise
+		}, ErrUnsupportedMode},
+		{"unknown units", func(mc *MachiningConfig) {
+			mc.Machine.Units = ZOriginBed
+		}, ErrUnsupportedMode},
+		{"clearance below the retract height", func(mc *MachiningConfig) {
+			mc.Machine.Retract = RetractConfig{ClearanceHeight: 3, RetractHeight: 4}
+		}, ErrInvalidParameter},
+		{"unknown carving mode", func(mc *MachiningConfig) {
+			mc.Carving.CarvingMode = FinishPassModeAlongAllDirs
+		}, ErrUnsupportedMode},
+		{"unknown entry mode", func(mc *MachiningConfig) {
+			mc.Entry.Mode = ToolChangeWithM6
+		}, ErrUnsupportedMode},
+		{"no height map", func(mc *MachiningConfig) {
+			mc.Carving.Sampler = nil
+		}, ErrInvalidParameter},
+	}
+
+	for _, test := range tests {
+		mc := newMachiningConfigForTest(t, &sampler)
+		test.change(mc)
+
+		var out bytes.Buffer
+		err := DoMachining(mc, &out)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Do machining, %s: expected error %v, got %v\n", test.name, test.expected, err)
+		}
+		if err != nil && out.Len() != 0 {
+			t.Errorf("Do machining, %s: expected no code for an invalid job\n", test.name)
+		}
+	}
+
+	// The first error writing the output is returned.
+	mc := newMachiningConfigForTest(t, &sampler)
+	err := DoMachining(mc, &failingTestWriter{remaining: 100})
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("Do machining: expected the write error, got %v\n", err)
+	}
+
+	err = DoMachiningWithOneFilePerTool(mc, func(n int, tool ToolConfig) io.Writer {
+		return &failingTestWriter{remaining: 100}
+	})
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("Do machining per tool: expected the write error, got %v\n", err)
+	}
+}
@@ -0,0 +1,101 @@
+package carving
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"alvin.com/GoCarver/geom"
+)
+
+// PostProcessor writes the code of a job in the G-code dialect of a machine controller. The
+// code generator works out the moves and the post-processor formats them, so that a new
+// controller only needs a new post-processor, registered with RegisterPostProcessor. Methods
+// return the lines to write, without line endings. Coordinates are given in millimeters and
+// feed rates in millimeters per minute, whatever the units of the code.
+type PostProcessor interface {
+	// Name returns the name of the dialect, as used to select the post-processor.
+	Name() string
+	// WithUnits returns the post-processor of the same dialect writing the code in the given
+	// UnitsXXX units.
+	WithUnits(units int) PostProcessor
+
+	// Preamble returns the lines at the start of the program. They must select the units of the
+	// code, absolute coordinates and the XY plane.
+	Preamble() []string
+	// ProgramEnd returns the lines at the end of the program, once the tool is back at the
+	// safe height.
+	ProgramEnd() []string
+	// Comment returns a line with the given comment.
+	Comment(text string) string
+	// SelectWorkOffset returns the line that selects work offset n, from 1 for G54 to 6 for G59.
+	SelectWorkOffset(n int) string
+
+	// SelectTool returns the lines that load tool toolNumber with the tool changer, or nil if
+	// the controller has no tool changer, in which case tools are changed by hand.
+	SelectTool(toolNumber int) []string
+	// ManualToolChange returns the lines that park the tool at the park position, in machine
+	// coordinates, and pause the program so that the tool can be changed by hand.
+	ManualToolChange(toolNumber int, park geom.Pt3) []string
+
+	// SpindleOn returns the line that starts the spindle at the given speed, in revolutions
+	// per minute.
+	SpindleOn(speed float64, clockwise bool) string
+	SpindleOff() string
+	// Dwell returns the line that waits for the given number of seconds.
+	Dwell(seconds float64) string
+	// Coolant returns the line that turns on the coolant of the given CoolantXXX mode, or turns
+	// off all coolant with CoolantOff.
+	Coolant(mode int) string
+
+	RapidMoveToZ(z float64) string
+	RapidMoveToXyz(p geom.Pt3) string
+	LinearMoveToZ(z, feedRate float64) string
+	LinearMoveToXyz(p geom.Pt3, feedRate float64) string
+	ArcTo(arc Arc, feedRate float64) string
+}
+
+// Arc is a move along an arc in the XY plane, with Z varying linearly along the arc. The arc
+// goes less than half-way around its center, as with G2/G3 moves given by a positive radius.
+type Arc struct {
+	From, To  geom.Pt3
+	Center    geom.Pt2
+	Radius    float64
+	Clockwise bool
+}
+
+// The name of the default post-processor.
+const DefaultPostProcessor = "grbl"
+
+var postProcessors = map[string]PostProcessor{}
+
+// RegisterPostProcessor makes the post-processor available by its name, replacing any
+// post-processor registered with the same name. Names are case-insensitive.
+func RegisterPostProcessor(pp PostProcessor) {
+	postProcessors[strings.ToLower(pp.Name())] = pp
+}
+
+// GetPostProcessor returns the post-processor with the given name, or the default one if the
+// name is empty. Return an error wrapping ErrUnsupportedMode if there is no such
+// post-processor.
+func GetPostProcessor(name string) (PostProcessor, error) {
+	if name == "" {
+		name = DefaultPostProcessor
+	}
+	pp, ok := postProcessors[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown post-processor %q, expected one of %s",
+			ErrUnsupportedMode, name, strings.Join(GetPostProcessorNames(), ", "))
+	}
+	return pp, nil
+}
+
+// GetPostProcessorNames returns the names of the registered post-processors, sorted.
+func GetPostProcessorNames() []string {
+	names := make([]string, 0, len(postProcessors))
+	for name := range postProcessors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
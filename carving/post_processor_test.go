@@ -0,0 +1,101 @@
+package carving
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"alvin.com/GoCarver/geom"
+	a "gotest.tools/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// Generate a short job that uses every part of the post-processor: the preamble, comments, tool
+// changes, spindle and coolant commands, dwells, rapid and linear moves, arcs and the program
+// end.
+func genPostProcessorTestJob(post PostProcessor) string {
+	var out bytes.Buffer
+	g := newGcodeGenerator(500, 200)
+	g.configure(&out, 20, 20, 10)
+	g.configurePostProcessor(post)
+	g.configureToolChange(ToolChangeWithM6, geom.NewPt3(-10, -20, -1))
+	g.startJob()
+
+	// A straight groove with the first tool.
+	g.changeTool(1, "3.00 mm flat end-mill (2 flutes)")
+	g.setSpindle(SpindleConfig{Speed: 18000, SpinUpDwell: 2.5, Coolant: CoolantMist})
+	g.startPath(2, 2, -1)
+	g.moveTo(2, 2, -1)
+	g.moveTo(18, 2, -1)
+	g.endPath(false)
+
+	// A half-circle around (10, 10) with the second tool, then a path far away from it.
+	g.changeTool(2, "1.50 mm ball-nose")
+	g.setSpindle(SpindleConfig{Speed: 24000, Direction: SpindleCounterclockwise, SpinUpDwell: 1,
+		Coolant: CoolantFlood})
+	g.startPath(15, 10, -0.5)
+	g.counterclockwiseArcTo(10, 15, -0.5, 5)
+	g.counterclockwiseArcTo(5, 10, -1, 5)
+	g.endPath(false)
+	g.startPath(-50, 60, -0.5)
+	g.moveTo(-50, 60, -0.5)
+	g.clockwiseArcTo(-47, 63, -0.5, 3)
+	g.endPath(false)
+
+	g.endJob()
+	return out.String()
+}
+
+func TestPostProcessorGoldenFiles(t *testing.T) {
+	names := GetPostProcessorNames()
+	a.DeepEqual(t, names, []string{"grbl", "linuxcnc", "mach", "marlin", "smoothieware"})
+
+	for _, name := range names {
+		post, err := GetPostProcessor(name)
+		a.NilError(t, err)
+		a.Equal(t, post.Name(), name)
+
+		checkGoldenFile(t, genPostProcessorTestJob(post), name+".nc")
+
+		// The same job written in inches.
+		inches := post.WithUnits(UnitsInches)
+		a.Equal(t, inches.Name(), name)
+		checkGoldenFile(t, genPostProcessorTestJob(inches), name+"_inches.nc")
+	}
+}
+
+func checkGoldenFile(t *testing.T, code, name string) {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *updateGolden {
+		a.NilError(t, os.WriteFile(golden, []byte(code), 0644))
+	}
+
+	expected, err := os.ReadFile(golden)
+	a.NilError(t, err)
+	a.Equal(t, code, string(expected), "golden file %s", name)
+}
+
+func TestGetPostProcessor(t *testing.T) {
+	post, err := GetPostProcessor("")
+	a.NilError(t, err)
+	a.Equal(t, post.Name(), DefaultPostProcessor)
+
+	post, err = GetPostProcessor("LinuxCNC")
+	a.NilError(t, err)
+	a.Equal(t, post.Name(), "linuxcnc")
+
+	_, err = GetPostProcessor("fanuc")
+	a.Assert(t, errors.Is(err, ErrUnsupportedMode))
+
+	// Jobs with an unknown post-processor generate no code.
+	mc := &MachiningConfig{}
+	mc.Machine.PostProcessor = "fanuc"
+	var out bytes.Buffer
+	a.Assert(t, errors.Is(DoMachining(mc, &out), ErrUnsupportedMode))
+	a.Equal(t, out.Len(), 0)
+}
@@ -0,0 +1,251 @@
+package carving
+
+import (
+	"math"
+
+	g "alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+)
+
+// Material left by the previous tool thinner than this is not worth machining again.
+const restMaterialTolerance = 0.05
+
+// RestMachiner provides support for generating the code to finish the carving with a small
+// tool only where a larger tool, used by a previous operation, could not reach. The regions
+// left by the larger tool are found by comparing the drop-cutter heights of both tools: where
+// the small tool goes deeper than the large tool, material is left to remove. The rest regions
+// are machined with runs along X, in several passes from the top of the material left by the
+// large tool.
+// Usage:
+// 1. Create a rest machiner with NewRestMachiner.
+// 2. Configure the various parameters with the ConfigureXXX functions.
+// 3. Generate the rest-machining code by calling the Run function.
+type RestMachiner struct {
+	carvingBottomLeft g.Pt2
+	carvingDimMm      g.Size2
+
+	zWhite float64 // Z coordinate for white samples.
+	zBlack float64 // Z coordinate for black samples.
+
+	toolDiameterMm   float64
+	stepOverFraction float64
+	maxStepDown      float64
+
+	sampler hmap.ScalarGridSampler
+
+	previousToolDiameterMm float64
+	previousSampler        hmap.ScalarGridSampler
+}
+
+// A span of the rest region along a row, from x0 to x1.
+type restSpan struct {
+	y, x0, x1 float64
+}
+
+// NewRestMachiner creates and returns a new rest machiner.
+func NewRestMachiner() *RestMachiner {
+	return &RestMachiner{}
+}
+
+// ConfigureArea is used to configure the area to finish, i.e. the carving area.
+func (r *RestMachiner) ConfigureArea(carvingAreaOrigin g.Pt2, carvingAreaDimMm g.Size2) {
+	r.carvingBottomLeft = carvingAreaOrigin
+	r.carvingDimMm = carvingAreaDimMm
+}
+
+// ConfigureTool is used to configure the small-tool parameters.
+func (r *RestMachiner) ConfigureTool(
+	toolDiameterMm float64, stepOverFraction float64, maxStepDownSizeMm float64) {
+
+	r.toolDiameterMm = toolDiameterMm
+	r.stepOverFraction = stepOverFraction
+	r.maxStepDown = math.Abs(maxStepDownSizeMm)
+}
+
+// ConfigureProfile is used to configure the carving profile. The sampler must account for the
+// shape of the small tool.
+func (r *RestMachiner) ConfigureProfile(
+	sampler hmap.ScalarGridSampler,
+	topHeightMm float64,
+	bottomHeightMm float64,
+	materialTopMm float64) {
+
+	r.sampler = sampler
+	r.zWhite = topHeightMm - materialTopMm
+	r.zBlack = bottomHeightMm - materialTopMm
+}
+
+// ConfigurePreviousTool is used to configure the larger tool used by the previous operation.
+// The sampler must account for the shape of the larger tool, over the same profile.
+func (r *RestMachiner) ConfigurePreviousTool(
+	previousSampler hmap.ScalarGridSampler, previousToolDiameterMm float64) {
+
+	r.previousSampler = previousSampler
+	r.previousToolDiameterMm = previousToolDiameterMm
+}
+
+// Run is called to generate the rest-machining code.
+func (r *RestMachiner) Run(gen codeGenerator) error {
+	if r.sampler == nil || r.previousSampler == nil ||
+		r.toolDiameterMm <= 0 || r.stepOverFraction <= 0 {
+		return nil
+	}
+
+	for _, span := range r.findRestSpans() {
+		run, top := r.newRestRun(span, gen)
+
+		// Start the passes from the top of the material left along the span, rather than from
+		// the top of the material.
+		run.currentCarvingDepth = top
+		if r.maxStepDown <= 0 {
+			run.setEnableCarvingAtFulldepth(true)
+		}
+		delta := 1.0
+		for !run.isDone() {
+			if err := run.doOnePass(delta); err != nil {
+				return err
+			}
+			delta = -delta
+		}
+	}
+	return nil
+}
+
+// Find the spans where the small tool can go deeper than the larger tool. Rows are one
+// step-over of the small tool apart and alternate direction, so that successive spans are
+// close to each other. Spans are extended by the radius of the small tool along the rows and
+// rest regions are extended by one row on each side, so that the small tool blends with the
+// surface left by the larger tool.
+func (r *RestMachiner) findRestSpans() []restSpan {
+	toolRadius := 0.5 * r.toolDiameterMm
+	x0 := r.carvingBottomLeft.X + toolRadius
+	y0 := r.carvingBottomLeft.Y + toolRadius
+	w := r.carvingDimMm.W - r.toolDiameterMm
+	h := r.carvingDimMm.H - r.toolDiameterMm
+	if w < 0 || h < 0 {
+		return nil
+	}
+
+	stepOver := r.toolDiameterMm * r.stepOverFraction
+	numRows := int(math.Ceil(h/stepOver-0.001)) + 1
+	numCols := r.sampler.GetNumSamplesFromX0ToX1(x0, x0+w)
+	if numCols < 2 {
+		numCols = 2
+	}
+	if w/float64(numCols-1) < minStepSize {
+		numCols = int(math.Ceil(w/minStepSize)) + 1
+	}
+
+	xs := make([]float64, numCols)
+	for i := range xs {
+		xs[i] = x0 + w*float64(i)/float64(numCols-1)
+	}
+
+	ys := make([]float64, numRows)
+	for j := range ys {
+		ys[j] = y0 + h*float64(j)/math.Max(1, float64(numRows-1))
+	}
+
+	isRest := make([][]bool, numRows)
+	for j, y := range ys {
+		isRest[j] = make([]bool, numCols)
+		for i, x := range xs {
+			p := g.NewPt2(x, y)
+			isRest[j][i] = r.getPreviousDepthAt(p)-r.getDepthAt(p) > restMaterialTolerance
+		}
+	}
+
+	// Extend the rest regions to the neighboring rows and by the tool radius along the rows.
+	dx := w / float64(numCols-1)
+	numColsToExtend := 0
+	if dx > 0 {
+		numColsToExtend = int(math.Ceil(toolRadius/dx - 0.001))
+	}
+
+	extended := make([][]bool, numRows)
+	for j := range extended {
+		extended[j] = make([]bool, numCols)
+		for jj := j - 1; jj <= j+1; jj++ {
+			if jj < 0 || jj >= numRows {
+				continue
+			}
+			for i, rest := range isRest[jj] {
+				if !rest {
+					continue
+				}
+				for ii := i - numColsToExtend; ii <= i+numColsToExtend; ii++ {
+					if ii >= 0 && ii < numCols {
+						extended[j][ii] = true
+					}
+				}
+			}
+		}
+	}
+
+	var spans []restSpan
+	for j, row := range extended {
+		var rowSpans []restSpan
+		for i := 0; i < numCols; i++ {
+			if !row[i] {
+				continue
+			}
+
+			i0 := i
+			for i+1 < numCols && row[i+1] {
+				i++
+			}
+			rowSpans = append(rowSpans, restSpan{y: ys[j], x0: xs[i0], x1: xs[i]})
+		}
+
+		// Odd rows go from right to left.
+		if j%2 == 1 {
+			for k := range rowSpans {
+				s := &rowSpans[len(rowSpans)-1-k]
+				spans = append(spans, restSpan{y: s.y, x0: s.x1, x1: s.x0})
+			}
+		} else {
+			spans = append(spans, rowSpans...)
+		}
+	}
+
+	return spans
+}
+
+// Create the carving run along the given span. Also return the top of the material left by the
+// larger tool along the span.
+func (r *RestMachiner) newRestRun(span restSpan, gen codeGenerator) (*angledCarvingRun, float64) {
+	p0 := g.NewPt2(span.x0, span.y)
+	p1 := g.NewPt2(span.x1, span.y)
+
+	run := &angledCarvingRun{}
+	run.configure(r.sampler, gen, p0, p1, r.zWhite, r.zBlack, r.maxStepDown)
+
+	top := math.Inf(-1)
+	for s := 0; s < run.numSteps; s++ {
+		q := p0.Add(run.step.Scale(float64(s)))
+		top = math.Max(top, r.getPreviousDepthAt(q))
+	}
+
+	return run, math.Min(0, top)
+}
+
+// Return the carving depth of the small tool at q.
+func (r *RestMachiner) getDepthAt(q g.Pt2) float64 {
+	s := r.sampler.At(q)
+	return (1-s)*r.zBlack + s*r.zWhite
+}
+
+// Return the carving depth left by the larger tool at q. The larger tool does not go as close
+// to the sides of the carving area as the small tool. Near the sides, we use the depth at the
+// closest point reached by the larger tool.
+func (r *RestMachiner) getPreviousDepthAt(q g.Pt2) float64 {
+	radius := 0.5 * r.previousToolDiameterMm
+	xMin := r.carvingBottomLeft.X + radius
+	yMin := r.carvingBottomLeft.Y + radius
+	xMax := math.Max(xMin, r.carvingBottomLeft.X+r.carvingDimMm.W-radius)
+	yMax := math.Max(yMin, r.carvingBottomLeft.Y+r.carvingDimMm.H-radius)
+	q = g.NewPt2(math.Max(xMin, math.Min(xMax, q.X)), math.Max(yMin, math.Min(yMax, q.Y)))
+
+	s := r.previousSampler.At(q)
+	return (1-s)*r.zBlack + s*r.zWhite
+}
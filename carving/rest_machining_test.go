@@ -0,0 +1,89 @@
+package carving
+
+import (
+	"math"
+	"testing"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+)
+
+// A sampler that returns black samples inside a rectangular pocket and white samples elsewhere,
+// with one sample per mm.
+type pocketTestSampler struct {
+	pMin, pMax geom.Pt2
+}
+
+var _ hmap.ScalarGridSampler = (*pocketTestSampler)(nil)
+
+func (s *pocketTestSampler) GetNumSamplesFromX0ToX1(x0, x1 float64) int {
+	return int(math.Round(math.Abs(x1 - x0)))
+}
+
+func (s *pocketTestSampler) GetNumSamplesFromY0ToY1(y0, y1 float64) int {
+	return int(math.Round(math.Abs(y1 - y0)))
+}
+
+func (s *pocketTestSampler) At(q geom.Pt2) float64 {
+	if q.X >= s.pMin.X && q.X <= s.pMax.X && q.Y >= s.pMin.Y && q.Y <= s.pMax.Y {
+		return 0
+	}
+	return 1
+}
+
+func (s *pocketTestSampler) EnableInvertImage(enable bool) {}
+
+func TestRestMachining(t *testing.T) {
+	// The small tool reaches the bottom of a 4 mm pocket that the large tool can't reach at all.
+	small := pocketTestSampler{pMin: geom.NewPt2(8, 8), pMax: geom.NewPt2(12, 12)}
+	large := hmap.NewConstantDepthSampler(1)
+
+	r := NewRestMachiner()
+	r.ConfigureArea(geom.NewPt2(0, 0), geom.NewSize2(20, 20))
+	r.ConfigureTool(1, 0.5, 1)
+	r.ConfigureProfile(&small, 10, 8, 10)
+	r.ConfigurePreviousTool(&large, 6)
+
+	gen := recordingTestGenerator{}
+	r.Run(&gen)
+	if len(gen.paths) == 0 {
+		t.Fatalf("Rest machining: expected paths in the pocket\n")
+	}
+
+	// The paths stay around the pocket, go down in two passes and reach the bottom.
+	const eps = 1e-9
+	minZ := 0.0
+	passDepths := map[float64]bool{}
+	for _, path := range gen.paths {
+		for _, p := range path.points {
+			if p.X < 8-2.2 || p.X > 12+2.2 || p.Y < 8-1-eps || p.Y > 12+1+eps {
+				t.Errorf("Rest machining: point too far from the pocket: %v\n", p)
+			}
+			minZ = math.Min(minZ, p.Z)
+		}
+		passDepths[math.Round(minPathDepth(path))] = true
+	}
+
+	if math.Abs(minZ+2) > eps {
+		t.Errorf("Rest machining: expected to reach depth -2, got %f\n", minZ)
+	}
+	if !passDepths[-1] || !passDepths[-2] {
+		t.Errorf("Rest machining: expected passes at -1 and -2, got %v\n", passDepths)
+	}
+
+	// Nothing is left when both tools reach the same depth.
+	r.ConfigurePreviousTool(&small, 1)
+	gen = recordingTestGenerator{}
+	r.Run(&gen)
+	if len(gen.paths) != 0 {
+		t.Errorf("Rest machining: expected no path, got %d\n", len(gen.paths))
+	}
+}
+
+func minPathDepth(path recordedPath) float64 {
+	minZ := 0.0
+	for _, p := range path.points {
+		minZ = math.Min(minZ, p.Z)
+	}
+	return minZ
+}
@@ -0,0 +1,367 @@
+package carving
+
+import (
+	"math"
+	"sort"
+
+	g "alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/mesh"
+)
+
+// WaterlineRougher provides support for generating the code to rough out the carving with a
+// flat end-mill, one Z level at a time. At each level, the rougher slices the triangle mesh of
+// the carving at the level, less the stock to leave, and clears the closed regions where the
+// tool can reach the level without coming closer than the stock to leave to the parts of the
+// mesh above the slice. The regions are cleared with back-and-forth passes along rows one
+// step-over apart. The areas that are already at their final height are not visited again at
+// lower levels.
+// Usage:
+// 1. Create a rougher with NewWaterlineRougher.
+// 2. Configure the various roughing parameters with the ConfigureXXX functions.
+// 3. Generate the roughing code by calling the Run function.
+type WaterlineRougher struct {
+	carvingBottomLeft g.Pt2
+	carvingDimMm      g.Size2
+
+	materialTopMm  float64
+	stockToLeaveMm float64
+
+	toolDiameterMm   float64
+	stepOverFraction float64
+	maxStepDown      float64
+
+	target *mesh.TriangleMesh
+}
+
+// A span of a row of the roughing passes, from x0 to x1, where the tool can reach the level.
+type roughingSpan struct {
+	row    int
+	x0, x1 float64
+}
+
+// The obstacles to the roughing tool at one level: the parts of the target mesh above the
+// level, less the stock to leave. The tool must stay further than the clearance from the
+// outline of the obstacles, given by the slice of the mesh.
+type roughingObstacles struct {
+	target    *mesh.TriangleMesh
+	height    float64 // Height of the slice, in the coordinates of the mesh.
+	clearance float64
+	outline   []mesh.SliceSegment
+}
+
+// NewWaterlineRougher creates and returns a new waterline rougher.
+func NewWaterlineRougher() *WaterlineRougher {
+	return &WaterlineRougher{}
+}
+
+// ConfigureArea is used to configure the area to rough out, i.e. the carving area.
+func (r *WaterlineRougher) ConfigureArea(carvingAreaOrigin g.Pt2, carvingAreaDimMm g.Size2) {
+	r.carvingBottomLeft = carvingAreaOrigin
+	r.carvingDimMm = carvingAreaDimMm
+}
+
+// ConfigureTool is used to configure the roughing-tool parameters. The roughing tool is
+// always a flat end-mill.
+func (r *WaterlineRougher) ConfigureTool(
+	toolDiameterMm float64, stepOverFraction float64, maxStepDownSizeMm float64) {
+
+	r.toolDiameterMm = toolDiameterMm
+	r.stepOverFraction = stepOverFraction
+	r.maxStepDown = math.Abs(maxStepDownSizeMm)
+}
+
+// ConfigureProfile is used to configure the carving to rough out, as a triangle mesh whose
+// heights are measured from the bottom of the material. The rougher leaves the given stock
+// both vertically and sideways.
+func (r *WaterlineRougher) ConfigureProfile(
+	target *mesh.TriangleMesh, materialTopMm float64, stockToLeaveMm float64) {
+
+	r.target = target
+	r.materialTopMm = materialTopMm
+	r.stockToLeaveMm = math.Max(0, stockToLeaveMm)
+}
+
+// Run is called to generate the roughing code.
+func (r *WaterlineRougher) Run(gen codeGenerator) {
+	if r.target == nil || r.toolDiameterMm <= 0 || r.stepOverFraction <= 0 {
+		return
+	}
+
+	x0, x1, ys := r.getRows()
+	if len(ys) == 0 {
+		return
+	}
+
+	// Find the lowest level the tool may go down to.
+	zMin, _ := r.target.GetZExtents()
+	lowest := math.Min(0, zMin-r.materialTopMm+r.stockToLeaveMm)
+
+	numLevels := 1
+	if r.maxStepDown > 0 {
+		numLevels = int(math.Ceil(-lowest/r.maxStepDown - 0.001))
+	}
+
+	for k := 1; k <= numLevels; k++ {
+		level := lowest * float64(k) / float64(numLevels)
+		obstacles := r.sliceAt(level)
+
+		spans := make([][]roughingSpan, len(ys))
+		for j, y := range ys {
+			spans[j] = obstacles.findSpansAlongRow(j, y, x0, x1)
+		}
+		for _, region := range findRoughingRegions(spans) {
+			r.clearRegion(region, ys, level, obstacles, gen)
+		}
+	}
+}
+
+// Return the range of x covered by the rows of the roughing passes, i.e. the carving area
+// inset by the tool radius, and the y coordinate of the rows, one step-over apart.
+func (r *WaterlineRougher) getRows() (x0, x1 float64, ys []float64) {
+	toolRadius := 0.5 * r.toolDiameterMm
+	x0 = r.carvingBottomLeft.X + toolRadius
+	y0 := r.carvingBottomLeft.Y + toolRadius
+	w := r.carvingDimMm.W - r.toolDiameterMm
+	h := r.carvingDimMm.H - r.toolDiameterMm
+	if w < 0 || h < 0 {
+		return 0, 0, nil
+	}
+
+	stepOver := r.toolDiameterMm * r.stepOverFraction
+	numRows := int(math.Ceil(h/stepOver-0.001)) + 1
+	ys = make([]float64, numRows)
+	for j := range ys {
+		ys[j] = y0 + h*float64(j)/math.Max(1, float64(numRows-1))
+	}
+
+	return x0, x0 + w, ys
+}
+
+// Return the obstacles to the tool at the given level. The clearance is the tool radius
+// enlarged by the stock to leave.
+func (r *WaterlineRougher) sliceAt(level float64) *roughingObstacles {
+	height := level + r.materialTopMm - r.stockToLeaveMm + epsilon
+	return &roughingObstacles{
+		target:    r.target,
+		height:    height,
+		clearance: 0.5*r.toolDiameterMm + r.stockToLeaveMm,
+		outline:   r.target.SliceAt(height),
+	}
+}
+
+// Return the spans of the row at y, from x0 to x1, where the tool clears the obstacles. The
+// spans are ordered along X.
+func (o *roughingObstacles) findSpansAlongRow(row int, y, x0, x1 float64) []roughingSpan {
+	var spans []roughingSpan
+	addSpan := func(lo, hi float64) {
+		if hi >= lo && o.isClearAt(g.NewPt2(0.5*(lo+hi), y)) {
+			spans = append(spans, roughingSpan{row: row, x0: lo, x1: hi})
+		}
+	}
+
+	// The stretches of the row between the blocked intervals are either all inside or all
+	// outside the obstacles, since the tool would come across their outline otherwise.
+	lo := x0
+	for _, blocked := range o.findBlockedIntervals(y, x0, x1, false) {
+		addSpan(lo, blocked[0])
+		lo = blocked[1]
+	}
+	addSpan(lo, x1)
+	return spans
+}
+
+// Return whether the tool can go from (x, y0) to (x, y1) without coming across the outline of
+// the obstacles. The tool must clear the obstacles at (x, y0).
+func (o *roughingObstacles) canMoveAlongY(x, y0, y1 float64) bool {
+	return len(o.findBlockedIntervals(x, math.Min(y0, y1), math.Max(y0, y1), true)) == 0
+}
+
+// Return whether p is outside the obstacles. Points outside the mesh are not.
+func (o *roughingObstacles) isClearAt(p g.Pt2) bool {
+	z, ok := o.target.HeightAt(p)
+	return ok && z <= o.height
+}
+
+// Return the intervals, from v0 to v1 along the line at the given coordinate, where the tool
+// comes closer than the clearance to the outline of the obstacles. The line goes along X, at
+// the given y, or along Y, at the given x, when alongY is true. The intervals are sorted and
+// don't overlap.
+func (o *roughingObstacles) findBlockedIntervals(
+	at, v0, v1 float64, alongY bool) [][2]float64 {
+
+	var intervals [][2]float64
+	for _, seg := range o.outline {
+		a, b := seg.P0, seg.P1
+		if alongY {
+			a, b = g.NewPt2(a.Y, a.X), g.NewPt2(b.Y, b.X)
+		}
+		lo, hi, ok := findCapsuleSpanAtY(a, b, o.clearance, at)
+		if ok && hi > v0 && lo < v1 {
+			intervals = append(intervals, [2]float64{math.Max(v0, lo), math.Min(v1, hi)})
+		}
+	}
+
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i][0] < intervals[j][0] })
+	merged := intervals[:0]
+	for _, in := range intervals {
+		if n := len(merged); n > 0 && in[0] <= merged[n-1][1] {
+			merged[n-1][1] = math.Max(merged[n-1][1], in[1])
+		} else {
+			merged = append(merged, in)
+		}
+	}
+	return merged
+}
+
+// Return the range of x where the line at height y comes within r of the segment from a to
+// b, or false if it doesn't. The points within r of the segment form a convex capsule, so the
+// range is bounded by the crossings of the line with the circles around the ends of the
+// segment and with the sides of the capsule.
+func findCapsuleSpanAtY(a, b g.Pt2, r, y float64) (lo, hi float64, ok bool) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	add := func(x float64) {
+		lo = math.Min(lo, x)
+		hi = math.Max(hi, x)
+	}
+
+	for _, c := range []g.Pt2{a, b} {
+		if dy := y - c.Y; math.Abs(dy) <= r {
+			w := math.Sqrt(r*r - dy*dy)
+			add(c.X - w)
+			add(c.X + w)
+		}
+	}
+
+	d := b.Sub(a)
+	if d.Y != 0 {
+		n := g.NewVec2(-d.Y, d.X).Norm().Scale(r)
+		for _, side := range []g.Vec2{n, n.Scale(-1)} {
+			if t := (y - a.Y - side.Y) / d.Y; t >= 0 && t <= 1 {
+				add(a.X + side.X + t*d.X)
+			}
+		}
+	}
+
+	return lo, hi, lo <= hi
+}
+
+// Find the closed regions where the tool can go down to the level, given the spans of each
+// row. Spans on consecutive rows that overlap belong to the same region. Each region is
+// returned as the list of its spans, ordered by row.
+func findRoughingRegions(spansByRow [][]roughingSpan) [][]roughingSpan {
+	numRows := len(spansByRow)
+	const noRegion = -1
+
+	var spans []roughingSpan
+	firstSpanOfRow := make([]int, numRows+1)
+	for j, row := range spansByRow {
+		firstSpanOfRow[j] = len(spans)
+		spans = append(spans, row...)
+	}
+	firstSpanOfRow[numRows] = len(spans)
+
+	// Flood-fill the regions.
+	regionOf := make([]int, len(spans))
+	for i := range regionOf {
+		regionOf[i] = noRegion
+	}
+
+	var regions [][]roughingSpan
+	for seed := range spans {
+		if regionOf[seed] != noRegion {
+			continue
+		}
+
+		id := len(regions)
+		regionOf[seed] = id
+		stack := []int{seed}
+		var region []roughingSpan
+		for len(stack) > 0 {
+			k := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			region = append(region, spans[k])
+
+			for _, j := range []int{spans[k].row - 1, spans[k].row + 1} {
+				if j < 0 || j >= numRows {
+					continue
+				}
+				for n := firstSpanOfRow[j]; n < firstSpanOfRow[j+1]; n++ {
+					if regionOf[n] == noRegion && spans[n].x0 <= spans[k].x1 && spans[n].x1 >= spans[k].x0 {
+						regionOf[n] = id
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+
+		sortSpansByRow(region)
+		regions = append(regions, region)
+	}
+
+	return regions
+}
+
+// Sort the spans by row, then along X.
+func sortSpansByRow(spans []roughingSpan) {
+	less := func(a, b roughingSpan) bool {
+		return a.row < b.row || a.row == b.row && a.x0 < b.x0
+	}
+
+	// Insertion sort: the spans are mostly sorted already.
+	for i := 1; i < len(spans); i++ {
+		for k := i; k > 0 && less(spans[k], spans[k-1]); k-- {
+			spans[k], spans[k-1] = spans[k-1], spans[k]
+		}
+	}
+}
+
+// Clear the given region at the given level, going back and forth along the spans of the region.
+// The tool stays down when moving from one span to an overlapping span on the next row, if the
+// step-over between the rows clears the obstacles. Otherwise, the path ends and a new path
+// starts at the next span.
+func (r *WaterlineRougher) clearRegion(region []roughingSpan, ys []float64, level float64,
+	obstacles *roughingObstacles, gen codeGenerator) {
+
+	forward := true
+	var prev *roughingSpan
+	for n := range region {
+		span := &region[n]
+		x0, x1 := span.x0, span.x1
+		if !forward {
+			x0, x1 = x1, x0
+		}
+		y := ys[span.row]
+
+		// Step over to the next row within the overlap of both spans, from the end of the
+		// previous span, which went in the opposite direction.
+		linked := false
+		xPrev := 0.0
+		if prev != nil && span.row == prev.row+1 && span.x0 <= prev.x1 && span.x1 >= prev.x0 {
+			xPrev = prev.x0
+			if !forward {
+				xPrev = prev.x1
+			}
+			xPrev = math.Max(span.x0, math.Min(span.x1, xPrev))
+			linked = obstacles.canMoveAlongY(xPrev, ys[prev.row], y)
+		}
+		if prev != nil && !linked {
+			gen.endPath(false)
+		}
+
+		if linked {
+			gen.moveTo(xPrev, ys[prev.row], level)
+			gen.moveTo(xPrev, y, level)
+			gen.moveTo(x0, y, level)
+		} else {
+			gen.startPath(x0, y, level)
+		}
+
+		gen.moveTo(x1, y, level)
+		forward = !forward
+		prev = span
+	}
+
+	if prev != nil {
+		gen.endPath(false)
+	}
+}
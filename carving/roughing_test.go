@@ -0,0 +1,178 @@
+package carving
+
+import (
+	"math"
+	"testing"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+	"alvin.com/GoCarver/mesh"
+)
+
+func TestFindRoughingRegions(t *testing.T) {
+	// Two pockets separated by a wall at x = 3. The deeper pocket is U-shaped so that some rows
+	// have two spans in the same region.
+	spans := [][]roughingSpan{
+		{{0, 0, 2}, {0, 4, 5}},
+		{{1, 0, 0}, {1, 2, 2}, {1, 4, 5}},
+		{{2, 0, 0}, {2, 2, 2}},
+		{{3, 4, 4}},
+	}
+
+	regions := findRoughingRegions(spans)
+	if len(regions) != 3 {
+		t.Fatalf("Find regions: expected 3 regions, got %d\n", len(regions))
+	}
+
+	expected := [][]roughingSpan{
+		{{0, 0, 2}, {1, 0, 0}, {1, 2, 2}, {2, 0, 0}, {2, 2, 2}},
+		{{0, 4, 5}, {1, 4, 5}},
+		{{3, 4, 4}},
+	}
+	for n, region := range regions {
+		if len(region) != len(expected[n]) {
+			t.Fatalf("Find regions: region %d, expected %v, got %v\n", n, expected[n], region)
+		}
+		for i, span := range region {
+			if span != expected[n][i] {
+				t.Errorf("Find regions: region %d, expected %v, got %v\n", n, expected[n], region)
+				break
+			}
+		}
+	}
+}
+
+func TestFindCapsuleSpanAtY(t *testing.T) {
+	tests := []struct {
+		a, b   geom.Pt2
+		y      float64
+		lo, hi float64
+		ok     bool
+	}{
+		// Crossing the circle around an end of a horizontal segment.
+		{geom.NewPt2(0, 0), geom.NewPt2(4, 0), 0.6, -0.8, 4.8, true},
+		// Crossing the sides of a diagonal segment.
+		{geom.NewPt2(0, 0), geom.NewPt2(4, 4), 2, 2 - math.Sqrt2, 2 + math.Sqrt2, true},
+		// Missing the segment.
+		{geom.NewPt2(0, 0), geom.NewPt2(4, 0), 1.5, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		lo, hi, ok := findCapsuleSpanAtY(test.a, test.b, 1, test.y)
+		if ok != test.ok || ok && (math.Abs(lo-test.lo) > 1e-9 || math.Abs(hi-test.hi) > 1e-9) {
+			t.Errorf("Capsule span from %v to %v at y = %f: expected %f to %f (%v), got %f to %f (%v)\n",
+				test.a, test.b, test.y, test.lo, test.hi, test.ok, lo, hi, ok)
+		}
+	}
+}
+
+func TestWaterlineRougher(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	target, err := mesh.NewTriangleMesh(geom.NewPt2(0, 0), geom.NewPt2(20, 20), 7, 10, &sampler)
+	if err != nil {
+		t.Fatalf("Waterline roughing: unexpected error building the mesh: %v\n", err)
+	}
+
+	r := NewWaterlineRougher()
+	r.ConfigureArea(geom.NewPt2(0, 0), geom.NewSize2(20, 20))
+	r.ConfigureTool(2, 0.5, 1)
+	r.ConfigureProfile(target, 10, 0.5)
+
+	gen := recordingTestGenerator{}
+	r.Run(&gen)
+
+	// The tool goes down to 3 mm below the top, less the stock to leave, in three levels. The
+	// whole area is clear at each level, so each level is a single path.
+	if len(gen.paths) != 3 {
+		t.Fatalf("Waterline roughing: expected 3 paths, got %d\n", len(gen.paths))
+	}
+
+	const eps = 1e-9
+	for k, path := range gen.paths {
+		level := -2.5 * float64(k+1) / 3
+		for _, p := range path.points {
+			if p.X < 1-eps || p.X > 19+eps || p.Y < 1-eps || p.Y > 19+eps {
+				t.Errorf("Waterline roughing: point outside of the carving area: %v\n", p)
+			}
+			if math.Abs(p.Z-level) > eps {
+				t.Errorf("Waterline roughing: expected depth %f, got %v\n", level, p)
+			}
+		}
+
+		// Rows are one step-over apart, from the bottom to the top of the area.
+		first := path.points[0]
+		last := path.points[len(path.points)-1]
+		if !first.EqXyz(1, 1, level) || math.Abs(last.Y-19) > eps {
+			t.Errorf("Waterline roughing: unexpected path ends %v, %v\n", first, last)
+		}
+	}
+}
+
+// A sampler of a flat surface at the bottom of the profile, with a thin pin up to the top, at
+// ten samples per mm.
+type pinTestSampler struct {
+	pin    geom.Pt2
+	radius float64
+}
+
+var _ hmap.ScalarGridSampler = (*pinTestSampler)(nil)
+
+func (s *pinTestSampler) GetNumSamplesFromX0ToX1(x0, x1 float64) int {
+	return int(math.Round(10*math.Abs(x1-x0))) + 1
+}
+
+func (s *pinTestSampler) GetNumSamplesFromY0ToY1(y0, y1 float64) int {
+	return int(math.Round(10*math.Abs(y1-y0))) + 1
+}
+
+func (s *pinTestSampler) At(q geom.Pt2) float64 {
+	if q.Sub(s.pin).Len() <= s.radius {
+		return 1
+	}
+	return 0
+}
+
+func (s *pinTestSampler) EnableInvertImage(enable bool) {}
+
+func TestWaterlineRougherAroundPin(t *testing.T) {
+	// The pin is between the rows at y = 5 and y = 6, closer than the tool radius to both.
+	sampler := pinTestSampler{pin: geom.NewPt2(10, 5.5), radius: 0.15}
+	target, err := mesh.NewTriangleMesh(geom.NewPt2(0, 0), geom.NewPt2(20, 20), 8, 10, &sampler)
+	if err != nil {
+		t.Fatalf("Roughing around a pin: unexpected error building the mesh: %v\n", err)
+	}
+
+	r := NewWaterlineRougher()
+	r.ConfigureArea(geom.NewPt2(0, 0), geom.NewSize2(20, 20))
+	r.ConfigureTool(2, 0.5, 1)
+	r.ConfigureProfile(target, 10, 0)
+
+	gen := recordingTestGenerator{}
+	r.Run(&gen)
+
+	// The tool goes around the pin on both levels, which takes new paths where it can't step
+	// over from one row to the next.
+	if len(gen.paths) <= 2 {
+		t.Fatalf("Roughing around a pin: expected more than 2 paths, got %d\n", len(gen.paths))
+	}
+	for _, path := range gen.paths {
+		for i := 1; i < len(path.points); i++ {
+			p0, p1 := path.points[i-1], path.points[i]
+			if d := distanceToSegment(sampler.pin, p0, p1); d < 1 {
+				t.Fatalf("Roughing around a pin: the tool goes within %f of the pin from %v to %v\n",
+					d, p0, p1)
+			}
+		}
+	}
+}
+
+// Return the distance from p to the segment from p0 to p1, in the XY plane.
+func distanceToSegment(p geom.Pt2, p0, p1 geom.Pt3) float64 {
+	q0 := geom.NewPt2(p0.X, p0.Y)
+	d := geom.NewPt2(p1.X, p1.Y).Sub(q0)
+	t := 0.0
+	if d.LenSq() > 0 {
+		t = math.Max(0, math.Min(1, p.Sub(q0).Dot(d)/d.LenSq()))
+	}
+	return p.Sub(q0.Add(d.Scale(t))).Len()
+}
@@ -0,0 +1,193 @@
+package carving
+
+import (
+	"fmt"
+	"math"
+
+	g "alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+)
+
+// Return the step-over fraction for the runs along X or Y. When a target scallop height is
+// configured, the step-over is reduced to leave scallops no higher than the target on flat
+// areas. The given step-over fraction is the maximum.
+func (c *Carver) getScallopStepOverFraction(stepOverFraction float64) float64 {
+	if c.scallopHeightMm <= 0 || c.toolDiameterMm <= 0 {
+		return stepOverFraction
+	}
+
+	return math.Min(stepOverFraction, c.getScallopStepOver(0)/c.toolDiameterMm)
+}
+
+// Return the largest distance between two adjacent runs that leaves scallops no higher than
+// the target scallop height, on a surface with the given slope across the runs. The distance
+// is measured horizontally and may be infinite, e.g. for flat end-mills on flat areas.
+func (c *Carver) getScallopStepOver(slope float64) float64 {
+	h := c.scallopHeightMm
+	cosSlope := 1 / math.Sqrt(1+slope*slope)
+
+	// The step-over of a round tip of radius r, along the surface.
+	roundTipStepOver := func(r float64) float64 {
+		if h >= r {
+			return 2 * r
+		}
+		return 2 * math.Sqrt(2*r*h-h*h)
+	}
+
+	switch c.toolType {
+	case ToolTypeFlat, ToolTypeBullNose:
+		// The flat bottom of the tool leaves a staircase on slopes, with steps as high as the
+		// step-over times the slope. The corner radius of bull-nose tools rounds the steps.
+		cornerRadius := 0.0
+		if c.toolType == ToolTypeBullNose {
+			cornerRadius = c.cornerRadiusMm
+		}
+		if slope <= 0 {
+			return math.Inf(1)
+		}
+		return math.Max(h/slope, roundTipStepOver(cornerRadius)*cosSlope)
+	case ToolTypeVBit:
+		return 2 * h * math.Tan(0.5*c.toolAngleDeg*math.Pi/180) * cosSlope
+	case ToolTypeTaperedBall:
+		return roundTipStepOver(c.tipRadiusMm) * cosSlope
+	default:
+		return roundTipStepOver(0.5*c.toolDiameterMm) * cosSlope
+	}
+}
+
+// Return an error wrapping ErrUnsupportedMode if a target scallop height is configured but
+// the carving mode or the sampler don't support it. The scallop height applies to the runs
+// along X, Y and at an angle, not to the loops, and needs a sampler that knows the slope of
+// the surface.
+func checkScallopHeightSupport(
+	scallopHeightMm float64, carveMode int, sampler hmap.ScalarGridSampler) error {
+
+	if scallopHeightMm <= 0 {
+		return nil
+	}
+	if carveMode == CarveModeSpiral || carveMode == CarveModeConcentric {
+		return fmt.Errorf("%w: scallop height with carving mode %d", ErrUnsupportedMode,
+			carveMode)
+	}
+	if _, ok := sampler.(hmap.SlopeSampler); !ok {
+		return fmt.Errorf("%w: scallop height with a sampler that doesn't know the slopes",
+			ErrUnsupportedMode)
+	}
+	return nil
+}
+
+// Set up the extra runs needed between the adjacent, parallel runs a0->a1 and b0->b1 to keep
+// the scallops under the target height where the surface is steep across the runs. The extra
+// runs are evenly spaced between both runs, clipped to the carving area, and only cover the
+// steep parts, extended by the tool radius. Return no runs when no target scallop height is
+// configured. The sampler must know the slope of the surface, see checkScallopHeightSupport.
+func (c *Carver) setupScallopRuns(
+	a0, a1, b0, b1 g.Pt2, gen codeGenerator, carveAtFullDepth bool) []oneRun {
+
+	slopeSampler, ok := c.sampler.(hmap.SlopeSampler)
+	pMin, pMax, fits := c.getInsetCarvingArea()
+	if !ok || !fits || c.scallopHeightMm <= 0 {
+		return nil
+	}
+
+	gap := b0.Sub(a0)
+	gapLen := gap.Len()
+	runLen := a1.Sub(a0).Len()
+	if gapLen < epsilon || runLen < epsilon {
+		return nil
+	}
+
+	// The slope is evaluated under the whole footprint of the tool, so sampling every quarter
+	// of the tool diameter is enough.
+	ds := math.Max(minStepSize, 0.25*c.toolDiameterMm)
+	numSamples := int(math.Ceil(runLen/ds)) + 1
+	step := a1.Sub(a0).Scale(1 / float64(numSamples-1))
+
+	// Find the number of extra runs needed at each sample along the runs.
+	numExtraRuns := 0
+	need := make([]bool, numSamples)
+	for i := range need {
+		qa := a0.Add(step.Scale(float64(i)))
+		qb := qa.Add(gap)
+		slope := math.Max(slopeSampler.MaxSlopeAt(qa, gap), slopeSampler.MaxSlopeAt(qb, gap))
+		n := int(math.Ceil(gapLen/c.getScallopStepOver(slope)-0.001)) - 1
+		if n > 0 {
+			need[i] = true
+			if n > numExtraRuns {
+				numExtraRuns = n
+			}
+		}
+	}
+
+	if numExtraRuns == 0 {
+		return nil
+	}
+
+	// Extend the steep spans by the tool radius, so that the extra runs blend with the surface.
+	numSamplesToExtend := int(math.Ceil(0.5*c.toolDiameterMm/step.Len() - 0.001))
+	extended := make([]bool, numSamples)
+	for i, steep := range need {
+		if !steep {
+			continue
+		}
+		for ii := i - numSamplesToExtend; ii <= i+numSamplesToExtend; ii++ {
+			if ii >= 0 && ii < numSamples {
+				extended[ii] = true
+			}
+		}
+	}
+
+	var runs []oneRun
+	for k := 1; k <= numExtraRuns; k++ {
+		offset := gap.Scale(float64(k) / float64(numExtraRuns+1))
+		for i := 0; i < numSamples; i++ {
+			if !extended[i] {
+				continue
+			}
+
+			i0 := i
+			for i+1 < numSamples && extended[i+1] {
+				i++
+			}
+
+			p0 := a0.Add(step.Scale(float64(i0))).Add(offset)
+			p1 := a0.Add(step.Scale(float64(i))).Add(offset)
+			p0, p1, ok = clipSegmentToRect(p0, p1, pMin, pMax)
+			if !ok || p1.Sub(p0).Len() < epsilon {
+				continue
+			}
+
+			run := &angledCarvingRun{}
+			run.configure(c.sampler, gen, p0, p1, c.zWhite, c.zBlack, c.maxStepDown)
+			if carveAtFullDepth {
+				run.setEnableCarvingAtFulldepth(true)
+			}
+			runs = append(runs, run)
+		}
+	}
+
+	return runs
+}
+
+// Clip the segment p0->p1 to the rectangle with corners pMin and pMax. Returns the end points
+// of the clipped segment, in the same order, and whether the segment crosses the rectangle.
+func clipSegmentToRect(p0, p1, pMin, pMax g.Pt2) (g.Pt2, g.Pt2, bool) {
+	dir := p1.Sub(p0)
+	length := dir.Len()
+	if length < epsilon {
+		return p0, p1, false
+	}
+
+	dir = dir.Scale(1 / length)
+	q0, q1, ok := clipLineToRect(g.NewVec2(p0.X, p0.Y), dir, pMin, pMax)
+	if !ok {
+		return p0, p1, false
+	}
+
+	t0 := math.Max(0, q0.Sub(p0).Dot(dir))
+	t1 := math.Min(length, q1.Sub(p0).Dot(dir))
+	if t1 < t0 {
+		return p0, p1, false
+	}
+	return p0.Add(dir.Scale(t0)), p0.Add(dir.Scale(t1)), true
+}
@@ -0,0 +1,150 @@
+package carving
+
+import (
+	"math"
+
+	"alvin.com/GoCarver/stock"
+)
+
+// Moves that cut deeper than the maximum step-down of the tool are fed no slower than this
+// fraction of the nominal feed rate.
+const minFeedRateFraction = 0.25
+
+// Return the cutter of the given tool, for tracking the remaining stock.
+func newStockCutter(tool ToolConfig) stock.Cutter {
+	switch tool.ToolType {
+	case ToolTypeFlat:
+		return stock.NewFlatCutter(tool.ToolDiameter)
+	case ToolTypeVBit:
+		return stock.NewVBitCutter(tool.ToolDiameter, tool.ToolAngle)
+	case ToolTypeTaperedBall:
+		return stock.NewTaperedBallCutter(tool.ToolDiameter, tool.ToolAngle, tool.TipRadius)
+	case ToolTypeBullNose:
+		return stock.NewBullNoseCutter(tool.ToolDiameter, tool.CornerRadius)
+	default:
+		return stock.NewBallCutter(tool.ToolDiameter)
+	}
+}
+
+// Configure the model of the remaining stock, or nil to not track it. When tracking the stock,
+// every move is subtracted from the stock, the moves at either end of a path that remove no
+// material are skipped and moves that cut deeper than the maximum step-down of the tool are
+// slowed down.
+func (g *gcodeGenerator) configureStock(s *stock.Stock) {
+	g.stock = s
+}
+
+func (g *gcodeGenerator) setStockCutter(cutter stock.Cutter, maxStepDown float64) {
+	g.stockCutter = cutter
+	g.stockMaxStepDown = maxStepDown
+}
+
+func (g *gcodeGenerator) isTrackingStock() bool {
+	return g.stock != nil && g.stockCutter != nil
+}
+
+// Subtract the straight move from p0 to p1 from the stock.
+func (g *gcodeGenerator) cutStock(p0, p1 pt3) {
+	if g.isTrackingStock() {
+		g.stock.CutSegment(p0, p1, g.stockCutter)
+	}
+}
+
+// Subtract the arc from p0 to p1 from the stock.
+func (g *gcodeGenerator) cutStockAlongArc(p0, p1 pt3, radius float64, clockwise bool) {
+	if g.isTrackingStock() {
+		g.stock.CutArc(p0, p1, radius, clockwise, g.stockCutter)
+	}
+}
+
+// Return the feed rate for the straight move from p0 to p1 at the given nominal feed rate. See
+// adjustFeedRate.
+func (g *gcodeGenerator) feedRateForMove(p0, p1 pt3, feedRate float64) float64 {
+	if !g.isTrackingStock() {
+		return feedRate
+	}
+	return g.adjustFeedRate(g.stock.CutDepth(p0, p1, g.stockCutter), feedRate)
+}
+
+// Return the feed rate for the arc from p0 to p1 at the given nominal feed rate. See
+// adjustFeedRate.
+func (g *gcodeGenerator) feedRateForArc(
+	p0, p1 pt3, radius float64, clockwise bool, feedRate float64) float64 {
+
+	if !g.isTrackingStock() {
+		return feedRate
+	}
+	depth := g.stock.ArcCutDepth(p0, p1, radius, clockwise, g.stockCutter)
+	return g.adjustFeedRate(depth, feedRate)
+}
+
+// Return the feed rate for a move that cuts a layer of the given depth. Moves that cut deeper
+// than the maximum step-down of the tool, e.g. where no earlier pass went, are slowed down in
+// proportion, but no slower than minFeedRateFraction of the nominal feed rate.
+func (g *gcodeGenerator) adjustFeedRate(depth, feedRate float64) float64 {
+	if g.stockMaxStepDown <= 0 || depth <= g.stockMaxStepDown {
+		return feedRate
+	}
+	return feedRate * math.Max(minFeedRateFraction, g.stockMaxStepDown/depth)
+}
+
+// A move of the path: the index of its path component and, for line-segment components, the
+// index of the point it goes to. Moves start at p0.
+type pathMove struct {
+	component int
+	point     int
+	p0        pt3
+}
+
+// Remove the moves at either end of the path that remove no material from the stock, leaving
+// an empty path when no move removes any. The moves that remove nothing between moves that do
+// are kept, since skipping them would take a retract and a new entry. Do nothing when the
+// stock isn't tracked.
+func (g *gcodeGenerator) trimMovesThatRemoveNothing() {
+	if !g.isTrackingStock() {
+		return
+	}
+
+	var first, last *pathMove
+	current := g.startingPoint
+	for i, section := range g.path {
+		if section.isLineSegmentComponent() {
+			if i == 0 {
+				current = section.points[0]
+			}
+			for j, p := range section.points[1:] {
+				if g.stock.RemovesMaterial(current, p, g.stockCutter) {
+					last = &pathMove{component: i, point: j + 1, p0: current}
+					if first == nil {
+						first = last
+					}
+				}
+				current = p
+			}
+		} else {
+			arc, p1 := section.points[0], section.points[1]
+			if g.stock.ArcRemovesMaterial(current, p1, arc.Y, arc.X > 0, g.stockCutter) {
+				last = &pathMove{component: i, point: 1, p0: current}
+				if first == nil {
+					first = last
+				}
+			}
+			current = p1
+		}
+	}
+
+	if first == nil {
+		g.path = g.path[:0]
+		return
+	}
+
+	path := g.path[first.component : last.component+1]
+	if path[len(path)-1].isLineSegmentComponent() {
+		path[len(path)-1].points = path[len(path)-1].points[:last.point+1]
+	}
+	if path[0].isLineSegmentComponent() {
+		path[0].points = path[0].points[first.point-1:]
+	}
+	g.startingPoint = first.p0
+	g.path = path
+}
@@ -0,0 +1,37 @@
+G90
+G17
+G21
+G28 G91 Z0
+G90
+(T1: 3.00 mm flat end-mill 2 flutes)
+G0 Z25.00
+T1 M6
+M3 S18000
+G4 P2.5
+M7
+G0 Z1.00
+G1 X2.00 Y2.00 Z1.00 F500.00
+G1 Z-1.00 F200.00
+G1 X18.00 Y2.00 Z-1.00 F500.00
+(T2: 1.50 mm ball-nose)
+G0 Z25.00
+M9
+M5
+T2 M6
+M4 S24000
+G4 P1.0
+M8
+G0 Z1.00
+G1 X15.00 Y10.00 Z1.00 F500.00
+G1 Z-0.50 F200.00
+G3 X10.00 Y15.00 Z-0.50 R5.00 F500.00
+G3 X5.00 Y10.00 Z-1.00 R5.00 F500.00
+G1 Z5.00 F200.00
+G0 X-50.00 Y60.00 Z5.00
+G1 Z-0.50 F200.00
+G2 X-47.00 Y63.00 Z-0.50 R3.00 F500.00
+G0 Z25.00
+M9
+M5
+G28 G91 Z0
+M30
@@ -0,0 +1,34 @@
+G17 G20 G40 G49 G80 G90 G94
+(T1: 3.00 mm flat end-mill 2 flutes)
+G0 Z0.9843
+T1 M6
+G43 H1
+M3 S18000
+G4 P2.5
+M7
+G0 Z0.0394
+G1 X0.0787 Y0.0787 Z0.0394 F19.69
+G1 Z-0.0394 F7.87
+G1 X0.7087 Y0.0787 Z-0.0394 F19.69
+(T2: 1.50 mm ball-nose)
+G0 Z0.9843
+M9
+M5
+T2 M6
+G43 H2
+M4 S24000
+G4 P1.0
+M8
+G0 Z0.0394
+G1 X0.5906 Y0.3937 Z0.0394 F19.69
+G1 Z-0.0197 F7.87
+G3 X0.3937 Y0.5906 Z-0.0197 I-0.196850 J0.000050 F19.69
+G3 X0.1969 Y0.3937 Z-0.0394 I0.000050 J-0.196850 F19.69
+G1 Z0.1969 F7.87
+G0 X-1.9685 Y2.3622 Z0.1969
+G1 Z-0.0197 F7.87
+G2 X-1.8504 Y2.4803 Z-0.0197 I0.118110 J-0.000010 F19.69
+G0 Z0.9843
+M9
+M5
+M2
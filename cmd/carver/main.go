@@ -0,0 +1,222 @@
+// Command carver generates the G-code for carving a model without the GUI, e.g. to script
+// the code generation on a build machine.
+//
+// Usage:
+//
+//	carver [flags] <model.carv | image.png | image.jpg>
+//
+// The model is read from a carver file, saved by the GUI, or set up with the default values
+// for an image. Any model value can be overridden by its tag with -set, e.g. -set step_over=25.
+// Choices are given by their index, e.g. -set tool_type=2. Use -list to show all the tags with
+// their values. The G-code dialect is selected by name with -post, e.g. -post linuxcnc.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	carv "alvin.com/GoCarver/carving"
+	"alvin.com/GoCarver/model"
+)
+
+// The -set flags, applied in order.
+type settings []string
+
+func (s *settings) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *settings) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected tag=value, got %q", value)
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+func main() {
+	var sets settings
+	output := flag.String("o", "-", "output file for the G-code, - for stdout")
+	post := flag.String("post", "", "G-code dialect, one of "+
+		strings.Join(carv.GetPostProcessorNames(), ", ")+" (default: the model's)")
+	imageFile := flag.String("image", "", "height-map image replacing the one of the model")
+	list := flag.Bool("list", false, "list the model values with their tags and exit")
+	flag.Var(&sets, "set", "set a model value, as tag=value (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] <model.carv | image>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	m, err := loadModel(flag.Arg(0), *imageFile, sets)
+	if err != nil {
+		fail(err)
+	}
+	if *post != "" {
+		choice, err := model.GetPostProcessorChoice(*post)
+		if err != nil {
+			fail(err)
+		}
+		if err := m.SetIntValue(model.PostProcessorTag, choice); err != nil {
+			fail(err)
+		}
+	}
+
+	if *list {
+		for _, tag := range model.GetAllValueTags() {
+			fmt.Printf("%s=%s\n", tag, m.GetValueAsString(tag))
+		}
+		return
+	}
+
+	if err := run(m, *output); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "carver: %v\n", err)
+	os.Exit(1)
+}
+
+// Load the model from a carver file or an image, then apply the settings.
+func loadModel(filename, imageFile string, sets settings) (*model.Model, error) {
+	m := model.NewModel()
+	if strings.EqualFold(filepath.Ext(filename), ".carv") {
+		if err := m.ReadFromFile(filename); err != nil {
+			return nil, fmt.Errorf("could not read model %s: %w", filename, err)
+		}
+	} else {
+		imageFile = filename
+	}
+
+	if imageFile != "" {
+		img, err := loadImage(imageFile)
+		if err != nil {
+			return nil, err
+		}
+		m.SetHeightMap(img, imageFile)
+	}
+
+	for _, s := range sets {
+		tag, value, _ := strings.Cut(s, "=")
+		if err := m.SetValueFromString(strings.TrimSpace(tag), strings.TrimSpace(value)); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func loadImage(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not load image %s: %w", filename, err)
+	}
+	return img, nil
+}
+
+// Generate the code for the model into the output file, or stdout for "-", and check it for
+// gouges if the model asks for it. Return an error if the code fails the gouge check.
+func run(m *model.Model, output string) error {
+	toStdout := output == "-" || output == ""
+	oneFilePerTool := m.GetBoolValue(model.OneFilePerToolTag)
+	if toStdout && oneFilePerTool {
+		return fmt.Errorf("one file per tool requires an output file")
+	}
+
+	job, err := m.NewJob(true)
+	if err != nil {
+		return err
+	}
+
+	// Keep a copy of the code to simulate it when checking for gouges.
+	var code bytes.Buffer
+	tee := func(w io.Writer) io.Writer {
+		if job.Target != nil {
+			return io.MultiWriter(w, &code)
+		}
+		return w
+	}
+
+	switch {
+	case oneFilePerTool:
+		err = model.DoMachiningWithOneFilePerTool(&job.Config, output, tee)
+	case toStdout:
+		err = doMachining(&job.Config, tee(os.Stdout))
+	default:
+		err = writeFile(output, func(w io.Writer) error {
+			return doMachining(&job.Config, tee(w))
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if job.Target == nil {
+		return nil
+	}
+
+	check, err := m.CheckGouges(job, &code)
+	if err != nil {
+		return fmt.Errorf("could not simulate the code: %w", err)
+	}
+
+	fmt.Fprint(os.Stderr, check.Report)
+	if !toStdout {
+		err := writeFile(model.GetDeviationImageFilename(output), func(w io.Writer) error {
+			return png.Encode(w, check.DeviationImage)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if check.Err != nil {
+		return fmt.Errorf("the carving fails the gouge check: %w", check.Err)
+	}
+	return nil
+}
+
+func doMachining(mc *carv.MachiningConfig, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if err := carv.DoMachining(mc, bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Create the file and write it with write.
+func writeFile(filename string, write func(w io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
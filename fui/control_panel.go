@@ -29,7 +29,7 @@ var _ listenForChangeWithTag = (*ControlPanel)(nil)
 type ControlPanel struct {
 	root *container.AppTabs
 
-	groups  map[string]*container.TabItem
+	groups  map[string]*fyne.Container // Grid container of each tab, by group tag.
 	uiItems map[string]*uiItemValueBinding
 
 	enableChangeNotifications bool
@@ -39,7 +39,7 @@ type ControlPanel struct {
 func NewControlPanel() *ControlPanel {
 	cp := &ControlPanel{}
 	cp.root = container.NewAppTabs()
-	cp.groups = make(map[string]*container.TabItem)
+	cp.groups = make(map[string]*fyne.Container)
 	cp.uiItems = make(map[string]*uiItemValueBinding)
 
 	return cp
@@ -142,9 +142,11 @@ func (cp *ControlPanel) AddGroup(tag string, title string) {
 	if !ok {
 		content := container.NewGridWithColumns(2)
 		content.Resize(fyne.NewSize(400, 10))
-		g := container.NewTabItem(title, content)
+
+		// The grid scrolls vertically when the tab has more rows than fit in the window.
+		g := container.NewTabItem(title, container.NewVScroll(content))
 		cp.root.Append(g)
-		cp.groups[tag] = g
+		cp.groups[tag] = content
 	}
 }
 
@@ -155,7 +157,7 @@ func (cp *ControlPanel) AddNumberEntry(
 	config NumericalEditConfigConfig) {
 
 	// Find the container for the host group.
-	tabItem, ok := cp.groups[addToGroupTag]
+	panel, ok := cp.groups[addToGroupTag]
 	if !ok {
 		fyne.LogError("Unknown group tag", nil)
 		return
@@ -179,7 +181,6 @@ func (cp *ControlPanel) AddNumberEntry(
 			item.floatVal, config.MinVal, config.MaxVal, config.Format, config.Regex))
 	item.widget = w
 
-	panel := tabItem.Content.(*fyne.Container)
 	if panel != nil {
 		panel.Add(widget.NewLabel(label))
 		panel.Add(w)
@@ -193,7 +194,7 @@ func (cp *ControlPanel) AddSelector(
 	choices []string) {
 
 	// Find the container for the host group.
-	tabItem, ok := cp.groups[addToGroupTag]
+	panel, ok := cp.groups[addToGroupTag]
 	if !ok {
 		fyne.LogError("Unknown group tag", nil)
 		return
@@ -218,7 +219,6 @@ func (cp *ControlPanel) AddSelector(
 	item.widget = w
 	cp.uiItems[itemTag] = item
 
-	panel := tabItem.Content.(*fyne.Container)
 	if panel != nil {
 		panel.Add(widget.NewLabel(label))
 		panel.Add(w)
@@ -231,7 +231,7 @@ func (cp *ControlPanel) AddCheckbox(
 	label string) {
 
 	// Find the container for the host group.
-	tabItem, ok := cp.groups[addToGroupTag]
+	panel, ok := cp.groups[addToGroupTag]
 	if !ok {
 		fyne.LogError("Unknown group tag", nil)
 		return
@@ -253,7 +253,6 @@ func (cp *ControlPanel) AddCheckbox(
 	item.widget = w
 	cp.uiItems[itemTag] = item
 
-	panel := tabItem.Content.(*fyne.Container)
 	if panel != nil {
 		panel.Add(widget.NewLabel(label))
 		panel.Add(w)
@@ -266,12 +265,11 @@ func (cp *ControlPanel) AddSeparator(
 	boldLabel bool) {
 
 	// Find the container for the host group.
-	tabItem, ok := cp.groups[addToGroupTag]
+	panel, ok := cp.groups[addToGroupTag]
 	if !ok {
 		fyne.LogError("Unknown group tag", nil)
 		return
 	}
-	panel := tabItem.Content.(*fyne.Container)
 	w := layout.NewSpacer()
 	if panel != nil {
 		panel.Add(widget.NewLabelWithStyle(
@@ -306,8 +304,7 @@ func (cp *ControlPanel) setChangeNotification(newState bool) {
 func (cp *ControlPanel) equalizeTabPanels() {
 	// Find the largest number of grid rows in all tab panes.
 	maxGridRows := 0
-	for _, g := range cp.groups {
-		panel := g.Content.(*fyne.Container)
+	for _, panel := range cp.groups {
 		if panel != nil {
 			n := len(panel.Objects) / 2
 			if n > maxGridRows {
@@ -318,8 +315,7 @@ func (cp *ControlPanel) equalizeTabPanels() {
 
 	// Fill all the grids with spacers so that they have the same number of rows. This
 	// is necessary to insure that rows have the same heights across all the tab panes.
-	for _, g := range cp.groups {
-		panel := g.Content.(*fyne.Container)
+	for _, panel := range cp.groups {
 		if panel != nil {
 			n := len(panel.Objects) / 2
 			for n < maxGridRows {
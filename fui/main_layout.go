@@ -3,7 +3,6 @@ package fui
 import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/layout"
 )
 
 const (
@@ -46,8 +45,7 @@ func (t *MainLayout) createImagePanel() *fyne.Container {
 
 func (t *MainLayout) createControlPanel() *fyne.Container {
 	t.cp = NewControlPanel()
-	return container.NewVBox(
-		container.NewPadded(t.cp.getRoot()),
-		layout.NewSpacer(),
-	)
+
+	// Let the tabs fill the height of the window so that long tab panes scroll.
+	return container.NewPadded(t.cp.getRoot())
 }
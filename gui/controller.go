@@ -0,0 +1,323 @@
+package gui
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	carv "alvin.com/GoCarver/carving"
+	"alvin.com/GoCarver/fui"
+	"alvin.com/GoCarver/model"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+type Controller struct {
+	uiManager      *UIManager
+	model          *model.Model
+	mainWindow     fyne.Window
+	useMeshSampler bool
+}
+
+func NewController(m *model.Model) *Controller {
+	c := &Controller{
+		model:          m,
+		useMeshSampler: true,
+	}
+
+	return c
+}
+
+func (c *Controller) GetUIManager() *UIManager {
+	return c.uiManager
+}
+
+func (c *Controller) GetModel() *model.Model {
+	return c.model
+}
+
+func (c *Controller) ConnectUI(uiManager *UIManager, mainWindow fyne.Window) {
+	c.uiManager = uiManager
+	c.mainWindow = mainWindow
+	c.uiManager.BuildUI(mainWindow)
+
+	c.uiManager.SetUIChangeListener(func(tag string) {
+		c.doOnItemChanged(tag)
+	})
+	c.uiManager.SetMenuSelectedListener(func(tag string) {
+		c.DoMenuChoice(tag)
+	})
+
+	c.updateAllUIItems()
+}
+
+func (c *Controller) DoMenuChoice(menuTag string) {
+	switch menuTag {
+	case MenuOpenImageTag:
+		c.doOpenImageFile()
+	case MenuNewModelTag:
+		break
+	case MenuOpenModelTag:
+		c.doOpenModel()
+	case MenuSaveModelTag:
+		c.doSaveModel()
+	case MenuSaveModelAsTag:
+		c.doSaveModelAs()
+	case MenuGenGrblTag:
+		c.doRunCarver()
+	default:
+	}
+}
+
+func (c *Controller) CheckShouldClose() bool {
+	return c.checkSaveOnDirty()
+}
+
+func (c *Controller) checkSaveOnDirty() bool {
+	if c.model.IsDirty() {
+		dlg := fui.NewDialog("Unsaved Changes")
+		if dlg.ShowYesNoDialog("There are unsaved changes. Would you like to save the model to file?") {
+			return c.doSaveModel()
+		} else {
+			return true
+		}
+	}
+
+	return true
+}
+
+func (c *Controller) updateAllUIItems() {
+	for _, tag := range c.uiManager.GetAllUIItemTags() {
+		c.updateUIFromModel(tag)
+	}
+
+	c.updateMenuItems()
+	c.uiManager.SetImage(c.model.GetHeightMap())
+}
+
+func (c *Controller) doOpenImageFile() {
+	d := fui.NewDialog("Choose Image")
+	if img, filename, err := d.OpenAndLoadImageFile(); err == nil {
+		c.model.SetHeightMap(img, filename)
+		c.updateAllUIItems()
+	}
+}
+
+func (c *Controller) doRunCarver() {
+	dir := ""
+	if c.model.GetFilePath() != "" {
+		dir = filepath.Dir(c.model.GetFilePath())
+	}
+	filename := c.getGrblOutputFilename(dir)
+	if filename == "" {
+		return
+	}
+
+	oneFilePerTool := c.model.GetBoolValue(model.OneFilePerToolTag)
+	var outFile *os.File
+	if !oneFilePerTool {
+		if outFile = c.createGrblOutputFile(filename); outFile == nil {
+			return
+		}
+		defer outFile.Close()
+	}
+
+	job, err := c.model.NewJob(c.useMeshSampler)
+	if err != nil {
+		dlg := fui.NewDialog("GRBL Error")
+		dlg.ShowErrorDialog("Could not set up the carving: err = %s", err.Error())
+		return
+	}
+
+	title := "Generating carving code"
+	progress := c.showProgressDialog(title, filepath.Base(c.model.GetFilePath()))
+
+	// Keep a copy of the code to simulate it when checking for gouges. With one file per tool,
+	// the files are written one after the other, so the copy is the whole job.
+	checkGouges := job.Target != nil
+	var code bytes.Buffer
+	tee := func(w io.Writer) io.Writer {
+		if checkGouges {
+			return io.MultiWriter(w, &code)
+		}
+		return w
+	}
+
+	if oneFilePerTool {
+		err = model.DoMachiningWithOneFilePerTool(&job.Config, filename, tee)
+	} else {
+		err = carv.DoMachining(&job.Config, tee(outFile))
+	}
+
+	progress.Hide()
+
+	if err != nil {
+		dlg := fui.NewDialog("GRBL Error")
+		dlg.ShowErrorDialog("Could not generate the carving code: err = %s", err.Error())
+		return
+	}
+
+	if checkGouges {
+		c.checkGouges(job, &code, filename)
+	}
+}
+
+// Simulate the generated code and compare the carved surface to the target surface. Save the
+// false-colour deviation image next to the code, and warn when the tool cut below the target
+// surface by more than the gouge tolerance.
+func (c *Controller) checkGouges(job *model.Job, code io.Reader, filename string) {
+	check, err := c.model.CheckGouges(job, code)
+	if err != nil {
+		dlg := fui.NewDialog("Simulation Error")
+		dlg.ShowErrorDialog("Error simulating the GRBL code: err = %s", err.Error())
+		return
+	}
+
+	if f := c.createGrblOutputFile(model.GetDeviationImageFilename(filename)); f != nil {
+		png.Encode(f, check.DeviationImage)
+		f.Close()
+	}
+
+	if check.Err != nil {
+		dlg := fui.NewDialog("Gouge Check")
+		dlg.ShowErrorDialog(
+			"The carving fails the gouge check: %s.\n\n%s", check.Err.Error(), check.Report)
+	}
+}
+
+func (c *Controller) doSaveModel() bool {
+	if c.model.GetFilePath() == "" {
+		return c.doSaveModelAs()
+	}
+
+	return c.doSaveModelToFile(c.model.GetFilePath())
+}
+
+func (c *Controller) doSaveModelAs() bool {
+	dir := ""
+	if c.model.GetFilePath() != "" {
+		dir = filepath.Dir(c.model.GetFilePath())
+	}
+
+	dlg := fui.NewDialog("Save Model As")
+	filename, err := dlg.SaveToCarverFile(dir)
+	if err == nil && filename != "" {
+		return c.doSaveModelToFile(filename)
+	}
+
+	return false
+}
+
+func (c *Controller) doSaveModelToFile(filename string) bool {
+	if filename != "" {
+		err := c.model.WriteToFile(filename)
+		if err != nil {
+			dlg := fui.NewDialog("Save Error")
+			dlg.ShowErrorDialog("Could not save emodel to %s: err = %s", filename, err.Error())
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (c *Controller) doOpenModel() {
+	if !c.checkSaveOnDirty() {
+		return
+	}
+
+	dir := ""
+	if c.model.GetFilePath() != "" {
+		dir = filepath.Dir(c.model.GetFilePath())
+	}
+
+	dlg := fui.NewDialog("Open a Carver File")
+	filename, err := dlg.OpenCarverFile(dir)
+	if err == nil && filename != "" {
+		c.uiManager.DisableListeners()
+		defer c.uiManager.EnableListeners()
+
+		if err := c.model.ReadFromFile(filename); err != nil {
+			dlg := fui.NewDialog("Open Error")
+			dlg.ShowErrorDialog("Errors while loading model %s: err = %s", filename, err.Error())
+			return
+		}
+
+		c.updateAllUIItems()
+	}
+}
+
+func (c *Controller) doOnItemChanged(tag string) {
+	var err error
+	switch {
+	case c.uiManager.IsNumEntryUIItem(tag):
+		err = model.SetModelValueByTag(c.model, tag, GetUiValueByTag[float32](c.uiManager, tag))
+	case c.uiManager.IsSelectorUIItem(tag):
+		err = model.SetModelValueByTag(c.model, tag, GetUiValueByTag[int](c.uiManager, tag))
+	case c.uiManager.IsCheckboxUIItem(tag):
+		err = model.SetModelValueByTag(c.model, tag, GetUiValueByTag[bool](c.uiManager, tag))
+	default:
+		err = fmt.Errorf("%w: no UI item %s", model.ErrUnknownTag, tag)
+	}
+	if err != nil {
+		dlg := fui.NewDialog("Model Error")
+		dlg.ShowErrorDialog("Could not update the model: err = %s", err.Error())
+		return
+	}
+
+	c.model.SetDirty(true)
+	c.updateMenuItems()
+}
+
+func (c *Controller) updateUIFromModel(tag string) {
+	switch {
+	case c.uiManager.IsNumEntryUIItem(tag):
+		SetUiValueByTag(c.uiManager, tag, model.GetModelValueByTag[float32](c.model, tag))
+	case c.uiManager.IsSelectorUIItem(tag):
+		SetUiValueByTag(c.uiManager, tag, model.GetModelValueByTag[int](c.model, tag))
+	case c.uiManager.IsCheckboxUIItem(tag):
+		SetUiValueByTag(c.uiManager, tag, model.GetModelValueByTag[bool](c.model, tag))
+	default:
+		log.Printf("Controller: updateUIFromModel - unknown tag = %s", tag)
+	}
+}
+
+func (c *Controller) updateMenuItems() {
+	c.uiManager.SetMenuItemEnabledState(MenuSaveModelTag, c.model.IsDirty() || c.model.GetFilePath() == "")
+	c.uiManager.SetMenuItemEnabledState(MenuGenGrblTag, c.model.GetHeightMap() != nil)
+}
+
+func (c *Controller) getGrblOutputFilename(dir string) string {
+	dlg := fui.NewDialog("Export GRBL Code")
+	filename, _ := dlg.SaveToGrblFile(dir)
+	return filename
+}
+
+func (c *Controller) createGrblOutputFile(filename string) *os.File {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		dlg := fui.NewDialog("GRBL Error")
+		dlg.ShowErrorDialog("Error opening GRBL output file %s: err = %s", filename, err.Error())
+		return nil
+	}
+
+	f.Truncate(0) // Just in case we're overwriting an existing file.
+	return f
+}
+
+func (c *Controller) showProgressDialog(title, subtitle string) *widget.PopUp {
+	progress := widget.NewProgressBarInfinite()
+	popup := widget.NewModalPopUp(
+		container.NewVBox(widget.NewCard(title, subtitle, progress)),
+		c.mainWindow.Canvas())
+	popup.Show()
+	return popup
+}
@@ -0,0 +1,680 @@
+package gui
+
+import (
+	"image"
+	"log"
+	"time"
+
+	"alvin.com/GoCarver/fui"
+	"alvin.com/GoCarver/model"
+	"fyne.io/fyne/v2"
+)
+
+const (
+	NumberRegex       = `[ ]*([0-9]*\.?[0-9]*)`
+	SignedNumberRegex = `[ ]*(-?[0-9]*\.?[0-9]*)`
+
+	PanelMaterialTag      = "material_panel"
+	PanelCarvingTag       = "carving_panel"
+	PanelHeightMapTag     = "height_map_panel"
+	PanelContourMachining = "contour_panel"
+	PanelRoughingTag      = "roughing_panel"
+	PanelMachineTag       = "machine_panel"
+	PanelRestTag          = "rest_panel"
+
+	MenuNewModelTag    = "menu_new"
+	MenuOpenModelTag   = "menu_open"
+	MenuSaveModelTag   = "menu_save"
+	MenuSaveModelAsTag = "menu_save_as"
+	MenuOpenImageTag   = "menu_open_img"
+
+	MenuGenGrblTag = "menu_gen_grbl"
+)
+
+// Choice strings.
+var toolTypeChoices = []string{"Ball nose", "Straight", "V-bit", "Tapered ball nose", "Bull nose"}
+var carvingDirectionChoices = []string{
+	"Along X", "Along Y", "First along X then along Y", "At an angle", "Inward spiral",
+	"Concentric loops"}
+var rasterDirectionChoices = []string{"Back and forth", "Climb only", "Conventional only"}
+var finishPassModeChoices = []string{
+	"First direction only", "Last direction only", "All directions"}
+var imageFillModeChoices = []string{"Fill", "Fit", "Crop"}
+var numTabPerSideChoices = []string{"no tab", "1 tab", "2 tabs", "3 tabs", "4 tabs"}
+var contourOutlineChoices = []string{"Carving area", "Material"}
+var restToolTypeChoices = []string{"Ball nose", "Straight"}
+var contourToolTypeChoices = []string{"Straight", "Bull nose"}
+var toolChangeModeChoices = []string{"Tool changer (M6)", "Pause (M0) and park"}
+var postProcessorChoices = []string{"GRBL", "LinuxCNC", "Mach3/Mach4", "Marlin", "Smoothieware"}
+var outputUnitsChoices = []string{"Millimeters (G21)", "Inches (G20)"}
+var spindleDirChoices = []string{"Clockwise (M3)", "Counterclockwise (M4)"}
+var coolantChoices = []string{"Off", "Mist or air assist (M7)", "Flood (M8)"}
+var originXYChoices = []string{"Bottom-left corner", "Bottom-right corner", "Top-left corner",
+	"Top-right corner", "Center"}
+var originOfChoices = []string{"Material", "Carving area"}
+var originZChoices = []string{"Top of the material", "Machine bed"}
+var workOffsetChoices = []string{"Active one", "G54", "G55", "G56", "G57", "G58", "G59"}
+var retractModeChoices = []string{"To the retract height", "Just above the material (carving)"}
+var entryModeChoices = []string{"Plunge", "Ramp", "Helix"}
+
+// Map image mode index from UI item to string mode used by Image Panel.
+var imgModeIndexToStrMode = []string{fui.ImgModeFill, fui.ImgModeFit, fui.ImgModeCrop}
+
+type UIManager struct {
+	uiRoot *fui.MainLayout
+	menu   *fui.MainMenu
+
+	allUIItemTags      []string
+	numEntryUIItemTags []string
+	selectorUIItemTags []string
+	checkboxUIItemTags []string
+
+	onUIChangeListener     func(uiItemTag string)
+	onMenuSelectedListener func(menuTag string)
+	disableListeners       bool
+
+	updateImageTimer *time.Timer
+}
+
+func NewUIManager() *UIManager {
+	return &UIManager{
+		allUIItemTags:      make([]string, 0),
+		numEntryUIItemTags: make([]string, 0),
+		selectorUIItemTags: make([]string, 0),
+		checkboxUIItemTags: make([]string, 0),
+	}
+}
+
+func (ui *UIManager) BuildUI(w fyne.Window) {
+	ui.uiRoot = fui.NewTopLayout()
+	ui.buildControlPanel()
+	w.SetContent(ui.uiRoot.GetRootContainer())
+
+	ui.buildMainMenu(w)
+}
+
+func (ui *UIManager) FinalizeUI() {
+	ui.uiRoot.GetControlPanel().SetChangeListener(func(tag string) {
+		ui.onUIItemChange(tag)
+	})
+}
+
+func (ui *UIManager) DisableListeners() {
+	ui.disableListeners = true
+}
+
+func (ui *UIManager) EnableListeners() {
+	ui.disableListeners = false
+}
+
+func (ui *UIManager) SetUIChangeListener(l func(string)) {
+	ui.onUIChangeListener = l
+}
+
+func (ui *UIManager) SetMenuSelectedListener(l func(string)) {
+	ui.onMenuSelectedListener = l
+}
+
+func (ui *UIManager) GetAllUIItemTags() []string {
+	return ui.allUIItemTags
+}
+
+func (ui *UIManager) IsNumEntryUIItem(itemTag string) bool {
+	for _, tag := range ui.numEntryUIItemTags {
+		if tag == itemTag {
+			return true
+		}
+	}
+	return false
+}
+
+func (ui *UIManager) IsSelectorUIItem(itemTag string) bool {
+	for _, tag := range ui.selectorUIItemTags {
+		if tag == itemTag {
+			return true
+		}
+	}
+	return false
+}
+
+func (ui *UIManager) IsCheckboxUIItem(itemTag string) bool {
+	for _, tag := range ui.checkboxUIItemTags {
+		if tag == itemTag {
+			return true
+		}
+	}
+	return false
+}
+
+func (ui *UIManager) SetMenuItemEnabledState(menuTag string, enabled bool) {
+	ui.menu.SetMenuItemEnabled(menuTag, enabled)
+}
+
+func (ui *UIManager) SetImage(img image.Image) {
+	ui.uiRoot.GetImagePanel().SetImage(img)
+	ui.delayedUpdateImagePanel()
+}
+
+func (ui *UIManager) SetUIItemFloatValue(tag string, val float32) {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.SetWidgetFloatValue(tag, float64(val))
+}
+
+func (ui *UIManager) SetUIItemIntValue(tag string, val int) {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.SetChoiceByIndex(tag, val)
+}
+
+func (ui *UIManager) SetUIItemBoolValue(tag string, val bool) {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.SetCheckboxState(tag, val)
+}
+
+func (ui *UIManager) GetUIItemFloatValue(tag string) float32 {
+	cp := ui.uiRoot.GetControlPanel()
+	val, _ := cp.GetWidgetFloatValue(tag)
+	return float32(val)
+}
+
+func (ui *UIManager) GetUIItemIntValue(tag string) int {
+	cp := ui.uiRoot.GetControlPanel()
+	val, _ := cp.GetWidgetIntValue(tag)
+	return val
+}
+
+func (ui *UIManager) GetUIItemBoolValue(tag string) bool {
+	cp := ui.uiRoot.GetControlPanel()
+	val, _ := cp.GetCheckBoxState(tag)
+	return val
+}
+
+func (ui *UIManager) buildControlPanel() {
+	ui.buildMaterialPanel()
+	ui.buildRoughingPanel()
+	ui.buildCarvingPanel()
+	ui.buildRestMachiningPanel()
+	ui.buildHeightMapPanel()
+	ui.buildContourMachiningPanel()
+	ui.buildMachinePanel()
+	ui.uiRoot.GetControlPanel().Finalize()
+}
+
+func (ui *UIManager) buildMaterialPanel() {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddGroup(PanelMaterialTag, "Material")
+
+	cp.AddSeparator(PanelMaterialTag, "Material area:", true)
+	ui.addNumberEntry(PanelMaterialTag, model.MatWidthTag, "Material width (mm):", materialDimensionsConfig())
+	ui.addNumberEntry(PanelMaterialTag, model.MatHeightTag, "Material height (mm):", materialDimensionsConfig())
+	ui.addNumberEntry(PanelMaterialTag, model.MatThicknessTag, "Material thickness (mm):", materialThicknessConfig())
+	cp.AddSeparator(PanelMaterialTag, "Carving area:", true)
+	ui.addNumberEntry(PanelMaterialTag, model.CarvWidthTag, "Carving area width (mm):", materialDimensionsConfig())
+	ui.addNumberEntry(PanelMaterialTag, model.CarvHeightTag, "Carving area height (mm):", materialDimensionsConfig())
+	ui.addNumberEntry(PanelMaterialTag, model.CarvOffsetXTag, "Carving offset X (mm):", carvingOffsetConfig())
+	ui.addNumberEntry(PanelMaterialTag, model.CarvOffsetYTag, "Carving offset Y (mm):", carvingOffsetConfig())
+	ui.addNumberEntry(PanelMaterialTag, model.CarvBlackDepthTag, "Black carving depth (mm):", carvingDepthConfig())
+	ui.addNumberEntry(PanelMaterialTag, model.CarvWhiteDepthTag, "White carving depth (mm):", carvingDepthConfig())
+}
+
+func (ui *UIManager) buildRoughingPanel() {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddGroup(PanelRoughingTag, "Roughing")
+
+	ui.addCheckbox(PanelRoughingTag, model.EnableRoughingTag, "Enable waterline roughing:")
+	ui.addNumberEntry(PanelRoughingTag, model.RoughingToolDiameterTag, "Flat end-mill diameter (mm):", toolDiameterConfig())
+	ui.addNumberEntry(PanelRoughingTag, model.RoughingStepOverTag, "Tool step over (%):", toolStepOverConfig())
+	ui.addNumberEntry(PanelRoughingTag, model.RoughingMaxStepDownTag, "Max step down (mm)):", stepDownSizeConfig())
+	ui.addNumberEntry(PanelRoughingTag, model.RoughingHorizFeedRateTag, "Horizontal feed rate (mm/min)):", feedRateConfig())
+	ui.addNumberEntry(PanelRoughingTag, model.RoughingVertFeedRateTag, "Vertical feed rate (mm/min)):", feedRateConfig())
+	ui.addNumberEntry(PanelRoughingTag, model.RoughingStockToLeaveTag, "Stock to leave (mm):", stockToLeaveConfig())
+	cp.AddSeparator(PanelRoughingTag, "Spindle (0 rpm to leave it alone):", true)
+	ui.addNumberEntry(PanelRoughingTag, model.RoughingSpindleSpeedTag, "Spindle speed (rpm):", spindleSpeedConfig())
+	ui.addSelector(PanelRoughingTag, model.RoughingSpindleDirTag, "Spindle direction:", spindleDirChoices)
+	ui.addNumberEntry(PanelRoughingTag, model.RoughingSpinUpDwellTag, "Spin-up dwell (s):", spinUpDwellConfig())
+	ui.addSelector(PanelRoughingTag, model.RoughingCoolantTag, "Coolant:", coolantChoices)
+}
+
+func (ui *UIManager) buildCarvingPanel() {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddGroup(PanelCarvingTag, "Carving")
+
+	cp.AddSeparator(PanelCarvingTag, "Tool:", true)
+	ui.addNumberEntry(PanelCarvingTag, model.ToolDiamTag, "Tool diameter (mm):", toolDiameterConfig())
+	ui.addNumberEntry(PanelCarvingTag, model.StepOverTag, "Tool step over (%):", toolStepOverConfig())
+	ui.addNumberEntry(PanelCarvingTag, model.ScallopHeightTag, "Scallop height (mm, 0=off):", scallopHeightConfig())
+	ui.addSelector(PanelCarvingTag, model.ToolTypeTag, "Tool type:", toolTypeChoices)
+	ui.addNumberEntry(PanelCarvingTag, model.ToolAngleTag, "Tool included angle (deg):", toolAngleConfig())
+	ui.addNumberEntry(PanelCarvingTag, model.ToolTipRadiusTag, "Tool tip radius (mm):", toolRadiusConfig())
+	ui.addNumberEntry(PanelCarvingTag, model.ToolCornerRadiusTag, "Tool corner radius (mm):", toolRadiusConfig())
+	cp.AddSeparator(PanelCarvingTag, "Carving:", true)
+	ui.addNumberEntry(PanelCarvingTag, model.MaxStepDownTag, "Max step down (mm)):", stepDownSizeConfig())
+	ui.addNumberEntry(PanelCarvingTag, model.HorizFeedRateTag, "Horizontal feed rate (mm/min)):", feedRateConfig())
+	ui.addNumberEntry(PanelCarvingTag, model.VertFeedRateTag, "Vertical feed rate (mm/min)):", feedRateConfig())
+	ui.addSelector(PanelCarvingTag, model.CarvDirectionTag, "Carving mode:", carvingDirectionChoices)
+	ui.addNumberEntry(PanelCarvingTag, model.RasterAngleTag, "Carving angle (deg):", rasterAngleConfig())
+	ui.addSelector(PanelCarvingTag, model.RasterDirectionTag, "Run direction:", rasterDirectionChoices)
+	ui.addNumberEntry(PanelCarvingTag, model.LoopCornerRadiusTag, "Loop corner radius (mm):", loopCornerRadiusConfig())
+	ui.addCheckbox(PanelCarvingTag, model.StayDownLinkingTag, "Stay down between runs:")
+	ui.addCheckbox(PanelCarvingTag, model.SkipAirCutsTag, "Skip areas already carved:")
+	cp.AddSeparator(PanelCarvingTag, "Spindle (0 rpm to leave it alone):", true)
+	ui.addNumberEntry(PanelCarvingTag, model.SpindleSpeedTag, "Spindle speed (rpm):", spindleSpeedConfig())
+	ui.addSelector(PanelCarvingTag, model.SpindleDirTag, "Spindle direction:", spindleDirChoices)
+	ui.addNumberEntry(PanelCarvingTag, model.SpinUpDwellTag, "Spin-up dwell (s):", spinUpDwellConfig())
+	ui.addSelector(PanelCarvingTag, model.CoolantTag, "Coolant:", coolantChoices)
+	cp.AddSeparator(PanelCarvingTag, "Optional finish pass:", true)
+	ui.addCheckbox(PanelCarvingTag, model.UseFinishPassTag, "Enable finishing pass:")
+	ui.addNumberEntry(PanelCarvingTag, model.FinishPassReductionTag, "Finishing step reduction (%):", finishingPassConfig())
+	ui.addSelector(PanelCarvingTag, model.FinishPassModeTag, "Finish mode:", finishPassModeChoices)
+	ui.addNumberEntry(PanelCarvingTag, model.FinishPassHorizFeedRateTag, "Finish pass horiz feed rate (mm/min)):", feedRateConfig())
+}
+
+func (ui *UIManager) buildRestMachiningPanel() {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddGroup(PanelRestTag, "Rest Machining")
+
+	ui.addCheckbox(PanelRestTag, model.EnableRestTag, "Enable rest machining:")
+	ui.addSelector(PanelRestTag, model.RestToolTypeTag, "Tool type:", restToolTypeChoices)
+	ui.addNumberEntry(PanelRestTag, model.RestToolDiameterTag, "Tool diameter (mm):", toolDiameterConfig())
+	ui.addNumberEntry(PanelRestTag, model.RestStepOverTag, "Tool step over (%):", toolStepOverConfig())
+	ui.addNumberEntry(PanelRestTag, model.RestMaxStepDownTag, "Max step down (mm)):", stepDownSizeConfig())
+	ui.addNumberEntry(PanelRestTag, model.RestHorizFeedRateTag, "Horizontal feed rate (mm/min)):", feedRateConfig())
+	ui.addNumberEntry(PanelRestTag, model.RestVertFeedRateTag, "Vertical feed rate (mm/min)):", feedRateConfig())
+	cp.AddSeparator(PanelRestTag, "Spindle (0 rpm to leave it alone):", true)
+	ui.addNumberEntry(PanelRestTag, model.RestSpindleSpeedTag, "Spindle speed (rpm):", spindleSpeedConfig())
+	ui.addSelector(PanelRestTag, model.RestSpindleDirTag, "Spindle direction:", spindleDirChoices)
+	ui.addNumberEntry(PanelRestTag, model.RestSpinUpDwellTag, "Spin-up dwell (s):", spinUpDwellConfig())
+	ui.addSelector(PanelRestTag, model.RestCoolantTag, "Coolant:", coolantChoices)
+}
+
+func (ui *UIManager) buildHeightMapPanel() {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddGroup(PanelHeightMapTag, "Height Map")
+
+	ui.addSelector(PanelHeightMapTag, model.ImgFillModeTag, "Image fill mode:", imageFillModeChoices)
+	ui.addCheckbox(PanelHeightMapTag, model.ImgMirrorXTag, "Image mirror-X:")
+	ui.addCheckbox(PanelHeightMapTag, model.ImgMirrorYTag, "Image mirror-Y:")
+}
+
+func (ui *UIManager) buildContourMachiningPanel() {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddGroup(PanelContourMachining, "Contour Machining")
+
+	ui.addCheckbox(PanelContourMachining, model.EnableContourTag, "Enable contour maching:")
+	ui.addSelector(PanelContourMachining, model.ContourToolTypeTag, "Tool type:", contourToolTypeChoices)
+	ui.addNumberEntry(PanelContourMachining, model.ContourToolDiameterTag, "Tool diameter (mm):", toolDiameterConfig())
+	ui.addNumberEntry(PanelContourMachining, model.ContourToolCornerRadiusTag, "Tool corner radius (mm):", toolRadiusConfig())
+	ui.addNumberEntry(PanelContourMachining, model.ContourMaxStepDownTag, "Max step down (mm)):", stepDownSizeConfig())
+	ui.addNumberEntry(PanelContourMachining, model.ContourHorizFeedRateTag, "Horizontal feed rate (mm/min)):", feedRateConfig())
+	ui.addNumberEntry(PanelContourMachining, model.ContourVertFeedRateTag, "Vertical feed rate (mm/min)):", feedRateConfig())
+	cp.AddSeparator(PanelContourMachining, "Spindle (0 rpm to leave it alone):", true)
+	ui.addNumberEntry(PanelContourMachining, model.ContourSpindleSpeedTag, "Spindle speed (rpm):", spindleSpeedConfig())
+	ui.addSelector(PanelContourMachining, model.ContourSpindleDirTag, "Spindle direction:", spindleDirChoices)
+	ui.addNumberEntry(PanelContourMachining, model.ContourSpinUpDwellTag, "Spin-up dwell (s):", spinUpDwellConfig())
+	ui.addSelector(PanelContourMachining, model.ContourCoolantTag, "Coolant:", coolantChoices)
+	ui.addSelector(PanelContourMachining, model.ContourOutlineTag, "Cut out around:", contourOutlineChoices)
+	ui.addNumberEntry(PanelContourMachining, model.ContourCornerRadiusTag, "Corner radius (mm)):", cornerRadiusConfig())
+	ui.addSelector(PanelContourMachining, model.ContourNubTabsPerSideTag, "Number of tabs on each side:", numTabPerSideChoices)
+	ui.addNumberEntry(PanelContourMachining, model.ContourTabWidthTag, "Width of tabs (mm)):", tabWidthConfig())
+	ui.addNumberEntry(PanelContourMachining, model.ContourTabHeightTag, "Height of tabs (mm)):", tabHeightConfig())
+}
+
+func (ui *UIManager) buildMachinePanel() {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddGroup(PanelMachineTag, "Machine")
+
+	cp.AddSeparator(PanelMachineTag, "Tool changes:", true)
+	ui.addSelector(PanelMachineTag, model.ToolChangeModeTag, "Change tools with:", toolChangeModeChoices)
+	ui.addNumberEntry(PanelMachineTag, model.ParkXTag, "Park position X (mm):", parkPositionConfig())
+	ui.addNumberEntry(PanelMachineTag, model.ParkYTag, "Park position Y (mm):", parkPositionConfig())
+	ui.addNumberEntry(PanelMachineTag, model.ParkZTag, "Park position Z (mm):", parkPositionConfig())
+	ui.addCheckbox(PanelMachineTag, model.OneFilePerToolTag, "One output file per tool:")
+	cp.AddSeparator(PanelMachineTag, "Output:", true)
+	ui.addSelector(PanelMachineTag, model.PostProcessorTag, "G-code dialect:", postProcessorChoices)
+	ui.addSelector(PanelMachineTag, model.OutputUnitsTag, "Units:", outputUnitsChoices)
+	ui.addCheckbox(PanelMachineTag, model.FitArcsTag, "Fit arcs (G2/G3):")
+	cp.AddSeparator(PanelMachineTag, "Work zero:", true)
+	ui.addSelector(PanelMachineTag, model.OriginXYTag, "X0 Y0 at:", originXYChoices)
+	ui.addSelector(PanelMachineTag, model.OriginOfTag, "X0 Y0 of:", originOfChoices)
+	ui.addSelector(PanelMachineTag, model.OriginZTag, "Z0 at:", originZChoices)
+	ui.addSelector(PanelMachineTag, model.WorkOffsetTag, "Work offset:", workOffsetChoices)
+	cp.AddSeparator(PanelMachineTag, "Heights above the material:", true)
+	ui.addNumberEntry(PanelMachineTag, model.SafeHeightTag, "Safe height (mm):", heightAboveMaterialConfig())
+	ui.addNumberEntry(PanelMachineTag, model.ClearanceHeightTag, "Clearance plane (mm):", heightAboveMaterialConfig())
+	ui.addNumberEntry(PanelMachineTag, model.RetractHeightTag, "Retract height (mm):", heightAboveMaterialConfig())
+	ui.addNumberEntry(PanelMachineTag, model.RapidThresholdTag, "Rapid moves beyond (mm):", rapidThresholdConfig())
+	ui.addSelector(PanelMachineTag, model.RetractModeTag, "Retract between paths:", retractModeChoices)
+	cp.AddSeparator(PanelMachineTag, "Path entry (carving and contour):", true)
+	ui.addSelector(PanelMachineTag, model.EntryModeTag, "Enter paths with:", entryModeChoices)
+	ui.addNumberEntry(PanelMachineTag, model.MaxRampAngleTag, "Max ramp angle (deg):", rampAngleConfig())
+	ui.addNumberEntry(PanelMachineTag, model.HelixRadiusTag, "Helix radius (mm):", helixRadiusConfig())
+	cp.AddSeparator(PanelMachineTag, "Stock model:", true)
+	ui.addCheckbox(PanelMachineTag, model.TrackStockTag, "Skip air cuts, slow deep cuts:")
+	ui.addNumberEntry(PanelMachineTag, model.StockResolutionTag, "Stock resolution (mm):", stockResolutionConfig())
+	ui.addCheckbox(PanelMachineTag, model.CheckGougesTag, "Simulate and check for gouges:")
+	ui.addNumberEntry(PanelMachineTag, model.GougeToleranceTag, "Gouge tolerance (mm):", gougeToleranceConfig())
+}
+
+func (ui *UIManager) addNumberEntry(
+	panel string, uiItemTag string, label string, config fui.NumericalEditConfigConfig) {
+
+	// The valid range of the entry is the range of the model value.
+	if min, max, ok := model.GetValueRange(uiItemTag); ok {
+		config.MinVal, config.MaxVal = min, max
+	}
+
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddNumberEntry(panel, uiItemTag, label, config)
+	ui.allUIItemTags = append(ui.allUIItemTags, uiItemTag)
+	ui.numEntryUIItemTags = append(ui.numEntryUIItemTags, uiItemTag)
+}
+
+func (ui *UIManager) addSelector(panel string, uiItemTag string, label string, choices []string) {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddSelector(panel, uiItemTag, label, choices)
+	ui.allUIItemTags = append(ui.allUIItemTags, uiItemTag)
+	ui.selectorUIItemTags = append(ui.selectorUIItemTags, uiItemTag)
+}
+
+func (ui *UIManager) addCheckbox(panel string, uiItemTag string, label string) {
+	cp := ui.uiRoot.GetControlPanel()
+	cp.AddCheckbox(panel, uiItemTag, label)
+	ui.allUIItemTags = append(ui.allUIItemTags, uiItemTag)
+	ui.checkboxUIItemTags = append(ui.checkboxUIItemTags, uiItemTag)
+}
+
+func (ui *UIManager) buildMainMenu(w fyne.Window) {
+	ui.menu = fui.NewMainMenu(func(itemTag string) {
+		if ui.onMenuSelectedListener != nil && !ui.disableListeners {
+			ui.onMenuSelectedListener(itemTag)
+		}
+	})
+
+	ui.menu.AddMenu("File")
+	ui.menu.AddMenuItem("File", MenuNewModelTag, "New Model...", false)
+	ui.menu.AddSeparator("File")
+	ui.menu.AddMenuItem("File", MenuOpenModelTag, "Open Model...", false)
+	ui.menu.AddMenuItem("File", MenuSaveModelTag, "Save Model...", true)
+	ui.menu.AddMenuItem("File", MenuSaveModelAsTag, "Save Model As...", false)
+	ui.menu.AddSeparator("File")
+	ui.menu.AddMenuItem("File", MenuOpenImageTag, "Load Image...", false)
+
+	ui.menu.AddMenu("Carve")
+	ui.menu.AddMenuItem("Carve", MenuGenGrblTag, "Gen GRBL...", false)
+
+	ui.menu.Realize(w)
+}
+
+func (ui *UIManager) onUIItemChange(uiItemTag string) {
+	cp := ui.uiRoot.GetControlPanel()
+	ip := ui.uiRoot.GetImagePanel()
+	switch uiItemTag {
+	case model.MatWidthTag, model.MatHeightTag:
+		w, _ := cp.GetWidgetFloatValue(model.MatWidthTag)
+		h, _ := cp.GetWidgetFloatValue(model.MatHeightTag)
+		ip.SetMaterialDimensions(w, h)
+		ui.delayedUpdateImagePanel()
+
+	case model.CarvWidthTag, model.CarvHeightTag:
+		w, _ := cp.GetWidgetFloatValue(model.CarvWidthTag)
+		h, _ := cp.GetWidgetFloatValue(model.CarvHeightTag)
+		ip.SetCarvingAreaDimensions(w, h)
+		ui.delayedUpdateImagePanel()
+
+	case model.CarvOffsetXTag, model.CarvOffsetYTag:
+		dx, _ := cp.GetWidgetFloatValue(model.CarvOffsetXTag)
+		dy, _ := cp.GetWidgetFloatValue(model.CarvOffsetYTag)
+		ip.SetCarvingAreaOffsets(dx, dy)
+		ui.delayedUpdateImagePanel()
+
+	case model.ImgFillModeTag, model.ImgMirrorXTag, model.ImgMirrorYTag:
+		mode, _ := cp.GetWidgetIntValue(model.ImgFillModeTag)
+		mirrorX, _ := cp.GetCheckBoxState(model.ImgMirrorXTag)
+		mirrorY, _ := cp.GetCheckBoxState(model.ImgMirrorYTag)
+		ip.SetImageOptions(imgModeIndexToStrMode[mode], mirrorX, mirrorY)
+		ui.delayedUpdateImagePanel()
+
+	default:
+	}
+
+	if ui.onUIChangeListener != nil && !ui.disableListeners {
+		ui.onUIChangeListener(uiItemTag)
+	}
+}
+
+func (ui *UIManager) delayedUpdateImagePanel() {
+	if ui.updateImageTimer == nil {
+		ui.updateImageTimer = time.NewTimer(25 * time.Millisecond)
+		go func() {
+			for {
+				<-ui.updateImageTimer.C
+				ui.uiRoot.GetImagePanel().Refresh()
+			}
+		}()
+
+	} else {
+		ui.updateImageTimer.Stop()
+		ui.updateImageTimer.Reset(25 * time.Millisecond)
+	}
+}
+
+func GetUiValueByTag[T any](ui *UIManager, tag string) T {
+	var ret T
+	switch p := any(&ret).(type) {
+	case *int:
+		*p = ui.GetUIItemIntValue(tag)
+	case *float32:
+		*p = ui.GetUIItemFloatValue(tag)
+	case *float64:
+		*p = float64(ui.GetUIItemFloatValue(tag))
+	case *bool:
+		*p = ui.GetUIItemBoolValue(tag)
+	default:
+		log.Fatalf("GetUiValueByTag: Unsupported type: %T\n", ret)
+	}
+
+	return ret
+}
+
+func SetUiValueByTag[T any](ui *UIManager, tag string, val T) {
+	switch p := any(&val).(type) {
+	case *int:
+		ui.SetUIItemIntValue(tag, *p)
+	case *float32:
+		ui.SetUIItemFloatValue(tag, *p)
+	case *float64:
+		ui.SetUIItemFloatValue(tag, float32(*p))
+	case *bool:
+		ui.SetUIItemBoolValue(tag, *p)
+	default:
+		log.Fatalf("SetUiValueByTag: Unsupported type: %T\n", val)
+	}
+}
+
+func materialDimensionsConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func carvingOffsetConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func carvingDepthConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  SignedNumberRegex,
+	}
+}
+
+func toolDiameterConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.3f",
+		Regex:  NumberRegex,
+	}
+}
+
+func toolAngleConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func toolRadiusConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.3f",
+		Regex:  NumberRegex,
+	}
+}
+
+func materialThicknessConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func toolStepOverConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func stepDownSizeConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.2f",
+		Regex:  NumberRegex,
+	}
+}
+
+func feedRateConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func rasterAngleConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func loopCornerRadiusConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func scallopHeightConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.3f",
+		Regex:  NumberRegex,
+	}
+}
+
+func stockToLeaveConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.2f",
+		Regex:  NumberRegex,
+	}
+}
+
+func finishingPassConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func cornerRadiusConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func tabWidthConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func tabHeightConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func parkPositionConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  SignedNumberRegex,
+	}
+}
+
+func heightAboveMaterialConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func rapidThresholdConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.0f",
+		Regex:  NumberRegex,
+	}
+}
+
+func spindleSpeedConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.0f",
+		Regex:  NumberRegex,
+	}
+}
+
+func spinUpDwellConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func rampAngleConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.1f",
+		Regex:  NumberRegex,
+	}
+}
+
+func stockResolutionConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.2f",
+		Regex:  NumberRegex,
+	}
+}
+
+func gougeToleranceConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.3f",
+		Regex:  NumberRegex,
+	}
+}
+
+func helixRadiusConfig() fui.NumericalEditConfigConfig {
+	return fui.NumericalEditConfigConfig{
+		Format: "%.2f",
+		Regex:  NumberRegex,
+	}
+}
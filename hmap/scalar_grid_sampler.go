@@ -8,3 +8,11 @@ type ScalarGridSampler interface {
 	EnableInvertImage(enable bool)
 	At(p geom.Pt2) float64
 }
+
+// SlopeSampler is implemented by samplers that also know the slope of the sampled surface,
+// such as samplers built on a triangle mesh.
+type SlopeSampler interface {
+	// Return the steepest slope, as rise over run along direction dir, of the surface under
+	// the tool at p. The slope is in carving units, not in sample units.
+	MaxSlopeAt(p geom.Pt2, dir geom.Vec2) float64
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"alvin.com/GoCarver/gui"
 	"alvin.com/GoCarver/model"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -13,9 +14,9 @@ func main() {
 
 	w := a.NewWindow("Go Carver")
 
-	uiManager := model.NewUIManager()
+	uiManager := gui.NewUIManager()
 	m := model.NewModel()
-	c := model.NewController(m)
+	c := gui.NewController(m)
 	c.ConnectUI(uiManager, w)
 
 	w.SetCloseIntercept(func() {
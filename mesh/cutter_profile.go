@@ -0,0 +1,123 @@
+package mesh
+
+import (
+	"math"
+)
+
+// CutterProfile describes the shape of a rotationally-symmetric cutter, as seen from the side.
+// The profile is measured from the tip of the cutter, along its axis. The overall radius of
+// the cutter is given separately by the tool footprint. The stock model uses the same
+// profiles to subtract the cuts of these tools.
+type CutterProfile interface {
+	// Return the height of the cutting surface above the tip of the cutter at distance rho
+	// from the axis of the cutter.
+	HeightAt(rho float64) float64
+
+	// Return the distance from the axis of the cutter at which the cutter touches a plane
+	// with the given slope (rise over run). Return +Inf when the cutter can only touch the
+	// plane with its outer rim.
+	contactDistanceForSlope(slope float64) float64
+}
+
+// The profile of a V-bit: a cone with its apex at the tip of the cutter.
+type vBitProfile struct {
+	cotHalfAngle float64 // Rise over run of the cone's surface.
+}
+
+var _ CutterProfile = vBitProfile{}
+
+// NewVBitProfile returns the profile of a V-bit with the given included angle, in degrees.
+// For instance, a 90-degree V-bit cuts a groove as wide as it is twice deep.
+func NewVBitProfile(includedAngleDeg float64) CutterProfile {
+	halfAngle := 0.5 * includedAngleDeg * math.Pi / 180.0
+	return vBitProfile{
+		cotHalfAngle: 1.0 / math.Tan(halfAngle),
+	}
+}
+
+func (p vBitProfile) HeightAt(rho float64) float64 {
+	return rho * p.cotHalfAngle
+}
+
+func (p vBitProfile) contactDistanceForSlope(slope float64) float64 {
+	// The cone touches planes shallower than its own surface with its tip and steeper
+	// planes with its rim.
+	if slope <= p.cotHalfAngle {
+		return 0
+	}
+	return math.Inf(1)
+}
+
+// The profile of a tapered ball-nose: a cone whose apex is replaced by a ball of radius
+// tipRadius, tangent to the cone.
+type taperedBallProfile struct {
+	tipRadius    float64
+	cotHalfAngle float64 // Rise over run of the cone's surface.
+
+	// The ball and the cone meet at distance tangentRho from the axis, at height tangentHeight
+	// above the tip.
+	tangentRho    float64
+	tangentHeight float64
+}
+
+var _ CutterProfile = taperedBallProfile{}
+
+// NewTaperedBallProfile returns the profile of a tapered ball-nose with the given included
+// angle, in degrees, and the given radius of the ball at the tip.
+func NewTaperedBallProfile(includedAngleDeg, tipRadius float64) CutterProfile {
+	halfAngle := 0.5 * includedAngleDeg * math.Pi / 180.0
+	tipRadius = math.Max(0, tipRadius)
+	return taperedBallProfile{
+		tipRadius:     tipRadius,
+		cotHalfAngle:  1.0 / math.Tan(halfAngle),
+		tangentRho:    tipRadius * math.Cos(halfAngle),
+		tangentHeight: tipRadius * (1.0 - math.Sin(halfAngle)),
+	}
+}
+
+func (p taperedBallProfile) HeightAt(rho float64) float64 {
+	if rho <= p.tangentRho {
+		return p.tipRadius - math.Sqrt(p.tipRadius*p.tipRadius-rho*rho)
+	}
+	return p.tangentHeight + (rho-p.tangentRho)*p.cotHalfAngle
+}
+
+func (p taperedBallProfile) contactDistanceForSlope(slope float64) float64 {
+	// Planes shallower than the cone touch the ball where its normal matches the plane's.
+	if slope <= p.cotHalfAngle {
+		return p.tipRadius * slope / math.Sqrt(1.0+slope*slope)
+	}
+	return math.Inf(1)
+}
+
+// The profile of a bull-nose end-mill: a flat end whose outer edge is rounded with a corner
+// radius. The cutting surface is a torus around a flat disk of radius flatRadius.
+type bullNoseProfile struct {
+	flatRadius   float64
+	cornerRadius float64
+}
+
+var _ CutterProfile = bullNoseProfile{}
+
+// NewBullNoseProfile returns the profile of a bull-nose end-mill with the given overall radius
+// and corner radius. The corner radius is clamped to the radius of the cutter.
+func NewBullNoseProfile(cutterRadius, cornerRadius float64) CutterProfile {
+	cornerRadius = math.Max(0, math.Min(cutterRadius, cornerRadius))
+	return bullNoseProfile{
+		flatRadius:   cutterRadius - cornerRadius,
+		cornerRadius: cornerRadius,
+	}
+}
+
+func (p bullNoseProfile) HeightAt(rho float64) float64 {
+	if rho <= p.flatRadius {
+		return 0
+	}
+	d := math.Min(rho-p.flatRadius, p.cornerRadius)
+	return p.cornerRadius - math.Sqrt(p.cornerRadius*p.cornerRadius-d*d)
+}
+
+func (p bullNoseProfile) contactDistanceForSlope(slope float64) float64 {
+	// The torus touches the plane where its normal matches the plane's.
+	return p.flatRadius + p.cornerRadius*slope/math.Sqrt(1.0+slope*slope)
+}
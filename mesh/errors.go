@@ -0,0 +1,12 @@
+package mesh
+
+import "errors"
+
+// Errors returned by the mesh and the mesh samplers. They are wrapped with the details of the
+// failure, so use errors.Is to check for them.
+var (
+	ErrEmptyMesh       = errors.New("mesh: empty mesh area")
+	ErrIndexOutOfRange = errors.New("mesh: index out of range")
+	ErrInvalidCutter   = errors.New("mesh: invalid cutter")
+	ErrNoSamples       = errors.New("mesh: no height-map sampler")
+)
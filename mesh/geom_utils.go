@@ -1,7 +1,6 @@
 package mesh
 
 import (
-	"log"
 	"math"
 
 	"alvin.com/GoCarver/geom"
@@ -81,10 +80,14 @@ func isPlanePointWithinTriangle(p geom.Pt3, trg Triangle) bool {
 		return v.Dot(w) >= 0
 	}
 
-	// If we get down here it means the triangle configuration has changed in
-	// the mesh. The code most likely needs to be updated accordingly.
-	log.Fatal("Unexpected triangle configuration.")
-	return false
+	// Other triangles aren't built by the mesh. Fall back to checking that p1 is on the inner
+	// side of all three edges, whichever way the triangle turns.
+	side := func(a, b geom.Pt2) float64 {
+		ab, ap := b.Sub(a), p1.Sub(a)
+		return ab.X*ap.Y - ab.Y*ap.X
+	}
+	s0, s1, s2 := side(q0, q1), side(q1, q2), side(q2, q0)
+	return (s0 >= 0 && s1 >= 0 && s2 >= 0) || (s0 <= 0 && s1 <= 0 && s2 <= 0)
 }
 
 // Project point p onto the line passing through q1 and q2 and returns the result. In case of a
@@ -117,6 +120,70 @@ func isLinePointOnSegment(p geom.Pt3, q1, q2 geom.Pt3) bool {
 	return v.Dot(w) >= 0
 }
 
+// Clip the XY-projection of segment p0-p1 to the disk with center c and radius r. If the
+// segment overlaps the disk, return ok = true and the parameters 0 <= t0 <= t1 <= 1 such
+// that p0 + t * (p1 - p0) lies within the disk for all t in [t0, t1].
+func clipSegmentToDisk(p0, p1 geom.Pt3, c geom.Pt2, r float64) (ok bool, t0, t1 float64) {
+	w := geom.NewVec2(p1.X-p0.X, p1.Y-p0.Y)
+	v := geom.NewPt2(p0.X, p0.Y).Sub(c)
+
+	a := w.LenSq()
+	if a < 1e-12 {
+		// Degenerate, vertical segment: check whether it is within the disk.
+		return v.LenSq() <= r*r, 0, 1
+	}
+
+	// Solve |v + t * w|^2 = r^2 for t.
+	b := 2.0 * v.Dot(w)
+	cc := v.LenSq() - r*r
+	D := b*b - 4*a*cc
+	if D < 0 {
+		return false, 0, 0
+	}
+
+	D = math.Sqrt(D)
+	t0 = math.Max(0, (-b-D)/(2*a))
+	t1 = math.Min(1, (-b+D)/(2*a))
+	if t0 > t1 {
+		return false, 0, 0
+	}
+
+	return true, t0, t1
+}
+
+// Find the maximum of the concave function f over the interval [t0, t1] using a golden-section
+// search. The search stops when the bracketing interval is shorter than tol. Return the
+// location tMax of the maximum and its value fMax.
+func maximizeConcaveFunction(
+	f func(t float64) float64, t0, t1, tol float64) (tMax, fMax float64) {
+
+	invPhi := 0.5 * (math.Sqrt(5) - 1)
+	a, b := t0, t1
+	c := b - invPhi*(b-a)
+	d := a + invPhi*(b-a)
+	fc, fd := f(c), f(d)
+	for b-a > tol {
+		if fc >= fd {
+			b, d, fd = d, c, fc
+			c = b - invPhi*(b-a)
+			fc = f(c)
+		} else {
+			a, c, fc = c, d, fd
+			d = a + invPhi*(b-a)
+			fd = f(d)
+		}
+	}
+
+	// The maximum may be at either end of the interval, which the search only approaches.
+	tMax, fMax = 0.5*(a+b), f(0.5*(a+b))
+	for _, t := range []float64{t0, t1} {
+		if ft := f(t); ft > fMax {
+			tMax, fMax = t, ft
+		}
+	}
+	return
+}
+
 // Let L be the line defined by point q and direction vector w. Move point p along
 // the z-direction until it is at distance R from L if possible. If successful,
 // return success = true and set pz to the new Z-coordinate for point p.
@@ -131,8 +198,7 @@ func dropPointPToDistanceRFromLine(
 	l := w.LenSq()
 
 	if math.Abs(l) < 1e-12 {
-		log.Fatalln("Can't define a line with a 0-length vector")
-		return false, 0
+		return false, 0 // A 0-length vector doesn't define a line.
 	}
 
 	m := v.Dot(w) / l
@@ -138,6 +138,15 @@ func TestIsPointWithinTriangle(t *testing.T) {
 	a.Assert(t, isPlanePointWithinTriangle(geom.NewPt3(0, 0.999, 0.999), &trg))
 	a.Assert(t, !isPlanePointWithinTriangle(geom.NewPt3(0, 1.0001, 1), &trg))
 	a.Assert(t, isPlanePointWithinTriangle(geom.NewPt3(0, 1, 1.0001), &trg))
+
+	// Triangles other than the mesh triangles, turning either way.
+	trg = makeMeshTriangle([3]geom.Pt3{{X: 0, Y: 0, Z: 0}, {X: 2, Y: 0, Z: 0}, {X: 1, Y: 2, Z: 0}})
+	a.Assert(t, isPlanePointWithinTriangle(geom.NewPt3(1, 1, 0), &trg))
+	a.Assert(t, isPlanePointWithinTriangle(geom.NewPt3(2, 0, 0), &trg))
+	a.Assert(t, !isPlanePointWithinTriangle(geom.NewPt3(0.4, 1, 0), &trg))
+	trg = makeMeshTriangle([3]geom.Pt3{{X: 0, Y: 0, Z: 0}, {X: 1, Y: 2, Z: 0}, {X: 2, Y: 0, Z: 0}})
+	a.Assert(t, isPlanePointWithinTriangle(geom.NewPt3(1, 1, 0), &trg))
+	a.Assert(t, !isPlanePointWithinTriangle(geom.NewPt3(1, -0.1, 0), &trg))
 }
 
 func TestProjectPointToLine(t *testing.T) {
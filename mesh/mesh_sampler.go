@@ -1,7 +1,7 @@
 package mesh
 
 import (
-	"log"
+	"fmt"
 	"math"
 
 	"alvin.com/GoCarver/geom"
@@ -12,9 +12,11 @@ type MeshSampler struct {
 	mesh               *TriangleMesh
 	cutterRadius       float64
 	useBallPointCutter bool
+	profile            CutterProfile // Shape of the cutter, if neither ball-point nor flat.
 }
 
 var _ hmap.ScalarGridSampler = (*MeshSampler)(nil)
+var _ hmap.SlopeSampler = (*MeshSampler)(nil)
 
 // Sample the given triangle with a ballcutter tool whose footprint is toolFootprint.
 // Return success=true if a contact point is found and set z to the height of the tip
@@ -68,34 +70,224 @@ func sampleTriangleWithBallpointTool(
 	return false, 0.0
 }
 
-// Utility function to return triangle's edge Vi, Vi+1, modulo 3.
-func edge(trg Triangle, i int) (geom.Pt3, geom.Pt3) {
-	if i < 0 || i > 2 {
-		log.Fatalln("Invalid edge index")
-		return geom.Pt3{}, geom.Pt3{}
+// Sample the given triangle with a flat end-mill whose footprint is toolFootprint.
+// Return success=true if a contact point is found and set z to the height of the flat
+// bottom of the tool. Otherwise, return success = false and z = 0.
+func sampleTriangleWithFlatTool(
+	toolFootprint Footprint, trg Triangle) (success bool, z float64) {
+
+	// We treat the flat tool as a disk of radius r = cutter-radius centered at the tool
+	// location. Contact may happen within the face of the triangle, along any of the three
+	// edges or at any of the three vertices.
+	toolRadius := 0.5 * toolFootprint.GetWidth()
+	toolXY := toolFootprint.GetCenterPoint()
+
+	// Against the triangle's plane, the disk makes contact on its rim, on the uphill side
+	// of the plane. If the plane is horizontal, any point of the disk will do.
+	n := trg.UnitNormal()
+	if n.Z > 1e-6 {
+		contactXY := toolXY
+		nxy := geom.NewVec2(n.X, n.Y)
+		if l := nxy.Len(); l > 1e-9 {
+			contactXY = toolXY.SubV(nxy.Scale(toolRadius / l))
+		}
+
+		contactPt := geom.NewPt3(contactXY.X, contactXY.Y, 0)
+		if isPlanePointWithinTriangle(contactPt, trg) {
+			// We found a valid contact point within the triangle. Since it is the highest point
+			// of the plane under the disk, no edge or vertex can be any higher.
+			q := trg.Vertex(0)
+			return true, q.Z - (n.X*(contactXY.X-q.X)+n.Y*(contactXY.Y-q.Y))/n.Z
+		}
 	}
 
-	j := i + 1
-	if j > 2 {
-		j = 0
+	// The tool may still make contact with one of the triangle's edges or vertices. We clip
+	// each edge to the disk. Since z varies linearly along the edge, the highest point of the
+	// clipped edge is at one of its ends.
+	for i := 0; i < 3; i++ {
+		vi, vj := edge(trg, i)
+		ok, t0, t1 := clipSegmentToDisk(vi, vj, toolXY, toolRadius)
+		if ok {
+			zEdge := math.Max(vi.Z+t0*(vj.Z-vi.Z), vi.Z+t1*(vj.Z-vi.Z))
+			if !success || zEdge > z {
+				z = zEdge
+			}
+			success = true
+		}
 	}
-	return trg.Vertex(i), trg.Vertex(j)
+
+	return
 }
 
-func NewMeshSamplerWithBallCutter(mesh *TriangleMesh, cutterDiameter float64) *MeshSampler {
+// Sample the given triangle with a cutter of the given profile whose footprint is
+// toolFootprint. Return success=true if a contact point is found and set z to the height
+// of the tip of the tool. Otherwise, return success = false and z = 0.
+func sampleTriangleWithProfiledTool(
+	toolFootprint Footprint, trg Triangle, profile CutterProfile) (success bool, z float64) {
+
+	toolRadius := 0.5 * toolFootprint.GetWidth()
+	toolXY := toolFootprint.GetCenterPoint()
+
+	// Against the triangle's plane, the cutter makes contact on the uphill side, at the
+	// distance from its axis where the slope of the profile matches the slope of the plane.
+	n := trg.UnitNormal()
+	if n.Z > 1e-6 {
+		contactXY := toolXY
+		rho := 0.0
+		nxy := geom.NewVec2(n.X, n.Y)
+		if l := nxy.Len(); l > 1e-9 {
+			rho = math.Min(toolRadius, profile.contactDistanceForSlope(l/n.Z))
+			contactXY = toolXY.SubV(nxy.Scale(rho / l))
+		}
+
+		contactPt := geom.NewPt3(contactXY.X, contactXY.Y, 0)
+		if isPlanePointWithinTriangle(contactPt, trg) {
+			q := trg.Vertex(0)
+			planeZ := q.Z - (n.X*(contactXY.X-q.X)+n.Y*(contactXY.Y-q.Y))/n.Z
+			return true, planeZ - profile.HeightAt(rho)
+		}
+	}
+
+	// Otherwise, the cutter makes contact along one of the edges or at one of the vertices.
+	// Along the part of an edge under the cutter, the height of the tip at contact is a
+	// concave function of the position on the edge, so it has a single maximum.
+	for i := 0; i < 3; i++ {
+		vi, vj := edge(trg, i)
+		ok, t0, t1 := clipSegmentToDisk(vi, vj, toolXY, toolRadius)
+		if !ok {
+			continue
+		}
+
+		tipZAt := func(t float64) float64 {
+			p := vi.Add(vj.Sub(vi).Scale(t))
+			rho := math.Min(toolRadius, geom.NewPt2(p.X, p.Y).Sub(toolXY).Len())
+			return p.Z - profile.HeightAt(rho)
+		}
+
+		tol := 1e-4 / math.Max(1e-4, vj.Sub(vi).Len())
+		_, zEdge := maximizeConcaveFunction(tipZAt, t0, t1, tol)
+		if !success || zEdge > z {
+			z = zEdge
+		}
+		success = true
+	}
+
+	return
+}
+
+// Utility function to return triangle's edge Vi, Vi+1, for edge index i of 0, 1 or 2. Edge 2
+// goes from V2 back to V0. The samplers only loop over these three indices.
+func edge(trg Triangle, i int) (geom.Pt3, geom.Pt3) {
+	return trg.Vertex(i), trg.Vertex((i + 1) % 3)
+}
+
+// NewMeshSamplerWithBallCutter creates a sampler for a ball-nose with the given diameter.
+func NewMeshSamplerWithBallCutter(
+	mesh *TriangleMesh, cutterDiameter float64) (*MeshSampler, error) {
+
+	if err := checkCutter(mesh, cutterDiameter); err != nil {
+		return nil, err
+	}
+
 	return &MeshSampler{
 		mesh:               mesh,
 		cutterRadius:       0.5 * cutterDiameter,
 		useBallPointCutter: true,
-	}
+	}, nil
 }
 
-func NewMeshSamplerWithFlatCutter(mesh *TriangleMesh, cutterDiameter float64) *MeshSampler {
+// NewMeshSamplerWithFlatCutter creates a sampler for a flat end-mill with the given diameter.
+func NewMeshSamplerWithFlatCutter(
+	mesh *TriangleMesh, cutterDiameter float64) (*MeshSampler, error) {
+
+	if err := checkCutter(mesh, cutterDiameter); err != nil {
+		return nil, err
+	}
+
 	return &MeshSampler{
 		mesh:               mesh,
 		cutterRadius:       0.5 * cutterDiameter,
 		useBallPointCutter: false,
+	}, nil
+}
+
+// NewMeshSamplerWithVBitCutter creates a sampler for a V-bit with the given diameter and
+// included angle, in degrees.
+func NewMeshSamplerWithVBitCutter(
+	mesh *TriangleMesh, cutterDiameter, includedAngleDeg float64) (*MeshSampler, error) {
+
+	if err := checkCutter(mesh, cutterDiameter); err != nil {
+		return nil, err
+	}
+	if err := checkIncludedAngle(includedAngleDeg); err != nil {
+		return nil, err
+	}
+
+	return &MeshSampler{
+		mesh:         mesh,
+		cutterRadius: 0.5 * cutterDiameter,
+		profile:      NewVBitProfile(includedAngleDeg),
+	}, nil
+}
+
+// NewMeshSamplerWithTaperedBallCutter creates a sampler for a tapered ball-nose with the
+// given diameter, included angle, in degrees, and radius of the ball at the tip.
+func NewMeshSamplerWithTaperedBallCutter(
+	mesh *TriangleMesh, cutterDiameter, includedAngleDeg, tipRadius float64) (*MeshSampler, error) {
+
+	if err := checkCutter(mesh, cutterDiameter); err != nil {
+		return nil, err
+	}
+	if err := checkIncludedAngle(includedAngleDeg); err != nil {
+		return nil, err
+	}
+	if tipRadius < 0 || tipRadius > 0.5*cutterDiameter {
+		return nil, fmt.Errorf("%w: tip radius %.3f for diameter %.3f",
+			ErrInvalidCutter, tipRadius, cutterDiameter)
+	}
+
+	return &MeshSampler{
+		mesh:         mesh,
+		cutterRadius: 0.5 * cutterDiameter,
+		profile:      NewTaperedBallProfile(includedAngleDeg, tipRadius),
+	}, nil
+}
+
+// NewMeshSamplerWithBullNoseCutter creates a sampler for a bull-nose end-mill with the
+// given diameter and corner radius.
+func NewMeshSamplerWithBullNoseCutter(
+	mesh *TriangleMesh, cutterDiameter, cornerRadius float64) (*MeshSampler, error) {
+
+	if err := checkCutter(mesh, cutterDiameter); err != nil {
+		return nil, err
+	}
+	if cornerRadius < 0 || cornerRadius > 0.5*cutterDiameter {
+		return nil, fmt.Errorf("%w: corner radius %.3f for diameter %.3f",
+			ErrInvalidCutter, cornerRadius, cutterDiameter)
+	}
+
+	return &MeshSampler{
+		mesh:         mesh,
+		cutterRadius: 0.5 * cutterDiameter,
+		profile:      NewBullNoseProfile(0.5*cutterDiameter, cornerRadius),
+	}, nil
+}
+
+func checkCutter(mesh *TriangleMesh, cutterDiameter float64) error {
+	if mesh == nil {
+		return ErrEmptyMesh
+	}
+	if !(cutterDiameter > 0) {
+		return fmt.Errorf("%w: diameter %.3f", ErrInvalidCutter, cutterDiameter)
 	}
+	return nil
+}
+
+func checkIncludedAngle(includedAngleDeg float64) error {
+	if !(includedAngleDeg > 0 && includedAngleDeg < 180) {
+		return fmt.Errorf("%w: included angle %.1f", ErrInvalidCutter, includedAngleDeg)
+	}
+	return nil
 }
 
 func (ms *MeshSampler) GetNumSamplesFromX0ToX1(x0, x1 float64) int {
@@ -125,18 +317,48 @@ func (ms *MeshSampler) EnableInvertImage(enable bool) {
 }
 
 func (ms *MeshSampler) At(p geom.Pt2) float64 {
+	if ms.profile != nil {
+		return ms.sampleToolAt(p, func(fp Footprint, trg Triangle) (bool, float64) {
+			return sampleTriangleWithProfiledTool(fp, trg, ms.profile)
+		})
+	}
+
 	if ms.useBallPointCutter {
-		return ms.sampleBallPointToolAt(p)
+		return ms.sampleToolAt(p, sampleTriangleWithBallpointTool)
 	}
 
-	// TODO: flat-cutter implementation
-	log.Fatal("Not implemented")
-	return 1.0
+	return ms.sampleToolAt(p, sampleTriangleWithFlatTool)
 }
 
-// Sample location p with a ballpoint tool and return the z-coordinate for the
-// tip of the tool.
-func (ms *MeshSampler) sampleBallPointToolAt(p geom.Pt2) float64 {
+// MaxSlopeAt returns the steepest slope along direction dir of the mesh triangles under the
+// footprint of the cutter at p.
+func (ms *MeshSampler) MaxSlopeAt(p geom.Pt2, dir geom.Vec2) float64 {
+	dir = dir.Norm()
+	toolFootprint := NewFootprint(
+		geom.NewPt2(p.X-ms.cutterRadius, p.Y-ms.cutterRadius),
+		geom.NewPt2(p.X+ms.cutterRadius, p.Y+ms.cutterRadius))
+	triangles := ms.mesh.GetTrianglesUnderFootprint(toolFootprint)
+
+	// The gradient of the triangle's plane is (-nx/nz, -ny/nz). Mesh triangles are never
+	// vertical, since the mesh is a height field.
+	maxSlope := 0.0
+	for t := triangles.Next(); t != nil; t = triangles.Next() {
+		n := t.UnitNormal()
+		if math.Abs(n.Z) < 1e-9 {
+			continue
+		}
+		slope := math.Abs((n.X*dir.X + n.Y*dir.Y) / n.Z)
+		maxSlope = math.Max(maxSlope, slope)
+	}
+
+	return maxSlope
+}
+
+// Sample location p with the tool whose per-triangle contact is computed by sampleTriangle
+// and return the z-coordinate for the tip of the tool.
+func (ms *MeshSampler) sampleToolAt(
+	p geom.Pt2, sampleTriangle func(Footprint, Triangle) (bool, float64)) float64 {
+
 	toolFootprint := NewFootprint(
 		geom.NewPt2(p.X-ms.cutterRadius, p.Y-ms.cutterRadius),
 		geom.NewPt2(p.X+ms.cutterRadius, p.Y+ms.cutterRadius))
@@ -147,7 +369,7 @@ func (ms *MeshSampler) sampleBallPointToolAt(p geom.Pt2) float64 {
 	toolZ := 0.0
 	t := triangles.Next()
 	for ; t != nil; t = triangles.Next() {
-		if ok, z := sampleTriangleWithBallpointTool(toolFootprint, t); ok {
+		if ok, z := sampleTriangle(toolFootprint, t); ok {
 			foundContact = true
 			toolZ = math.Max(z, toolZ)
 		}
@@ -29,3 +29,100 @@ func TestSampleTriangleWithBallpoint(t *testing.T) {
 	a.Equal(t, ok, true)
 	a.Assert(t, epsEq(h, 0.5, 0.00032))
 }
+
+func TestSampleTriangleWithFlatTool(t *testing.T) {
+	trg := makeMeshTriangle([3]geom.Pt3{{X: 0, Y: 0, Z: 1}, {X: 0, Y: 1, Z: 1}, {X: 1, Y: 1, Z: 0}})
+
+	// Tool centered in the triangle: the rim touches the plane on the uphill side.
+	fp := NewFootprint(geom.NewPt2(0.2, 0.55), geom.NewPt2(0.4, 0.75))
+	ok, h := sampleTriangleWithFlatTool(fp, &trg)
+	a.Equal(t, ok, true)
+	a.Assert(t, epsEq(h, 0.8, 1e-6))
+
+	// Large tool covering the whole triangle rests on the top edge.
+	fp = NewFootprint(geom.NewPt2(-2, -2), geom.NewPt2(2, 2))
+	ok, h = sampleTriangleWithFlatTool(fp, &trg)
+	a.Equal(t, ok, true)
+	a.Assert(t, epsEq(h, 1.0, 1e-6))
+
+	// Tool to the right of the triangle, only reaching around the lower vertex. The highest
+	// contact is where the rim crosses the top edge.
+	fp = NewFootprint(geom.NewPt2(0.95, 0.55), geom.NewPt2(1.85, 1.45))
+	ok, h = sampleTriangleWithFlatTool(fp, &trg)
+	a.Equal(t, ok, true)
+	a.Assert(t, epsEq(h, 0.05, 1e-6))
+
+	// Tool just off the diagonal edge: contact along the edge, not the face.
+	fp = NewFootprint(geom.NewPt2(0.55, 0.35), geom.NewPt2(0.85, 0.65))
+	ok, h = sampleTriangleWithFlatTool(fp, &trg)
+	a.Equal(t, ok, true)
+	a.Assert(t, epsEq(h, 1.0-(2.4-math.Sqrt(0.02))/4, 1e-6))
+
+	// Tool far away from the triangle.
+	fp = NewFootprint(geom.NewPt2(5, 5), geom.NewPt2(6, 6))
+	ok, _ = sampleTriangleWithFlatTool(fp, &trg)
+	a.Equal(t, ok, false)
+}
+
+func TestSampleTriangleWithProfiledTool(t *testing.T) {
+	trg := makeMeshTriangle([3]geom.Pt3{{X: 0, Y: 0, Z: 1}, {X: 0, Y: 1, Z: 1}, {X: 1, Y: 1, Z: 0}})
+	fp := NewFootprint(geom.NewPt2(0.2, 0.55), geom.NewPt2(0.4, 0.75))
+
+	// A 90-degree V-bit on a 45-degree slope touches the plane with its tip.
+	ok, h := sampleTriangleWithProfiledTool(fp, &trg, NewVBitProfile(90))
+	a.Equal(t, ok, true)
+	a.Assert(t, epsEq(h, 0.7, 1e-6))
+
+	// A 120-degree V-bit is shallower than the plane and touches it with its rim.
+	ok, h = sampleTriangleWithProfiledTool(fp, &trg, NewVBitProfile(120))
+	a.Equal(t, ok, true)
+	a.Assert(t, epsEq(h, 0.8-0.1/math.Sqrt(3), 1e-6))
+
+	// A narrow tapered ball-nose touches the plane with its ball.
+	ok, h = sampleTriangleWithProfiledTool(fp, &trg, NewTaperedBallProfile(30, 0.1))
+	a.Equal(t, ok, true)
+	a.Assert(t, epsEq(h, 0.7+0.1*(math.Sqrt(2)-1), 1e-6))
+
+	// A bull-nose on a 45-degree slope touches the plane on its corner. The corner's center
+	// is at 0.05 from the axis and 0.05 above the tip.
+	ok, h = sampleTriangleWithProfiledTool(fp, &trg, NewBullNoseProfile(0.1, 0.05))
+	a.Equal(t, ok, true)
+	a.Assert(t, epsEq(h, 0.75+0.05*(math.Sqrt(2)-1), 1e-6))
+
+	// A 90-degree V-bit to the left of the triangle touches the left edge.
+	fp = NewFootprint(geom.NewPt2(-0.8, 0.0), geom.NewPt2(0.2, 1.0))
+	ok, h = sampleTriangleWithProfiledTool(fp, &trg, NewVBitProfile(90))
+	a.Equal(t, ok, true)
+	a.Assert(t, epsEq(h, 0.7, 1e-6))
+
+	// Tool far away from the triangle.
+	fp = NewFootprint(geom.NewPt2(5, 5), geom.NewPt2(6, 6))
+	ok, _ = sampleTriangleWithProfiledTool(fp, &trg, NewVBitProfile(90))
+	a.Equal(t, ok, false)
+}
+
+func TestTaperedBallProfile(t *testing.T) {
+	p := NewTaperedBallProfile(60, 1.0).(taperedBallProfile)
+
+	// The ball and the cone meet smoothly.
+	a.Assert(t, epsEq(p.tangentRho, 0.5*math.Sqrt(3), 1e-9))
+	a.Assert(t, epsEq(p.HeightAt(p.tangentRho), 0.5, 1e-9))
+	a.Assert(t, epsEq(p.HeightAt(p.tangentRho+1), 0.5+math.Sqrt(3), 1e-9))
+	a.Assert(t, epsEq(p.contactDistanceForSlope(p.cotHalfAngle), p.tangentRho, 1e-9))
+	a.Assert(t, math.IsInf(p.contactDistanceForSlope(2*p.cotHalfAngle), 1))
+}
+
+func TestMaxSlopeAt(t *testing.T) {
+	// The mesh slopes up by 10 over 100 along X.
+	m, err := NewTriangleMesh(geom.NewPt2(xMin, yMin), geom.NewPt2(xMax, yMax), zBlack, zWhite,
+		new4x4Sampler(1, 0))
+	a.NilError(t, err)
+	ms, err := NewMeshSamplerWithBallCutter(m, 10)
+	a.NilError(t, err)
+
+	p := geom.NewPt2(40, 40)
+	a.Assert(t, epsEq(ms.MaxSlopeAt(p, geom.NewVec2(1, 0)), 0.1, 1e-9))
+	a.Assert(t, epsEq(ms.MaxSlopeAt(p, geom.NewVec2(-2, 0)), 0.1, 1e-9))
+	a.Assert(t, epsEq(ms.MaxSlopeAt(p, geom.NewVec2(0, 1)), 0, 1e-9))
+	a.Assert(t, epsEq(ms.MaxSlopeAt(p, geom.NewVec2(1, 1)), 0.1/math.Sqrt2, 1e-9))
+}
@@ -0,0 +1,58 @@
+package mesh
+
+import (
+	"alvin.com/GoCarver/geom"
+)
+
+// SliceSegment is a segment of the intersection of the mesh surface with a horizontal plane.
+type SliceSegment struct {
+	P0, P1 geom.Pt2
+}
+
+// SliceAt returns the segments along which the mesh surface crosses height z, one for each
+// triangle that has vertices both above z and at or below z. Together, the segments outline the
+// parts of the mesh that are above z, except along the boundary of the mesh. The segments are
+// not ordered.
+func (t *TriangleMesh) SliceAt(z float64) []SliceSegment {
+	var segments []SliceSegment
+	for ir := 0; ir+1 < len(t.rows); ir++ {
+		row0 := &t.rows[ir]
+		row1 := &t.rows[ir+1]
+		for ic := 0; ic+1 < len(t.x); ic++ {
+			v00 := geom.NewPt3(t.x[ic], row0.y, row0.z[ic])
+			v01 := geom.NewPt3(t.x[ic], row1.y, row1.z[ic])
+			v11 := geom.NewPt3(t.x[ic+1], row1.y, row1.z[ic+1])
+			v10 := geom.NewPt3(t.x[ic+1], row0.y, row0.z[ic+1])
+
+			// Same triangles as in GetTriangle.
+			for _, trg := range [2][3]geom.Pt3{{v00, v01, v11}, {v00, v11, v10}} {
+				if s, ok := sliceTriangle(trg, z); ok {
+					segments = append(segments, s)
+				}
+			}
+		}
+	}
+	return segments
+}
+
+// Return the segment along which the triangle crosses height z, or false if the triangle is
+// entirely above z or entirely at or below z.
+func sliceTriangle(vertices [3]geom.Pt3, z float64) (SliceSegment, bool) {
+	var crossings []geom.Pt2
+	for i := 0; i < 3; i++ {
+		p, q := vertices[i], vertices[(i+1)%3]
+		if (p.Z > z) == (q.Z > z) {
+			continue
+		}
+
+		t := (z - p.Z) / (q.Z - p.Z)
+		crossings = append(crossings, geom.NewPt2(p.X+t*(q.X-p.X), p.Y+t*(q.Y-p.Y)))
+	}
+
+	// An edge crosses z if and only if exactly one of its vertices is above z, so there are
+	// either none or two crossings.
+	if len(crossings) != 2 {
+		return SliceSegment{}, false
+	}
+	return SliceSegment{P0: crossings[0], P1: crossings[1]}, true
+}
@@ -1,7 +1,7 @@
 package mesh
 
 import (
-	"log"
+	"fmt"
 	"math"
 
 	"alvin.com/GoCarver/geom"
@@ -42,14 +42,18 @@ var _ TriangleIterator = (*triangleArray)(nil)
 // NewTriangleMesh creates and returns a new triangle mesh generated from the min/max corners
 // of the grid and a sampler. The number of vertices along x and y in the grid is determined
 // by the number of samples that the sampler generate in these directions. (See interface
-// iSampler.) A grid point is generated for each sample.
+// iSampler.) A grid point is generated for each sample. Return ErrEmptyMesh if the grid has no
+// area.
 func NewTriangleMesh(
 	pMin geom.Pt2, pMax geom.Pt2,
 	zBlack, zWhite float64,
-	sampler hmap.ScalarGridSampler) *TriangleMesh {
+	sampler hmap.ScalarGridSampler) (*TriangleMesh, error) {
 
 	if pMin.X == pMax.X || pMin.Y == pMax.Y {
-		return nil
+		return nil, fmt.Errorf("%w: from %v to %v", ErrEmptyMesh, pMin, pMax)
+	}
+	if sampler == nil {
+		return nil, ErrNoSamples
 	}
 
 	mesh := &TriangleMesh{}
@@ -59,7 +63,7 @@ func NewTriangleMesh(
 	mesh.zMin = math.Min(zBlack, zWhite)
 	mesh.zMax = math.Max(zBlack, zWhite)
 
-	return mesh
+	return mesh, nil
 }
 
 // GetZExtents returns the mesh z-extents.
@@ -85,12 +89,11 @@ func (t *TriangleMesh) GetMeshFootprint() Footprint {
 }
 
 // GetTriangle returns the triangle at index (iX, iY) where 0 <= iX < nX and
-// 0 <= iY < nY. Triangle counts nX and nY are returned by GetNumTriangles.
-func (t *TriangleMesh) GetTriangle(iX, iY int) Triangle {
-	nX, nY := t.GetNumTriangles()
-	if iX < 0 || iX >= nX || iY < 0 || iY >= nY {
-		log.Fatal("Triangle indices out of range")
-		return &meshTriangle{}
+// 0 <= iY < nY. Triangle counts nX and nY are returned by GetNumTriangles. Return
+// ErrIndexOutOfRange for other indices.
+func (t *TriangleMesh) GetTriangle(iX, iY int) (Triangle, error) {
+	if err := t.checkTriangleIndices(iX, iY); err != nil {
+		return nil, err
 	}
 
 	trg := &meshTriangle{}
@@ -119,15 +122,14 @@ func (t *TriangleMesh) GetTriangle(iX, iY int) Triangle {
 		trg.vertices[2] = geom.NewPt3(xRight, yBottom, t.rows[iY].z[iV+1])
 	}
 
-	return trg
+	return trg, nil
 }
 
-// GetFootprintForTriangle returns the footprint for triangle at indices (iX, iY).
-func (t *TriangleMesh) GetFootprintForTriangle(iX, iY int) Footprint {
-	nX, nY := t.GetNumTriangles()
-	if iX < 0 || iX >= nX || iY < 0 || iY >= nY {
-		log.Fatal("Triangle indices out of range")
-		return Footprint{}
+// GetFootprintForTriangle returns the footprint for triangle at indices (iX, iY). Return
+// ErrIndexOutOfRange for indices outside the mesh.
+func (t *TriangleMesh) GetFootprintForTriangle(iX, iY int) (Footprint, error) {
+	if err := t.checkTriangleIndices(iX, iY); err != nil {
+		return Footprint{}, err
 	}
 
 	// Vertices and triangles are layed out as follows in the grid:
@@ -144,7 +146,15 @@ func (t *TriangleMesh) GetFootprintForTriangle(iX, iY int) Footprint {
 	f.PMax.X = t.x[nV+1]
 	f.PMax.Y = t.rows[iY+1].y
 
-	return f
+	return f, nil
+}
+
+func (t *TriangleMesh) checkTriangleIndices(iX, iY int) error {
+	nX, nY := t.GetNumTriangles()
+	if iX < 0 || iX >= nX || iY < 0 || iY >= nY {
+		return fmt.Errorf("%w: triangle (%d, %d) of %d x %d", ErrIndexOutOfRange, iX, iY, nX, nY)
+	}
+	return nil
 }
 
 // GetTrianglesUnderFootprint gathers all the mesh triangles that are covered by the given footprint
@@ -196,6 +206,30 @@ func (t *TriangleMesh) GetTrianglesUnderFootprint(f Footprint) TriangleIterator
 	return ta
 }
 
+// HeightAt returns the height of the mesh surface at p, interpolated over the triangle under p.
+// Returns false if p is outside the mesh.
+func (t *TriangleMesh) HeightAt(p geom.Pt2) (float64, bool) {
+	f := NewFootprint(p, p)
+	ir, _ := t.findRowsForFootprint(f)
+	ic, _ := t.findColumnsForFootprint(f)
+	if ir < 0 || ic < 0 {
+		return 0, false
+	}
+
+	row0 := &t.rows[ir]
+	row1 := &t.rows[ir+1]
+	u := (p.X - t.x[ic]) / (t.x[ic+1] - t.x[ic])
+	v := (p.Y - row0.y) / (row1.y - row0.y)
+	z00, z10 := row0.z[ic], row0.z[ic+1]
+	z01, z11 := row1.z[ic], row1.z[ic+1]
+
+	// The diagonal of each grid square goes from (x0, y0) to (x1, y1). See gridRow.
+	if u <= v {
+		return z00 + v*(z01-z00) + u*(z11-z01), true
+	}
+	return z00 + u*(z10-z00) + v*(z11-z10), true
+}
+
 // Find the rows that overlap with the given footprint. Returns indices iMinRow, iMaxRow
 // such that the footprint fits entirely within the y-coordinates of each row. Returns
 // iMinRow == iMaxRow == -1 if the footprint doesn't overlap the mesh at all.
@@ -363,13 +397,9 @@ func (t *TriangleMesh) populateVerticesForRow(
 }
 
 // Allocate and fill the array of triangle normals for grid row with index rowIndex.
-// Pre-condition: z-coordinates  for rows at index rowIndex and rowIndex+1 must be populated.
+// Pre-condition: z-coordinates  for rows at index rowIndex and rowIndex+1 must be populated,
+// so rowIndex is less than the number of rows minus one.
 func (t *TriangleMesh) populateNormalsForRow(rowIndex int) {
-	if rowIndex >= len(t.rows)-1 {
-		log.Fatal("Index rowIndex out of range")
-		return
-	}
-
 	//    xk  xl   x-coordinates xk and xl
 	// yj +---+    row of vertices at y = yj
 	//    |  /|
@@ -1,6 +1,7 @@
 package mesh
 
 import (
+	"errors"
 	"math"
 	"os"
 	"testing"
@@ -85,8 +86,8 @@ func TestFlatTriangleMesh(t *testing.T) {
 	s := new4x4Sampler(0, 0)
 	p1 := geom.NewPt2(xMin, yMin)
 	p2 := geom.NewPt2(xMax, yMax)
-	m := NewTriangleMesh(p1, p2, zBlack, zWhite, s)
-	a.Assert(t, m != nil)
+	m, err := NewTriangleMesh(p1, p2, zBlack, zWhite, s)
+	a.NilError(t, err)
 
 	nX, nY := m.GetNumTriangles()
 	a.Assert(t, is.Equal(nX, 8))
@@ -94,7 +95,8 @@ func TestFlatTriangleMesh(t *testing.T) {
 
 	// printMeshTriangles(m, t)
 
-	t00 := m.GetTriangle(0, 0)
+	t00, err := m.GetTriangle(0, 0)
+	a.NilError(t, err)
 	a.Assert(t, t00.Vertex(0).EqXyz(0, 0, 10))
 	visitAllTriangles(m, t, func(iX, iY int, trg Triangle, t *testing.T) {
 		a.Assert(t, trg.UnitNormal().EqXyz(0, 0, 1))
@@ -103,19 +105,23 @@ func TestFlatTriangleMesh(t *testing.T) {
 		a.Assert(t, trg.Vertex(2).Z == zWhite)
 	})
 
-	fp := m.GetFootprintForTriangle(0, 0)
+	fp, err := m.GetFootprintForTriangle(0, 0)
+	a.NilError(t, err)
 	a.Assert(t, fp.PMax.Eq(25, 25))
 	a.Assert(t, fp.PMin.Eq(0, 0))
 
-	fp = m.GetFootprintForTriangle(1, 0)
+	fp, err = m.GetFootprintForTriangle(1, 0)
+	a.NilError(t, err)
 	a.Assert(t, fp.PMax.Eq(25, 25))
 	a.Assert(t, fp.PMin.Eq(0, 0))
 
-	fp = m.GetFootprintForTriangle(6, 3)
+	fp, err = m.GetFootprintForTriangle(6, 3)
+	a.NilError(t, err)
 	a.Assert(t, fp.PMax.Eq(100, 100))
 	a.Assert(t, fp.PMin.Eq(75, 75))
 
-	fp = m.GetFootprintForTriangle(7, 3)
+	fp, err = m.GetFootprintForTriangle(7, 3)
+	a.NilError(t, err)
 	a.Assert(t, fp.PMax.Eq(100, 100))
 	a.Assert(t, fp.PMin.Eq(75, 75))
 
@@ -174,8 +180,8 @@ func TestXSlopeTriangleMesh(t *testing.T) {
 	s := new4x4Sampler(1, 0)
 	p1 := geom.NewPt2(xMin, yMin)
 	p2 := geom.NewPt2(xMax, yMax)
-	m := NewTriangleMesh(p1, p2, zBlack, zWhite, s)
-	a.Assert(t, m != nil)
+	m, err := NewTriangleMesh(p1, p2, zBlack, zWhite, s)
+	a.NilError(t, err)
 
 	nX, nY := m.GetNumTriangles()
 	a.Assert(t, is.Equal(nX, 8))
@@ -208,8 +214,8 @@ func TestYSlopeTriangleMesh(t *testing.T) {
 	s := new4x4Sampler(0, 1)
 	p1 := geom.NewPt2(xMin, yMin)
 	p2 := geom.NewPt2(xMax, yMax)
-	m := NewTriangleMesh(p1, p2, zBlack, zWhite, s)
-	a.Assert(t, m != nil)
+	m, err := NewTriangleMesh(p1, p2, zBlack, zWhite, s)
+	a.NilError(t, err)
 
 	nX, nY := m.GetNumTriangles()
 	a.Assert(t, is.Equal(nX, 8))
@@ -237,11 +243,78 @@ func TestYSlopeTriangleMesh(t *testing.T) {
 	a.Assert(t, t1.Vertex(2).EqXyz(50, 50, 5))
 }
 
+func TestTriangleMeshHeightAt(t *testing.T) {
+	// 4x4 sampler with both weights = 1 produces a mesh with z = x * y / 1000 at the vertices,
+	// which is not planar over the grid squares.
+	s := new4x4Sampler(1, 1)
+	m, err := NewTriangleMesh(geom.NewPt2(xMin, yMin), geom.NewPt2(xMax, yMax), zBlack, zWhite, s)
+	a.NilError(t, err)
+
+	check := func(p geom.Pt2, expected float64) {
+		z, ok := m.HeightAt(p)
+		a.Assert(t, ok)
+		a.Assert(t, math.Abs(z-expected) < 1e-9, "height at %v: expected %f, got %f", p, expected, z)
+	}
+
+	check(geom.NewPt2(50, 75), 3.75)
+	check(geom.NewPt2(100, 100), 10)
+
+	// Both triangles of the grid square from (25, 0) to (50, 25).
+	check(geom.NewPt2(37.5, 6.25), 0.3125)
+	check(geom.NewPt2(31.25, 12.5), 0.46875)
+
+	_, ok := m.HeightAt(geom.NewPt2(50, 101))
+	a.Assert(t, !ok)
+}
+
+func TestTriangleMeshSliceAt(t *testing.T) {
+	// The mesh slopes up toward increasing X, with z = x / 10, so it crosses z = 3 along the
+	// line x = 30, over the whole height of the mesh.
+	s := new4x4Sampler(1, 0)
+	m, err := NewTriangleMesh(geom.NewPt2(xMin, yMin), geom.NewPt2(xMax, yMax), zBlack, zWhite, s)
+	a.NilError(t, err)
+
+	length := 0.0
+	for _, seg := range m.SliceAt(3) {
+		a.Assert(t, math.Abs(seg.P0.X-30) < 1e-9 && math.Abs(seg.P1.X-30) < 1e-9,
+			"unexpected slice segment %v", seg)
+		length += seg.P1.Sub(seg.P0).Len()
+	}
+	a.Assert(t, math.Abs(length-100) < 1e-9, "slice length: expected 100, got %f", length)
+
+	// The mesh doesn't cross its top or any height below its bottom.
+	a.Assert(t, is.Len(m.SliceAt(10), 0))
+	a.Assert(t, is.Len(m.SliceAt(-1), 0))
+}
+
+func TestTriangleMeshErrors(t *testing.T) {
+	s := new4x4Sampler(0, 0)
+	_, err := NewTriangleMesh(geom.NewPt2(xMin, yMin), geom.NewPt2(xMin, yMax), zBlack, zWhite, s)
+	a.Assert(t, errors.Is(err, ErrEmptyMesh))
+
+	m, err := NewTriangleMesh(geom.NewPt2(xMin, yMin), geom.NewPt2(xMax, yMax), zBlack, zWhite, s)
+	a.NilError(t, err)
+	_, err = m.GetTriangle(8, 0)
+	a.Assert(t, errors.Is(err, ErrIndexOutOfRange))
+	_, err = m.GetFootprintForTriangle(0, -1)
+	a.Assert(t, errors.Is(err, ErrIndexOutOfRange))
+
+	_, err = NewMeshSamplerWithFlatCutter(m, 0)
+	a.Assert(t, errors.Is(err, ErrInvalidCutter))
+	_, err = NewMeshSamplerWithVBitCutter(m, 6, 180)
+	a.Assert(t, errors.Is(err, ErrInvalidCutter))
+	_, err = NewMeshSamplerWithBullNoseCutter(m, 6, 4)
+	a.Assert(t, errors.Is(err, ErrInvalidCutter))
+	_, err = NewMeshSamplerWithBallCutter(nil, 6)
+	a.Assert(t, errors.Is(err, ErrEmptyMesh))
+}
+
 func visitAllTriangles(m *TriangleMesh, t *testing.T, visitor func(iX, iY int, trg Triangle, t *testing.T)) {
 	nX, nY := m.GetNumTriangles()
 	for y := 0; y < nY; y++ {
 		for x := 0; x < nX; x++ {
-			trg := m.GetTriangle(x, y)
+			trg, err := m.GetTriangle(x, y)
+			a.NilError(t, err)
 			visitor(x, y, trg, t)
 		}
 	}
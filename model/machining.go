@@ -0,0 +1,620 @@
+package model
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	carv "alvin.com/GoCarver/carving"
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+	"alvin.com/GoCarver/mesh"
+	"alvin.com/GoCarver/sim"
+	"alvin.com/GoCarver/util"
+
+	"github.com/disintegration/imaging"
+)
+
+// Job is a machining job set up from the model, ready for generating the code.
+type Job struct {
+	Config carv.MachiningConfig
+
+	// The target surface, when the model asks for checking the code for gouges.
+	Target *mesh.TriangleMesh
+}
+
+// NewJob sets up the machining job for the current model. The height-map samplers are built on
+// a triangle mesh to account for the shape of the tools, unless useMeshSampler is false. Return
+// an error wrapping one of the carving ErrXXX values if a model choice has no carving
+// equivalent.
+func (m *Model) NewJob(useMeshSampler bool) (*Job, error) {
+	if m.GetHeightMap() == nil {
+		return nil, fmt.Errorf("the model has no height-map image")
+	}
+	if imgMode := m.GetIntValue(ImgFillModeTag); imgMode < ImageModeFill || imgMode > ImageModeCrop {
+		return nil, fmt.Errorf("%w: unknown image fill mode %d", carv.ErrUnsupportedMode, imgMode)
+	}
+	postProcessor := m.GetIntValue(PostProcessorTag)
+	if postProcessor < 0 || postProcessor >= len(postProcessorNames) {
+		return nil, fmt.Errorf("%w: unknown model post-processor %d",
+			carv.ErrUnsupportedMode, postProcessor)
+	}
+
+	// Keep the first error converting the model choices to carving values.
+	var err error
+	convert := func(value int, e error) int {
+		if err == nil {
+			err = e
+		}
+		return value
+	}
+
+	job := &Job{}
+	mc := &job.Config
+	mc.Machine.ToolChangeMode =
+		convert(carverToolChangeModeFromModelMode(m.GetIntValue(ToolChangeModeTag)))
+	mc.Machine.ParkPosition = geom.NewPt3(
+		float64(m.GetFloat32Value(ParkXTag)),
+		float64(m.GetFloat32Value(ParkYTag)),
+		float64(m.GetFloat32Value(ParkZTag)))
+	mc.Machine.EnableArcFitting = m.GetBoolValue(FitArcsTag)
+	mc.Machine.PostProcessor = postProcessorNames[postProcessor]
+	mc.Machine.Units = convert(carverUnitsFromModelUnits(m.GetIntValue(OutputUnitsTag)))
+	mc.Machine.Retract = carv.RetractConfig{
+		Mode:            convert(carverRetractModeFromModelMode(m.GetIntValue(RetractModeTag))),
+		SafeHeight:      float64(m.GetFloat32Value(SafeHeightTag)),
+		ClearanceHeight: float64(m.GetFloat32Value(ClearanceHeightTag)),
+		RetractHeight:   float64(m.GetFloat32Value(RetractHeightTag)),
+		RapidThreshold:  float64(m.GetFloat32Value(RapidThresholdTag)),
+	}
+	mc.Machine.Origin = carv.OriginConfig{
+		XY:         convert(carverOriginXYFromModelOrigin(m.GetIntValue(OriginXYTag))),
+		XYOf:       convert(carverOriginOfFromModelOriginOf(m.GetIntValue(OriginOfTag))),
+		Z:          convert(carverOriginZFromModelOrigin(m.GetIntValue(OriginZTag))),
+		WorkOffset: m.GetIntValue(WorkOffsetTag),
+	}
+
+	mc.Entry.Mode = convert(carverEntryModeFromModelMode(m.GetIntValue(EntryModeTag)))
+	mc.Entry.MaxRampAngle = float64(m.GetFloat32Value(MaxRampAngleTag))
+	mc.Entry.HelixRadius = float64(m.GetFloat32Value(HelixRadiusTag))
+
+	mc.Stock.Enable = m.GetBoolValue(TrackStockTag)
+	mc.Stock.Resolution = float64(m.GetFloat32Value(StockResolutionTag))
+
+	mc.Material.MaterialDim = geom.NewSize2FromFloat32(
+		m.GetFloat32Value(MatWidthTag), m.GetFloat32Value(MatHeightTag))
+	mc.Material.CarvingAreaOrigin = geom.NewPt2FromFloat32(
+		m.GetFloat32Value(CarvOffsetXTag), m.GetFloat32Value(CarvOffsetYTag))
+	mc.Material.CarvingAreaDim = geom.NewSize2FromFloat32(
+		m.GetFloat32Value(CarvWidthTag), m.GetFloat32Value(CarvHeightTag))
+	mc.Material.MaterialThickness = float64(m.GetFloat32Value(MatThicknessTag))
+
+	mc.Carving.Tool.ToolType = convert(carverToolTypeFromModelToolType(m.GetIntValue(ToolTypeTag)))
+	mc.Carving.Tool.ToolDiameter = float64(m.GetFloat32Value(ToolDiamTag))
+	mc.Carving.Tool.ToolAngle = float64(m.GetFloat32Value(ToolAngleTag))
+	mc.Carving.Tool.TipRadius = float64(m.GetFloat32Value(ToolTipRadiusTag))
+	mc.Carving.Tool.CornerRadius = float64(m.GetFloat32Value(ToolCornerRadiusTag))
+	mc.Carving.Tool.HorizFeedRate = float64(m.GetFloat32Value(HorizFeedRateTag))
+	mc.Carving.Tool.VertFeedRate = float64(m.GetFloat32Value(VertFeedRateTag))
+
+	stepOverFraction := float64(m.GetFloat32Value(StepOverTag)) * 0.01
+	mc.Carving.StepOverFraction = math.Max(0.05, math.Min(1.0, stepOverFraction))
+	mc.Carving.ScallopHeight = float64(m.GetFloat32Value(ScallopHeightTag))
+	mc.Carving.Tool.MaxStepDown = float64(m.GetFloat32Value(MaxStepDownTag))
+	mc.Carving.CarvingMode =
+		convert(carverModeFromModelCarvingMode(m.GetIntValue(CarvDirectionTag)))
+	mc.Carving.RasterAngle = float64(m.GetFloat32Value(RasterAngleTag))
+	mc.Carving.RasterDirection =
+		convert(carverRasterDirectionFromModelDirection(m.GetIntValue(RasterDirectionTag)))
+	mc.Carving.LoopCornerRadius = float64(m.GetFloat32Value(LoopCornerRadiusTag))
+	mc.Carving.EnableStayDown = m.GetBoolValue(StayDownLinkingTag)
+	mc.Carving.SkipAirCuts = m.GetBoolValue(SkipAirCutsTag)
+
+	topZ := mc.Material.MaterialThickness + float64(m.GetFloat32Value(CarvWhiteDepthTag))
+	bottomZ := mc.Material.MaterialThickness + float64(m.GetFloat32Value(CarvBlackDepthTag))
+	invertImage := false
+	if bottomZ > topZ {
+		invertImage = true
+		bottomZ, topZ = topZ, bottomZ
+	}
+
+	mc.Carving.CarvingTopZ = topZ
+	mc.Carving.CarvingBottomZ = bottomZ
+
+	mc.Roughing.Enable = m.GetBoolValue(EnableRoughingTag)
+	mc.Roughing.Tool.ToolType = carv.ToolTypeFlat
+	mc.Roughing.Tool.ToolDiameter = float64(m.GetFloat32Value(RoughingToolDiameterTag))
+	mc.Roughing.Tool.HorizFeedRate = float64(m.GetFloat32Value(RoughingHorizFeedRateTag))
+	mc.Roughing.Tool.VertFeedRate = float64(m.GetFloat32Value(RoughingVertFeedRateTag))
+	mc.Roughing.Tool.MaxStepDown = float64(m.GetFloat32Value(RoughingMaxStepDownTag))
+	roughingStepOver := float64(m.GetFloat32Value(RoughingStepOverTag)) * 0.01
+	mc.Roughing.StepOverFraction = math.Max(0.05, math.Min(1.0, roughingStepOver))
+	mc.Roughing.StockToLeave = float64(m.GetFloat32Value(RoughingStockToLeaveTag))
+
+	mc.Rest.Enable = m.GetBoolValue(EnableRestTag)
+	mc.Rest.Tool.ToolType = convert(carverToolTypeFromModelToolType(m.GetIntValue(RestToolTypeTag)))
+	mc.Rest.Tool.ToolDiameter = float64(m.GetFloat32Value(RestToolDiameterTag))
+	mc.Rest.Tool.HorizFeedRate = float64(m.GetFloat32Value(RestHorizFeedRateTag))
+	mc.Rest.Tool.VertFeedRate = float64(m.GetFloat32Value(RestVertFeedRateTag))
+	mc.Rest.Tool.MaxStepDown = float64(m.GetFloat32Value(RestMaxStepDownTag))
+	restStepOver := float64(m.GetFloat32Value(RestStepOverTag)) * 0.01
+	mc.Rest.StepOverFraction = math.Max(0.05, math.Min(1.0, restStepOver))
+
+	mc.Carving.FinishStepFraction =
+		float64(m.GetFloat32Value(FinishPassReductionTag)) * 0.01 * stepOverFraction
+	mc.Carving.EnableFinishing = m.GetBoolValue(UseFinishPassTag)
+	mc.Carving.FinishMode =
+		convert(carverFinishModeFromModelFinishMode(m.GetIntValue(FinishPassModeTag)))
+	mc.Carving.FinishHorizFeedRate = float64(m.GetFloat32Value(FinishPassHorizFeedRateTag))
+
+	mc.Contour.Enable = m.GetBoolValue(EnableContourTag)
+	mc.Contour.Tool.ToolType =
+		convert(carverToolTypeFromContourToolType(m.GetIntValue(ContourToolTypeTag)))
+	mc.Contour.Tool.ToolDiameter = float64(m.GetFloat32Value(ContourToolDiameterTag))
+	mc.Contour.Tool.CornerRadius = float64(m.GetFloat32Value(ContourToolCornerRadiusTag))
+	mc.Contour.Tool.HorizFeedRate = float64(m.GetFloat32Value(ContourHorizFeedRateTag))
+	mc.Contour.Tool.VertFeedRate = float64(m.GetFloat32Value(ContourVertFeedRateTag))
+	mc.Contour.Tool.MaxStepDown = float64(m.GetFloat32Value(ContourMaxStepDownTag))
+	mc.Contour.Outline =
+		convert(carverContourOutlineFromModelOutline(m.GetIntValue(ContourOutlineTag)))
+	mc.Contour.CornerRadius = float64(m.GetFloat32Value(ContourCornerRadiusTag))
+	mc.Contour.NumTabsPerSide = m.GetIntValue(ContourNubTabsPerSideTag)
+	mc.Contour.TabWidth = float64(m.GetFloat32Value(ContourTabWidthTag))
+	mc.Contour.TabHeight = float64(m.GetFloat32Value(ContourTabHeightTag))
+
+	// Each tool runs the spindle and the coolant its own way.
+	getSpindle := func(speedTag, dirTag, dwellTag, coolantTag string) carv.SpindleConfig {
+		return carv.SpindleConfig{
+			Speed:       float64(m.GetFloat32Value(speedTag)),
+			Direction:   convert(carverSpindleDirFromModelDir(m.GetIntValue(dirTag))),
+			SpinUpDwell: float64(m.GetFloat32Value(dwellTag)),
+			Coolant:     convert(carverCoolantFromModelCoolant(m.GetIntValue(coolantTag))),
+		}
+	}
+	mc.Roughing.Tool.Spindle = getSpindle(RoughingSpindleSpeedTag, RoughingSpindleDirTag,
+		RoughingSpinUpDwellTag, RoughingCoolantTag)
+	mc.Carving.Tool.Spindle = getSpindle(SpindleSpeedTag, SpindleDirTag, SpinUpDwellTag,
+		CoolantTag)
+	mc.Rest.Tool.Spindle = getSpindle(RestSpindleSpeedTag, RestSpindleDirTag,
+		RestSpinUpDwellTag, RestCoolantTag)
+	mc.Contour.Tool.Spindle = getSpindle(ContourSpindleSpeedTag, ContourSpindleDirTag,
+		ContourSpinUpDwellTag, ContourCoolantTag)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := m.setSamplers(mc, invertImage, useMeshSampler)
+	if err != nil {
+		return nil, err
+	}
+	if m.GetBoolValue(CheckGougesTag) {
+		job.Target = target
+	}
+
+	return job, nil
+}
+
+// GougeCheck is the result of checking the generated code for gouges.
+type GougeCheck struct {
+	Report *sim.DeviationReport
+
+	// False-colour image of the deviations. Colours saturate at twice the gouge tolerance, so
+	// that gouges within the tolerance show.
+	DeviationImage image.Image
+
+	// Non-nil when the tool cut below the target surface by more than the gouge tolerance.
+	Err error
+}
+
+// CheckGouges simulates the code generated for the job and compares the carved surface to the
+// target surface. Return an error if the code can't be simulated.
+func (m *Model) CheckGouges(job *Job, code io.Reader) (*GougeCheck, error) {
+	if job.Target == nil {
+		return nil, fmt.Errorf("the job has no target surface")
+	}
+
+	mc := &job.Config
+	config := sim.Config{
+		MaterialDim:       mc.Material.MaterialDim,
+		MaterialThickness: mc.Material.MaterialThickness,
+		Resolution:        math.Max(0.05, float64(m.GetFloat32Value(StockResolutionTag))),
+		Origin:            carv.GetWorkOrigin(mc),
+		Cutters:           carv.GetToolCutters(mc),
+	}
+
+	heightMap, err := sim.Simulate(code, config)
+	if err != nil {
+		return nil, err
+	}
+
+	report := sim.CompareToMesh(heightMap, config, job.Target)
+	tolerance := float64(m.GetFloat32Value(GougeToleranceTag))
+	return &GougeCheck{
+		Report:         report,
+		DeviationImage: report.DeviationImage(math.Max(0.01, 2*tolerance)),
+		Err:            report.CheckGouges(tolerance),
+	}, nil
+}
+
+// GetToolFilename returns the name of the file for the n-th tool change, starting at 0, when
+// generating one file per tool. The files are named after the given file, with the index of the
+// tool change and the tool number, e.g. job-1-T1.nc, job-2-T2.nc.
+func GetToolFilename(filename string, n int, tool carv.ToolConfig) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s-%d-T%d%s", strings.TrimSuffix(filename, ext), n+1, tool.ToolNumber, ext)
+}
+
+// DoMachiningWithOneFilePerTool generates the code for the machining config with one file per
+// tool change, named with GetToolFilename after the given file. The writer of each file is
+// passed through tee, e.g. to keep a copy of the code. Return the first error creating, writing
+// or closing the files.
+func DoMachiningWithOneFilePerTool(
+	mc *carv.MachiningConfig, filename string, tee func(io.Writer) io.Writer) error {
+
+	var files []*os.File
+	var createErr error
+	err := carv.DoMachiningWithOneFilePerTool(mc, func(n int, tool carv.ToolConfig) io.Writer {
+		f, e := os.Create(GetToolFilename(filename, n, tool))
+		if e != nil {
+			if createErr == nil {
+				createErr = e
+			}
+			return io.Discard
+		}
+		files = append(files, f)
+		return tee(f)
+	})
+	if createErr != nil {
+		err = createErr
+	}
+
+	for _, f := range files {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// GetDeviationImageFilename returns the name of the deviation image saved next to the code in
+// the given file, e.g. job-deviation.png for job.nc.
+func GetDeviationImageFilename(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + "-deviation.png"
+}
+
+// Set up the carving, roughing and rest-machining samplers of the machining config. The
+// height-map samplers are built on a triangle mesh to account for the shape of the tools,
+// unless the mesh sampler is disabled. Roughing, rest machining and a scallop height always
+// require the mesh. Return the mesh of the target surface if it was built or if the model asks
+// for checking gouges, nil otherwise. Return an error if the mesh or a mesh sampler can't be
+// built, e.g. because the carving area is empty or a tool is invalid.
+func (m *Model) setSamplers(
+	mc *carv.MachiningConfig, invertImage bool, useMeshSampler bool) (*mesh.TriangleMesh, error) {
+
+	carvOrigin := mc.Material.CarvingAreaOrigin
+	carvDim := mc.Material.CarvingAreaDim
+	sampler := m.getHeightMapSampler(mc.Material.MaterialDim, carvDim, carvOrigin, invertImage)
+	mc.Carving.Sampler = sampler
+
+	needMesh := m.GetBoolValue(CheckGougesTag)
+	// The scallop height needs the slopes of the mesh sampler.
+	useMeshSampler = useMeshSampler || mc.Carving.ScallopHeight > 0
+	if !useMeshSampler && !mc.Roughing.Enable && !mc.Rest.Enable && !needMesh {
+		return nil, nil
+	}
+
+	tmesh, err := mesh.NewTriangleMesh(
+		carvOrigin, carvOrigin.Add(geom.NewVec2(carvDim.W, carvDim.H)),
+		mc.Carving.CarvingBottomZ, mc.Carving.CarvingTopZ, sampler)
+	if err != nil {
+		return nil, err
+	}
+	if useMeshSampler || mc.Rest.Enable {
+		// Rest machining compares the drop-cutter heights of the carving and rest-machining
+		// tools, so the carving sampler must account for the carving tool too.
+		if mc.Carving.Sampler, err = getMeshSamplerForTool(tmesh, mc.Carving.Tool); err != nil {
+			return nil, err
+		}
+	}
+
+	// The rougher slices the mesh at each level.
+	mc.Roughing.Target = tmesh
+
+	if mc.Rest.Enable {
+		if mc.Rest.Sampler, err = getMeshSamplerForTool(tmesh, mc.Rest.Tool); err != nil {
+			return nil, err
+		}
+	}
+
+	return tmesh, nil
+}
+
+// Return a sampler of the current model height map over the carving area.
+func (m *Model) getHeightMapSampler(
+	matDim, carvDim geom.Size2,
+	carvOrigin geom.Pt2,
+	invertImage bool) hmap.ScalarGridSampler {
+
+	imgGray := m.getHeightMapImageForSampler()
+	imgMode := m.GetIntValue(ImgFillModeTag)
+
+	xform := geom.NewXformCache(
+		float32(matDim.W), float32(matDim.H),
+		float32(carvDim.W), float32(carvDim.H),
+		float32(carvOrigin.X), float32(carvOrigin.Y),
+		imgGray.Bounds().Dx(), imgGray.Bounds().Dy(), imgMode)
+	sampler := hmap.NewPixelDepthSampler(xform.GetMc2NicXform(), carvOrigin, carvDim, imgGray)
+	sampler.EnableInvertImage(invertImage)
+	return sampler
+}
+
+// Return a mesh sampler that accounts for the shape of the given tool.
+func getMeshSamplerForTool(
+	tmesh *mesh.TriangleMesh, tool carv.ToolConfig) (hmap.ScalarGridSampler, error) {
+
+	switch tool.ToolType {
+	case carv.ToolTypeFlat:
+		return mesh.NewMeshSamplerWithFlatCutter(tmesh, tool.ToolDiameter)
+	case carv.ToolTypeVBit:
+		return mesh.NewMeshSamplerWithVBitCutter(tmesh, tool.ToolDiameter, tool.ToolAngle)
+	case carv.ToolTypeTaperedBall:
+		return mesh.NewMeshSamplerWithTaperedBallCutter(
+			tmesh, tool.ToolDiameter, tool.ToolAngle, tool.TipRadius)
+	case carv.ToolTypeBullNose:
+		return mesh.NewMeshSamplerWithBullNoseCutter(tmesh, tool.ToolDiameter, tool.CornerRadius)
+	default:
+		return mesh.NewMeshSamplerWithBallCutter(tmesh, tool.ToolDiameter)
+	}
+}
+
+// Return a gray-scale image for the current model height map, mirroring along X and Y
+// as needed.
+func (m *Model) getHeightMapImageForSampler() *image.Gray {
+	heightMap := m.GetHeightMap()
+	mirrorX := m.GetBoolValue(ImgMirrorXTag)
+	mirrorY := m.GetBoolValue(ImgMirrorYTag)
+	if mirrorX && mirrorY {
+		heightMap = imaging.Rotate180(heightMap)
+	} else if mirrorX {
+		heightMap = imaging.FlipH(heightMap)
+	} else if mirrorY {
+		heightMap = imaging.FlipV(heightMap)
+	}
+
+	return util.ImageToGrayImage(heightMap)
+}
+
+func carverToolTypeFromModelToolType(modelToolType int) (int, error) {
+	switch modelToolType {
+	case ToolTypeBallNose:
+		return carv.ToolTypeBallPoint, nil
+	case ToolTypeStraight:
+		return carv.ToolTypeFlat, nil
+	case ToolTypeVBit:
+		return carv.ToolTypeVBit, nil
+	case ToolTypeTaperedBallNose:
+		return carv.ToolTypeTaperedBall, nil
+	case ToolTypeBullNose:
+		return carv.ToolTypeBullNose, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model tool type %d",
+			carv.ErrInvalidTool, modelToolType)
+	}
+}
+
+func carverModeFromModelCarvingMode(modelCarvingMode int) (int, error) {
+	switch modelCarvingMode {
+	case CarvingModeAlongX:
+		return carv.CarveModeXOnly, nil
+	case CarvingModeAlongY:
+		return carv.CarveModeYOnly, nil
+	case CarvingModeAlongXThenY:
+		return carv.CarveModeXThenY, nil
+	case CarvingModeAtAngle:
+		return carv.CarveModeAtAngle, nil
+	case CarvingModeSpiral:
+		return carv.CarveModeSpiral, nil
+	case CarvingModeConcentric:
+		return carv.CarveModeConcentric, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model carving mode %d",
+			carv.ErrUnsupportedMode, modelCarvingMode)
+	}
+}
+
+func carverFinishModeFromModelFinishMode(modelFinishMode int) (int, error) {
+	switch modelFinishMode {
+	case FinishModeFirstDirectionOnly:
+		return carv.FinishPassModeAlongFirstDirOnly, nil
+	case FinishModeLastDirectionOnly:
+		return carv.FinishPassModeAlongLastDirOnly, nil
+	case FinishModeInAllDirections:
+		return carv.FinishPassModeAlongAllDirs, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model finish-pass mode %d",
+			carv.ErrUnsupportedMode, modelFinishMode)
+	}
+}
+
+func carverRasterDirectionFromModelDirection(modelRasterDirection int) (int, error) {
+	switch modelRasterDirection {
+	case RasterDirectionBackAndForth:
+		return carv.RasterBackAndForth, nil
+	case RasterDirectionClimb:
+		return carv.RasterClimbOnly, nil
+	case RasterDirectionConventional:
+		return carv.RasterConventionalOnly, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model raster direction %d",
+			carv.ErrUnsupportedMode, modelRasterDirection)
+	}
+}
+
+// The carving post-processor names, indexed by the model post-processor choices.
+var postProcessorNames = []string{
+	PostProcessorGrbl:         "grbl",
+	PostProcessorLinuxCNC:     "linuxcnc",
+	PostProcessorMach:         "mach",
+	PostProcessorMarlin:       "marlin",
+	PostProcessorSmoothieware: "smoothieware",
+}
+
+// GetPostProcessorChoice returns the model post-processor choice for the name of a carving
+// post-processor, ignoring case. Return an error wrapping carving.ErrUnsupportedMode if the
+// model has no such choice.
+func GetPostProcessorChoice(name string) (int, error) {
+	for choice, n := range postProcessorNames {
+		if strings.EqualFold(n, name) {
+			return choice, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown post-processor %q, expected one of %s",
+		carv.ErrUnsupportedMode, name, strings.Join(postProcessorNames, ", "))
+}
+
+func carverToolChangeModeFromModelMode(modelToolChangeMode int) (int, error) {
+	switch modelToolChangeMode {
+	case ToolChangeModeM6:
+		return carv.ToolChangeWithM6, nil
+	case ToolChangeModePause:
+		return carv.ToolChangeWithPause, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model tool-change mode %d",
+			carv.ErrUnsupportedMode, modelToolChangeMode)
+	}
+}
+
+func carverEntryModeFromModelMode(modelEntryMode int) (int, error) {
+	switch modelEntryMode {
+	case EntryModePlunge:
+		return carv.EntryPlunge, nil
+	case EntryModeRamp:
+		return carv.EntryRamp, nil
+	case EntryModeHelix:
+		return carv.EntryHelix, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model entry mode %d",
+			carv.ErrUnsupportedMode, modelEntryMode)
+	}
+}
+
+func carverUnitsFromModelUnits(modelUnits int) (int, error) {
+	switch modelUnits {
+	case OutputUnitsMillimeters:
+		return carv.UnitsMillimeters, nil
+	case OutputUnitsInches:
+		return carv.UnitsInches, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model output units %d",
+			carv.ErrUnsupportedMode, modelUnits)
+	}
+}
+
+func carverRetractModeFromModelMode(modelRetractMode int) (int, error) {
+	switch modelRetractMode {
+	case RetractModeToHeight:
+		return carv.RetractToHeight, nil
+	case RetractModeMinimum:
+		return carv.RetractMinimum, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model retract mode %d",
+			carv.ErrUnsupportedMode, modelRetractMode)
+	}
+}
+
+func carverOriginXYFromModelOrigin(modelOriginXY int) (int, error) {
+	switch modelOriginXY {
+	case OriginXYBottomLeft:
+		return carv.OriginBottomLeft, nil
+	case OriginXYBottomRight:
+		return carv.OriginBottomRight, nil
+	case OriginXYTopLeft:
+		return carv.OriginTopLeft, nil
+	case OriginXYTopRight:
+		return carv.OriginTopRight, nil
+	case OriginXYCenter:
+		return carv.OriginCenter, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model XY origin %d",
+			carv.ErrUnsupportedMode, modelOriginXY)
+	}
+}
+
+func carverOriginOfFromModelOriginOf(modelOriginOf int) (int, error) {
+	switch modelOriginOf {
+	case OriginOfMaterial:
+		return carv.OriginOfMaterial, nil
+	case OriginOfCarvingArea:
+		return carv.OriginOfCarvingArea, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model XY origin of %d",
+			carv.ErrUnsupportedMode, modelOriginOf)
+	}
+}
+
+func carverOriginZFromModelOrigin(modelOriginZ int) (int, error) {
+	switch modelOriginZ {
+	case OriginZMaterialTop:
+		return carv.ZOriginStockTop, nil
+	case OriginZMachineBed:
+		return carv.ZOriginBed, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model Z origin %d",
+			carv.ErrUnsupportedMode, modelOriginZ)
+	}
+}
+
+func carverSpindleDirFromModelDir(modelSpindleDir int) (int, error) {
+	switch modelSpindleDir {
+	case SpindleDirClockwise:
+		return carv.SpindleClockwise, nil
+	case SpindleDirCounterclockwise:
+		return carv.SpindleCounterclockwise, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model spindle direction %d",
+			carv.ErrUnsupportedMode, modelSpindleDir)
+	}
+}
+
+func carverCoolantFromModelCoolant(modelCoolant int) (int, error) {
+	switch modelCoolant {
+	case CoolantModeOff:
+		return carv.CoolantOff, nil
+	case CoolantModeMist:
+		return carv.CoolantMist, nil
+	case CoolantModeFlood:
+		return carv.CoolantFlood, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model coolant mode %d",
+			carv.ErrUnsupportedMode, modelCoolant)
+	}
+}
+
+// The contour can only be cut with a straight or a bull-nose tool.
+func carverToolTypeFromContourToolType(contourToolType int) (int, error) {
+	switch contourToolType {
+	case ContourToolTypeStraight:
+		return carv.ToolTypeFlat, nil
+	case ContourToolTypeBullNose:
+		return carv.ToolTypeBullNose, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown contour tool type %d",
+			carv.ErrInvalidTool, contourToolType)
+	}
+}
+
+func carverContourOutlineFromModelOutline(modelOutline int) (int, error) {
+	switch modelOutline {
+	case ContourOutlineCarvingArea:
+		return carv.ContourAroundCarvingArea, nil
+	case ContourOutlineMaterial:
+		return carv.ContourAroundMaterial, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown model contour outline %d",
+			carv.ErrUnsupportedMode, modelOutline)
+	}
+}
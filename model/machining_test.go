@@ -0,0 +1,133 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	carv "alvin.com/GoCarver/carving"
+)
+
+func TestNewJob(t *testing.T) {
+	m := NewModel()
+	if _, err := m.NewJob(false); err == nil {
+		t.Errorf("New job: expected an error without a height map\n")
+	}
+
+	m.SetHeightMap(image.NewGray(image.Rect(0, 0, 40, 20)), "test.png")
+	for _, set := range []struct{ tag, value string }{
+		{MatWidthTag, "40"},
+		{MatHeightTag, "20"},
+		{CarvWidthTag, "40"},
+		{CarvHeightTag, "20"},
+		{MaxStepDownTag, "0.5"},
+		{StepOverTag, "25"},
+		{OutputUnitsTag, "1"},
+		{EnableContourTag, "true"},
+		{ContourToolTypeTag, "1"},
+		{ContourToolCornerRadiusTag, "0.5"},
+		{ContourSpindleSpeedTag, "12000"},
+		{RoughingCoolantTag, "1"},
+	} {
+		if err := m.SetValueFromString(set.tag, set.value); err != nil {
+			t.Fatalf("New job: unexpected error setting %s: %v\n", set.tag, err)
+		}
+	}
+
+	job, err := m.NewJob(false)
+	if err != nil {
+		t.Fatalf("New job: unexpected error: %v\n", err)
+	}
+	mc := &job.Config
+	if mc.Material.MaterialDim.W != 40 || mc.Material.CarvingAreaDim.H != 20 {
+		t.Errorf("New job: unexpected material %v\n", mc.Material)
+	}
+	if mc.Carving.Tool.MaxStepDown != 0.5 || mc.Carving.StepOverFraction != 0.25 {
+		t.Errorf("New job: unexpected carving tool %v, step over %f\n",
+			mc.Carving.Tool, mc.Carving.StepOverFraction)
+	}
+	if mc.Machine.Units != carv.UnitsInches || !mc.Contour.Enable {
+		t.Errorf("New job: expected a contour in inches\n")
+	}
+	if mc.Contour.Tool.ToolType != carv.ToolTypeBullNose || mc.Contour.Tool.CornerRadius != 0.5 {
+		t.Errorf("New job: unexpected contour tool %v\n", mc.Contour.Tool)
+	}
+	if mc.Contour.Tool.Spindle.Speed != 12000 || mc.Carving.Tool.Spindle.Speed != 18000 {
+		t.Errorf("New job: unexpected spindle speeds %v, %v\n",
+			mc.Contour.Tool.Spindle, mc.Carving.Tool.Spindle)
+	}
+	if mc.Roughing.Tool.Spindle.Coolant != carv.CoolantMist ||
+		mc.Carving.Tool.Spindle.Coolant != carv.CoolantOff {
+		t.Errorf("New job: expected coolant for the roughing tool only\n")
+	}
+	if mc.Carving.Sampler == nil || job.Target != nil {
+		t.Errorf("New job: expected a sampler and no target surface\n")
+	}
+
+	var code bytes.Buffer
+	if err := carv.DoMachining(mc, &code); err != nil {
+		t.Fatalf("New job: unexpected error generating the code: %v\n", err)
+	}
+	if !bytes.Contains(code.Bytes(), []byte("G20")) {
+		t.Errorf("New job: expected code in inches\n")
+	}
+
+	// Model choices without a carving equivalent are errors.
+	for _, tag := range []string{
+		OutputUnitsTag, CarvDirectionTag, PostProcessorTag, ImgFillModeTag} {
+		m := NewModel()
+		m.SetHeightMap(image.NewGray(image.Rect(0, 0, 40, 20)), "test.png")
+		if err := m.SetIntValue(tag, 99); err != nil {
+			t.Fatalf("New job: unexpected error setting %s: %v\n", tag, err)
+		}
+		if _, err := m.NewJob(false); !errors.Is(err, carv.ErrUnsupportedMode) {
+			t.Errorf("New job: expected an unsupported %s, got %v\n", tag, err)
+		}
+	}
+}
+
+func TestDoMachiningWithOneFilePerTool(t *testing.T) {
+	m := NewModel()
+	m.SetHeightMap(image.NewGray(image.Rect(0, 0, 40, 20)), "test.png")
+	if err := m.SetBoolValue(EnableContourTag, true); err != nil {
+		t.Fatalf("One file per tool: unexpected error: %v\n", err)
+	}
+	job, err := m.NewJob(false)
+	if err != nil {
+		t.Fatalf("One file per tool: unexpected error: %v\n", err)
+	}
+
+	// The carving and the contour go to their own files, and the tee sees both.
+	filename := filepath.Join(t.TempDir(), "job.nc")
+	var code bytes.Buffer
+	tee := func(w io.Writer) io.Writer { return io.MultiWriter(w, &code) }
+	if err := DoMachiningWithOneFilePerTool(&job.Config, filename, tee); err != nil {
+		t.Fatalf("One file per tool: unexpected error: %v\n", err)
+	}
+
+	size := 0
+	for n, tool := range []carv.ToolConfig{{ToolNumber: 1}, {ToolNumber: 2}} {
+		content, err := os.ReadFile(GetToolFilename(filename, n, tool))
+		if err != nil {
+			t.Fatalf("One file per tool: expected file %d: %v\n", n, err)
+		}
+		if !bytes.Contains(content, []byte(fmt.Sprintf("(T%d: ", tool.ToolNumber))) {
+			t.Errorf("One file per tool: expected tool %d in file %d\n", tool.ToolNumber, n)
+		}
+		size += len(content)
+	}
+	if code.Len() != size {
+		t.Errorf("One file per tool: expected %d bytes of code, got %d\n", size, code.Len())
+	}
+
+	// The files can't be created in a missing directory.
+	filename = filepath.Join(t.TempDir(), "missing", "job.nc")
+	if err := DoMachiningWithOneFilePerTool(&job.Config, filename, tee); err == nil {
+		t.Errorf("One file per tool: expected an error for a missing directory\n")
+	}
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"errors"
+	"fmt"
 	"image"
 	"log"
 
@@ -22,11 +24,24 @@ type material struct {
 type carving struct {
 	ToolDiameter       float32 `json:"tool_diameter"`
 	ToolType           int     `json:"tool_type"`
+	ToolAngle          float32 `json:"tool_angle"`
+	ToolTipRadius      float32 `json:"tool_tip_radius"`
+	ToolCornerRadius   float32 `json:"tool_corner_radius"`
 	StepOverPercent    float32 `json:"step_over_percent"`
+	ScallopHeight      float32 `json:"scallop_height"`
 	MaxStepDownSize    float32 `json:"max_step_down_size"`
 	HorizontalFeedRate float32 `json:"horizontal_feed_rate"`
 	VerticalFeedRate   float32 `json:"vertical_feed_rate"`
 	CarvingMode        int     `json:"carving_mode"`
+	RasterAngle        float32 `json:"raster_angle"`
+	RasterDirection    int     `json:"raster_direction"`
+	LoopCornerRadius   float32 `json:"loop_corner_radius"`
+	StayDownLinking    bool    `json:"stay_down_linking"`
+	SkipAirCuts        bool    `json:"skip_air_cuts"`
+	SpindleSpeed       float32 `json:"spindle_speed"`
+	SpindleDir         int     `json:"spindle_direction"`
+	SpinUpDwell        float32 `json:"spin_up_dwell"`
+	Coolant            int     `json:"coolant"`
 
 	EnableFinishPass           bool    `json:"enable_finish_pass"`
 	FinishPassReductionPercent float32 `json:"finish_step_reduction_percent"`
@@ -46,39 +61,165 @@ type contourMachining struct {
 	Enable             bool    `json:"enable_contour_machining"`
 	ToolType           int     `json:"contour_tool_type"`
 	ToolDiameter       float32 `json:"contour_tool_diameter"`
+	ToolCornerRadius   float32 `json:"contour_tool_corner_radius"`
 	MaxStepDownSize    float32 `json:"contour_max_step_down_size"`
 	HorizontalFeedRate float32 `json:"contour_horizontal_feed_rate"`
 	VerticalFeedRate   float32 `json:"contour_vertical_feed_rate"`
+	Outline            int     `json:"contour_outline"`
 	CornerRadius       float32 `json:"contour_corner_radius"`
 	NumTabsPerSize     int     `json:"contour_num_tabs_per_side"`
 	TabWidth           float32 `json:"contour_tab_width"`
 	TabHeight          float32 `json:"contour_tab_height"`
+	SpindleSpeed       float32 `json:"contour_spindle_speed"`
+	SpindleDir         int     `json:"contour_spindle_direction"`
+	SpinUpDwell        float32 `json:"contour_spin_up_dwell"`
+	Coolant            int     `json:"contour_coolant"`
+}
+
+type roughing struct {
+	Enable             bool    `json:"enable_roughing"`
+	ToolDiameter       float32 `json:"roughing_tool_diameter"`
+	StepOverPercent    float32 `json:"roughing_step_over_percent"`
+	MaxStepDownSize    float32 `json:"roughing_max_step_down_size"`
+	HorizontalFeedRate float32 `json:"roughing_horizontal_feed_rate"`
+	VerticalFeedRate   float32 `json:"roughing_vertical_feed_rate"`
+	StockToLeave       float32 `json:"roughing_stock_to_leave"`
+	SpindleSpeed       float32 `json:"roughing_spindle_speed"`
+	SpindleDir         int     `json:"roughing_spindle_direction"`
+	SpinUpDwell        float32 `json:"roughing_spin_up_dwell"`
+	Coolant            int     `json:"roughing_coolant"`
+}
+
+type restMachining struct {
+	Enable             bool    `json:"enable_rest_machining"`
+	ToolType           int     `json:"rest_tool_type"`
+	ToolDiameter       float32 `json:"rest_tool_diameter"`
+	StepOverPercent    float32 `json:"rest_step_over_percent"`
+	MaxStepDownSize    float32 `json:"rest_max_step_down_size"`
+	HorizontalFeedRate float32 `json:"rest_horizontal_feed_rate"`
+	VerticalFeedRate   float32 `json:"rest_vertical_feed_rate"`
+	SpindleSpeed       float32 `json:"rest_spindle_speed"`
+	SpindleDir         int     `json:"rest_spindle_direction"`
+	SpinUpDwell        float32 `json:"rest_spin_up_dwell"`
+	Coolant            int     `json:"rest_coolant"`
+}
+
+type machine struct {
+	ToolChangeMode  int     `json:"tool_change_mode"`
+	ParkX           float32 `json:"park_x"`
+	ParkY           float32 `json:"park_y"`
+	ParkZ           float32 `json:"park_z"`
+	OneFilePerTool  bool    `json:"one_file_per_tool"`
+	FitArcs         bool    `json:"fit_arcs"`
+	PostProcessor   int     `json:"post_processor"`
+	OutputUnits     int     `json:"output_units"`
+	RetractMode     int     `json:"retract_mode"`
+	SafeHeight      float32 `json:"safe_height"`
+	ClearanceHeight float32 `json:"clearance_height"`
+	RetractHeight   float32 `json:"retract_height"`
+	RapidThreshold  float32 `json:"rapid_threshold"`
+	OriginXY        int     `json:"origin_xy"`
+	OriginOf        int     `json:"origin_of"`
+	OriginZ         int     `json:"origin_z"`
+	WorkOffset      int     `json:"work_offset"`
+	EntryMode       int     `json:"entry_mode"`
+	MaxRampAngle    float32 `json:"max_ramp_angle"`
+	HelixRadius     float32 `json:"helix_radius"`
+	TrackStock      bool    `json:"track_stock"`
+	StockResolution float32 `json:"stock_resolution"`
+	CheckGouges     bool    `json:"check_gouges"`
+	GougeTolerance  float32 `json:"gouge_tolerance"`
 }
 
 type modelRoot struct {
+	Machine   machine          `json:"machine"`
 	Material  material         `json:"material"`
+	Roughing  roughing         `json:"roughing"`
 	Carving   carving          `json:"carving"`
+	Rest      restMachining    `json:"rest_machining"`
 	HeightMap heightMap        `json:"height_map"`
 	Contour   contourMachining `json:"contour_machining"`
 }
 
 const (
-	ToolTypeBallNose = 0
-	ToolTypeStraight = 1
+	ToolTypeBallNose        = 0
+	ToolTypeStraight        = 1
+	ToolTypeVBit            = 2
+	ToolTypeTaperedBallNose = 3
+	ToolTypeBullNose        = 4
 
 	CarvingModeAlongX      = 0
 	CarvingModeAlongY      = 1
 	CarvingModeAlongXThenY = 2
+	CarvingModeAtAngle     = 3
+	CarvingModeSpiral      = 4
+	CarvingModeConcentric  = 5
+
+	RasterDirectionBackAndForth = 0
+	RasterDirectionClimb        = 1
+	RasterDirectionConventional = 2
 
 	FinishModeFirstDirectionOnly = 0
 	FinishModeLastDirectionOnly  = 1
 	FinishModeInAllDirections    = 2
 
+	ContourOutlineCarvingArea = 0
+	ContourOutlineMaterial    = 1
+
+	ContourToolTypeStraight = 0
+	ContourToolTypeBullNose = 1
+
+	ToolChangeModeM6    = 0
+	ToolChangeModePause = 1
+
+	PostProcessorGrbl         = 0
+	PostProcessorLinuxCNC     = 1
+	PostProcessorMach         = 2
+	PostProcessorMarlin       = 3
+	PostProcessorSmoothieware = 4
+
+	OutputUnitsMillimeters = 0
+	OutputUnitsInches      = 1
+
+	SpindleDirClockwise        = 0
+	SpindleDirCounterclockwise = 1
+
+	CoolantModeOff   = 0
+	CoolantModeMist  = 1
+	CoolantModeFlood = 2
+
+	RetractModeToHeight = 0
+	RetractModeMinimum  = 1
+
+	OriginXYBottomLeft  = 0
+	OriginXYBottomRight = 1
+	OriginXYTopLeft     = 2
+	OriginXYTopRight    = 3
+	OriginXYCenter      = 4
+
+	OriginOfMaterial    = 0
+	OriginOfCarvingArea = 1
+
+	OriginZMaterialTop = 0
+	OriginZMachineBed  = 1
+
+	WorkOffsetActive = 0 // Then 1 to 6 for G54 to G59.
+
+	EntryModePlunge = 0
+	EntryModeRamp   = 1
+	EntryModeHelix  = 2
+
 	ImageModeFill = geom.ImageModeFill // Stretch image to fill viewport
 	ImageModeFit  = geom.ImageModeFit  // Whole image fits in viewport, keep aspect ratio
 	ImageModeCrop = geom.ImageModeCrop // Stretch image to fill viewport, keep aspect ratio
 )
 
+// ErrUnknownTag is returned when setting a model value with a tag that the model doesn't have.
+var ErrUnknownTag = errors.New("model: unknown tag")
+
+// ErrValueOutOfRange is returned when setting a number model value outside of its valid range.
+var ErrValueOutOfRange = errors.New("model: value out of range")
+
 type Model struct {
 	root modelRoot
 
@@ -89,6 +230,32 @@ type Model struct {
 func NewModel() *Model {
 	return &Model{
 		root: modelRoot{
+			Machine: machine{
+				ToolChangeMode:  ToolChangeModePause,
+				ParkX:           0.0, // millimeters, in machine coordinates
+				ParkY:           0.0,
+				ParkZ:           -1.0,
+				FitArcs:         false,
+				PostProcessor:   PostProcessorGrbl,
+				OutputUnits:     OutputUnitsMillimeters,
+				RetractMode:     RetractModeToHeight,
+				SafeHeight:      25.0, // millimeters above the material
+				ClearanceHeight: 5.0,
+				RetractHeight:   1.0,
+				RapidThreshold:  50.0, // millimeters
+				OriginXY:        OriginXYBottomLeft,
+				OriginOf:        OriginOfMaterial,
+				OriginZ:         OriginZMaterialTop,
+				WorkOffset:      WorkOffsetActive,
+				EntryMode:       EntryModePlunge,
+				MaxRampAngle:    3.0, // degrees
+				HelixRadius:     1.0, // millimeters
+				TrackStock:      false,
+				StockResolution: 0.25, // millimeters
+				CheckGouges:     false,
+				GougeTolerance:  0.05, // millimeters
+			},
+
 			Material: material{
 				MaterialWidth:      100.0,
 				MaterialHeight:     100.0,
@@ -101,21 +268,61 @@ func NewModel() *Model {
 				CarvingAreaOffsetY: 5.0,
 			},
 
+			Roughing: roughing{
+				ToolDiameter:       6.35, // millimeters
+				StepOverPercent:    40,   // Percent of tool diameter
+				MaxStepDownSize:    2.0,
+				HorizontalFeedRate: 800.0, // millimeters per minute
+				VerticalFeedRate:   300.0, // millimeters per minutes
+				StockToLeave:       0.5,   // millimeters
+				SpindleSpeed:       18000.0,
+				SpindleDir:         SpindleDirClockwise,
+				SpinUpDwell:        2.0, // seconds
+				Coolant:            CoolantModeOff,
+			},
+
 			Carving: carving{
 				ToolDiameter:               3.175, // millimeters
+				ToolAngle:                  60.0,  // degrees
+				ToolTipRadius:              0.25,  // millimeters
+				ToolCornerRadius:           0.5,   // millimeters
 				StepOverPercent:            40,    // Percent of tool diameter
+				ScallopHeight:              0.0,   // millimeters, 0 to use the step over
 				MaxStepDownSize:            0.5,
 				HorizontalFeedRate:         500.0, // millimeters per minute
 				VerticalFeedRate:           300.0, // millimeters per minutes
 				CarvingMode:                CarvingModeAlongX,
+				RasterAngle:                45.0, // degrees
+				RasterDirection:            RasterDirectionBackAndForth,
+				LoopCornerRadius:           10.0, // millimeters
+				StayDownLinking:            false,
+				SkipAirCuts:                false,
+				SpindleSpeed:               18000.0, // revolutions per minute, 0 to leave the spindle alone
+				SpindleDir:                 SpindleDirClockwise,
+				SpinUpDwell:                2.0, // seconds
+				Coolant:                    CoolantModeOff,
 				EnableFinishPass:           false,
 				FinishPassReductionPercent: 50.0,
 				FinishMode:                 FinishModeFirstDirectionOnly,
 				FinishHorizFeedRate:        750.0, // millimeters per minute,
 			},
 
+			Rest: restMachining{
+				ToolType:           ToolTypeBallNose,
+				ToolDiameter:       1.0, // millimeters
+				StepOverPercent:    40,  // Percent of tool diameter
+				MaxStepDownSize:    0.5,
+				HorizontalFeedRate: 400.0, // millimeters per minute
+				VerticalFeedRate:   200.0, // millimeters per minutes
+				SpindleSpeed:       18000.0,
+				SpindleDir:         SpindleDirClockwise,
+				SpinUpDwell:        2.0, // seconds
+				Coolant:            CoolantModeOff,
+			},
+
 			Contour: contourMachining{
 				ToolDiameter:       3.175, // millimeters
+				ToolCornerRadius:   0.5,   // millimeters
 				MaxStepDownSize:    0.5,
 				HorizontalFeedRate: 500.0, // millimeters per minute
 				VerticalFeedRate:   300.0, // millimeters per minutes
@@ -123,6 +330,10 @@ func NewModel() *Model {
 				NumTabsPerSize:     2,
 				TabWidth:           4.0, // millimeters
 				TabHeight:          0.5, // millimeters
+				SpindleSpeed:       18000.0,
+				SpindleDir:         SpindleDirClockwise,
+				SpinUpDwell:        2.0, // seconds
+				Coolant:            CoolantModeOff,
 			},
 		},
 	}
@@ -132,6 +343,38 @@ func (m *Model) SetDirty(dirty bool) {
 	m.dirty = dirty
 }
 
+func (m *Model) IsDirty() bool {
+	return m.dirty
+}
+
+// GetFilePath returns the path of the file the model was last read from or written to, or an
+// empty string for a new model.
+func (m *Model) GetFilePath() string {
+	return m.fromFilePath
+}
+
+// ReadFromFile replaces the model with the one in the given carver file.
+func (m *Model) ReadFromFile(filename string) error {
+	if err := newModelIO(m).readFromFile(filename); err != nil {
+		return err
+	}
+
+	m.fromFilePath = filename
+	m.dirty = false
+	return nil
+}
+
+// WriteToFile writes the model to the given carver file.
+func (m *Model) WriteToFile(filename string) error {
+	if err := newModelIO(m).writeToFile(filename); err != nil {
+		return err
+	}
+
+	m.fromFilePath = filename
+	m.dirty = false
+	return nil
+}
+
 func (m *Model) GetFloat32Value(tag string) float32 {
 	switch tag {
 	case MatWidthTag:
@@ -154,14 +397,26 @@ func (m *Model) GetFloat32Value(tag string) float32 {
 		return m.root.Material.WhiteCarvingDepth
 	case ToolDiamTag:
 		return m.root.Carving.ToolDiameter
+	case ToolAngleTag:
+		return m.root.Carving.ToolAngle
+	case ToolTipRadiusTag:
+		return m.root.Carving.ToolTipRadius
+	case ToolCornerRadiusTag:
+		return m.root.Carving.ToolCornerRadius
 	case StepOverTag:
 		return m.root.Carving.StepOverPercent
+	case ScallopHeightTag:
+		return m.root.Carving.ScallopHeight
 	case MaxStepDownTag:
 		return m.root.Carving.MaxStepDownSize
 	case HorizFeedRateTag:
 		return m.root.Carving.HorizontalFeedRate
 	case VertFeedRateTag:
 		return m.root.Carving.VerticalFeedRate
+	case RasterAngleTag:
+		return m.root.Carving.RasterAngle
+	case LoopCornerRadiusTag:
+		return m.root.Carving.LoopCornerRadius
 	case FinishPassReductionTag:
 		return m.root.Carving.FinishPassReductionPercent
 	case FinishPassHorizFeedRateTag:
@@ -174,15 +429,77 @@ func (m *Model) GetFloat32Value(tag string) float32 {
 		return m.root.Contour.VerticalFeedRate
 	case ContourToolDiameterTag:
 		return m.root.Contour.ToolDiameter
+	case ContourToolCornerRadiusTag:
+		return m.root.Contour.ToolCornerRadius
 	case ContourTabWidthTag:
 		return m.root.Contour.TabWidth
 	case ContourTabHeightTag:
 		return m.root.Contour.TabHeight
 	case ContourMaxStepDownTag:
 		return m.root.Contour.MaxStepDownSize
+	case RoughingToolDiameterTag:
+		return m.root.Roughing.ToolDiameter
+	case RoughingStepOverTag:
+		return m.root.Roughing.StepOverPercent
+	case RoughingMaxStepDownTag:
+		return m.root.Roughing.MaxStepDownSize
+	case RoughingHorizFeedRateTag:
+		return m.root.Roughing.HorizontalFeedRate
+	case RoughingVertFeedRateTag:
+		return m.root.Roughing.VerticalFeedRate
+	case RoughingStockToLeaveTag:
+		return m.root.Roughing.StockToLeave
+	case RestToolDiameterTag:
+		return m.root.Rest.ToolDiameter
+	case RestStepOverTag:
+		return m.root.Rest.StepOverPercent
+	case RestMaxStepDownTag:
+		return m.root.Rest.MaxStepDownSize
+	case RestHorizFeedRateTag:
+		return m.root.Rest.HorizontalFeedRate
+	case RestVertFeedRateTag:
+		return m.root.Rest.VerticalFeedRate
+	case ParkXTag:
+		return m.root.Machine.ParkX
+	case MaxRampAngleTag:
+		return m.root.Machine.MaxRampAngle
+	case HelixRadiusTag:
+		return m.root.Machine.HelixRadius
+	case SpindleSpeedTag:
+		return m.root.Carving.SpindleSpeed
+	case SpinUpDwellTag:
+		return m.root.Carving.SpinUpDwell
+	case RoughingSpindleSpeedTag:
+		return m.root.Roughing.SpindleSpeed
+	case RoughingSpinUpDwellTag:
+		return m.root.Roughing.SpinUpDwell
+	case RestSpindleSpeedTag:
+		return m.root.Rest.SpindleSpeed
+	case RestSpinUpDwellTag:
+		return m.root.Rest.SpinUpDwell
+	case ContourSpindleSpeedTag:
+		return m.root.Contour.SpindleSpeed
+	case ContourSpinUpDwellTag:
+		return m.root.Contour.SpinUpDwell
+	case SafeHeightTag:
+		return m.root.Machine.SafeHeight
+	case ClearanceHeightTag:
+		return m.root.Machine.ClearanceHeight
+	case RetractHeightTag:
+		return m.root.Machine.RetractHeight
+	case RapidThresholdTag:
+		return m.root.Machine.RapidThreshold
+	case StockResolutionTag:
+		return m.root.Machine.StockResolution
+	case GougeToleranceTag:
+		return m.root.Machine.GougeTolerance
+	case ParkYTag:
+		return m.root.Machine.ParkY
+	case ParkZTag:
+		return m.root.Machine.ParkZ
 	}
 
-	log.Fatalf("Model: GetFloat32: Invalid tag = %s", tag)
+	log.Printf("Model: GetFloat32: Invalid tag = %s", tag)
 	return 0
 }
 
@@ -190,6 +507,8 @@ func (m *Model) GetIntValue(tag string) int {
 	switch tag {
 	case CarvDirectionTag:
 		return m.root.Carving.CarvingMode
+	case RasterDirectionTag:
+		return m.root.Carving.RasterDirection
 	case ImgFillModeTag:
 		return m.root.HeightMap.ImageMode
 	case ToolTypeTag:
@@ -200,9 +519,47 @@ func (m *Model) GetIntValue(tag string) int {
 		return m.root.Contour.ToolType
 	case ContourNubTabsPerSideTag:
 		return m.root.Contour.NumTabsPerSize
+	case ContourOutlineTag:
+		return m.root.Contour.Outline
+	case ToolChangeModeTag:
+		return m.root.Machine.ToolChangeMode
+	case PostProcessorTag:
+		return m.root.Machine.PostProcessor
+	case OutputUnitsTag:
+		return m.root.Machine.OutputUnits
+	case EntryModeTag:
+		return m.root.Machine.EntryMode
+	case SpindleDirTag:
+		return m.root.Carving.SpindleDir
+	case CoolantTag:
+		return m.root.Carving.Coolant
+	case RoughingSpindleDirTag:
+		return m.root.Roughing.SpindleDir
+	case RoughingCoolantTag:
+		return m.root.Roughing.Coolant
+	case RestSpindleDirTag:
+		return m.root.Rest.SpindleDir
+	case RestCoolantTag:
+		return m.root.Rest.Coolant
+	case ContourSpindleDirTag:
+		return m.root.Contour.SpindleDir
+	case ContourCoolantTag:
+		return m.root.Contour.Coolant
+	case RetractModeTag:
+		return m.root.Machine.RetractMode
+	case OriginXYTag:
+		return m.root.Machine.OriginXY
+	case OriginOfTag:
+		return m.root.Machine.OriginOf
+	case OriginZTag:
+		return m.root.Machine.OriginZ
+	case WorkOffsetTag:
+		return m.root.Machine.WorkOffset
+	case RestToolTypeTag:
+		return m.root.Rest.ToolType
 	}
 
-	log.Fatalf("Model: GetChoice: Invalid tag = %s", tag)
+	log.Printf("Model: GetChoice: Invalid tag = %s", tag)
 	return 0
 }
 
@@ -214,11 +571,27 @@ func (m *Model) GetBoolValue(tag string) bool {
 		return m.root.HeightMap.MirrorY
 	case UseFinishPassTag:
 		return m.root.Carving.EnableFinishPass
+	case StayDownLinkingTag:
+		return m.root.Carving.StayDownLinking
+	case SkipAirCutsTag:
+		return m.root.Carving.SkipAirCuts
 	case EnableContourTag:
 		return m.root.Contour.Enable
+	case EnableRoughingTag:
+		return m.root.Roughing.Enable
+	case OneFilePerToolTag:
+		return m.root.Machine.OneFilePerTool
+	case FitArcsTag:
+		return m.root.Machine.FitArcs
+	case TrackStockTag:
+		return m.root.Machine.TrackStock
+	case CheckGougesTag:
+		return m.root.Machine.CheckGouges
+	case EnableRestTag:
+		return m.root.Rest.Enable
 	}
 
-	log.Fatalf("Model: GetBool: Invalid tag = %s", tag)
+	log.Printf("Model: GetBool: Invalid tag = %s", tag)
 	return false
 }
 
@@ -226,7 +599,22 @@ func (m *Model) GetHeightMap() image.Image {
 	return m.root.HeightMap.Image
 }
 
-func (m *Model) SetFloat32Value(tag string, val float32) {
+// SetHeightMap sets the image of the height map, loaded from the given file.
+func (m *Model) SetHeightMap(img image.Image, filename string) {
+	m.root.HeightMap.Image = img
+	m.root.HeightMap.ImageFileName = filename
+	m.dirty = true
+}
+
+// SetFloat32Value sets the number model value with the given tag. The value must be within
+// the range given by GetValueRange.
+func (m *Model) SetFloat32Value(tag string, val float32) error {
+	// Values are compared as stored, so that e.g. 0.01 is a valid step-down.
+	if min, max, ok := GetValueRange(tag); ok && (val < float32(min) || val > float32(max)) {
+		return fmt.Errorf("%w: %s must be between %g and %g, not %g",
+			ErrValueOutOfRange, tag, min, max, val)
+	}
+
 	switch tag {
 	case MatWidthTag:
 		m.root.Material.MaterialWidth = val
@@ -248,14 +636,26 @@ func (m *Model) SetFloat32Value(tag string, val float32) {
 		m.root.Material.WhiteCarvingDepth = val
 	case ToolDiamTag:
 		m.root.Carving.ToolDiameter = val
+	case ToolAngleTag:
+		m.root.Carving.ToolAngle = val
+	case ToolTipRadiusTag:
+		m.root.Carving.ToolTipRadius = val
+	case ToolCornerRadiusTag:
+		m.root.Carving.ToolCornerRadius = val
 	case StepOverTag:
 		m.root.Carving.StepOverPercent = val
+	case ScallopHeightTag:
+		m.root.Carving.ScallopHeight = val
 	case MaxStepDownTag:
 		m.root.Carving.MaxStepDownSize = val
 	case HorizFeedRateTag:
 		m.root.Carving.HorizontalFeedRate = val
 	case VertFeedRateTag:
 		m.root.Carving.VerticalFeedRate = val
+	case RasterAngleTag:
+		m.root.Carving.RasterAngle = val
+	case LoopCornerRadiusTag:
+		m.root.Carving.LoopCornerRadius = val
 	case FinishPassReductionTag:
 		m.root.Carving.FinishPassReductionPercent = val
 	case FinishPassHorizFeedRateTag:
@@ -268,21 +668,86 @@ func (m *Model) SetFloat32Value(tag string, val float32) {
 		m.root.Contour.VerticalFeedRate = val
 	case ContourToolDiameterTag:
 		m.root.Contour.ToolDiameter = val
+	case ContourToolCornerRadiusTag:
+		m.root.Contour.ToolCornerRadius = val
 	case ContourTabWidthTag:
 		m.root.Contour.TabWidth = val
 	case ContourTabHeightTag:
 		m.root.Contour.TabHeight = val
 	case ContourMaxStepDownTag:
 		m.root.Contour.MaxStepDownSize = val
+	case RoughingToolDiameterTag:
+		m.root.Roughing.ToolDiameter = val
+	case RoughingStepOverTag:
+		m.root.Roughing.StepOverPercent = val
+	case RoughingMaxStepDownTag:
+		m.root.Roughing.MaxStepDownSize = val
+	case RoughingHorizFeedRateTag:
+		m.root.Roughing.HorizontalFeedRate = val
+	case RoughingVertFeedRateTag:
+		m.root.Roughing.VerticalFeedRate = val
+	case RoughingStockToLeaveTag:
+		m.root.Roughing.StockToLeave = val
+	case RestToolDiameterTag:
+		m.root.Rest.ToolDiameter = val
+	case RestStepOverTag:
+		m.root.Rest.StepOverPercent = val
+	case RestMaxStepDownTag:
+		m.root.Rest.MaxStepDownSize = val
+	case RestHorizFeedRateTag:
+		m.root.Rest.HorizontalFeedRate = val
+	case RestVertFeedRateTag:
+		m.root.Rest.VerticalFeedRate = val
+	case ParkXTag:
+		m.root.Machine.ParkX = val
+	case MaxRampAngleTag:
+		m.root.Machine.MaxRampAngle = val
+	case HelixRadiusTag:
+		m.root.Machine.HelixRadius = val
+	case SpindleSpeedTag:
+		m.root.Carving.SpindleSpeed = val
+	case SpinUpDwellTag:
+		m.root.Carving.SpinUpDwell = val
+	case RoughingSpindleSpeedTag:
+		m.root.Roughing.SpindleSpeed = val
+	case RoughingSpinUpDwellTag:
+		m.root.Roughing.SpinUpDwell = val
+	case RestSpindleSpeedTag:
+		m.root.Rest.SpindleSpeed = val
+	case RestSpinUpDwellTag:
+		m.root.Rest.SpinUpDwell = val
+	case ContourSpindleSpeedTag:
+		m.root.Contour.SpindleSpeed = val
+	case ContourSpinUpDwellTag:
+		m.root.Contour.SpinUpDwell = val
+	case SafeHeightTag:
+		m.root.Machine.SafeHeight = val
+	case ClearanceHeightTag:
+		m.root.Machine.ClearanceHeight = val
+	case RetractHeightTag:
+		m.root.Machine.RetractHeight = val
+	case RapidThresholdTag:
+		m.root.Machine.RapidThreshold = val
+	case StockResolutionTag:
+		m.root.Machine.StockResolution = val
+	case GougeToleranceTag:
+		m.root.Machine.GougeTolerance = val
+	case ParkYTag:
+		m.root.Machine.ParkY = val
+	case ParkZTag:
+		m.root.Machine.ParkZ = val
 	default:
-		log.Fatalf("Model: SetFloat32: Invalid tag = %s", tag)
+		return fmt.Errorf("%w: %s is not a number", ErrUnknownTag, tag)
 	}
+	return nil
 }
 
-func (m *Model) SetIntValue(tag string, val int) {
+func (m *Model) SetIntValue(tag string, val int) error {
 	switch tag {
 	case CarvDirectionTag:
 		m.root.Carving.CarvingMode = val
+	case RasterDirectionTag:
+		m.root.Carving.RasterDirection = val
 	case ImgFillModeTag:
 		m.root.HeightMap.ImageMode = val
 	case ToolTypeTag:
@@ -293,12 +758,51 @@ func (m *Model) SetIntValue(tag string, val int) {
 		m.root.Contour.ToolType = val
 	case ContourNubTabsPerSideTag:
 		m.root.Contour.NumTabsPerSize = val
+	case ContourOutlineTag:
+		m.root.Contour.Outline = val
+	case ToolChangeModeTag:
+		m.root.Machine.ToolChangeMode = val
+	case PostProcessorTag:
+		m.root.Machine.PostProcessor = val
+	case OutputUnitsTag:
+		m.root.Machine.OutputUnits = val
+	case EntryModeTag:
+		m.root.Machine.EntryMode = val
+	case SpindleDirTag:
+		m.root.Carving.SpindleDir = val
+	case CoolantTag:
+		m.root.Carving.Coolant = val
+	case RoughingSpindleDirTag:
+		m.root.Roughing.SpindleDir = val
+	case RoughingCoolantTag:
+		m.root.Roughing.Coolant = val
+	case RestSpindleDirTag:
+		m.root.Rest.SpindleDir = val
+	case RestCoolantTag:
+		m.root.Rest.Coolant = val
+	case ContourSpindleDirTag:
+		m.root.Contour.SpindleDir = val
+	case ContourCoolantTag:
+		m.root.Contour.Coolant = val
+	case RetractModeTag:
+		m.root.Machine.RetractMode = val
+	case OriginXYTag:
+		m.root.Machine.OriginXY = val
+	case OriginOfTag:
+		m.root.Machine.OriginOf = val
+	case OriginZTag:
+		m.root.Machine.OriginZ = val
+	case WorkOffsetTag:
+		m.root.Machine.WorkOffset = val
+	case RestToolTypeTag:
+		m.root.Rest.ToolType = val
 	default:
-		log.Fatalf("Model: SetChoice: Invalid tag = %s", tag)
+		return fmt.Errorf("%w: %s is not a choice", ErrUnknownTag, tag)
 	}
+	return nil
 }
 
-func (m *Model) SetBoolValue(tag string, val bool) {
+func (m *Model) SetBoolValue(tag string, val bool) error {
 	switch tag {
 	case ImgMirrorXTag:
 		m.root.HeightMap.MirrorX = val
@@ -306,11 +810,28 @@ func (m *Model) SetBoolValue(tag string, val bool) {
 		m.root.HeightMap.MirrorY = val
 	case UseFinishPassTag:
 		m.root.Carving.EnableFinishPass = val
+	case StayDownLinkingTag:
+		m.root.Carving.StayDownLinking = val
+	case SkipAirCutsTag:
+		m.root.Carving.SkipAirCuts = val
 	case EnableContourTag:
 		m.root.Contour.Enable = val
+	case EnableRoughingTag:
+		m.root.Roughing.Enable = val
+	case OneFilePerToolTag:
+		m.root.Machine.OneFilePerTool = val
+	case FitArcsTag:
+		m.root.Machine.FitArcs = val
+	case TrackStockTag:
+		m.root.Machine.TrackStock = val
+	case CheckGougesTag:
+		m.root.Machine.CheckGouges = val
+	case EnableRestTag:
+		m.root.Rest.Enable = val
 	default:
-		log.Fatalf("Model: SetBool: Invalid tag = %s", tag)
+		return fmt.Errorf("%w: %s is not a boolean", ErrUnknownTag, tag)
 	}
+	return nil
 }
 
 func GetModelValueByTag[T any](m *Model, tag string) T {
@@ -325,23 +846,26 @@ func GetModelValueByTag[T any](m *Model, tag string) T {
 	case *bool:
 		*p = m.GetBoolValue(tag)
 	default:
-		log.Fatalf("GetModelValueByTag: Unsupported type: %T\n", ret)
+		log.Printf("GetModelValueByTag: Unsupported type: %T\n", ret)
 	}
 
 	return ret
 }
 
-func SetModelValueByTag[T any](m *Model, tag string, val T) {
+// SetModelValueByTag sets the model value with the given tag. Return an error wrapping
+// ErrUnknownTag if the model has no value of that type with that tag, or ErrValueOutOfRange if
+// the value is outside of its valid range.
+func SetModelValueByTag[T any](m *Model, tag string, val T) error {
 	switch p := any(&val).(type) {
 	case *int:
-		m.SetIntValue(tag, *p)
+		return m.SetIntValue(tag, *p)
 	case *float32:
-		m.SetFloat32Value(tag, *p)
+		return m.SetFloat32Value(tag, *p)
 	case *float64:
-		m.SetFloat32Value(tag, float32(*p))
+		return m.SetFloat32Value(tag, float32(*p))
 	case *bool:
-		m.SetBoolValue(tag, *p)
+		return m.SetBoolValue(tag, *p)
 	default:
-		log.Fatalf("SetModelValueByTag: Unsupported type: %T\n", val)
+		return fmt.Errorf("SetModelValueByTag: unsupported type %T", val)
 	}
 }
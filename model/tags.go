@@ -0,0 +1,372 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Tags of the model values. The UI items and the command-line options that edit a model value
+// are named after its tag.
+const (
+	MatWidthTag       = "mat_width"
+	MatHeightTag      = "mat_height"
+	MatThicknessTag   = "mat_tick"
+	CarvWidthTag      = "carv_width"
+	CarvHeightTag     = "carv_height"
+	CarvOffsetXTag    = "carv_offset_X"
+	CarvOffsetYTag    = "carv_offset_Y"
+	CarvBlackDepthTag = "carv_black_depth_tag"
+	CarvWhiteDepthTag = "carv_white_depth_tag"
+
+	ToolDiamTag                = "tool_diam"
+	StepOverTag                = "step_over"
+	ScallopHeightTag           = "scallop_height"
+	ToolTypeTag                = "tool_type"
+	ToolAngleTag               = "tool_angle"
+	ToolTipRadiusTag           = "tool_tip_radius"
+	ToolCornerRadiusTag        = "tool_corner_radius"
+	MaxStepDownTag             = "max_step_down"
+	HorizFeedRateTag           = "horiz_feed_rate"
+	VertFeedRateTag            = "vert_feed_rate"
+	CarvDirectionTag           = "carv_direction"
+	RasterAngleTag             = "raster_angle"
+	RasterDirectionTag         = "raster_direction"
+	LoopCornerRadiusTag        = "loop_corner_radius"
+	StayDownLinkingTag         = "stay_down_linking"
+	SkipAirCutsTag             = "skip_air_cuts"
+	UseFinishPassTag           = "use_finishing_pass"
+	FinishPassReductionTag     = "finish_pass_reduc"
+	FinishPassModeTag          = "finish_pass_mode"
+	FinishPassHorizFeedRateTag = "finish_pass_horiz_feed"
+	SpindleSpeedTag            = "spindle_speed"
+	SpindleDirTag              = "spindle_direction"
+	SpinUpDwellTag             = "spin_up_dwell"
+	CoolantTag                 = "coolant"
+
+	EnableContourTag           = "enable_contour_machining"
+	ContourToolTypeTag         = "contour_tool_type"
+	ContourOutlineTag          = "contour_outline"
+	ContourToolDiameterTag     = "contour_tool_diameter"
+	ContourToolCornerRadiusTag = "contour_tool_corner_radius"
+	ContourMaxStepDownTag      = "contour_max_step_down_size"
+	ContourHorizFeedRateTag    = "contour_horizontal_feed_rate"
+	ContourVertFeedRateTag     = "contour_vertical_feed_rate"
+	ContourCornerRadiusTag     = "contour_corner_radius"
+	ContourNubTabsPerSideTag   = "contour_num_tabs_per_side"
+	ContourTabWidthTag         = "contour_tab_width"
+	ContourTabHeightTag        = "contour_tab_height"
+	ContourSpindleSpeedTag     = "contour_spindle_speed"
+	ContourSpindleDirTag       = "contour_spindle_direction"
+	ContourSpinUpDwellTag      = "contour_spin_up_dwell"
+	ContourCoolantTag          = "contour_coolant"
+
+	EnableRoughingTag        = "enable_roughing"
+	RoughingToolDiameterTag  = "roughing_tool_diameter"
+	RoughingStepOverTag      = "roughing_step_over"
+	RoughingMaxStepDownTag   = "roughing_max_step_down_size"
+	RoughingHorizFeedRateTag = "roughing_horizontal_feed_rate"
+	RoughingVertFeedRateTag  = "roughing_vertical_feed_rate"
+	RoughingStockToLeaveTag  = "roughing_stock_to_leave"
+	RoughingSpindleSpeedTag  = "roughing_spindle_speed"
+	RoughingSpindleDirTag    = "roughing_spindle_direction"
+	RoughingSpinUpDwellTag   = "roughing_spin_up_dwell"
+	RoughingCoolantTag       = "roughing_coolant"
+
+	EnableRestTag        = "enable_rest_machining"
+	RestToolTypeTag      = "rest_tool_type"
+	RestToolDiameterTag  = "rest_tool_diameter"
+	RestStepOverTag      = "rest_step_over"
+	RestMaxStepDownTag   = "rest_max_step_down_size"
+	RestHorizFeedRateTag = "rest_horizontal_feed_rate"
+	RestVertFeedRateTag  = "rest_vertical_feed_rate"
+	RestSpindleSpeedTag  = "rest_spindle_speed"
+	RestSpindleDirTag    = "rest_spindle_direction"
+	RestSpinUpDwellTag   = "rest_spin_up_dwell"
+	RestCoolantTag       = "rest_coolant"
+
+	ToolChangeModeTag = "tool_change_mode"
+	ParkXTag          = "park_x"
+	ParkYTag          = "park_y"
+	ParkZTag          = "park_z"
+	OneFilePerToolTag = "one_file_per_tool"
+	FitArcsTag        = "fit_arcs"
+	PostProcessorTag  = "post_processor"
+	OutputUnitsTag    = "output_units"
+	EntryModeTag      = "entry_mode"
+	MaxRampAngleTag   = "max_ramp_angle"
+	HelixRadiusTag    = "helix_radius"
+
+	RetractModeTag     = "retract_mode"
+	SafeHeightTag      = "safe_height"
+	ClearanceHeightTag = "clearance_height"
+	RetractHeightTag   = "retract_height"
+	RapidThresholdTag  = "rapid_threshold"
+
+	OriginXYTag   = "origin_xy"
+	OriginOfTag   = "origin_of"
+	OriginZTag    = "origin_z"
+	WorkOffsetTag = "work_offset"
+
+	TrackStockTag      = "track_stock"
+	StockResolutionTag = "stock_resolution"
+	CheckGougesTag     = "check_gouges"
+	GougeToleranceTag  = "gouge_tolerance"
+
+	ImgFillModeTag = "img_fill_mode"
+	ImgMirrorXTag  = "img_mirror_x"
+	ImgMirrorYTag  = "img_mirror_y"
+)
+
+// The kinds of model values.
+const (
+	FloatValue = iota
+	IntValue
+	BoolValue
+)
+
+var valueKindByTag = map[string]int{
+	MatWidthTag:                FloatValue,
+	MatHeightTag:               FloatValue,
+	MatThicknessTag:            FloatValue,
+	CarvWidthTag:               FloatValue,
+	CarvHeightTag:              FloatValue,
+	CarvOffsetXTag:             FloatValue,
+	CarvOffsetYTag:             FloatValue,
+	CarvBlackDepthTag:          FloatValue,
+	CarvWhiteDepthTag:          FloatValue,
+	ToolDiamTag:                FloatValue,
+	ToolAngleTag:               FloatValue,
+	ToolTipRadiusTag:           FloatValue,
+	ToolCornerRadiusTag:        FloatValue,
+	StepOverTag:                FloatValue,
+	ScallopHeightTag:           FloatValue,
+	MaxStepDownTag:             FloatValue,
+	HorizFeedRateTag:           FloatValue,
+	VertFeedRateTag:            FloatValue,
+	RasterAngleTag:             FloatValue,
+	LoopCornerRadiusTag:        FloatValue,
+	FinishPassReductionTag:     FloatValue,
+	FinishPassHorizFeedRateTag: FloatValue,
+	ContourCornerRadiusTag:     FloatValue,
+	ContourHorizFeedRateTag:    FloatValue,
+	ContourVertFeedRateTag:     FloatValue,
+	ContourToolDiameterTag:     FloatValue,
+	ContourToolCornerRadiusTag: FloatValue,
+	ContourTabWidthTag:         FloatValue,
+	ContourTabHeightTag:        FloatValue,
+	ContourMaxStepDownTag:      FloatValue,
+	RoughingToolDiameterTag:    FloatValue,
+	RoughingStepOverTag:        FloatValue,
+	RoughingMaxStepDownTag:     FloatValue,
+	RoughingHorizFeedRateTag:   FloatValue,
+	RoughingVertFeedRateTag:    FloatValue,
+	RoughingStockToLeaveTag:    FloatValue,
+	RestToolDiameterTag:        FloatValue,
+	RestStepOverTag:            FloatValue,
+	RestMaxStepDownTag:         FloatValue,
+	RestHorizFeedRateTag:       FloatValue,
+	RestVertFeedRateTag:        FloatValue,
+	ParkXTag:                   FloatValue,
+	ParkYTag:                   FloatValue,
+	ParkZTag:                   FloatValue,
+	MaxRampAngleTag:            FloatValue,
+	HelixRadiusTag:             FloatValue,
+	SpindleSpeedTag:            FloatValue,
+	SpinUpDwellTag:             FloatValue,
+	RoughingSpindleSpeedTag:    FloatValue,
+	RoughingSpinUpDwellTag:     FloatValue,
+	RestSpindleSpeedTag:        FloatValue,
+	RestSpinUpDwellTag:         FloatValue,
+	ContourSpindleSpeedTag:     FloatValue,
+	ContourSpinUpDwellTag:      FloatValue,
+	SafeHeightTag:              FloatValue,
+	ClearanceHeightTag:         FloatValue,
+	RetractHeightTag:           FloatValue,
+	RapidThresholdTag:          FloatValue,
+	StockResolutionTag:         FloatValue,
+	GougeToleranceTag:          FloatValue,
+	CarvDirectionTag:           IntValue,
+	RasterDirectionTag:         IntValue,
+	ImgFillModeTag:             IntValue,
+	ToolTypeTag:                IntValue,
+	FinishPassModeTag:          IntValue,
+	ContourToolTypeTag:         IntValue,
+	ContourNubTabsPerSideTag:   IntValue,
+	ContourOutlineTag:          IntValue,
+	ToolChangeModeTag:          IntValue,
+	PostProcessorTag:           IntValue,
+	OutputUnitsTag:             IntValue,
+	SpindleDirTag:              IntValue,
+	CoolantTag:                 IntValue,
+	RoughingSpindleDirTag:      IntValue,
+	RoughingCoolantTag:         IntValue,
+	RestSpindleDirTag:          IntValue,
+	RestCoolantTag:             IntValue,
+	ContourSpindleDirTag:       IntValue,
+	ContourCoolantTag:          IntValue,
+	RetractModeTag:             IntValue,
+	OriginXYTag:                IntValue,
+	OriginOfTag:                IntValue,
+	OriginZTag:                 IntValue,
+	WorkOffsetTag:              IntValue,
+	EntryModeTag:               IntValue,
+	RestToolTypeTag:            IntValue,
+	ImgMirrorXTag:              BoolValue,
+	ImgMirrorYTag:              BoolValue,
+	UseFinishPassTag:           BoolValue,
+	StayDownLinkingTag:         BoolValue,
+	SkipAirCutsTag:             BoolValue,
+	EnableContourTag:           BoolValue,
+	EnableRoughingTag:          BoolValue,
+	OneFilePerToolTag:          BoolValue,
+	FitArcsTag:                 BoolValue,
+	TrackStockTag:              BoolValue,
+	CheckGougesTag:             BoolValue,
+	EnableRestTag:              BoolValue,
+}
+
+// The valid range of a number model value, bounds included.
+type valueRange struct {
+	min, max float64
+}
+
+// The valid ranges of the number model values, by tag. The UI number entries are limited to
+// the same ranges.
+var valueRangeByTag = map[string]valueRange{
+	MatWidthTag:                {10, 300},
+	MatHeightTag:               {10, 300},
+	MatThicknessTag:            {5, 50},
+	CarvWidthTag:               {10, 300},
+	CarvHeightTag:              {10, 300},
+	CarvOffsetXTag:             {0, 99},
+	CarvOffsetYTag:             {0, 99},
+	CarvBlackDepthTag:          {-20, 5},
+	CarvWhiteDepthTag:          {-20, 5},
+	RoughingToolDiameterTag:    {1, 15},
+	RoughingStepOverTag:        {1, 200},
+	RoughingMaxStepDownTag:     {0.01, 9},
+	RoughingHorizFeedRateTag:   {10, 2000},
+	RoughingVertFeedRateTag:    {10, 2000},
+	RoughingStockToLeaveTag:    {0, 5},
+	ToolDiamTag:                {1, 15},
+	StepOverTag:                {1, 200},
+	ScallopHeightTag:           {0, 1},
+	ToolAngleTag:               {5, 150},
+	ToolTipRadiusTag:           {0, 5},
+	ToolCornerRadiusTag:        {0, 5},
+	MaxStepDownTag:             {0.01, 9},
+	HorizFeedRateTag:           {10, 2000},
+	VertFeedRateTag:            {10, 2000},
+	RasterAngleTag:             {0, 180},
+	LoopCornerRadiusTag:        {0, 500},
+	FinishPassReductionTag:     {1, 90},
+	FinishPassHorizFeedRateTag: {10, 2000},
+	RestToolDiameterTag:        {1, 15},
+	RestStepOverTag:            {1, 200},
+	RestMaxStepDownTag:         {0.01, 9},
+	RestHorizFeedRateTag:       {10, 2000},
+	RestVertFeedRateTag:        {10, 2000},
+	ContourToolDiameterTag:     {1, 15},
+	ContourToolCornerRadiusTag: {0, 5},
+	ContourMaxStepDownTag:      {0.01, 9},
+	ContourHorizFeedRateTag:    {10, 2000},
+	ContourVertFeedRateTag:     {10, 2000},
+	ContourCornerRadiusTag:     {0, 20},
+	ContourTabWidthTag:         {2, 10},
+	ContourTabHeightTag:        {0.2, 2},
+	ParkXTag:                   {-2000, 2000},
+	ParkYTag:                   {-2000, 2000},
+	ParkZTag:                   {-2000, 2000},
+	SafeHeightTag:              {0.1, 200},
+	ClearanceHeightTag:         {0.1, 200},
+	RetractHeightTag:           {0.1, 200},
+	RapidThresholdTag:          {0, 2000},
+	SpindleSpeedTag:            {0, 60000},
+	SpinUpDwellTag:             {0, 60},
+	RoughingSpindleSpeedTag:    {0, 60000},
+	RoughingSpinUpDwellTag:     {0, 60},
+	RestSpindleSpeedTag:        {0, 60000},
+	RestSpinUpDwellTag:         {0, 60},
+	ContourSpindleSpeedTag:     {0, 60000},
+	ContourSpinUpDwellTag:      {0, 60},
+	MaxRampAngleTag:            {0.5, 45},
+	HelixRadiusTag:             {0.1, 50},
+	StockResolutionTag:         {0.05, 2},
+	GougeToleranceTag:          {0, 5},
+}
+
+// GetValueKind returns the kind of the model value with the given tag, and whether the tag is
+// the tag of a model value at all.
+func GetValueKind(tag string) (kind int, ok bool) {
+	kind, ok = valueKindByTag[tag]
+	return
+}
+
+// GetValueRange returns the valid range of the number model value with the given tag, and
+// whether the tag is the tag of a number model value at all.
+func GetValueRange(tag string) (min, max float64, ok bool) {
+	r, ok := valueRangeByTag[tag]
+	return r.min, r.max, ok
+}
+
+// GetAllValueTags returns the tags of all the model values, in alphabetical order.
+func GetAllValueTags() []string {
+	tags := make([]string, 0, len(valueKindByTag))
+	for tag := range valueKindByTag {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// SetValueFromString parses the value and sets the model value with the given tag. Choices are
+// given by their index, e.g. 2 for the third choice. Numbers must be within the range given by
+// GetValueRange.
+func (m *Model) SetValueFromString(tag string, value string) error {
+	kind, ok := GetValueKind(tag)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownTag, tag)
+	}
+
+	var err error
+	switch kind {
+	case FloatValue:
+		v, e := strconv.ParseFloat(value, 32)
+		if e != nil {
+			return fmt.Errorf("invalid number %q for %s", value, tag)
+		}
+		err = m.SetFloat32Value(tag, float32(v))
+	case IntValue:
+		v, e := strconv.Atoi(value)
+		if e != nil || v < 0 {
+			return fmt.Errorf("invalid choice index %q for %s", value, tag)
+		}
+		err = m.SetIntValue(tag, v)
+	default:
+		v, e := strconv.ParseBool(value)
+		if e != nil {
+			return fmt.Errorf("invalid boolean %q for %s", value, tag)
+		}
+		err = m.SetBoolValue(tag, v)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.SetDirty(true)
+	return nil
+}
+
+// GetValueAsString returns the model value with the given tag, formatted for
+// SetValueFromString.
+func (m *Model) GetValueAsString(tag string) string {
+	switch valueKindByTag[tag] {
+	case FloatValue:
+		return strconv.FormatFloat(float64(m.GetFloat32Value(tag)), 'g', -1, 32)
+	case IntValue:
+		return strconv.Itoa(m.GetIntValue(tag))
+	default:
+		return strconv.FormatBool(m.GetBoolValue(tag))
+	}
+}
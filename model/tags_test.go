@@ -0,0 +1,102 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValueRanges(t *testing.T) {
+	m := NewModel()
+	for _, tag := range GetAllValueTags() {
+		kind, _ := GetValueKind(tag)
+		min, max, ok := GetValueRange(tag)
+		if ok != (kind == FloatValue) {
+			t.Errorf("Value range of %s: expected a range for numbers only\n", tag)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if v := m.GetFloat32Value(tag); v < float32(min) || v > float32(max) {
+			t.Errorf("Value range of %s: default %g outside of [%g, %g]\n", tag, v, min, max)
+		}
+	}
+}
+
+func TestSetValueFromString(t *testing.T) {
+	m := NewModel()
+	for _, set := range []struct{ tag, value string }{
+		{MaxStepDownTag, "0.5"},
+		{MaxStepDownTag, "0.01"},
+		{CarvBlackDepthTag, "-20"},
+		{ParkXTag, "-12.5"},
+		{ToolTypeTag, "2"},
+		{EnableRoughingTag, "true"},
+	} {
+		if err := m.SetValueFromString(set.tag, set.value); err != nil {
+			t.Errorf("Set %s=%s: unexpected error: %v\n", set.tag, set.value, err)
+		} else if s := m.GetValueAsString(set.tag); s != set.value {
+			t.Errorf("Set %s=%s: got %s back\n", set.tag, set.value, s)
+		}
+	}
+	if !m.IsDirty() {
+		t.Errorf("Set: expected the model to be dirty\n")
+	}
+
+	for _, set := range []struct {
+		tag, value string
+		err        error
+	}{
+		{"no_such_tag", "1", ErrUnknownTag},
+		{MaxStepDownTag, "0", ErrValueOutOfRange},
+		{MaxStepDownTag, "-1", ErrValueOutOfRange},
+		{RoughingMaxStepDownTag, "0", ErrValueOutOfRange},
+		{MatWidthTag, "1000", ErrValueOutOfRange},
+		{ToolDiamTag, "0.5", ErrValueOutOfRange},
+		{MaxStepDownTag, "deep", nil},
+		{ToolTypeTag, "-1", nil},
+		{EnableRoughingTag, "maybe", nil},
+	} {
+		err := m.SetValueFromString(set.tag, set.value)
+		if err == nil || (set.err != nil && !errors.Is(err, set.err)) {
+			t.Errorf("Set %s=%s: expected error %v, got %v\n", set.tag, set.value, set.err, err)
+		}
+	}
+
+	// Values out of range are left alone.
+	if s := m.GetValueAsString(MaxStepDownTag); s != "0.01" {
+		t.Errorf("Set: expected the step-down to stay 0.01, got %s\n", s)
+	}
+	err := SetModelValueByTag(m, MaxStepDownTag, float32(10))
+	if !errors.Is(err, ErrValueOutOfRange) {
+		t.Errorf("Set: expected the step-down to be out of range, got %v\n", err)
+	}
+}
+
+func TestGetValueAsString(t *testing.T) {
+	// Every value reads back as it was written.
+	m := NewModel()
+	other := NewModel()
+	for _, tag := range GetAllValueTags() {
+		s := m.GetValueAsString(tag)
+		if err := other.SetValueFromString(tag, s); err != nil {
+			t.Errorf("Get %s: unexpected error setting %s back: %v\n", tag, s, err)
+		} else if o := other.GetValueAsString(tag); o != s {
+			t.Errorf("Get %s: expected %s, got %s\n", tag, s, o)
+		}
+	}
+
+	// Numbers are written as short as they can be read back.
+	m.SetFloat32Value(StepOverTag, 12.5)
+	m.SetIntValue(ToolTypeTag, 3)
+	m.SetBoolValue(UseFinishPassTag, true)
+	for tag, expected := range map[string]string{
+		StepOverTag:      "12.5",
+		ToolTypeTag:      "3",
+		UseFinishPassTag: "true",
+	} {
+		if s := m.GetValueAsString(tag); s != expected {
+			t.Errorf("Get %s: expected %s, got %s\n", tag, expected, s)
+		}
+	}
+}
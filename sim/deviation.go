@@ -0,0 +1,114 @@
+package sim
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/mesh"
+)
+
+// DeviationReport compares a simulated carved surface to the target surface. Deviations are
+// positive where material is left above the target surface and negative where the tool cut
+// below it, i.e. where it gouged the surface.
+type DeviationReport struct {
+	MaxGouge       float64 // Depth of the deepest cut below the target surface, or 0.
+	MaxGougeAt     geom.Pt2
+	MaxRemaining   float64 // Thickness of the thickest material left above the target, or 0.
+	MaxRemainingAt geom.Pt2
+	RMSDeviation   float64
+	NumSamples     int // Number of pixels of the height map over the target surface.
+
+	// Deviation of each pixel of the height map, row by row, or NaN outside the target.
+	deviations    []float64
+	width, height int
+}
+
+// CompareToMesh compares the carved height map, as returned by Simulate, to the target surface.
+// Heights of the target mesh are measured from the bottom of the material, as for the meshes
+// built for the carving. Pixels outside the target mesh are not compared.
+func CompareToMesh(heightMap *image.Gray16, config Config, target *mesh.TriangleMesh) *DeviationReport {
+	bounds := heightMap.Bounds()
+	r := &DeviationReport{
+		width:      bounds.Dx(),
+		height:     bounds.Dy(),
+		deviations: make([]float64, bounds.Dx()*bounds.Dy()),
+	}
+
+	sumSqrd := 0.0
+	for y := 0; y < r.height; y++ {
+		for x := 0; x < r.width; x++ {
+			k := y*r.width + x
+			p := config.PixelCenter(x, y, heightMap)
+			targetZ, ok := target.HeightAt(p)
+			if !ok {
+				r.deviations[k] = math.NaN()
+				continue
+			}
+
+			carvedZ := config.HeightFromGray(heightMap.Gray16At(bounds.Min.X+x, bounds.Min.Y+y).Y)
+			d := carvedZ - (targetZ - config.MaterialThickness)
+			r.deviations[k] = d
+			sumSqrd += d * d
+			r.NumSamples++
+
+			if -d > r.MaxGouge {
+				r.MaxGouge, r.MaxGougeAt = -d, p
+			}
+			if d > r.MaxRemaining {
+				r.MaxRemaining, r.MaxRemainingAt = d, p
+			}
+		}
+	}
+
+	if r.NumSamples > 0 {
+		r.RMSDeviation = math.Sqrt(sumSqrd / float64(r.NumSamples))
+	}
+	return r
+}
+
+// CheckGouges returns an error if the tool cut deeper than the tolerance below the target
+// surface anywhere.
+func (r *DeviationReport) CheckGouges(tolerance float64) error {
+	if r.MaxGouge > tolerance {
+		return fmt.Errorf("gouge of %.3f mm at (%.2f, %.2f) exceeds the tolerance of %.3f mm",
+			r.MaxGouge, r.MaxGougeAt.X, r.MaxGougeAt.Y, tolerance)
+	}
+	return nil
+}
+
+// DeviationImage returns a false-colour image of the deviations, with the same layout as the
+// height map. Gouges are red, material left above the target is blue and the target surface is
+// white. Colours saturate at deviations of fullScale millimeters. Pixels outside the target
+// surface are gray.
+func (r *DeviationReport) DeviationImage(fullScale float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, r.width, r.height))
+	for y := 0; y < r.height; y++ {
+		for x := 0; x < r.width; x++ {
+			d := r.deviations[y*r.width+x]
+			if math.IsNaN(d) {
+				img.SetRGBA(x, y, color.RGBA{R: 128, G: 128, B: 128, A: 255})
+				continue
+			}
+
+			fade := uint8(math.Round(255 * (1 - math.Min(1, math.Abs(d)/fullScale))))
+			if d < 0 {
+				img.SetRGBA(x, y, color.RGBA{R: 255, G: fade, B: fade, A: 255})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{R: fade, G: fade, B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func (r *DeviationReport) String() string {
+	return fmt.Sprintf("Max gouge: %.3f mm at (%.2f, %.2f)\n"+
+		"Max remaining material: %.3f mm at (%.2f, %.2f)\n"+
+		"RMS deviation: %.3f mm over %d samples\n",
+		r.MaxGouge, r.MaxGougeAt.X, r.MaxGougeAt.Y,
+		r.MaxRemaining, r.MaxRemainingAt.X, r.MaxRemainingAt.Y,
+		r.RMSDeviation, r.NumSamples)
+}
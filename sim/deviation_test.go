@@ -0,0 +1,215 @@
+package sim
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"alvin.com/GoCarver/carving"
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+	"alvin.com/GoCarver/mesh"
+	"alvin.com/GoCarver/stock"
+)
+
+func TestCompareToMesh(t *testing.T) {
+	// The target is flat, 1 mm below the top of the material, over the left half of the
+	// material.
+	sampler := hmap.NewConstantDepthSampler(0.5)
+	target, err := mesh.NewTriangleMesh(geom.NewPt2(0, 0), geom.NewPt2(10, 10), 8, 10, &sampler)
+	if err != nil {
+		t.Fatalf("Compare to mesh: unexpected error: %v\n", err)
+	}
+
+	// The flat end-mill of tool T2 cuts 1.5 mm deep around (5, 5).
+	config := newConfigForTest()
+	toolpath := "T2 M6\nG0 X5 Y5 Z1\nG1 Z-1.5\nG0 Z1\n"
+	heightMap, err := Simulate(strings.NewReader(toolpath), config)
+	if err != nil {
+		t.Fatalf("Compare to mesh: unexpected error: %v\n", err)
+	}
+
+	r := CompareToMesh(heightMap, config, target)
+	const eps = 0.001
+	if math.Abs(r.MaxGouge-0.5) > eps || r.MaxGougeAt.Sub(geom.NewPt2(5, 5)).Len() > 2 {
+		t.Errorf("Compare to mesh: expected a 0.5 mm gouge at (5, 5), got %f at %v\n",
+			r.MaxGouge, r.MaxGougeAt)
+	}
+	if math.Abs(r.MaxRemaining-1) > eps {
+		t.Errorf("Compare to mesh: expected 1 mm of remaining material, got %f\n", r.MaxRemaining)
+	}
+	if r.NumSamples != 100*100 {
+		t.Errorf("Compare to mesh: expected %d samples, got %d\n", 100*100, r.NumSamples)
+	}
+
+	// 4*4*pi of the 10*10 mm are gouged 0.5 mm deep, the rest is 1 mm too high.
+	gouged := 4 * math.Pi / 100
+	rms := math.Sqrt(gouged*0.25 + (1 - gouged))
+	if math.Abs(r.RMSDeviation-rms) > 0.01 {
+		t.Errorf("Compare to mesh: expected an RMS deviation of %f, got %f\n", rms, r.RMSDeviation)
+	}
+
+	if r.CheckGouges(0.1) == nil {
+		t.Errorf("Compare to mesh: expected the gouge to exceed 0.1 mm\n")
+	}
+	if err := r.CheckGouges(0.6); err != nil {
+		t.Errorf("Compare to mesh: unexpected error: %v\n", err)
+	}
+
+	// Gouges are red, remaining material blue and pixels outside the target gray.
+	img := r.DeviationImage(1)
+	colorAt := func(p geom.Pt2) [3]uint8 {
+		c := img.RGBAAt(int(p.X/config.Resolution), 99-int(p.Y/config.Resolution))
+		return [3]uint8{c.R, c.G, c.B}
+	}
+	if c := colorAt(geom.NewPt2(5, 5)); c != [3]uint8{255, 128, 128} {
+		t.Errorf("Compare to mesh: expected a red gouge, got %v\n", c)
+	}
+	if c := colorAt(geom.NewPt2(1, 9)); c != [3]uint8{0, 0, 255} {
+		t.Errorf("Compare to mesh: expected blue material, got %v\n", c)
+	}
+	if c := colorAt(geom.NewPt2(15, 5)); c != [3]uint8{128, 128, 128} {
+		t.Errorf("Compare to mesh: expected gray outside the target, got %v\n", c)
+	}
+}
+
+// A sampler of a steep dome in the middle of a 20 x 10 mm area, with 5 samples per mm.
+type domeTestSampler struct{}
+
+var _ hmap.ScalarGridSampler = (*domeTestSampler)(nil)
+
+func (s *domeTestSampler) GetNumSamplesFromX0ToX1(x0, x1 float64) int {
+	return int(math.Round(5 * math.Abs(x1-x0)))
+}
+
+func (s *domeTestSampler) GetNumSamplesFromY0ToY1(y0, y1 float64) int {
+	return int(math.Round(5 * math.Abs(y1-y0)))
+}
+
+func (s *domeTestSampler) At(q geom.Pt2) float64 {
+	d := q.Sub(geom.NewPt2(10, 5)).Len()
+	return math.Max(0, 1-d*d/16)
+}
+
+func (s *domeTestSampler) EnableInvertImage(enable bool) {}
+
+func TestGougeCheckForDropCutter(t *testing.T) {
+	sampler := domeTestSampler{}
+	target, err := mesh.NewTriangleMesh(geom.NewPt2(0, 0), geom.NewPt2(20, 10), 7, 10, &sampler)
+	if err != nil {
+		t.Fatalf("Gouge check: unexpected error: %v\n", err)
+	}
+
+	mc := newMachiningConfigForTest(nil)
+	mc.Carving.Tool.ToolDiameter = 3
+	mc.Carving.Tool.MaxStepDown = 3
+	mc.Carving.CarvingBottomZ = 7
+	mc.Carving.StepOverFraction = 0.2
+
+	config := newConfigForTest()
+	getReport := func(s hmap.ScalarGridSampler) *DeviationReport {
+		mc.Carving.Sampler = s
+		heightMap, _ := simulateMachining(t, "Gouge check", mc, config)
+		return CompareToMesh(heightMap, config, target)
+	}
+
+	// The drop cutter keeps the ball above the surface of the dome.
+	dropCutter, err := mesh.NewMeshSamplerWithBallCutter(target, 3)
+	if err != nil {
+		t.Fatalf("Gouge check: unexpected error: %v\n", err)
+	}
+	r := getReport(dropCutter)
+	if err := r.CheckGouges(0.05); err != nil {
+		t.Errorf("Gouge check: unexpected gouge with the drop cutter: %v\n%s", err, r)
+	}
+
+	// Following the height map with the tip of the ball gouges the sides of the dome.
+	r = getReport(&sampler)
+	if r.CheckGouges(0.05) == nil {
+		t.Errorf("Gouge check: expected gouges without the drop cutter\n%s", r)
+	}
+}
+
+func TestGougeCheckForHelixEntries(t *testing.T) {
+	sampler := domeTestSampler{}
+	areaMin, areaMax := geom.NewPt2(2, 1), geom.NewPt2(18, 9)
+	target, err := mesh.NewTriangleMesh(areaMin, areaMax, 7, 10, &sampler)
+	if err != nil {
+		t.Fatalf("Helix entries: unexpected error: %v\n", err)
+	}
+	dropCutter, err := mesh.NewMeshSamplerWithBallCutter(target, 3)
+	if err != nil {
+		t.Fatalf("Helix entries: unexpected error: %v\n", err)
+	}
+
+	mc := newMachiningConfigForTest(dropCutter)
+	mc.Material.CarvingAreaOrigin = areaMin
+	mc.Material.CarvingAreaDim = geom.NewSize2(areaMax.X-areaMin.X, areaMax.Y-areaMin.Y)
+	mc.Carving.Tool.ToolDiameter = 3
+	mc.Carving.CarvingBottomZ = 7
+	mc.Contour = carving.ContourConfig{Enable: true, Outline: carving.ContourAroundCarvingArea,
+		Tool: carving.ToolConfig{ToolType: carving.ToolTypeFlat, ToolDiameter: 3,
+			MaxStepDown: 2.5, HorizFeedRate: 500, VertFeedRate: 300}}
+	mc.Entry = carving.EntryConfig{Mode: carving.EntryHelix, MaxRampAngle: 10, HelixRadius: 1}
+
+	// The helixes of the carving stay above the dome, and those of the contour outside the
+	// outline, so that neither cuts into the part.
+	config := newConfigForTest()
+	heightMap, code := simulateMachining(t, "Helix entries", mc, config)
+	if !strings.Contains(code, "G3 ") {
+		t.Fatalf("Helix entries: expected helixes\n")
+	}
+	r := CompareToMesh(heightMap, config, target)
+	if err := r.CheckGouges(0.05); err != nil {
+		t.Errorf("Helix entries: unexpected gouge: %v\n%s", err, r)
+	}
+}
+
+// A sampler of a plane sloping down along X, 0.2 mm under the tip of the ramp of
+// TestGougeCheckForLongRamp, with 5 samples per mm. The plane goes from the bottom of a 10 mm
+// thick material, at 0, to its top, at 1.
+type rampTestSampler struct{}
+
+var _ hmap.ScalarGridSampler = (*rampTestSampler)(nil)
+
+func (s *rampTestSampler) GetNumSamplesFromX0ToX1(x0, x1 float64) int {
+	return int(math.Round(5 * math.Abs(x1-x0)))
+}
+
+func (s *rampTestSampler) GetNumSamplesFromY0ToY1(y0, y1 float64) int {
+	return int(math.Round(5 * math.Abs(y1-y0)))
+}
+
+func (s *rampTestSampler) At(q geom.Pt2) float64 {
+	return (10 - 0.5*(q.X-2) - 0.2) / 10
+}
+
+func (s *rampTestSampler) EnableInvertImage(enable bool) {}
+
+func TestGougeCheckForLongRamp(t *testing.T) {
+	sampler := rampTestSampler{}
+	target, err := mesh.NewTriangleMesh(geom.NewPt2(4, 2), geom.NewPt2(16, 8), 0, 10, &sampler)
+	if err != nil {
+		t.Fatalf("Long ramp: unexpected error: %v\n", err)
+	}
+
+	// The tip of a 6 mm ball going down 8 mm over 16 mm stays 0.2 mm above the target, but
+	// the ball dips 3 - 2.683 - 0.671 = 0.354 mm below its tip along the slope, between the
+	// ends of the segment.
+	config := newConfigForTest()
+	config.Cutters = map[int]stock.Cutter{3: stock.NewBallCutter(6)}
+	toolpath := "T3 M6\nG0 X2 Y5 Z1\nG1 Z0\nG1 X18 Z-8\nG0 Z1\n"
+	heightMap, err := Simulate(strings.NewReader(toolpath), config)
+	if err != nil {
+		t.Fatalf("Long ramp: unexpected error: %v\n", err)
+	}
+
+	r := CompareToMesh(heightMap, config, target)
+	if math.Abs(r.MaxGouge-0.154) > 0.02 || math.Abs(r.MaxGougeAt.Y-5) > 0.1 {
+		t.Errorf("Long ramp: expected a 0.154 mm gouge along y=5, got %f at %v\n",
+			r.MaxGouge, r.MaxGougeAt)
+	}
+	if r.CheckGouges(0.05) == nil {
+		t.Errorf("Long ramp: expected the ramp to gouge the target\n%s", r)
+	}
+}
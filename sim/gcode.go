@@ -0,0 +1,280 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"alvin.com/GoCarver/geom"
+)
+
+const mmPerInch = 25.4
+
+// The kinds of moves found in the toolpath.
+const (
+	moveRapid = iota
+	moveLinear
+	moveClockwiseArc
+	moveCounterclockwiseArc
+)
+
+// A move of the tool, from the position before the move to p. Arcs are given with their radius.
+type move struct {
+	kind   int
+	p      geom.Pt3
+	radius float64
+}
+
+// The state of the machine while reading the toolpath. Positions are in work coordinates, in
+// millimeters. Coordinates that are unknown, e.g. after moves in machine coordinates, are NaN.
+type gcodeReader struct {
+	pos         geom.Pt3
+	motion      int     // Current motion mode, one of the moveXXX values.
+	relative    bool    // Whether coordinates are relative to the current position (G91).
+	scale       float64 // Millimeters per unit.
+	tool        int     // Number of the tool selected with the last M6.
+	pendingTool int     // Number of the tool given with the last T word.
+}
+
+func newGcodeReader() *gcodeReader {
+	return &gcodeReader{
+		pos:    geom.NewPt3(math.NaN(), math.NaN(), math.NaN()),
+		motion: moveRapid,
+		scale:  1,
+	}
+}
+
+// Read the toolpath and call onToolChange for each tool change and onMove for each move.
+// Only the subset of G-code used for carving is supported: rapid, linear and arc moves in the
+// XY plane, absolute and relative coordinates, millimeters and inches and tool changes with M6
+// or, when the machine pauses for tool changes, with the "(Tn: description)" comments that name
+// the tools. Arcs are given by their radius or by their center relative to their start, and go
+// less than half-way around their center. Other commands, e.g. for the spindle, feeds, pauses
+// and tool offsets, don't move the tool and are ignored. Moves in machine coordinates (G53) and
+// homing (G28) leave the tool at an unknown position.
+func (r *gcodeReader) read(
+	toolpath io.Reader, onToolChange func(tool int) error, onMove func(from geom.Pt3, m move)) error {
+
+	scanner := bufio.NewScanner(toolpath)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if err := r.readLine(scanner.Text(), onToolChange, onMove); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (r *gcodeReader) readLine(
+	line string, onToolChange func(tool int) error, onMove func(from geom.Pt3, m move)) error {
+
+	if tool, ok := toolFromComment(line); ok {
+		r.tool = tool
+		if err := onToolChange(r.tool); err != nil {
+			return err
+		}
+	}
+
+	words, err := splitWords(stripComments(line))
+	if err != nil {
+		return err
+	}
+
+	var coords [3]float64
+	var hasCoord [3]bool
+	var center [2]float64
+	radius := 0.0
+	hasRadius := false
+	isMachineMove := false
+	isHoming := false
+	isArcCenter := false
+	changeTool := false
+
+	for _, w := range words {
+		switch w.letter {
+		case 'G':
+			switch w.value {
+			case 0, 1, 2, 3:
+				r.motion = []int{
+					moveRapid, moveLinear, moveClockwiseArc, moveCounterclockwiseArc}[int(w.value)]
+			case 20:
+				r.scale = mmPerInch
+			case 21:
+				r.scale = 1
+			case 28:
+				isHoming = true
+			case 53:
+				isMachineMove = true
+			case 90:
+				r.relative = false
+			case 91:
+				r.relative = true
+			case 17, 4, 40, 43, 49, 80, 94, 91.1, 54, 55, 56, 57, 58, 59:
+				// The XY plane, dwells, tool offsets, canceling canned cycles, feeds per minute,
+				// relative arc centers and work coordinate systems don't change the toolpath.
+			default:
+				return fmt.Errorf("unsupported command G%v", w.value)
+			}
+		case 'X', 'Y', 'Z':
+			axis := int(w.letter - 'X')
+			coords[axis] = w.value * r.scale
+			hasCoord[axis] = true
+		case 'R':
+			radius = w.value * r.scale
+			hasRadius = true
+		case 'I', 'J':
+			center[w.letter-'I'] = w.value * r.scale
+			isArcCenter = true
+		case 'T':
+			r.pendingTool = int(w.value)
+		case 'M':
+			if w.value == 6 {
+				changeTool = true
+			}
+		}
+	}
+
+	if changeTool {
+		r.tool = r.pendingTool
+		if err := onToolChange(r.tool); err != nil {
+			return err
+		}
+	}
+
+	if !hasCoord[0] && !hasCoord[1] && !hasCoord[2] {
+		if isHoming {
+			r.pos = geom.NewPt3(math.NaN(), math.NaN(), math.NaN())
+		}
+		return nil
+	}
+
+	if isMachineMove || isHoming {
+		// The position in work coordinates is unknown along the axes that moved.
+		for axis, has := range hasCoord {
+			if has {
+				r.setAxis(axis, math.NaN())
+			}
+		}
+		return nil
+	}
+
+	from := r.pos
+	for axis, has := range hasCoord {
+		if !has {
+			continue
+		}
+		v := coords[axis]
+		if r.relative {
+			v += r.getAxis(axis)
+		}
+		r.setAxis(axis, v)
+	}
+
+	m := move{kind: r.motion, p: r.pos}
+	if m.kind == moveClockwiseArc || m.kind == moveCounterclockwiseArc {
+		switch {
+		case isArcCenter:
+			m.radius = math.Hypot(center[0], center[1])
+		case hasRadius:
+			m.radius = radius
+		default:
+			return fmt.Errorf("arcs must be given by their radius or their center")
+		}
+	}
+	onMove(from, m)
+	return nil
+}
+
+func (r *gcodeReader) getAxis(axis int) float64 {
+	return []float64{r.pos.X, r.pos.Y, r.pos.Z}[axis]
+}
+
+func (r *gcodeReader) setAxis(axis int, v float64) {
+	switch axis {
+	case 0:
+		r.pos.X = v
+	case 1:
+		r.pos.Y = v
+	default:
+		r.pos.Z = v
+	}
+}
+
+// A word of G-code, e.g. G1 or X12.5.
+type gcodeWord struct {
+	letter byte
+	value  float64
+}
+
+// Return the line without its comments, in parentheses or after a semicolon, nor the percent
+// signs that start and end some programs.
+func stripComments(line string) string {
+	if strings.TrimSpace(line) == "%" {
+		return ""
+	}
+
+	var b strings.Builder
+	depth := 0
+	for _, c := range line {
+		switch {
+		case c == ';' && depth == 0:
+			return b.String()
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// Return the tool named by a "(Tn: description)" or ";Tn: description" comment, which the
+// carving code writes before using each tool.
+func toolFromComment(line string) (tool int, ok bool) {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "(T") && strings.HasSuffix(line, ")"):
+	case strings.HasPrefix(line, ";T"):
+	default:
+		return 0, false
+	}
+
+	number, _, found := strings.Cut(line[2:], ":")
+	if !found {
+		return 0, false
+	}
+	tool, err := strconv.Atoi(number)
+	return tool, err == nil
+}
+
+// Split the line into words, each one a letter followed by a number.
+func splitWords(line string) ([]gcodeWord, error) {
+	line = strings.ToUpper(strings.Join(strings.Fields(line), ""))
+	var words []gcodeWord
+	for i := 0; i < len(line); {
+		letter := line[i]
+		if letter < 'A' || letter > 'Z' {
+			return nil, fmt.Errorf("unexpected character %q", letter)
+		}
+
+		j := i + 1
+		for j < len(line) && (line[j] == '.' || line[j] == '-' || line[j] == '+' ||
+			(line[j] >= '0' && line[j] <= '9')) {
+			j++
+		}
+
+		value, err := strconv.ParseFloat(line[i+1:j], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid word %q", line[i:j])
+		}
+		words = append(words, gcodeWord{letter: letter, value: value})
+		i = j
+	}
+	return words, nil
+}
@@ -0,0 +1,149 @@
+// Package sim simulates the carving of a toolpath, to preview the carved surface before
+// spending hours on the machine.
+package sim
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/stock"
+)
+
+// Config configures the simulation of a toolpath.
+type Config struct {
+	MaterialDim       geom.Size2
+	MaterialThickness float64
+	Resolution        float64 // Width of the pixels of the carved height map, in millimeters.
+
+	// The work zero of the toolpath, relative to the bottom-left corner of the top of the
+	// material. See carving.GetWorkOrigin.
+	Origin geom.Pt3
+
+	// The cutter of the tools, by tool number. Tools not in the map use the default cutter.
+	Cutters       map[int]stock.Cutter
+	DefaultCutter stock.Cutter
+}
+
+// Simulate reads a GRBL toolpath, as generated by the carving package, and returns the height
+// map of the carved material. The toolpath is in work coordinates, relative to the work zero
+// given by Config.Origin.
+//
+// Pixels of the height map are Resolution wide. Rows go from the top of the material, at Y=H,
+// down to its bottom, at Y=0, as for the input images. Gray levels are proportional to the
+// height of the carved surface: 0 is the bottom of the material and 0xffff its top. Use
+// Config.HeightFromGray to convert gray levels back to heights.
+func Simulate(toolpath io.Reader, config Config) (*image.Gray16, error) {
+	if config.Resolution <= 0 || config.MaterialThickness <= 0 {
+		return nil, fmt.Errorf("invalid simulation resolution or material thickness")
+	}
+
+	material := stock.NewStock(geom.NewPt2(0, 0), config.MaterialDim, config.Resolution)
+	cutter := config.DefaultCutter
+
+	onToolChange := func(tool int) error {
+		if c, ok := config.Cutters[tool]; ok {
+			cutter = c
+		} else {
+			cutter = config.DefaultCutter
+		}
+		if cutter == nil {
+			return fmt.Errorf("no cutter for tool T%d", tool)
+		}
+		return nil
+	}
+
+	var err error
+	onMove := func(from geom.Pt3, m move) {
+		if cutter == nil {
+			if err == nil {
+				err = fmt.Errorf("no cutter for the moves before the first tool change")
+			}
+			return
+		}
+
+		o := config.Origin
+		from = geom.NewPt3(from.X+o.X, from.Y+o.Y, from.Z+o.Z)
+		to := geom.NewPt3(m.p.X+o.X, m.p.Y+o.Y, m.p.Z+o.Z)
+		switch m.kind {
+		case moveClockwiseArc, moveCounterclockwiseArc:
+			material.CutArc(from, to, m.radius, m.kind == moveClockwiseArc, cutter)
+		default:
+			material.CutSegment(from, to, cutter)
+		}
+	}
+
+	if e := newGcodeReader().read(toolpath, onToolChange, onMove); e != nil {
+		return nil, e
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nx, ny := material.GridSize()
+	heightMap := image.NewGray16(image.Rect(0, 0, nx, ny))
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			gray := config.grayFromHeight(material.CellHeight(i, j))
+			heightMap.SetGray16(i, ny-1-j, color.Gray16{Y: gray})
+		}
+	}
+
+	return heightMap, nil
+}
+
+// HeightFromGray returns the height of the carved surface, in millimeters relative to the top
+// of the material, for the given gray level of the height map.
+func (c *Config) HeightFromGray(gray uint16) float64 {
+	return (float64(gray)/0xffff - 1) * c.MaterialThickness
+}
+
+// PixelCenter returns the center of pixel (x, y) of the height map, in material coordinates.
+func (c *Config) PixelCenter(x, y int, heightMap *image.Gray16) geom.Pt2 {
+	ny := heightMap.Bounds().Dy()
+	return geom.NewPt2((float64(x)+0.5)*c.Resolution, (float64(ny-1-y)+0.5)*c.Resolution)
+}
+
+// Return the gray level of the height map for the given height. Heights below the bottom of
+// the material, where the tool cut through, are clamped to the bottom.
+func (c *Config) grayFromHeight(height float64) uint16 {
+	v := 1 + height/c.MaterialThickness
+	return uint16(math.Round(0xffff * math.Max(0, math.Min(1, v))))
+}
+
+// Shade returns a shaded preview of the height map, lit from the top-left corner.
+func Shade(heightMap *image.Gray16, config Config) *image.Gray {
+	const ambient = 0.25
+	light := geom.NewVec3(-1, 1, 2).Norm()
+
+	bounds := heightMap.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	heightAt := func(x, y int) float64 {
+		x = int(math.Max(0, math.Min(float64(w-1), float64(x))))
+		y = int(math.Max(0, math.Min(float64(h-1), float64(y))))
+		return config.HeightFromGray(heightMap.Gray16At(bounds.Min.X+x, bounds.Min.Y+y).Y)
+	}
+
+	preview := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Image rows go down along Y.
+			dzdx := (heightAt(x+1, y) - heightAt(x-1, y)) / (2 * config.Resolution)
+			dzdy := (heightAt(x, y-1) - heightAt(x, y+1)) / (2 * config.Resolution)
+			normal := geom.NewVec3(-dzdx, -dzdy, 1).Norm()
+			intensity := ambient + (1-ambient)*math.Max(0, normal.Dot(light))
+			preview.SetGray(x, y, color.Gray{Y: uint8(math.Round(255 * math.Min(1, intensity)))})
+		}
+	}
+
+	return preview
+}
+
+// WritePreviewPNG writes the shaded preview of the height map to w, as a PNG image.
+func WritePreviewPNG(w io.Writer, heightMap *image.Gray16, config Config) error {
+	return png.Encode(w, Shade(heightMap, config))
+}
@@ -0,0 +1,308 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"testing"
+
+	"alvin.com/GoCarver/carving"
+	"alvin.com/GoCarver/geom"
+	"alvin.com/GoCarver/hmap"
+	"alvin.com/GoCarver/stock"
+)
+
+func newConfigForTest() Config {
+	return Config{
+		MaterialDim:       geom.NewSize2(20, 10),
+		MaterialThickness: 10,
+		Resolution:        0.1,
+		DefaultCutter:     stock.NewBallCutter(2),
+		Cutters:           map[int]stock.Cutter{2: stock.NewFlatCutter(4)},
+	}
+}
+
+// Return the height of the simulated surface at p.
+func heightAt(t *testing.T, toolpath string, config Config, p geom.Pt2) float64 {
+	heightMap, err := Simulate(strings.NewReader(toolpath), config)
+	if err != nil {
+		t.Fatalf("Simulate: unexpected error: %v\n", err)
+	}
+
+	x := int(p.X / config.Resolution)
+	y := heightMap.Bounds().Dy() - 1 - int(p.Y/config.Resolution)
+	c := config.PixelCenter(x, y, heightMap)
+	if math.Abs(c.X-p.X) > config.Resolution || math.Abs(c.Y-p.Y) > config.Resolution {
+		t.Fatalf("Simulate: expected pixel (%d, %d) at %v, got %v\n", x, y, p, c)
+	}
+	return config.HeightFromGray(heightMap.Gray16At(x, y).Y)
+}
+
+func TestSimulate(t *testing.T) {
+	config := newConfigForTest()
+	const eps = 0.01
+	check := func(name, toolpath string, p geom.Pt2, expected float64) {
+		if h := heightAt(t, toolpath, config, p); math.Abs(h-expected) > eps {
+			t.Errorf("%s: expected height %f at %v, got %f\n", name, expected, p, h)
+		}
+	}
+
+	groove := "G90 G17 G21\n(T1: ball-nose)\nG0 Z25\nT1 M6\nG0 X5 Y5 Z1\n" +
+		"G1 Z-1 F300\nG1 X15 Y5 Z-1 F500 ; Along X\nG0 Z25\nG28 G91 Z0\nM30\n"
+	check("Groove", groove, geom.NewPt2(10.05, 5.05), -1)
+	check("Groove", groove, geom.NewPt2(10.05, 7.05), 0)
+	check("Groove", groove, geom.NewPt2(2.05, 5.05), 0)
+
+	// The flat end-mill of tool T2 goes through the material.
+	flat := "T2 M6\nG0 X10 Y5 Z1\nG1 Z-12\nG0 Z25\n"
+	check("Flat", flat, geom.NewPt2(11.05, 5.05), -10)
+	check("Flat", flat, geom.NewPt2(12.55, 5.05), 0)
+
+	// A counterclockwise half-circle around (10, 5), in two arcs.
+	arc := "T1 M6\nG0 X13 Y5 Z1\nG1 Z-1\nG3 X10 Y8 Z-1 R3\nX7 Y5 R3\n"
+	check("Arc", arc, geom.NewPt2(10.05, 8.05), -1)
+	check("Arc", arc, geom.NewPt2(7.95, 7.15), -1)
+	check("Arc", arc, geom.NewPt2(10.05, 2.05), 0)
+
+	// The same half-circle, with the arcs given by their center.
+	arcByCenter := "%\nT1 M6\nG0 X13 Y5 Z1\nG1 Z-1\nG3 X10 Y8 Z-1 I-3 J0\nX7 Y5 I0 J-3\n%\n"
+	check("Arc by center", arcByCenter, geom.NewPt2(10.05, 8.05), -1)
+	check("Arc by center", arcByCenter, geom.NewPt2(7.95, 7.15), -1)
+	check("Arc by center", arcByCenter, geom.NewPt2(10.05, 2.05), 0)
+
+	// When pausing for tool changes, the tools are named by comments only.
+	paused := "(T2: flat end-mill)\nG0 X10 Y5 Z1\nG1 Z-1\nG0 Z25\n(T1: ball-nose)\nM0\n" +
+		"G0 X10 Y5 Z1\nG1 Z-2\n"
+	check("Paused", paused, geom.NewPt2(11.55, 5.05), -1)
+	check("Paused", paused, geom.NewPt2(10.05, 5.05), -2)
+	paused = strings.NewReplacer("(T2: flat end-mill)", ";T2: flat end-mill").Replace(paused)
+	check("Paused", paused, geom.NewPt2(11.55, 5.05), -1)
+
+	// Inches and relative moves.
+	inches := "G20\nT1 M6\nG0 X0.2 Y0.2 Z0.1\nG91\nG1 Z-0.14\nX0.2\n"
+	check("Inches", inches, geom.NewPt2(7.65, 5.05), -1.016)
+
+	// Moves in machine coordinates leave the tool at an unknown position, until all axes have
+	// moved again.
+	machine := "T1 M6\nG0 X5 Y5 Z1\nG53 G0 Z-1\nG53 G0 X0 Y0\nG0 Z-1\nG1 X10\nG0 X10 Y5 Z-1\n" +
+		"G1 X15\n"
+	check("Machine", machine, geom.NewPt2(6.05, 5.05), 0)
+	check("Machine", machine, geom.NewPt2(12.05, 5.05), -1)
+}
+
+func TestSimulateErrors(t *testing.T) {
+	config := newConfigForTest()
+	config.DefaultCutter = nil
+	for _, toolpath := range []string{
+		"T1 M6\n",
+		"G0 X1 Y1 Z1\n",
+		"T2 M6\nG0 X0 Y0 Z0\nG2 X1 Y1\n",
+		"T2 M6\nG81 X1 Y1\n",
+		"T2 M6\nG1 X1 Y1 Z%\n",
+	} {
+		if _, err := Simulate(strings.NewReader(toolpath), config); err == nil {
+			t.Errorf("Simulate: expected an error for %q\n", toolpath)
+		}
+	}
+}
+
+func TestShade(t *testing.T) {
+	config := newConfigForTest()
+	heightMap, err := Simulate(strings.NewReader("T1 M6\nG0 X5 Y5 Z1\nG1 Z-1\nG1 X15\n"), config)
+	if err != nil {
+		t.Fatalf("Shade: unexpected error: %v\n", err)
+	}
+
+	// Flat areas all have the same shade. The light comes from the top, so the side of the
+	// groove facing down is lit and the side facing up is in the shade.
+	preview := Shade(heightMap, config)
+	flat := preview.GrayAt(10, 10).Y
+	if preview.GrayAt(180, 90).Y != flat || preview.GrayAt(100, 80).Y != flat {
+		t.Errorf("Shade: expected flat areas to have the same shade\n")
+	}
+	if preview.GrayAt(100, 44).Y >= flat || preview.GrayAt(100, 54).Y <= flat {
+		t.Errorf("Shade: expected the sides of the groove to be lit differently\n")
+	}
+
+	var out bytes.Buffer
+	if err := WritePreviewPNG(&out, heightMap, config); err != nil || out.Len() == 0 {
+		t.Errorf("Shade: could not write the preview: %v\n", err)
+	}
+}
+
+// Return a job that carves the material of newConfigForTest from the given sampler, 2 mm deep
+// along X with a 2 mm ball-nose.
+func newMachiningConfigForTest(sampler hmap.ScalarGridSampler) *carving.MachiningConfig {
+	mc := &carving.MachiningConfig{}
+	mc.Material = carving.MaterialConfig{
+		MaterialDim:       geom.NewSize2(20, 10),
+		CarvingAreaOrigin: geom.NewPt2(0, 0),
+		CarvingAreaDim:    geom.NewSize2(20, 10),
+		MaterialThickness: 10,
+	}
+	mc.Carving.Tool = carving.ToolConfig{ToolType: carving.ToolTypeBallPoint, ToolDiameter: 2,
+		HorizFeedRate: 500, VertFeedRate: 300, MaxStepDown: 1}
+	mc.Carving.Sampler = sampler
+	mc.Carving.CarvingTopZ = 10
+	mc.Carving.CarvingBottomZ = 8
+	mc.Carving.StepOverFraction = 0.4
+	mc.Carving.CarvingMode = carving.CarveModeXOnly
+	return mc
+}
+
+// Generate the code of the job and simulate it with the cutters of the job. Return the
+// simulated surface and the code.
+func simulateMachining(
+	t *testing.T, name string, mc *carving.MachiningConfig, config Config) (*image.Gray16, string) {
+
+	var toolpath bytes.Buffer
+	if err := carving.DoMachining(mc, &toolpath); err != nil {
+		t.Fatalf("%s: unexpected error: %v\n", name, err)
+	}
+	code := toolpath.String()
+
+	config.Cutters = carving.GetToolCutters(mc)
+	heightMap, err := Simulate(&toolpath, config)
+	if err != nil {
+		t.Fatalf("%s: unexpected error: %v\n", name, err)
+	}
+	return heightMap, code
+}
+
+// Check that both simulated surfaces are within eps of each other.
+func checkSameSurface(
+	t *testing.T, name string, config Config, expected, heightMap *image.Gray16, eps float64) {
+
+	b := heightMap.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			h := config.HeightFromGray(heightMap.Gray16At(x, y).Y)
+			e := config.HeightFromGray(expected.Gray16At(x, y).Y)
+			if math.Abs(h-e) > eps {
+				t.Fatalf("%s: expected height %f at (%d, %d), got %f\n", name, e, x, y, h)
+			}
+		}
+	}
+}
+
+func TestSimulateCarving(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	mc := newMachiningConfigForTest(&sampler)
+	mc.Carving.StepOverFraction = 0.2
+
+	// The whole carving area is carved 2 mm deep, with scallops no higher than 0.021 mm
+	// between the runs 0.4 mm apart.
+	config := newConfigForTest()
+	heightMap, _ := simulateMachining(t, "Simulate carving", mc, config)
+	for y := 20; y < 80; y++ {
+		for x := 20; x < 180; x++ {
+			h := config.HeightFromGray(heightMap.Gray16At(x, y).Y)
+			if h < -2.001 || h > -2+0.021 {
+				t.Fatalf("Simulate carving: unexpected height %f at (%d, %d)\n", h, x, y)
+			}
+		}
+	}
+}
+
+func TestSimulatePostProcessors(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	mc := newMachiningConfigForTest(&sampler)
+	mc.Machine.ToolChangeMode = carving.ToolChangeWithM6
+	mc.Machine.EnableArcFitting = true
+	mc.Carving.CarvingBottomZ = 9
+	mc.Carving.CarvingMode = carving.CarveModeConcentric
+	mc.Carving.LoopCornerRadius = 2
+
+	// Every dialect carves the same surface as GRBL, to the rounding of the arc centers.
+	config := newConfigForTest()
+	simulate := func(post string) *image.Gray16 {
+		mc.Machine.PostProcessor = post
+		heightMap, code := simulateMachining(t, "Simulate "+post, mc, config)
+		if post != carving.DefaultPostProcessor && !strings.Contains(code, " I") {
+			t.Fatalf("Simulate %s: expected arcs by their center\n", post)
+		}
+		return heightMap
+	}
+
+	expected := simulate(carving.DefaultPostProcessor)
+	for _, post := range carving.GetPostProcessorNames() {
+		checkSameSurface(t, "Simulate "+post, config, expected, simulate(post), 0.01)
+	}
+}
+
+func TestSimulateWorkOrigins(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0.5)
+	mc := newMachiningConfigForTest(&sampler)
+	mc.Material.CarvingAreaOrigin = geom.NewPt2(2, 1)
+	mc.Material.CarvingAreaDim = geom.NewSize2(12, 8)
+
+	// Whatever the work zero, the code carves the same surface.
+	simulate := func(origin carving.OriginConfig) *image.Gray16 {
+		mc.Machine.Origin = origin
+		config := newConfigForTest()
+		config.Origin = carving.GetWorkOrigin(mc)
+		heightMap, _ := simulateMachining(t, "Simulate work origin", mc, config)
+		return heightMap
+	}
+
+	expected := simulate(carving.OriginConfig{})
+	for _, origin := range []carving.OriginConfig{
+		{XY: carving.OriginCenter, Z: carving.ZOriginBed},
+		{XY: carving.OriginTopRight, XYOf: carving.OriginOfCarvingArea, WorkOffset: 2},
+	} {
+		name := fmt.Sprintf("Simulate work origin %v", origin)
+		checkSameSurface(t, name, newConfigForTest(), expected, simulate(origin), 0)
+	}
+}
+
+func TestSimulateInches(t *testing.T) {
+	sampler := hmap.NewConstantDepthSampler(0)
+	mc := newMachiningConfigForTest(&sampler)
+	mc.Machine.EnableArcFitting = true
+	mc.Carving.CarvingBottomZ = 9
+	mc.Carving.CarvingMode = carving.CarveModeConcentric
+	mc.Carving.LoopCornerRadius = 2
+
+	// The code in inches carves the same surface as the code in millimeters, with arcs given by
+	// their radius or their center. Coordinates are rounded to 0.0001 in, about 0.003 mm, which
+	// moves the surface by up to 0.02 mm where the flank of the ball is steep.
+	config := newConfigForTest()
+	simulate := func(post string, units int) *image.Gray16 {
+		mc.Machine.PostProcessor = post
+		mc.Machine.Units = units
+		heightMap, code := simulateMachining(t, "Simulate "+post+" in inches", mc, config)
+		if units == carving.UnitsInches && !strings.Contains(code, "G20") {
+			t.Fatalf("Simulate %s in inches: expected G20\n", post)
+		}
+		return heightMap
+	}
+
+	for _, post := range []string{"grbl", "linuxcnc"} {
+		expected := simulate(post, carving.UnitsMillimeters)
+		heightMap := simulate(post, carving.UnitsInches)
+		checkSameSurface(t, "Simulate "+post+" in inches", config, expected, heightMap, 0.02)
+	}
+}
+
+func TestMinimumRetract(t *testing.T) {
+	mc := newMachiningConfigForTest(&domeTestSampler{})
+	mc.Carving.CarvingBottomZ = 7
+	mc.Carving.RasterDirection = carving.RasterClimbOnly
+
+	config := newConfigForTest()
+	simulate := func(mode int) (*image.Gray16, string) {
+		mc.Machine.Retract.Mode = mode
+		return simulateMachining(t, "Minimum retract", mc, config)
+	}
+
+	// Lifting the tool less carves the same surface, since the tool never goes through the
+	// material between paths.
+	expected, code := simulate(carving.RetractToHeight)
+	heightMap, minCode := simulate(carving.RetractMinimum)
+	if strings.Count(minCode, "Z1.00\n") >= strings.Count(code, "Z1.00\n") {
+		t.Errorf("Minimum retract: expected fewer retracts to the retract height\n")
+	}
+	checkSameSurface(t, "Minimum retract", config, expected, heightMap, 0)
+}
@@ -0,0 +1,95 @@
+package stock
+
+import (
+	"math"
+
+	"alvin.com/GoCarver/geom"
+)
+
+// Arcs are subtracted from the stock as line segments no longer than this.
+const arcStep = 0.5
+
+// ArcPoints returns points along the arc from p0 to p1 in the XY plane, no more than arcStep
+// apart, with Z varying linearly along the arc. As for G2/G3 moves with a positive radius, the
+// arc goes less than half-way around its center. The points are p0 and p1 only when the radius
+// is too small for the arc to join them, or when either point is unknown, i.e. NaN.
+func ArcPoints(p0, p1 geom.Pt3, radius float64, clockwise bool) []geom.Pt3 {
+	halfChord := 0.5 * math.Hypot(p1.X-p0.X, p1.Y-p0.Y)
+	if !(halfChord > 0 && radius >= halfChord) {
+		return []geom.Pt3{p0, p1}
+	}
+
+	center := ArcCenter(p0, p1, radius, clockwise)
+	a0 := math.Atan2(p0.Y-center.Y, p0.X-center.X)
+	sweep := 2 * math.Asin(math.Min(1, halfChord/radius))
+	if clockwise {
+		sweep = -sweep
+	}
+
+	numSteps := int(math.Ceil(math.Abs(sweep) * radius / arcStep))
+	points := make([]geom.Pt3, 0, numSteps+1)
+	points = append(points, p0)
+	for i := 1; i < numSteps; i++ {
+		t := float64(i) / float64(numSteps)
+		a := a0 + t*sweep
+		points = append(points, geom.NewPt3(
+			center.X+radius*math.Cos(a), center.Y+radius*math.Sin(a), p0.Z+t*(p1.Z-p0.Z)))
+	}
+	return append(points, p1)
+}
+
+// ArcCenter returns the center of the arc from p0 to p1 in the XY plane. See ArcPoints for the
+// shape of the arc. The center is half-way between p0 and p1 when the radius is too small for
+// the arc to join them.
+func ArcCenter(p0, p1 geom.Pt3, radius float64, clockwise bool) geom.Pt2 {
+	chord := geom.NewVec2(p1.X-p0.X, p1.Y-p0.Y)
+	halfChord := 0.5 * chord.Len()
+	mid := geom.NewPt2(0.5*(p0.X+p1.X), 0.5*(p0.Y+p1.Y))
+	if halfChord == 0 || radius <= halfChord {
+		return mid
+	}
+
+	// The center is to the right of the chord for clockwise arcs and to its left otherwise.
+	offset := math.Sqrt(radius*radius - halfChord*halfChord)
+	toCenter := geom.NewVec2(-chord.Y, chord.X).Norm().Scale(offset)
+	if clockwise {
+		toCenter = toCenter.Scale(-1)
+	}
+	return mid.Add(toCenter)
+}
+
+// CutArc removes the material swept by the cutter going along the arc from p0 to p1. See
+// ArcPoints for the shape of the arc.
+func (s *Stock) CutArc(p0, p1 geom.Pt3, radius float64, clockwise bool, cutter Cutter) {
+	points := ArcPoints(p0, p1, radius, clockwise)
+	for i := 1; i < len(points); i++ {
+		s.CutSegment(points[i-1], points[i], cutter)
+	}
+}
+
+// ArcRemovesMaterial returns whether the cutter would remove material going along the arc
+// from p0 to p1.
+func (s *Stock) ArcRemovesMaterial(p0, p1 geom.Pt3, radius float64, clockwise bool,
+	cutter Cutter) bool {
+
+	points := ArcPoints(p0, p1, radius, clockwise)
+	for i := 1; i < len(points); i++ {
+		if s.RemovesMaterial(points[i-1], points[i], cutter) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArcCutDepth returns the thickest layer of material that the cutter would remove going along
+// the arc from p0 to p1, or 0 when the cutter would only cut air.
+func (s *Stock) ArcCutDepth(p0, p1 geom.Pt3, radius float64, clockwise bool,
+	cutter Cutter) float64 {
+
+	depth := 0.0
+	points := ArcPoints(p0, p1, radius, clockwise)
+	for i := 1; i < len(points); i++ {
+		depth = math.Max(depth, s.CutDepth(points[i-1], points[i], cutter))
+	}
+	return depth
+}
@@ -0,0 +1,87 @@
+package stock
+
+import (
+	"math"
+
+	"alvin.com/GoCarver/mesh"
+)
+
+// Cutter describes the shape of a rotationally-symmetric cutter, as seen from the side.
+type Cutter interface {
+	// Return the radius of the cutter.
+	Radius() float64
+
+	// Return the height of the cutting surface above the tip of the cutter at distance rho
+	// from the axis of the cutter, with 0 <= rho <= Radius().
+	HeightAt(rho float64) float64
+}
+
+type flatCutter struct {
+	radius float64
+}
+
+// NewFlatCutter returns a flat end-mill with the given diameter.
+func NewFlatCutter(diameter float64) Cutter {
+	return flatCutter{radius: 0.5 * diameter}
+}
+
+func (c flatCutter) Radius() float64 {
+	return c.radius
+}
+
+func (c flatCutter) HeightAt(rho float64) float64 {
+	return 0
+}
+
+type ballCutter struct {
+	radius float64
+}
+
+// NewBallCutter returns a ball-nose end-mill with the given diameter.
+func NewBallCutter(diameter float64) Cutter {
+	return ballCutter{radius: 0.5 * diameter}
+}
+
+func (c ballCutter) Radius() float64 {
+	return c.radius
+}
+
+func (c ballCutter) HeightAt(rho float64) float64 {
+	rho = math.Min(rho, c.radius)
+	return c.radius - math.Sqrt(c.radius*c.radius-rho*rho)
+}
+
+// A cutter whose shape is given by one of the profiles of the mesh sampler, so that the stock
+// is cut with the same shape as the tool is positioned with.
+type profiledCutter struct {
+	radius  float64
+	profile mesh.CutterProfile
+}
+
+// NewVBitCutter returns a V-bit with the given diameter and included angle, in degrees.
+func NewVBitCutter(diameter, includedAngleDeg float64) Cutter {
+	return profiledCutter{radius: 0.5 * diameter, profile: mesh.NewVBitProfile(includedAngleDeg)}
+}
+
+// NewTaperedBallCutter returns a tapered ball-nose with the given diameter, included angle, in
+// degrees, and radius of the ball at the tip.
+func NewTaperedBallCutter(diameter, includedAngleDeg, tipRadius float64) Cutter {
+	return profiledCutter{
+		radius:  0.5 * diameter,
+		profile: mesh.NewTaperedBallProfile(includedAngleDeg, tipRadius),
+	}
+}
+
+// NewBullNoseCutter returns a bull-nose end-mill with the given diameter and corner radius.
+func NewBullNoseCutter(diameter, cornerRadius float64) Cutter {
+	radius := 0.5 * diameter
+	return profiledCutter{radius: radius, profile: mesh.NewBullNoseProfile(radius, cornerRadius)}
+}
+
+func (c profiledCutter) Radius() float64 {
+	return c.radius
+}
+
+func (c profiledCutter) HeightAt(rho float64) float64 {
+	return c.profile.HeightAt(math.Min(rho, c.radius))
+}
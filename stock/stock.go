@@ -0,0 +1,201 @@
+package stock
+
+import (
+	"math"
+
+	"alvin.com/GoCarver/geom"
+)
+
+// Material thinner than this is not worth removing.
+const minRemovedThickness = 0.01
+
+// Stock is a height-field model of the material remaining on the workpiece. It is a grid of
+// square cells over the material, each one holding the height of the top of the material at
+// the center of the cell. Heights are relative to the top of the material: they start at 0 and
+// go down as the tool removes material. Every move of the tool is subtracted from the stock with
+// CutSegment, so that later moves can be checked for whether they remove anything at all.
+type Stock struct {
+	origin     geom.Pt2
+	resolution float64 // Width of the cells.
+	nx, ny     int
+	heights    []float64
+}
+
+// NewStock returns a new stock covering the rectangle at origin with the given size, with cells
+// of the given width. The top of the stock is at height 0.
+func NewStock(origin geom.Pt2, size geom.Size2, resolution float64) *Stock {
+	nx := int(math.Max(1, math.Ceil(size.W/resolution)))
+	ny := int(math.Max(1, math.Ceil(size.H/resolution)))
+	return &Stock{
+		origin:     origin,
+		resolution: resolution,
+		nx:         nx,
+		ny:         ny,
+		heights:    make([]float64, nx*ny),
+	}
+}
+
+// GridSize returns the number of cells of the stock along X and Y.
+func (s *Stock) GridSize() (nx, ny int) {
+	return s.nx, s.ny
+}
+
+// CellCenter returns the center of cell (i, j), where i goes along X and j along Y.
+func (s *Stock) CellCenter(i, j int) geom.Pt2 {
+	return geom.NewPt2(
+		s.origin.X+(float64(i)+0.5)*s.resolution,
+		s.origin.Y+(float64(j)+0.5)*s.resolution)
+}
+
+// CellHeight returns the height of the top of the material in cell (i, j).
+func (s *Stock) CellHeight(i, j int) float64 {
+	return s.heights[j*s.nx+i]
+}
+
+// HeightAt returns the height of the top of the material at p. Return -Inf outside the stock,
+// where there is no material.
+func (s *Stock) HeightAt(p geom.Pt2) float64 {
+	i := int(math.Floor((p.X - s.origin.X) / s.resolution))
+	j := int(math.Floor((p.Y - s.origin.Y) / s.resolution))
+	if i < 0 || i >= s.nx || j < 0 || j >= s.ny {
+		return math.Inf(-1)
+	}
+	return s.heights[j*s.nx+i]
+}
+
+// CutSegment removes the material swept by the cutter going in a straight line from p0 to p1.
+// The positions are those of the tip of the cutter.
+func (s *Stock) CutSegment(p0, p1 geom.Pt3, cutter Cutter) {
+	s.sweep(p0, p1, cutter, func(k int, toolZ float64) bool {
+		if toolZ < s.heights[k] {
+			s.heights[k] = toolZ
+		}
+		return true
+	})
+}
+
+// CutDepth returns the thickest layer of material that the cutter would remove going in a
+// straight line from p0 to p1, or 0 when the cutter would only cut air.
+func (s *Stock) CutDepth(p0, p1 geom.Pt3, cutter Cutter) float64 {
+	depth := 0.0
+	s.sweep(p0, p1, cutter, func(k int, toolZ float64) bool {
+		depth = math.Max(depth, s.heights[k]-toolZ)
+		return true
+	})
+	return depth
+}
+
+// RemovesMaterial returns whether the cutter would remove material going in a straight line
+// from p0 to p1.
+func (s *Stock) RemovesMaterial(p0, p1 geom.Pt3, cutter Cutter) bool {
+	removes := false
+	s.sweep(p0, p1, cutter, func(k int, toolZ float64) bool {
+		removes = s.heights[k]-toolZ > minRemovedThickness
+		return !removes
+	})
+	return removes
+}
+
+// ContactHeight returns the lowest height the tip of the cutter at p can go down to without
+// touching the material, or -Inf if there is no material within reach of the cutter. Each cell
+// is taken as a square column of material at the height of the cell, so the height errs on the
+// high side rather than letting the cutter into material between the centers of the cells.
+func (s *Stock) ContactHeight(p geom.Pt2, cutter Cutter) float64 {
+	r := cutter.Radius()
+	halfDiagonal := 0.5 * math.Sqrt2 * s.resolution
+	reach := r + halfDiagonal
+	i0, i1 := s.toCellRange(p.X-reach, p.X+reach, s.origin.X, s.nx)
+	j0, j1 := s.toCellRange(p.Y-reach, p.Y+reach, s.origin.Y, s.ny)
+
+	height := math.Inf(-1)
+	for j := j0; j <= j1; j++ {
+		for i := i0; i <= i1; i++ {
+			rho := s.CellCenter(i, j).Sub(p).Len() - halfDiagonal
+			if rho > r {
+				continue
+			}
+			height = math.Max(height, s.heights[j*s.nx+i]-cutter.HeightAt(math.Max(0, rho)))
+		}
+	}
+	return height
+}
+
+// Call visit for each cell under the cutter going in a straight line from p0 to p1, with the
+// index of the cell and the lowest height of the cutting surface over the center of the cell.
+// Stop as soon as visit returns false.
+//
+// The cutting surface over a cell is evaluated where the axis of the cutter is closest to the
+// cell, in the XY plane, and at both ends of the segment. Along sloped segments, the lowest
+// point may be anywhere the cell is under the cutter, so the cutting surface is also evaluated
+// at steps no longer than the width of the cells along that part of the segment. This may
+// still miss the lowest point slightly, which leaves a little more material in the stock than
+// the cutter actually removes.
+func (s *Stock) sweep(p0, p1 geom.Pt3, cutter Cutter, visit func(k int, toolZ float64) bool) {
+	// The position of the tool may be unknown, e.g. after a tool change.
+	r := cutter.Radius()
+	if r <= 0 || !isFinite(p0) || !isFinite(p1) {
+		return
+	}
+
+	i0, i1 := s.toCellRange(math.Min(p0.X, p1.X)-r, math.Max(p0.X, p1.X)+r, s.origin.X, s.nx)
+	j0, j1 := s.toCellRange(math.Min(p0.Y, p1.Y)-r, math.Max(p0.Y, p1.Y)+r, s.origin.Y, s.ny)
+
+	q0 := geom.NewPt2(p0.X, p0.Y)
+	d := geom.NewPt2(p1.X, p1.Y).Sub(q0)
+	lenSq := d.LenSq()
+	isSloped := p0.Z != p1.Z && lenSq > 0
+
+	// Return the height of the cutting surface over c with the tip of the cutter at parameter
+	// t along the segment, or +Inf if c is outside the cutter.
+	toolZAt := func(c geom.Pt2, t float64) float64 {
+		rho := c.Sub(q0.Add(d.Scale(t))).Len()
+		if rho > r {
+			return math.Inf(1)
+		}
+		return p0.Z + t*(p1.Z-p0.Z) + cutter.HeightAt(rho)
+	}
+
+	for j := j0; j <= j1; j++ {
+		for i := i0; i <= i1; i++ {
+			c := s.CellCenter(i, j)
+
+			tClosest := 0.0
+			if lenSq > 0 {
+				tClosest = c.Sub(q0).Dot(d) / lenSq
+			}
+			t := math.Max(0, math.Min(1, tClosest))
+
+			toolZ := math.Min(toolZAt(c, t), math.Min(toolZAt(c, 0), toolZAt(c, 1)))
+			if isSloped {
+				// The cell is under the cutter for t in [tClosest - w, tClosest + w].
+				distSq := c.Sub(q0.Add(d.Scale(tClosest))).LenSq()
+				if distSq < r*r {
+					w := math.Sqrt((r*r - distSq) / lenSq)
+					ta, tb := math.Max(0, tClosest-w), math.Min(1, tClosest+w)
+					n := int(math.Ceil((tb - ta) * math.Sqrt(lenSq) / s.resolution))
+					for k := 0; k <= n && ta < tb; k++ {
+						toolZ = math.Min(toolZ, toolZAt(c, ta+(tb-ta)*float64(k)/float64(n)))
+					}
+				}
+			}
+			if math.IsInf(toolZ, 1) {
+				continue
+			}
+			if !visit(j*s.nx+i, toolZ) {
+				return
+			}
+		}
+	}
+}
+
+func isFinite(p geom.Pt3) bool {
+	return !math.IsNaN(p.X+p.Y+p.Z) && !math.IsInf(p.X+p.Y+p.Z, 0)
+}
+
+// Return the range of cells, along one axis with n cells starting at origin, whose centers may
+// be between v0 and v1. The range is empty, with first > last, when none are.
+func (s *Stock) toCellRange(v0, v1, origin float64, n int) (first, last int) {
+	first = int(math.Max(0, math.Floor((v0-origin)/s.resolution-0.5)))
+	last = int(math.Min(float64(n-1), math.Ceil((v1-origin)/s.resolution-0.5)))
+	return first, last
+}
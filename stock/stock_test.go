@@ -0,0 +1,184 @@
+package stock
+
+import (
+	"math"
+	"testing"
+
+	"alvin.com/GoCarver/geom"
+)
+
+func TestCutSegment(t *testing.T) {
+	s := NewStock(geom.NewPt2(0, 0), geom.NewSize2(20, 10), 0.1)
+	ball := NewBallCutter(4)
+
+	// A groove along X, 1 mm deep, from x=5 to x=15.
+	p0 := geom.NewPt3(5, 5, -1)
+	p1 := geom.NewPt3(15, 5, -1)
+	if !s.RemovesMaterial(p0, p1, ball) {
+		t.Errorf("Expected the groove to remove material\n")
+	}
+	if d := s.CutDepth(p0, p1, ball); math.Abs(d-1) > 0.01 {
+		t.Errorf("Expected to cut 1 mm deep, got %f\n", d)
+	}
+	s.CutSegment(p0, p1, ball)
+
+	const eps = 0.01
+	checkHeight := func(p geom.Pt2, expected float64) {
+		if h := s.HeightAt(p); math.Abs(h-expected) > eps {
+			t.Errorf("Expected height %f at %v, got %f\n", expected, p, h)
+		}
+	}
+
+	// The bottom of the groove is at -1 and its sides follow the ball.
+	checkHeight(geom.NewPt2(10.05, 5.05), -1)
+	checkHeight(geom.NewPt2(10.05, 6.05), -1+2-math.Sqrt(4-1.05*1.05))
+	checkHeight(geom.NewPt2(10.05, 8.05), 0)
+	checkHeight(geom.NewPt2(2.05, 5.05), 0)
+	checkHeight(geom.NewPt2(16.05, 5.05), -1+2-math.Sqrt(4-1.05*1.05))
+	if h := s.HeightAt(geom.NewPt2(25, 5)); !math.IsInf(h, -1) {
+		t.Errorf("Expected no material outside the stock, got %f\n", h)
+	}
+
+	// Going along the groove again removes nothing, going deeper does.
+	if s.RemovesMaterial(p0, p1, ball) {
+		t.Errorf("Expected the same groove to remove nothing\n")
+	}
+	if d := s.CutDepth(p0, p1, ball); d > eps {
+		t.Errorf("Expected to cut nothing, got %f\n", d)
+	}
+	deeper := geom.NewPt3(15, 5, -1.5)
+	if d := s.CutDepth(p0, deeper, ball); math.Abs(d-0.5) > eps {
+		t.Errorf("Expected to cut 0.5 mm deep, got %f\n", d)
+	}
+
+	// A flat end-mill plunging in the middle of the groove cuts a flat bottom.
+	flat := NewFlatCutter(2)
+	s.CutSegment(geom.NewPt3(10, 5, 1), geom.NewPt3(10, 5, -2), flat)
+	checkHeight(geom.NewPt2(10.05, 5.05), -2)
+	checkHeight(geom.NewPt2(10.85, 5.05), -2)
+	checkHeight(geom.NewPt2(11.25, 5.05), -1+2-math.Sqrt(4-0.05*0.05))
+}
+
+func TestCutLongRamp(t *testing.T) {
+	s := NewStock(geom.NewPt2(0, 0), geom.NewSize2(40, 10), 0.1)
+	ball := NewBallCutter(6)
+
+	// A 6 mm ball going down 15 mm over 30 mm. Over the midpoint, at x=15, the lowest point of
+	// the ball is reached with the tip at x=15+u, where u/sqrt(9-u^2) = 0.5, i.e. u^2 = 1.8.
+	p0 := geom.NewPt3(0, 5, 0)
+	p1 := geom.NewPt3(30, 5, -15)
+	u := math.Sqrt(1.8)
+	expected := -0.5*(15+u) + 3 - math.Sqrt(9-1.8)
+	if d := s.CutDepth(p0, p1, ball); d < 15-0.01 {
+		t.Errorf("Expected to cut 15 mm deep, got %f\n", d)
+	}
+	s.CutSegment(p0, p1, ball)
+
+	// The center of the cell is 0.05 mm off the midpoint, along the slope.
+	if h := s.HeightAt(geom.NewPt2(15.01, 5.01)); math.Abs(h-(expected-0.025)) > 0.01 {
+		t.Errorf("Expected height %f over the midpoint of the ramp, got %f\n", expected-0.025, h)
+	}
+
+	// A ramp along a diagonal cuts the same profile.
+	s = NewStock(geom.NewPt2(0, 0), geom.NewSize2(40, 40), 0.1)
+	s.CutSegment(geom.NewPt3(0, 0, 0), geom.NewPt3(30/math.Sqrt2, 30/math.Sqrt2, -15), ball)
+	m := 15 / math.Sqrt2
+	if h := s.HeightAt(geom.NewPt2(m, m)); math.Abs(h-expected) > 0.05 {
+		t.Errorf("Expected height %f over the midpoint of the diagonal ramp, got %f\n",
+			expected, h)
+	}
+}
+
+func TestContactHeight(t *testing.T) {
+	s := NewStock(geom.NewPt2(0, 0), geom.NewSize2(20, 10), 0.1)
+	s.CutSegment(geom.NewPt3(10, 5, 1), geom.NewPt3(10, 5, -2), NewFlatCutter(4))
+
+	const eps = 1e-9
+	flat := NewFlatCutter(2)
+	checkContact := func(p geom.Pt2, cutter Cutter, expected float64) {
+		if h := s.ContactHeight(p, cutter); math.Abs(h-expected) > eps {
+			t.Errorf("Expected contact height %f at %v, got %f\n", expected, p, h)
+		}
+	}
+
+	// Smaller cutters reach the bottom of the pocket in its middle, not across its side.
+	checkContact(geom.NewPt2(10, 5), flat, -2)
+	checkContact(geom.NewPt2(10, 5), NewBallCutter(2), -2)
+	checkContact(geom.NewPt2(11.5, 5), flat, 0)
+
+	// The height errs on the high side for a ball as wide as the pocket, which would just fit.
+	if h := s.ContactHeight(geom.NewPt2(10, 5), NewBallCutter(4)); h < -2 || h > -1 {
+		t.Errorf("Expected a contact height between -2 and -1, got %f\n", h)
+	}
+
+	if h := s.ContactHeight(geom.NewPt2(30, 5), flat); !math.IsInf(h, -1) {
+		t.Errorf("Expected no contact outside the stock, got %f\n", h)
+	}
+}
+
+func TestCutterProfiles(t *testing.T) {
+	const eps = 1e-9
+	check := func(name string, c Cutter, rho, expected float64) {
+		if h := c.HeightAt(rho); math.Abs(h-expected) > eps {
+			t.Errorf("%s: expected height %f at %f, got %f\n", name, expected, rho, h)
+		}
+	}
+
+	check("Flat", NewFlatCutter(6), 2.5, 0)
+	check("Ball", NewBallCutter(6), 3, 3)
+	check("V-bit", NewVBitCutter(6, 90), 2, 2)
+	check("Bull-nose", NewBullNoseCutter(6, 1), 2, 0)
+	check("Bull-nose", NewBullNoseCutter(6, 1), 3, 1)
+	check("Tapered ball", NewTaperedBallCutter(6, 90, 1), 0, 0)
+	check("Tapered ball", NewTaperedBallCutter(6, 90, 1), 2, 3-math.Sqrt2)
+}
+
+func TestArcPoints(t *testing.T) {
+	const eps = 1e-9
+	check := func(p0, p1 geom.Pt3, radius float64, clockwise bool, center geom.Pt2) {
+		if c := ArcCenter(p0, p1, radius, clockwise); c.Sub(center).Len() > eps {
+			t.Errorf("Arc center: expected %v, got %v\n", center, c)
+		}
+		points := ArcPoints(p0, p1, radius, clockwise)
+		if len(points) < 3 || !points[0].Eq(p0) || !points[len(points)-1].Eq(p1) {
+			t.Fatalf("Arc points: expected points from %v to %v, got %v\n", p0, p1, points)
+		}
+		for i, p := range points {
+			r := math.Hypot(p.X-center.X, p.Y-center.Y)
+			if math.Abs(r-radius) > eps {
+				t.Errorf("Arc points: expected point %v at %f from %v, got %f\n", p, radius, center, r)
+			}
+			if i > 0 && math.Hypot(p.X-points[i-1].X, p.Y-points[i-1].Y) > arcStep+eps {
+				t.Errorf("Arc points: points %v and %v are too far apart\n", points[i-1], p)
+			}
+		}
+	}
+
+	// Quarter circles around the origin, going down in Z.
+	check(geom.NewPt3(5, 0, 0), geom.NewPt3(0, 5, -1), 5, false, geom.NewPt2(0, 0))
+	check(geom.NewPt3(0, 5, 0), geom.NewPt3(5, 0, -1), 5, true, geom.NewPt2(0, 0))
+	check(geom.NewPt3(5, 0, 0), geom.NewPt3(0, 5, -1), 5, true, geom.NewPt2(5, 5))
+
+	// Radii too small to join both points give a straight line.
+	points := ArcPoints(geom.NewPt3(0, 0, 0), geom.NewPt3(10, 0, 0), 2, false)
+	if len(points) != 2 {
+		t.Errorf("Arc points: expected a straight line, got %v\n", points)
+	}
+}
+
+func TestCutArc(t *testing.T) {
+	s := NewStock(geom.NewPt2(0, 0), geom.NewSize2(20, 20), 0.1)
+	flat := NewFlatCutter(2)
+	p0, p1 := geom.NewPt3(15, 10, -1), geom.NewPt3(10, 15, -1)
+
+	if d := s.ArcCutDepth(p0, p1, 5, false, flat); math.Abs(d-1) > 1e-9 {
+		t.Errorf("Expected the arc to cut 1.0 deep, got %f\n", d)
+	}
+	s.CutArc(p0, p1, 5, false, flat)
+	if d := s.ArcCutDepth(p0, p1, 5, false, flat); d > 1e-9 {
+		t.Errorf("Expected the arc to only cut air, got %f\n", d)
+	}
+	if s.ArcRemovesMaterial(p0, p1, 5, false, flat) {
+		t.Errorf("Expected the arc to remove no material\n")
+	}
+}